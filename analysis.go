@@ -0,0 +1,46 @@
+package webp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/deepteams/webp/internal/lossy"
+)
+
+// Analysis is the result of the analysis pass of the lossy encoder on an
+// image, as returned by [AnalyzeImage], for EncoderOptions.Analysis. It is
+// immutable and may be shared by concurrent encodes.
+type Analysis struct {
+	width, height int
+	mb            *lossy.Analysis
+}
+
+// AnalyzeImage runs the analysis pass of the lossy encoder on img: it
+// measures the complexity of each 16x16 macroblock, from which the encoder
+// assigns the segments and modulates their quantizers. The complexities
+// depend only on the pixels, so encoding the same image several times,
+// at different qualities, methods or segment counts, can skip the pass by
+// setting EncoderOptions.Analysis to the result.
+//
+// img is converted to YUV as the default options do. Encodes with Exact,
+// UseSharpYUV or dithering convert it slightly differently; they still
+// accept the analysis, which then fits their input less exactly.
+func AnalyzeImage(img image.Image) (*Analysis, error) {
+	if img == nil {
+		return nil, errors.New("webp: nil image")
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("webp: invalid image dimensions %dx%d", w, h)
+	}
+	if w > MaxDimension || h > MaxDimension {
+		return nil, fmt.Errorf("webp: image dimension %dx%d exceeds maximum %d", w, h, MaxDimension)
+	}
+	enc, _, _, err := newLossyEncoder(img, DefaultOptions())
+	if err != nil {
+		return nil, err
+	}
+	defer lossy.ReleaseEncoder(enc)
+	return &Analysis{width: w, height: h, mb: enc.Analyze()}, nil
+}
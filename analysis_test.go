@@ -0,0 +1,56 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestEncode_Analysis(t *testing.T) {
+	img := gradientTestImage(100, 70)
+	for i := 0; i < len(img.Pix); i += 4 {
+		if i%36 < 12 {
+			img.Pix[i] ^= uint8(i * 7)
+		}
+	}
+	analysis, err := AnalyzeImage(img)
+	if err != nil {
+		t.Fatalf("AnalyzeImage: %v", err)
+	}
+
+	roi := image.NewGray(img.Bounds())
+	for i := range roi.Pix {
+		roi.Pix[i] = uint8(i)
+	}
+	// The cached analysis gives the same bytes at any quality, method or
+	// segment count.
+	for _, opts := range []EncoderOptions{
+		{Quality: 10, Method: 4},
+		{Quality: 75, Method: 4},
+		{Quality: 95, Method: 6},
+		{Quality: 60, Method: 0, Segments: 2},
+		{Quality: 60, Method: 4, ROIMap: roi},
+		{Quality: 60, Method: 4, TargetSize: 2000, Pass: 4},
+	} {
+		want := mustEncode(t, img, &opts)
+		opts.Analysis = analysis
+		if got := mustEncode(t, img, &opts); !bytes.Equal(got, want) {
+			t.Errorf("q%.0f m%d: output with Analysis differs (%d vs %d bytes)", opts.Quality, opts.Method, len(got), len(want))
+		}
+	}
+
+	// Lossless encodes ignore it.
+	lossless := mustEncode(t, img, &EncoderOptions{Lossless: true, Quality: 75})
+	if got := mustEncode(t, img, &EncoderOptions{Lossless: true, Quality: 75, Analysis: analysis}); !bytes.Equal(got, lossless) {
+		t.Error("lossless: output with Analysis differs")
+	}
+
+	if _, err := AnalyzeImage(nil); err == nil {
+		t.Error("AnalyzeImage(nil): no error")
+	}
+	err = Encode(new(bytes.Buffer), gradientTestImage(100, 64), &EncoderOptions{Quality: 75, Analysis: analysis})
+	if err == nil || !strings.Contains(err.Error(), "Analysis") {
+		t.Errorf("size mismatch: err = %v", err)
+	}
+}
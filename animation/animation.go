@@ -12,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/deepteams/webp/internal/container"
 	"github.com/deepteams/webp/mux"
 )
 
@@ -53,6 +54,12 @@ var FrameDecoderFunc func(bitstreamData, alphaData []byte) (*image.NRGBA, error)
 // It will be set by the codec package once available.
 var FrameEncoderFunc func(img image.Image, lossless bool, quality int) ([]byte, error)
 
+// FrameEncoderMethodFunc is like FrameEncoderFunc but also takes the
+// compression method (0-6). It is used for frames added with a per-frame
+// Method override; when nil, FrameEncoderFunc is used and Method is ignored.
+// It will be set by the codec package once available.
+var FrameEncoderMethodFunc func(img image.Image, lossless bool, quality, method int) ([]byte, error)
+
 // SimpleEncodeFunc encodes an image as a complete simple (non-animated) WebP
 // file. It is used by the single-frame optimization to compare the size of
 // an animated single-frame WebP against a simple WebP. Returns the full
@@ -65,6 +72,15 @@ var (
 	ErrFrameOutOfRect = errors.New("animation: frame exceeds canvas bounds")
 	ErrNilImage       = errors.New("animation: frame image is nil")
 	ErrNoDecoder      = errors.New("animation: no frame decoder available")
+
+	// ErrTooManyFrames is returned when an animation has more frames than
+	// DecodeConfig.MaxFrames, or than the limit of 10000 that always
+	// applies.
+	ErrTooManyFrames = mux.ErrTooManyFrames
+
+	// ErrCannotMeetBudget is returned by AnimEncoder.Close when the
+	// animation cannot be made to fit in EncodeOptions.MaxBytes.
+	ErrCannotMeetBudget = errors.New("animation: cannot fit animation in MaxBytes")
 )
 
 // maxDuration is the maximum frame duration in milliseconds (24-bit max,
@@ -75,11 +91,16 @@ const maxDuration = 0xFFFFFF // 16777215
 // This matches the C libwebp MAX_LOOP_COUNT constant.
 const maxLoopCount = 0xFFFF // 65535
 
-// DecodeConfig holds options for Decode.
+// DecodeConfig holds options for DecodeWithConfig.
 type DecodeConfig struct {
 	// DecodePixels controls whether pixel data is decoded.
 	// If false, Frame.BitstreamData is populated but Image is nil.
 	DecodePixels bool
+
+	// MaxFrames, if positive, rejects animations with more frames with an
+	// error wrapping ErrTooManyFrames, to bound the memory and CPU spent on
+	// untrusted uploads. Parsing stops at the first frame over the limit.
+	MaxFrames int
 }
 
 // maxInputSize is the maximum allowed input size for animation decoding (256 MB).
@@ -90,6 +111,12 @@ const maxInputSize = 256 * 1024 * 1024
 // FrameDecoderFunc is set and DecodeFrames/AnimDecoder is used.
 // Inputs exceeding 256 MB are rejected.
 func Decode(r io.Reader) (*Animation, error) {
+	return DecodeWithConfig(r, nil)
+}
+
+// DecodeWithConfig is like Decode but applies cfg. A nil cfg is the zero
+// DecodeConfig, which Decode uses.
+func DecodeWithConfig(r io.Reader, cfg *DecodeConfig) (*Animation, error) {
 	data, err := io.ReadAll(io.LimitReader(r, maxInputSize+1))
 	if err != nil {
 		return nil, err
@@ -97,12 +124,29 @@ func Decode(r io.Reader) (*Animation, error) {
 	if len(data) > maxInputSize {
 		return nil, fmt.Errorf("animation: input too large (exceeds %d bytes)", maxInputSize)
 	}
-	return DecodeBytes(data)
+	if cfg == nil {
+		cfg = &DecodeConfig{}
+	}
+	anim, err := decodeBytes(data, cfg.MaxFrames)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DecodePixels {
+		if err := anim.DecodeFrames(); err != nil {
+			return nil, err
+		}
+	}
+	return anim, nil
 }
 
 // DecodeBytes parses a WebP animation from raw bytes.
 func DecodeBytes(data []byte) (*Animation, error) {
-	dmx, err := mux.NewDemuxer(data)
+	return decodeBytes(data, 0)
+}
+
+// decodeBytes is DecodeBytes with DecodeConfig.MaxFrames.
+func decodeBytes(data []byte, maxFrames int) (*Animation, error) {
+	dmx, err := mux.NewDemuxerMaxFrames(data, maxFrames)
 	if err != nil {
 		return nil, err
 	}
@@ -142,6 +186,7 @@ func DecodeBytes(data []byte) (*Animation, error) {
 			Blend:         BlendMethod(fi.BlendMode),
 			IsKeyframe:    fi.IsKeyframe,
 			HasAlpha:      fi.HasAlpha,
+			Codec:         frameCodec(fi),
 			BitstreamData: fi.Data,
 			AlphaData:     fi.AlphaData,
 		}
@@ -150,7 +195,18 @@ func DecodeBytes(data []byte) (*Animation, error) {
 	return anim, nil
 }
 
-// TotalDuration returns the sum of all frame durations.
+// frameCodec returns the Codec of a demuxed frame.
+func frameCodec(fi *mux.FrameInfo) Codec {
+	if fi.IsLossless {
+		return CodecLossless
+	}
+	return CodecLossy
+}
+
+// TotalDuration returns the sum of all frame durations. It is zero when
+// every frame has a zero Duration, which players usually show as 100ms per
+// frame (see FrameAtTime); callers needing a playback length should
+// substitute their own default in that case.
 func (a *Animation) TotalDuration() time.Duration {
 	var total time.Duration
 	for i := range a.Frames {
@@ -159,6 +215,61 @@ func (a *Animation) TotalDuration() time.Duration {
 	return total
 }
 
+// CodecHistogram returns the number of frames stored with each Codec.
+func (a *Animation) CodecHistogram() map[Codec]int {
+	h := make(map[Codec]int)
+	for i := range a.Frames {
+		h[a.Frames[i].Codec]++
+	}
+	return h
+}
+
+// defaultFrameDuration is the display duration used for frames whose
+// Duration is zero or negative. It matches the 100ms delay gwebp substitutes
+// for zero-delay frames when converting to GIF.
+const defaultFrameDuration = 100 * time.Millisecond
+
+// displayDuration returns the effective display duration of f.
+func displayDuration(f *Frame) time.Duration {
+	if f.Duration <= 0 {
+		return defaultFrameDuration
+	}
+	return f.Duration
+}
+
+// FrameAtTime returns the index of the frame displayed at playback time t,
+// measured from the start of the first loop. Frames with a zero Duration are
+// treated as lasting 100ms. When LoopCount is 0 the animation loops forever;
+// otherwise it plays LoopCount times and then stays on the last frame.
+// Combine with AnimDecoder.Seek to render the canvas for a playhead position.
+func (a *Animation) FrameAtTime(t time.Duration) (int, error) {
+	if len(a.Frames) == 0 {
+		return 0, ErrNoFrames
+	}
+	if t < 0 {
+		return 0, fmt.Errorf("animation: negative time %v", t)
+	}
+
+	var total time.Duration
+	for i := range a.Frames {
+		total += displayDuration(&a.Frames[i])
+	}
+
+	if a.LoopCount > 0 && t/total >= time.Duration(a.LoopCount) {
+		return len(a.Frames) - 1, nil
+	}
+	t %= total
+
+	for i := range a.Frames {
+		d := displayDuration(&a.Frames[i])
+		if t < d {
+			return i, nil
+		}
+		t -= d
+	}
+	return len(a.Frames) - 1, nil
+}
+
 // DecodeFrames decodes all frames using FrameDecoderFunc.
 // Frames that already have a non-nil Image are skipped.
 func (a *Animation) DecodeFrames() error {
@@ -182,6 +293,36 @@ func (a *Animation) DecodeFrames() error {
 	return nil
 }
 
+// DecodeRawFrame decodes frame i on its own, at its own size rather than
+// the canvas size, without compositing it onto earlier frames; the frame's
+// OffsetX, OffsetY, Blend and Dispose fields say where and how it applies.
+// This is what re-muxing needs, and it avoids rebuilding the canvas. The
+// frame is decoded from BitstreamData with FrameDecoderFunc, or copied from
+// Image if it has no bitstream; a is not modified.
+func (a *Animation) DecodeRawFrame(i int) (*image.NRGBA, error) {
+	if i < 0 || i >= len(a.Frames) {
+		return nil, fmt.Errorf("animation: frame index %d out of range [0, %d)", i, len(a.Frames))
+	}
+	f := &a.Frames[i]
+	if f.BitstreamData == nil {
+		if f.Image == nil {
+			return nil, ErrNilImage
+		}
+		src := toNRGBA(f.Image)
+		img := image.NewNRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+		copyImageRect(img, src, 0, 0)
+		return img, nil
+	}
+	if FrameDecoderFunc == nil {
+		return nil, ErrNoDecoder
+	}
+	img, err := FrameDecoderFunc(f.BitstreamData, f.AlphaData)
+	if err != nil {
+		return nil, fmt.Errorf("animation: decoding frame %d: %w", i, err)
+	}
+	return img, nil
+}
+
 // DecodeFramesParallel decodes all frames using FrameDecoderFunc in parallel.
 // Each frame's VP8/VP8L bitstream is decoded independently on a separate
 // goroutine. The number of concurrent decoders is limited to GOMAXPROCS.
@@ -282,6 +423,14 @@ type AnimDecoder struct {
 	prevFrameDisposed *image.NRGBA
 	pos               int
 
+	// UseBackgroundColor fills the canvas with the animation's
+	// BackgroundColor instead of transparent black, before the first frame
+	// and where a frame is disposed to the background. The WebP format only
+	// calls the background color a hint, and libwebp ignores it as the
+	// decoder does by default; set this to composite the authored
+	// background. Change it only before the first frame or before Reset.
+	UseBackgroundColor bool
+
 	// State for keyframe detection.
 	prevFrameWasKeyframe bool
 	prevDispose          DisposeMethod
@@ -292,7 +441,8 @@ type AnimDecoder struct {
 const maxCanvasArea = uint64(1) << 30 // ~1 billion pixels, ~4GB NRGBA max
 
 // NewAnimDecoder creates an AnimDecoder from an Animation.
-// The canvas is initialized to transparent (0,0,0,0), matching the C reference.
+// The canvas is initialized to transparent (0,0,0,0), matching the C reference,
+// unless UseBackgroundColor is set.
 // Returns an error if canvas dimensions are invalid or exceed safety limits.
 func NewAnimDecoder(anim *Animation) (*AnimDecoder, error) {
 	if anim.CanvasWidth <= 0 || anim.CanvasHeight <= 0 {
@@ -321,10 +471,14 @@ func (d *AnimDecoder) HasNext() bool {
 // This matches the C libwebp IsKeyFrame() logic, using the bitstream's
 // has_alpha flag instead of scanning pixel data.
 func (d *AnimDecoder) isKeyFrame(idx int) bool {
-	f := &d.anim.Frames[idx]
+	return d.frameIsKeyFrame(&d.anim.Frames[idx], idx == 0)
+}
 
+// frameIsKeyFrame is isKeyFrame for a frame that need not be stored in
+// d.anim, such as one read by a StreamDecoder.
+func (d *AnimDecoder) frameIsKeyFrame(f *Frame, first bool) bool {
 	// First frame is always a keyframe.
-	if idx == 0 {
+	if first {
 		return true
 	}
 
@@ -367,67 +521,162 @@ func (d *AnimDecoder) NextFrame() (*image.NRGBA, time.Duration, error) {
 		return nil, 0, ErrNoFrames
 	}
 	f := &d.anim.Frames[d.pos]
+	if err := d.advance(); err != nil {
+		return nil, 0, err
+	}
+
+	// Snapshot the current canvas for the caller.
+	snap := image.NewNRGBA(d.currFrame.Bounds())
+	copy(snap.Pix, d.currFrame.Pix)
+	return snap, f.Duration, nil
+}
+
+// advance composites the frame at d.pos onto the canvas, prepares the
+// disposed canvas for the following frame and moves to the next position.
+func (d *AnimDecoder) advance() error {
+	if err := d.render(&d.anim.Frames[d.pos], d.pos == 0); err != nil {
+		return err
+	}
+	d.pos++
+	return nil
+}
+
+// render composites f onto the canvas and applies its dispose method to the
+// buffer used as the starting point of the following frame.
+func (d *AnimDecoder) render(f *Frame, first bool) error {
 	if f.Image == nil {
-		return nil, 0, ErrNilImage
+		return ErrNilImage
 	}
 
-	keyFrame := d.isKeyFrame(d.pos)
+	keyFrame := d.frameIsKeyFrame(f, first)
 
 	// Initialize currFrame.
 	if keyFrame {
-		// Keyframe: start from a blank transparent canvas.
-		clearCanvas(d.currFrame)
+		// Keyframe: start from a blank canvas.
+		d.clear(d.currFrame)
 	} else {
 		// Non-keyframe: start from the previous disposed canvas.
 		copy(d.currFrame.Pix, d.prevFrameDisposed.Pix)
 	}
 
-	// Composite the frame onto currFrame.
-	d.compositeFrame(f)
+	// DisposePrevious needs the frame region as it was before this frame.
+	// prevFrameDisposed still holds it (even for keyframes, which start
+	// from a blank canvas but must not discard what came before).
+	var saved []uint8
+	if f.Dispose == DisposePrevious {
+		saved = saveCanvasRect(d.prevFrameDisposed, f.Bounds())
+	}
 
-	// Snapshot the current canvas for the caller.
-	snap := image.NewNRGBA(d.currFrame.Bounds())
-	copy(snap.Pix, d.currFrame.Pix)
+	// Composite the frame onto currFrame.
+	compositeFrame(d.currFrame, f)
 
 	// Prepare prevFrameDisposed for the next iteration:
 	// 1. Copy currFrame to prevFrameDisposed
 	// 2. Apply this frame's dispose method to prevFrameDisposed
 	copy(d.prevFrameDisposed.Pix, d.currFrame.Pix)
-	applyDispose(d.prevFrameDisposed, f)
+	applyDispose(d.prevFrameDisposed, f, d.background())
+	if saved != nil {
+		restoreCanvasRect(d.prevFrameDisposed, f.Bounds(), saved)
+	}
 
 	// Update keyframe detection state for next frame.
 	d.prevFrameWasKeyframe = keyFrame
 	d.prevDispose = f.Dispose
 	d.prevBounds = f.Bounds()
+	return nil
+}
 
-	d.pos++
-	return snap, f.Duration, nil
+// Seek positions the decoder so that the next call to NextFrame returns the
+// canvas for frame frameIndex. The canvas is rebuilt by replaying frames
+// from the nearest keyframe at or before frameIndex, honoring the dispose and
+// blend methods of every replayed frame. On error the decoder is reset.
+func (d *AnimDecoder) Seek(frameIndex int) error {
+	if frameIndex < 0 || frameIndex >= len(d.anim.Frames) {
+		return fmt.Errorf("animation: seek index %d out of range [0, %d)", frameIndex, len(d.anim.Frames))
+	}
+
+	// Keyframe detection only depends on frame metadata, so walk the
+	// frames without compositing to find the replay start point and the
+	// detection state just before it.
+	d.Reset()
+	start := 0
+	var startWasKeyframe bool
+	var startDispose DisposeMethod
+	var startBounds image.Rectangle
+	for i := 0; i <= frameIndex; i++ {
+		keyFrame := d.isKeyFrame(i)
+		// A dispose-previous keyframe hands the canvas from before it to
+		// the next frame, so replay can only start there if it is the
+		// target itself.
+		if keyFrame && (i == frameIndex || d.anim.Frames[i].Dispose != DisposePrevious) {
+			start = i
+			startWasKeyframe = d.prevFrameWasKeyframe
+			startDispose = d.prevDispose
+			startBounds = d.prevBounds
+		}
+		f := &d.anim.Frames[i]
+		d.prevFrameWasKeyframe = keyFrame
+		d.prevDispose = f.Dispose
+		d.prevBounds = f.Bounds()
+	}
+
+	// The start frame is a keyframe and clears the canvas itself, so the
+	// buffers only need to be blank.
+	d.Reset()
+	d.pos = start
+	d.prevFrameWasKeyframe = startWasKeyframe
+	d.prevDispose = startDispose
+	d.prevBounds = startBounds
+	for d.pos < frameIndex {
+		if err := d.advance(); err != nil {
+			d.Reset()
+			return err
+		}
+	}
+	return nil
 }
 
 // Reset rewinds the decoder to the first frame and clears the canvas.
 func (d *AnimDecoder) Reset() {
 	d.pos = 0
-	clearCanvas(d.currFrame)
-	clearCanvas(d.prevFrameDisposed)
+	d.clear(d.currFrame)
+	d.clear(d.prevFrameDisposed)
 	d.prevFrameWasKeyframe = false
 	d.prevDispose = DisposeNone
 	d.prevBounds = image.Rectangle{}
 }
 
+// background returns the color of a blank canvas.
+func (d *AnimDecoder) background() color.NRGBA {
+	if d.UseBackgroundColor {
+		return d.anim.BackgroundColor
+	}
+	return color.NRGBA{}
+}
+
+// clear fills canvas with the background.
+func (d *AnimDecoder) clear(canvas *image.NRGBA) {
+	if bg := d.background(); bg != (color.NRGBA{}) {
+		fillRect(canvas, canvas.Bounds(), bg)
+		return
+	}
+	clearCanvas(canvas)
+}
+
 // Canvas returns the current canvas state (not a copy).
 func (d *AnimDecoder) Canvas() *image.NRGBA {
 	return d.currFrame
 }
 
-// compositeFrame blends the frame onto the current canvas.
+// compositeFrame blends the frame onto canvas.
 // Frame bounds are clamped to the canvas dimensions to prevent out-of-bounds access.
-func (d *AnimDecoder) compositeFrame(f *Frame) {
+func compositeFrame(canvas *image.NRGBA, f *Frame) {
 	src := toNRGBA(f.Image)
 	rect := f.Bounds()
 	srcBounds := src.Bounds()
 
 	// Clamp frame bounds to canvas dimensions to prevent out-of-bounds writes.
-	canvasBounds := d.currFrame.Bounds()
+	canvasBounds := canvas.Bounds()
 	rect = rect.Intersect(canvasBounds)
 	if rect.Empty() {
 		return
@@ -446,10 +695,10 @@ func (d *AnimDecoder) compositeFrame(f *Frame) {
 			srcPx := src.NRGBAAt(sx, sy)
 
 			if f.Blend == BlendNone {
-				d.currFrame.SetNRGBA(x, y, srcPx)
+				canvas.SetNRGBA(x, y, srcPx)
 			} else {
-				dstPx := d.currFrame.NRGBAAt(x, y)
-				d.currFrame.SetNRGBA(x, y, alphaBlendNRGBA(srcPx, dstPx))
+				dstPx := canvas.NRGBAAt(x, y)
+				canvas.SetNRGBA(x, y, alphaBlendNRGBA(srcPx, dstPx))
 			}
 		}
 	}
@@ -503,6 +752,95 @@ type EncodeOptions struct {
 	// forced to be a keyframe. If Kmax <= 0, keyframe insertion is disabled
 	// (only the first frame is a keyframe).
 	Kmax int
+
+	// ForceKeyframes encodes every frame as a full-canvas keyframe. It is
+	// equivalent to Kmax=1 and overrides Kmin/Kmax; the encoder then never
+	// changes a frame's dispose method, so DefaultDispose is kept as-is.
+	ForceKeyframes bool
+
+	// DefaultDispose is the dispose method given to each frame added with
+	// AddFrame. The optimized encoder may still switch the previous frame to
+	// DisposeBackground (or back to DisposeNone) when that yields a smaller
+	// sub-frame, unless ForceKeyframes is set. DisposePrevious is not part of
+	// the WebP format and is written as DisposeNone.
+	DefaultDispose DisposeMethod
+
+	// DefaultBlend is the blend method given to frames added from
+	// NewBitstreamFrame. Frames encoded from images use the blend method
+	// their sub-frame rectangle requires.
+	DefaultBlend BlendMethod
+
+	// Parallel runs the FrameEncoderFunc calls of AddFrame on a pool of
+	// GOMAXPROCS goroutines: the sub-frame candidates of several frames,
+	// the dispose-none and dispose-background candidates of one frame and,
+	// with AllowMixed, both codecs are encoded concurrently. Frame diffing
+	// stays on the caller's goroutine and frames are committed in order
+	// with the same decisions as without Parallel, so the output is
+	// identical. AddFrame copies the image and returns before the frame is
+	// encoded; an encoding error may therefore be returned by a later
+	// AddFrame or by Close.
+	Parallel bool
+
+	// MergeThreshold lets lossy frames that barely differ from the previous
+	// one be merged into it, extending its duration, instead of being
+	// encoded. A frame is merged when every pixel's R, G and B channels are
+	// within MergeThreshold (0-31) of the previous canvas, weighted by alpha
+	// as in libwebp. Higher values merge more frames, giving smaller files
+	// at the risk of visible stalls; lower values preserve subtle motion.
+	// The default 0 merges only identical frames; -1 derives the threshold
+	// from the frame quality, like the blending check (31 at quality 0, 1 at
+	// quality 100). Lossless frames are only merged when identical.
+	MergeThreshold int
+
+	// FrameInfoFunc, if set, is called with the encoding decisions for each
+	// frame the encoder stores from AddFrame, once they are final: when the
+	// next frame is stored (which may change this frame's dispose method or,
+	// by merging into it, its duration) or on Close. It is called on the
+	// goroutine calling AddFrame or Close and does not affect the output.
+	FrameInfoFunc func(FrameEncodeInfo)
+
+	// FixedFPS, if positive, gives every frame added with AddFrame,
+	// AddFrameOpts or AddKeyframe a duration of 1000/FixedFPS milliseconds
+	// (rounded down), ignoring the duration passed in, for players that
+	// assume uniform timing. Merged identical frames still add that duration
+	// to the frame they are merged into. It must be at most 1000; AddRawFrame
+	// is not affected.
+	FixedFPS int
+
+	// MaxBytes, if positive, caps the size of the file written by Close.
+	// When the animation is larger, Close encodes it again, lowering the
+	// quality in steps of 10 down to 10 (lossless frames are encoded lossy)
+	// and then merging the frames that differ least from the previous one
+	// into it, until the file fits. It returns ErrCannotMeetBudget, without
+	// writing anything, if even a single frame at quality 10 is too large or
+	// frames were added pre-encoded. The encoder keeps a copy of every frame
+	// added to be able to do this, and FrameInfoFunc only reports the first
+	// encoding.
+	MaxBytes int
+
+	// ForceAlphaFlag and ForceICCFlag set the alpha and ICC profile flags
+	// of the VP8X header whatever the frames and metadata, for players that
+	// check them, such as those that need the alpha flag on an animation
+	// whose first frame is opaque. Either one also keeps a single-frame
+	// animation from being written as a simple WebP, which has no VP8X
+	// header. ForceICCFlag needs a profile set with SetICCProfile; Close
+	// fails with mux.ErrMuxValidation without one, as the flag would
+	// announce an ICCP chunk the file does not have.
+	ForceAlphaFlag bool
+	ForceICCFlag   bool
+}
+
+// FrameEncodeInfo describes how the encoder stored one frame, for
+// EncodeOptions.FrameInfoFunc.
+type FrameEncodeInfo struct {
+	Index      int             // position of the frame in the file
+	IsKeyframe bool            // full-canvas frame that does not depend on earlier ones
+	Rect       image.Rectangle // frame rectangle on the canvas
+	Dispose    DisposeMethod
+	Blend      BlendMethod
+	Codec      Codec
+	Size       int           // encoded size of the frame data in bytes
+	Duration   time.Duration // including any merged identical frames
 }
 
 // AnimEncoder writes an animated WebP file using mux.Muxer.
@@ -539,6 +877,28 @@ type AnimEncoder struct {
 	countSinceKeyframe int                // Frames since the last keyframe.
 	prevFrameRect      image.Rectangle    // Bounding rect of previous frame (for dispose-bg). Always valid after a frame is committed.
 	prevMuxIndex       int                // Index of previous frame in muxer (for retroactive dispose update).
+
+	// cur holds the codec settings of the last committed frame: the
+	// encoder-wide options, or a FrameOptions override for that frame.
+	cur FrameOptions
+
+	// info describes the last frame stored from AddFrame until it is
+	// reported to FrameInfoFunc.
+	info *FrameEncodeInfo
+
+	// budgetFrames holds the frames added with MaxBytes set; budgetRaw
+	// records that pre-encoded frames were added too.
+	budgetFrames []budgetFrame
+	budgetRaw    bool
+
+	// Parallel pipeline state. lastCanvas is the canvas of the last frame
+	// added, which may not be committed yet; workers limits the concurrent
+	// encodes and running tracks them so Close can wait for stragglers.
+	// workers is nil without Parallel.
+	lastCanvas *image.NRGBA
+	pending    []*pendingFrame
+	workers    chan struct{}
+	running    sync.WaitGroup
 }
 
 // sanitizeKeyframeOptions adjusts kmin/kmax to valid ranges, matching the
@@ -588,25 +948,74 @@ const maxCanvasDimension = 16383
 // NewEncoder creates a new AnimEncoder.
 // Returns nil if canvas dimensions are invalid.
 func NewEncoder(w io.Writer, canvasWidth, canvasHeight int, opts *EncodeOptions) *AnimEncoder {
-	if canvasWidth <= 0 || canvasHeight <= 0 || canvasWidth > maxCanvasDimension || canvasHeight > maxCanvasDimension {
+	if !validCanvasSize(canvasWidth, canvasHeight) {
 		return nil
 	}
-	m := mux.NewMuxer()
-	enc := &AnimEncoder{
-		w:      w,
-		muxer:  m,
-		width:  canvasWidth,
-		height: canvasHeight,
-	}
+	enc := &AnimEncoder{muxer: mux.NewMuxer()}
+	enc.init(w, canvasWidth, canvasHeight, opts)
+	return enc
+}
+
+// Reset discards the encoder's state and prepares it to write a new
+// animation to w, as if it had just been returned by NewEncoder, while
+// keeping its allocations. Metadata set with SetICCProfile, SetEXIF and
+// SetXMP is cleared. Reset returns an error if frames have been added
+// since the encoder was created or last reset and Close has not been
+// called, or if the canvas dimensions are invalid.
+func (e *AnimEncoder) Reset(w io.Writer, canvasWidth, canvasHeight int, opts *EncodeOptions) error {
+	if !e.closed && (e.muxer.NumFrames() > 0 || len(e.pending) > 0) {
+		return errors.New("animation: Reset called before Close")
+	}
+	if !validCanvasSize(canvasWidth, canvasHeight) {
+		return fmt.Errorf("animation: invalid canvas size %dx%d", canvasWidth, canvasHeight)
+	}
+	e.muxer.Reset()
+	e.init(w, canvasWidth, canvasHeight, opts)
+	return nil
+}
+
+func validCanvasSize(w, h int) bool {
+	return w > 0 && h > 0 && w <= maxCanvasDimension && h <= maxCanvasDimension
+}
+
+// init sets up a new or reset encoder. The muxer must be empty.
+func (e *AnimEncoder) init(w io.Writer, canvasWidth, canvasHeight int, opts *EncodeOptions) {
+	e.w = w
+	e.width = canvasWidth
+	e.height = canvasHeight
+	e.closed = false
+	e.opts = EncodeOptions{}
 	if opts != nil {
-		enc.opts = *opts
+		e.opts = *opts
 	}
-	enc.opts.LoopCount = clampLoopCount(enc.opts.LoopCount)
-	sanitizeKeyframeOptions(&enc.opts.Kmin, &enc.opts.Kmax)
-	m.SetCanvasSize(canvasWidth, canvasHeight)
-	m.SetLoopCount(enc.opts.LoopCount)
-	m.SetBackgroundColor(nrgbaToARGB(enc.opts.BackgroundColor))
-	return enc
+	e.opts.LoopCount = clampLoopCount(e.opts.LoopCount)
+	if e.opts.ForceKeyframes {
+		e.opts.Kmax = 1
+	}
+	sanitizeKeyframeOptions(&e.opts.Kmin, &e.opts.Kmax)
+
+	e.prevCanvas = nil
+	e.frameCount = 0
+	e.countSinceKeyframe = 0
+	e.prevFrameRect = image.Rectangle{}
+	e.prevMuxIndex = 0
+	e.cur = FrameOptions{}
+	e.info = nil
+	clear(e.budgetFrames)
+	e.budgetFrames = e.budgetFrames[:0]
+	e.budgetRaw = false
+	e.lastCanvas = nil
+	e.pending = e.pending[:0]
+	e.workers = nil
+	if e.opts.Parallel {
+		e.workers = make(chan struct{}, runtime.GOMAXPROCS(0))
+	}
+
+	e.muxer.SetCanvasSize(canvasWidth, canvasHeight)
+	e.muxer.SetLoopCount(e.opts.LoopCount)
+	e.muxer.SetBackgroundColor(nrgbaToARGB(e.opts.BackgroundColor))
+	e.muxer.SetForceAlphaFlag(e.opts.ForceAlphaFlag)
+	e.muxer.SetForceICCFlag(e.opts.ForceICCFlag)
 }
 
 // AddFrame adds an animation frame. If FrameEncoderFunc is set, any image.Image
@@ -614,127 +1023,242 @@ func NewEncoder(w io.Writer, canvasWidth, canvasHeight int, opts *EncodeOptions)
 // optimization. Otherwise, only *bitstreamFrame (from NewBitstreamFrame) is
 // accepted and no optimization is applied.
 func (e *AnimEncoder) AddFrame(img image.Image, duration time.Duration) error {
+	return e.AddFrameOpts(img, duration, nil)
+}
+
+// AddKeyframe is like AddFrame but always stores img as a full-canvas
+// keyframe, even when it is identical or nearly identical to the previous
+// frame, and restarts the Kmin/Kmax count from it. Use it to mark scene
+// cuts or chapter boundaries. A bitstream frame is stored as given.
+func (e *AnimEncoder) AddKeyframe(img image.Image, duration time.Duration) error {
+	return e.addFrame(img, duration, nil, true)
+}
+
+// FrameOptions overrides the encoder-wide codec settings for a single frame
+// added with AddFrameOpts.
+type FrameOptions struct {
+	Quality  int  // 0-100.
+	Lossless bool // Encode this frame as VP8L instead of VP8.
+
+	// Method is the compression method (1-6). 0 (or any value < 0) uses
+	// the codec default that AddFrame uses, so that a FrameOptions literal
+	// without it keeps the usual effort; method 0 itself cannot be asked
+	// for per frame. It requires FrameEncoderMethodFunc to take effect.
+	Method int
+}
+
+// AddFrameOpts is like AddFrame but encodes this frame with fo instead of
+// the Quality and Lossless values from EncodeOptions. Sub-frame detection,
+// frame merging and dispose selection still work against the full canvas.
+// A nil fo is equivalent to AddFrame.
+func (e *AnimEncoder) AddFrameOpts(img image.Image, duration time.Duration, fo *FrameOptions) error {
+	return e.addFrame(img, duration, fo, false)
+}
+
+// addFrame implements AddFrameOpts and AddKeyframe. key forces a keyframe.
+func (e *AnimEncoder) addFrame(img image.Image, duration time.Duration, fo *FrameOptions, key bool) error {
 	if e.closed {
 		return errors.New("animation: encoder is closed")
 	}
+	if e.opts.MergeThreshold < -1 || e.opts.MergeThreshold > 31 {
+		return fmt.Errorf("animation: MergeThreshold %d out of range [-1, 31]", e.opts.MergeThreshold)
+	}
+	if e.opts.FixedFPS < 0 || e.opts.FixedFPS > 1000 {
+		return fmt.Errorf("animation: FixedFPS %d out of range [0, 1000]", e.opts.FixedFPS)
+	}
+	if e.opts.MaxBytes < 0 {
+		return fmt.Errorf("animation: negative MaxBytes %d", e.opts.MaxBytes)
+	}
+	if e.opts.FixedFPS > 0 {
+		duration = time.Duration(1000/e.opts.FixedFPS) * time.Millisecond
+	}
+	if fo != nil {
+		if fo.Quality < 0 || fo.Quality > 100 {
+			return fmt.Errorf("animation: frame quality %d out of range [0, 100]", fo.Quality)
+		}
+		if fo.Method > 6 {
+			return fmt.Errorf("animation: frame method %d out of range [1, 6]", fo.Method)
+		}
+	}
+	cur := FrameOptions{Quality: e.opts.Quality, Lossless: e.opts.Lossless}
+	if fo != nil {
+		cur = *fo
+	}
 	// Fast path for pre-encoded bitstream data (no optimization possible).
 	if bf, ok := img.(*bitstreamFrame); ok {
+		if err := e.flush(); err != nil {
+			return err
+		}
+		e.reportFrameInfo()
+		e.cur = cur
 		e.frameCount++
+		e.budgetRaw = true
 		return e.muxer.AddFrame(bf.data, &mux.FrameOptions{
-			Duration: int(duration / time.Millisecond),
+			Duration:    int(duration / time.Millisecond),
+			BlendMode:   mux.BlendMode(e.opts.DefaultBlend),
+			DisposeMode: e.defaultDispose(),
 		})
 	}
 	// Use the registered encoder function with sub-frame optimization.
 	if FrameEncoderFunc != nil {
-		return e.addOptimizedFrame(img, duration)
+		return e.addOptimizedFrame(img, duration, cur, key)
 	}
 	return errors.New("animation: no frame encoder available; use AddRawFrame or register FrameEncoderFunc")
 }
 
-// encodeFrame encodes an image using the configured codec. When AllowMixed is
-// true, the image is encoded as both lossy and lossless, and the smaller result
-// is returned. This matches the C libwebp behavior where allow_mixed causes
-// each frame to be tried with both codecs independently.
-func (e *AnimEncoder) encodeFrame(img image.Image, lossless bool, quality int) ([]byte, error) {
-	bs, err := FrameEncoderFunc(img, lossless, quality)
-	if err != nil {
-		return nil, err
-	}
-	if !e.opts.AllowMixed {
-		return bs, nil
+// callFrameEncoder invokes FrameEncoderMethodFunc when fo carries a Method
+// override, and FrameEncoderFunc otherwise.
+func callFrameEncoder(img image.Image, lossless bool, fo FrameOptions) ([]byte, error) {
+	if fo.Method > 0 && FrameEncoderMethodFunc != nil {
+		return FrameEncoderMethodFunc(img, lossless, fo.Quality, fo.Method)
 	}
-	// Try the reversed codec (lossy if configured lossless, and vice versa).
-	bsAlt, errAlt := FrameEncoderFunc(img, !lossless, quality)
-	if errAlt != nil {
-		// If the alternate codec fails, use the primary result.
-		return bs, nil
-	}
-	if len(bsAlt) < len(bs) {
-		return bsAlt, nil
-	}
-	return bs, nil
+	return FrameEncoderFunc(img, lossless, fo.Quality)
 }
 
 // addOptimizedFrame encodes a frame with sub-frame rectangle detection,
-// dispose method selection, and keyframe policy.
-func (e *AnimEncoder) addOptimizedFrame(img image.Image, duration time.Duration) error {
+// dispose method selection, and keyframe policy. key skips all of them and
+// stores the frame as a keyframe.
+func (e *AnimEncoder) addOptimizedFrame(img image.Image, duration time.Duration, fo FrameOptions, key bool) error {
 	currCanvas := toNRGBA(img)
 
 	// Ensure canvas dimensions match. If the image is smaller than the canvas,
-	// place it at (0,0) on a full-canvas NRGBA.
-	if currCanvas.Bounds().Dx() != e.width || currCanvas.Bounds().Dy() != e.height {
+	// place it at (0,0) on a full-canvas NRGBA. With Parallel the frame is
+	// encoded after AddFrame returns, so the caller's image is copied too.
+	sameSize := currCanvas.Bounds().Dx() == e.width && currCanvas.Bounds().Dy() == e.height
+	if !sameSize || (e.workers != nil && image.Image(currCanvas) == img) {
 		full := image.NewNRGBA(image.Rect(0, 0, e.width, e.height))
 		copyImageRect(full, currCanvas, 0, 0)
 		currCanvas = full
 	}
 
-	isFirstFrame := e.frameCount == 0
-	durMS := int(duration / time.Millisecond)
+	if e.opts.MaxBytes > 0 {
+		e.budgetFrames = append(e.budgetFrames, budgetFrame{
+			canvas: e.keepCanvas(currCanvas),
+			durMS:  int(duration / time.Millisecond),
+			fo:     fo,
+			key:    key,
+		})
+	}
+	p := e.prepareFrame(currCanvas, int(duration/time.Millisecond), fo, key)
+	if e.workers != nil {
+		return e.enqueue(p)
+	}
+	return e.commitFrame(p)
+}
 
-	if isFirstFrame {
-		// First frame is always a full-canvas keyframe.
-		bs, err := e.encodeFrame(currCanvas, e.opts.Lossless, e.opts.Quality)
+// commitFrame stores p in the muxer. The first frame is always a full-canvas
+// keyframe; a frame identical to the previous one extends its duration;
+// otherwise the keyframe policy picks between a keyframe and a sub-frame.
+func (e *AnimEncoder) commitFrame(p *pendingFrame) error {
+	e.cur = p.fo
+	if p.first {
+		bs, err := e.keyEncode(p).wait()
 		if err != nil {
 			return fmt.Errorf("animation: encoding frame: %w", err)
 		}
-		if err := e.muxer.AddFrame(bs, &mux.FrameOptions{
-			Duration:    durMS,
-			BlendMode:   mux.BlendMode(BlendNone),
-			DisposeMode: mux.DisposeMode(DisposeNone),
-		}); err != nil {
-			return err
-		}
-		e.prevCanvas = cloneNRGBA(currCanvas)
-		e.prevFrameRect = image.Rect(0, 0, e.width, e.height)
-		e.prevMuxIndex = e.muxer.NumFrames() - 1
-		e.frameCount++
-		e.countSinceKeyframe = 0
-		return nil
+		return e.addKeyframe(p, bs)
+	}
+	if p.forceKey {
+		return e.encodeKeyframe(p)
 	}
 
 	// Check if this frame is pixel-identical to the previous canvas. If so,
 	// merge it by extending the previous frame's duration instead of encoding
 	// a new frame. This matches the C libwebp frame_skipped / empty-rect logic.
-	if isCanvasIdentical(e.prevCanvas, currCanvas) {
-		return e.increasePreviousDuration(durMS)
+	if p.identical {
+		return e.increasePreviousDuration(p.durMS)
 	}
 
 	e.countSinceKeyframe++
 
 	// Determine if this frame must be a keyframe.
-	forceKeyframe := e.countSinceKeyframe >= e.opts.Kmax
-
-	// If forced keyframe or the changed area is very large (>90% of canvas),
-	// encode as a full-canvas keyframe.
-	if forceKeyframe {
-		return e.encodeKeyframe(currCanvas, durMS)
+	if e.countSinceKeyframe >= e.opts.Kmax {
+		return e.encodeKeyframe(p)
 	}
 
 	// Try sub-frame encoding with both dispose methods and pick the best.
-	return e.encodeSubFrame(currCanvas, durMS)
+	return e.encodeSubFrame(p)
 }
 
 // encodeKeyframe encodes the current canvas as a full-canvas keyframe.
-func (e *AnimEncoder) encodeKeyframe(currCanvas *image.NRGBA, durMS int) error {
-	bs, err := e.encodeFrame(currCanvas, e.opts.Lossless, e.opts.Quality)
+func (e *AnimEncoder) encodeKeyframe(p *pendingFrame) error {
+	bs, err := e.keyEncode(p).wait()
 	if err != nil {
 		return fmt.Errorf("animation: encoding keyframe: %w", err)
 	}
+	return e.addKeyframe(p, bs)
+}
+
+// addKeyframe adds bs, the full-canvas encode of p, to the muxer.
+func (e *AnimEncoder) addKeyframe(p *pendingFrame, bs []byte) error {
 	if err := e.muxer.AddFrame(bs, &mux.FrameOptions{
-		Duration:    durMS,
+		Duration:    p.durMS,
 		BlendMode:   mux.BlendMode(BlendNone),
-		DisposeMode: mux.DisposeMode(DisposeNone),
+		DisposeMode: e.defaultDispose(),
 	}); err != nil {
 		return err
 	}
-	e.prevCanvas = cloneNRGBA(currCanvas)
+	e.prevCanvas = e.keepCanvas(p.canvas)
 	e.prevFrameRect = image.Rect(0, 0, e.width, e.height)
 	e.prevMuxIndex = e.muxer.NumFrames() - 1
 	e.frameCount++
 	e.countSinceKeyframe = 0
+	e.trackFrameInfo(true, e.prevFrameRect, BlendNone, bs)
 	return nil
 }
 
+// trackFrameInfo records the frame just stored at prevMuxIndex for
+// FrameInfoFunc, reporting the frame before it, which is now final.
+func (e *AnimEncoder) trackFrameInfo(key bool, rect image.Rectangle, blend BlendMethod, bs []byte) {
+	if e.opts.FrameInfoFunc == nil {
+		return
+	}
+	e.reportFrameInfo()
+	codec := CodecLossy
+	if len(bs) > 0 && bs[0] == container.VP8LMagicByte {
+		codec = CodecLossless
+	}
+	e.info = &FrameEncodeInfo{
+		Index:      e.prevMuxIndex,
+		IsKeyframe: key,
+		Rect:       rect,
+		Blend:      blend,
+		Codec:      codec,
+		Size:       len(bs),
+	}
+}
+
+// reportFrameInfo passes the tracked frame, with its final dispose method
+// and duration, to FrameInfoFunc.
+func (e *AnimEncoder) reportFrameInfo() {
+	if e.info == nil {
+		return
+	}
+	info := *e.info
+	e.info = nil
+	info.Dispose = DisposeMethod(e.muxer.FrameDisposeMode(info.Index))
+	info.Duration = time.Duration(e.muxer.FrameDuration(info.Index)) * time.Millisecond
+	e.opts.FrameInfoFunc(info)
+}
+
+// keepCanvas returns a copy of canvas that the encoder may keep, or canvas
+// itself when it is already a private copy (Parallel).
+func (e *AnimEncoder) keepCanvas(canvas *image.NRGBA) *image.NRGBA {
+	if e.workers != nil {
+		return canvas
+	}
+	return cloneNRGBA(canvas)
+}
+
+// defaultDispose returns the muxer dispose mode for EncodeOptions.DefaultDispose.
+// DisposePrevious cannot be stored in an ANMF chunk and maps to DisposeNone.
+func (e *AnimEncoder) defaultDispose() mux.DisposeMode {
+	if e.opts.DefaultDispose == DisposeBackground {
+		return mux.DisposeBackground
+	}
+	return mux.DisposeNone
+}
+
 // qualityToMaxDiff converts an encoding quality (0-100) to a maximum per-channel
 // pixel difference threshold, matching the C libwebp QualityToMaxDiff:
 //
@@ -835,40 +1359,18 @@ func isLossyBlendingPossible(src, dst *image.NRGBA, rect image.Rectangle, qualit
 // Matching the C libwebp reference, this method generates two candidate
 // sub-frames -- one assuming the previous frame uses DISPOSE_NONE and one
 // assuming DISPOSE_BACKGROUND -- then picks the candidate with the smaller
-// encoded size. When DISPOSE_BACKGROUND wins, the previous frame's dispose
-// method is retroactively updated in the muxer.
+// encoded size. The previous frame's dispose method is then retroactively
+// updated in the muxer to match the winning candidate.
 //
 // Note: The C reference skips the dispose-BG candidate when
 // prev_candidate_undecided is true (because the previous frame's rectangle is
 // unknown). In the Go encoder, frames are committed immediately so
 // prevFrameRect is always valid, and both candidates can always be evaluated.
 // See AnimEncoder type comment for the full DIFF-AN3 rationale.
-func (e *AnimEncoder) encodeSubFrame(currCanvas *image.NRGBA, durMS int) error {
+func (e *AnimEncoder) encodeSubFrame(p *pendingFrame) error {
 	// --- Candidate 1: DISPOSE_NONE on previous frame ---
 	// The previous canvas is unchanged; diff against it directly.
-	rectNone := findChangedRect(e.prevCanvas, currCanvas)
-	if rectNone.Empty() {
-		// No pixel changed -- encode a minimal 1x1 frame.
-		rectNone = image.Rect(0, 0, 1, 1)
-	}
-	rectNone = snapToEven(rectNone)
-	rectNone = rectNone.Intersect(image.Rect(0, 0, e.width, e.height))
-
-	// Check if blending is possible for the DISPOSE_NONE candidate.
-	// Matching C libwebp: blend mode is BLEND if validation passes, NO_BLEND otherwise.
-	blendNone := BlendNone
-	if e.opts.Lossless {
-		if isLosslessBlendingPossible(e.prevCanvas, currCanvas, rectNone) {
-			blendNone = BlendAlpha
-		}
-	} else {
-		if isLossyBlendingPossible(e.prevCanvas, currCanvas, rectNone, e.opts.Quality) {
-			blendNone = BlendAlpha
-		}
-	}
-
-	subImgNone := extractSubImage(currCanvas, rectNone)
-	bsNone, err := e.encodeFrame(subImgNone, e.opts.Lossless, e.opts.Quality)
+	bsNone, err := p.none.wait()
 	if err != nil {
 		return fmt.Errorf("animation: encoding sub-frame (dispose-none): %w", err)
 	}
@@ -876,31 +1378,8 @@ func (e *AnimEncoder) encodeSubFrame(currCanvas *image.NRGBA, durMS int) error {
 	// --- Candidate 2: DISPOSE_BACKGROUND on previous frame ---
 	// Simulate what the canvas would look like if the previous frame's
 	// rectangle were cleared to transparent after display.
-	var bsBG []byte
-	var rectBG image.Rectangle
-	blendBG := BlendNone
-	prevDisposedCanvas := cloneNRGBA(e.prevCanvas)
-	fillRect(prevDisposedCanvas, e.prevFrameRect, color.NRGBA{})
-	rectBG = findChangedRect(prevDisposedCanvas, currCanvas)
-	if rectBG.Empty() {
-		rectBG = image.Rect(0, 0, 1, 1)
-	}
-	rectBG = snapToEven(rectBG)
-	rectBG = rectBG.Intersect(image.Rect(0, 0, e.width, e.height))
-
-	// Check if blending is possible for the DISPOSE_BG candidate.
-	if e.opts.Lossless {
-		if isLosslessBlendingPossible(prevDisposedCanvas, currCanvas, rectBG) {
-			blendBG = BlendAlpha
-		}
-	} else {
-		if isLossyBlendingPossible(prevDisposedCanvas, currCanvas, rectBG, e.opts.Quality) {
-			blendBG = BlendAlpha
-		}
-	}
-
-	subImgBG := extractSubImage(currCanvas, rectBG)
-	bsBG, err = e.encodeFrame(subImgBG, e.opts.Lossless, e.opts.Quality)
+	e.startBG(p)
+	bsBG, err := p.bg.wait()
 	if err != nil {
 		// If encoding the BG candidate fails, fall through with DISPOSE_NONE.
 		bsBG = nil
@@ -910,47 +1389,45 @@ func (e *AnimEncoder) encodeSubFrame(currCanvas *image.NRGBA, durMS int) error {
 	useBG := bsBG != nil && len(bsBG) < len(bsNone)
 
 	bestBS := bsNone
-	bestRect := rectNone
+	bestRect := p.rectNone
 	bestDispose := DisposeNone
-	bestBlend := blendNone
+	bestBlend := p.blendNone
 	if useBG {
 		bestBS = bsBG
-		bestRect = rectBG
+		bestRect = p.rectBG
 		bestDispose = DisposeBackground
-		bestBlend = blendBG
+		bestBlend = p.blendBG
 	}
 
 	// If the changed area is very large, a full-canvas keyframe may compress
 	// better than the sub-frame. Try both and pick the smaller one.
-	canvasArea := e.width * e.height
-	changedArea := bestRect.Dx() * bestRect.Dy()
-	if changedArea > canvasArea*9/10 {
-		bsKey, errKey := e.encodeFrame(currCanvas, e.opts.Lossless, e.opts.Quality)
+	if isLargeRect(bestRect, e.width, e.height) {
+		bsKey, errKey := e.keyEncode(p).wait()
 		if errKey == nil && len(bsKey) < len(bestBS) {
-			return e.encodeKeyframe(currCanvas, durMS)
+			return e.addKeyframe(p, bsKey)
 		}
 	}
 
-	// If DISPOSE_BACKGROUND was chosen, retroactively update the previous
-	// frame's dispose method in the muxer.
-	if bestDispose == DisposeBackground {
-		e.muxer.SetFrameDisposeMode(e.prevMuxIndex, mux.DisposeBackground)
-	}
+	// Retroactively set the previous frame's dispose method in the muxer to
+	// the one the chosen candidate was computed against, which may differ
+	// from DefaultDispose.
+	e.muxer.SetFrameDisposeMode(e.prevMuxIndex, mux.DisposeMode(bestDispose))
 
 	if err := e.muxer.AddFrame(bestBS, &mux.FrameOptions{
-		Duration:    durMS,
+		Duration:    p.durMS,
 		OffsetX:     bestRect.Min.X,
 		OffsetY:     bestRect.Min.Y,
 		BlendMode:   mux.BlendMode(bestBlend),
-		DisposeMode: mux.DisposeMode(DisposeNone),
+		DisposeMode: e.defaultDispose(),
 	}); err != nil {
 		return err
 	}
 
-	e.prevCanvas = cloneNRGBA(currCanvas)
+	e.prevCanvas = e.keepCanvas(p.canvas)
 	e.prevFrameRect = bestRect
 	e.prevMuxIndex = e.muxer.NumFrames() - 1
 	e.frameCount++
+	e.trackFrameInfo(false, bestRect, bestBlend, bestBS)
 	return nil
 }
 
@@ -963,6 +1440,27 @@ func isCanvasIdentical(a, b *image.NRGBA) bool {
 	return bytes.Equal(a.Pix, b.Pix)
 }
 
+// isCanvasSimilar reports whether b is close enough to a, under the
+// MergeThreshold option, for a frame encoded with fo to be merged into the
+// previous one.
+func (e *AnimEncoder) isCanvasSimilar(a, b *image.NRGBA, fo FrameOptions) bool {
+	maxDiff := e.opts.MergeThreshold
+	if maxDiff < 0 {
+		maxDiff = qualityToMaxDiff(fo.Quality)
+	}
+	if fo.Lossless || maxDiff == 0 || a == nil || b == nil {
+		return false
+	}
+	for i := 0; i+3 < len(a.Pix) && i+3 < len(b.Pix); i += 4 {
+		src := color.NRGBA{R: a.Pix[i], G: a.Pix[i+1], B: a.Pix[i+2], A: a.Pix[i+3]}
+		dst := color.NRGBA{R: b.Pix[i], G: b.Pix[i+1], B: b.Pix[i+2], A: b.Pix[i+3]}
+		if !pixelsAreSimilar(src, dst, maxDiff) {
+			return false
+		}
+	}
+	return true
+}
+
 // increasePreviousDuration extends the previous frame's duration by durMS
 // milliseconds, merging the current (identical) frame into the previous one.
 //
@@ -982,13 +1480,17 @@ func (e *AnimEncoder) increasePreviousDuration(durMS int) error {
 	}
 
 	// Overflow: cap the previous frame at maxDuration and emit a 1x1
-	// transparent filler frame for the remaining duration.
+	// transparent filler frame for the remaining duration. The filler takes
+	// over the previous frame's dispose method so the canvas is kept while
+	// it is shown.
 	e.muxer.SetFrameDuration(e.prevMuxIndex, maxDuration)
+	prevDispose := e.muxer.FrameDisposeMode(e.prevMuxIndex)
+	e.muxer.SetFrameDisposeMode(e.prevMuxIndex, mux.DisposeNone)
 	remainder := newDur - maxDuration
 
 	// Encode a 1x1 transparent pixel as the filler frame.
 	fillerImg := image.NewNRGBA(image.Rect(0, 0, 1, 1))
-	bs, err := e.encodeFrame(fillerImg, e.opts.Lossless, e.opts.Quality)
+	bs, err := e.startEncode(fillerImg, e.cur).wait()
 	if err != nil {
 		return fmt.Errorf("animation: encoding filler frame: %w", err)
 	}
@@ -998,7 +1500,7 @@ func (e *AnimEncoder) increasePreviousDuration(durMS int) error {
 		OffsetX:     0,
 		OffsetY:     0,
 		BlendMode:   mux.BlendMode(BlendAlpha), // Blend on: transparent over existing = no change.
-		DisposeMode: mux.DisposeMode(DisposeNone),
+		DisposeMode: prevDispose,
 	}); err != nil {
 		return err
 	}
@@ -1006,6 +1508,7 @@ func (e *AnimEncoder) increasePreviousDuration(durMS int) error {
 	e.prevMuxIndex = e.muxer.NumFrames() - 1
 	e.frameCount++
 	e.countSinceKeyframe++
+	e.trackFrameInfo(false, image.Rect(0, 0, 1, 1), BlendAlpha, bs)
 	// prevCanvas and prevFrameRect remain unchanged since the canvas is identical.
 	return nil
 }
@@ -1022,14 +1525,20 @@ func findChangedRect(prev, curr *image.NRGBA) image.Rectangle {
 	if w == 0 || h == 0 {
 		return image.Rectangle{}
 	}
-	stride := prev.Stride
 	rowLen := w * 4
+	prevRow := func(y int) []byte {
+		off := prev.PixOffset(prev.Rect.Min.X, prev.Rect.Min.Y+y)
+		return prev.Pix[off : off+rowLen]
+	}
+	currRow := func(y int) []byte {
+		off := curr.PixOffset(curr.Rect.Min.X, curr.Rect.Min.Y+y)
+		return curr.Pix[off : off+rowLen]
+	}
 
 	// Find top boundary: first changed row.
 	minY := h
 	for y := 0; y < h; y++ {
-		off := y * stride
-		if !bytes.Equal(prev.Pix[off:off+rowLen], curr.Pix[off:off+rowLen]) {
+		if !bytes.Equal(prevRow(y), currRow(y)) {
 			minY = y
 			break
 		}
@@ -1041,8 +1550,7 @@ func findChangedRect(prev, curr *image.NRGBA) image.Rectangle {
 	// Find bottom boundary: last changed row.
 	maxY := minY + 1
 	for y := h - 1; y > minY; y-- {
-		off := y * stride
-		if !bytes.Equal(prev.Pix[off:off+rowLen], curr.Pix[off:off+rowLen]) {
+		if !bytes.Equal(prevRow(y), currRow(y)) {
 			maxY = y + 1
 			break
 		}
@@ -1052,25 +1560,25 @@ func findChangedRect(prev, curr *image.NRGBA) image.Rectangle {
 	minX := w
 	maxX := 0
 	for y := minY; y < maxY; y++ {
-		rowOff := y * stride
+		p, c := prevRow(y), currRow(y)
 		// Scan left, only up to current minX.
 		for x := 0; x < minX; x++ {
-			off := rowOff + x*4
-			if prev.Pix[off] != curr.Pix[off] ||
-				prev.Pix[off+1] != curr.Pix[off+1] ||
-				prev.Pix[off+2] != curr.Pix[off+2] ||
-				prev.Pix[off+3] != curr.Pix[off+3] {
+			off := x * 4
+			if p[off] != c[off] ||
+				p[off+1] != c[off+1] ||
+				p[off+2] != c[off+2] ||
+				p[off+3] != c[off+3] {
 				minX = x
 				break
 			}
 		}
 		// Scan right, only beyond current maxX.
 		for x := w - 1; x >= maxX; x-- {
-			off := rowOff + x*4
-			if prev.Pix[off] != curr.Pix[off] ||
-				prev.Pix[off+1] != curr.Pix[off+1] ||
-				prev.Pix[off+2] != curr.Pix[off+2] ||
-				prev.Pix[off+3] != curr.Pix[off+3] {
+			off := x * 4
+			if p[off] != c[off] ||
+				p[off+1] != c[off+1] ||
+				p[off+2] != c[off+2] ||
+				p[off+3] != c[off+3] {
 				maxX = x + 1
 				break
 			}
@@ -1087,7 +1595,9 @@ func findChangedRect(prev, curr *image.NRGBA) image.Rectangle {
 	return image.Rect(minX, minY, maxX, maxY)
 }
 
-// snapToEven adjusts the rectangle so offsets are even (VP8 requirement).
+// snapToEven adjusts the rectangle so offsets are even. This is a container
+// requirement, not a VP8 one: ANMF stores the frame offsets divided by two,
+// so lossless (VP8L) frames cannot start at odd coordinates either.
 // When an offset is odd, the width/height is expanded by 1 to compensate,
 // so the rectangle still covers the same area plus the extra pixel from
 // snapping the offset down. This matches the C libwebp SnapToEvenOffsets:
@@ -1109,6 +1619,41 @@ func snapToEven(r image.Rectangle) image.Rectangle {
 	return image.Rect(minX, minY, minX+w, minY+h)
 }
 
+// ChangedRect returns the smallest rectangle, in cur's coordinates, that
+// contains every pixel differing between prev and cur, or an empty
+// rectangle if they are identical. It is the diff the encoder uses to turn
+// a canvas update into a sub-frame. If prev is nil or the bounds differ,
+// all of cur.Bounds() is returned.
+//
+// Frame offsets in a WebP animation must be even; pass the result through
+// [SnapToEven] before using it as one.
+func ChangedRect(prev, cur *image.NRGBA) image.Rectangle {
+	if prev == nil || prev.Bounds() != cur.Bounds() {
+		return cur.Bounds()
+	}
+	return findChangedRect(prev, cur).Add(cur.Rect.Min)
+}
+
+// SnapToEven moves the top-left corner of r to even coordinates, growing r
+// by one pixel along each axis whose offset was odd, so that it can be used
+// as an animation frame rectangle. The encoder applies it to every
+// sub-frame.
+func SnapToEven(r image.Rectangle) image.Rectangle {
+	return snapToEven(r)
+}
+
+// SubImage returns a copy of the pixels of src inside r (clipped to the
+// bounds of src) as a new image whose bounds start at (0,0). Unlike
+// src.SubImage, it shares no memory with src, so it can be handed to an
+// encoder while src keeps changing.
+func SubImage(src *image.NRGBA, r image.Rectangle) *image.NRGBA {
+	r = r.Intersect(src.Bounds())
+	if r.Empty() {
+		return image.NewNRGBA(image.Rectangle{})
+	}
+	return extractSubImage(src, r)
+}
+
 // extractSubImage creates a new NRGBA image containing the pixels from src
 // within the given rectangle. The returned image has bounds starting at (0,0).
 func extractSubImage(src *image.NRGBA, rect image.Rectangle) *image.NRGBA {
@@ -1119,7 +1664,7 @@ func extractSubImage(src *image.NRGBA, rect image.Rectangle) *image.NRGBA {
 	}
 	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
 	for y := 0; y < h; y++ {
-		srcOff := (rect.Min.Y+y)*src.Stride + rect.Min.X*4
+		srcOff := src.PixOffset(rect.Min.X, rect.Min.Y+y)
 		dstOff := y * dst.Stride
 		copy(dst.Pix[dstOff:dstOff+w*4], src.Pix[srcOff:srcOff+w*4])
 	}
@@ -1158,6 +1703,11 @@ func (e *AnimEncoder) AddRawFrame(bitstreamData []byte, duration time.Duration,
 	if e.closed {
 		return errors.New("animation: encoder is closed")
 	}
+	if err := e.flush(); err != nil {
+		return err
+	}
+	e.reportFrameInfo()
+	e.budgetRaw = true
 	return e.muxer.AddFrame(bitstreamData, &mux.FrameOptions{
 		Duration:    int(duration / time.Millisecond),
 		OffsetX:     offsetX,
@@ -1185,13 +1735,22 @@ func (e *AnimEncoder) SetXMP(data []byte) {
 // Close finalizes the animation and writes the WebP file to the writer.
 // When there is exactly one frame and SimpleEncodeFunc is available, the
 // encoder also tries encoding the image as a simple (non-animated) WebP.
-// If the simple version is smaller, it is used instead. This matches the
-// C libwebp OptimizeSingleFrame behavior.
+// If the simple version is smaller, it is used instead, unless
+// ForceAlphaFlag or ForceICCFlag is set. This matches the C libwebp
+// OptimizeSingleFrame behavior.
 func (e *AnimEncoder) Close() error {
 	if e.closed {
 		return nil
 	}
 	e.closed = true
+	err := e.flush()
+	// Encodes still running after an error are no longer needed, but
+	// they must not outlive Close.
+	e.running.Wait()
+	if err != nil {
+		return err
+	}
+	e.reportFrameInfo()
 
 	// Assemble the animated output into a buffer first so we can compare
 	// sizes with a simple (non-animated) encoding when there is 1 frame.
@@ -1199,23 +1758,76 @@ func (e *AnimEncoder) Close() error {
 	if err := e.muxer.Assemble(&animBuf); err != nil {
 		return err
 	}
-	animData := animBuf.Bytes()
+	out := animBuf.Bytes()
 
 	// Single-frame optimization: if there is exactly 1 frame and we have
 	// the canvas image and the simple encoder, try encoding as a simple
-	// WebP and pick the smaller output.
-	if e.frameCount == 1 && e.prevCanvas != nil && SimpleEncodeFunc != nil {
-		simpleData, err := SimpleEncodeFunc(e.prevCanvas, e.opts.Lossless, float32(e.opts.Quality))
-		if err == nil && len(simpleData) > 0 && len(simpleData) < len(animData) {
-			_, writeErr := e.w.Write(simpleData)
-			return writeErr
+	// WebP and pick the smaller output, unless VP8X flags are forced.
+	forced := e.opts.ForceAlphaFlag || e.opts.ForceICCFlag
+	if e.frameCount == 1 && e.prevCanvas != nil && SimpleEncodeFunc != nil && !forced {
+		simpleData, err := SimpleEncodeFunc(e.prevCanvas, e.cur.Lossless, float32(e.cur.Quality))
+		if err == nil && len(simpleData) > 0 && len(simpleData) < len(out) {
+			out = simpleData
 		}
 	}
 
-	_, err := e.w.Write(animData)
+	if e.opts.MaxBytes > 0 && len(out) > e.opts.MaxBytes {
+		if out, err = e.fitBudget(); err != nil {
+			return err
+		}
+	}
+	_, err = e.w.Write(out)
 	return err
 }
 
+// EncodeAll writes frames as an animated WebP to w, mirroring gif.EncodeAll.
+// delays[i] is the display duration of frames[i]. The canvas takes the size
+// and origin of the first frame's bounds. Later frames with the same bounds
+// replace the whole canvas; frames whose bounds are a strict sub-rectangle of
+// the canvas overwrite only that region of the previous canvas, so their
+// bounds act as per-frame offsets. Frames are passed through AnimEncoder, so
+// sub-frame detection, frame merging and dispose selection all apply.
+func EncodeAll(w io.Writer, frames []image.Image, delays []time.Duration, o *EncodeOptions) error {
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+	if len(delays) != len(frames) {
+		return fmt.Errorf("animation: %d frames but %d delays", len(frames), len(delays))
+	}
+	if frames[0] == nil {
+		return ErrNilImage
+	}
+
+	canvasRect := frames[0].Bounds()
+	enc := NewEncoder(w, canvasRect.Dx(), canvasRect.Dy(), o)
+	if enc == nil {
+		return ErrCanvasSize
+	}
+
+	var canvas *image.NRGBA
+	for i, img := range frames {
+		if img == nil {
+			return fmt.Errorf("animation: frame %d: %w", i, ErrNilImage)
+		}
+		b := img.Bounds()
+		if !b.In(canvasRect) {
+			return fmt.Errorf("animation: frame %d bounds %v outside canvas %v: %w", i, b, canvasRect, ErrFrameOutOfRect)
+		}
+		if b == canvasRect || canvas == nil {
+			// canvas is nil only for frame 0, whose bounds define canvasRect.
+			canvas = image.NewNRGBA(image.Rect(0, 0, canvasRect.Dx(), canvasRect.Dy()))
+			copyImageRect(canvas, toNRGBA(img), 0, 0)
+		} else {
+			canvas = cloneNRGBA(canvas)
+			copyImageRect(canvas, toNRGBA(img), b.Min.X-canvasRect.Min.X, b.Min.Y-canvasRect.Min.Y)
+		}
+		if err := enc.AddFrame(canvas, delays[i]); err != nil {
+			return fmt.Errorf("animation: frame %d: %w", i, err)
+		}
+	}
+	return enc.Close()
+}
+
 // bitstreamFrame wraps raw bitstream data as an image.Image for AddFrame.
 type bitstreamFrame struct {
 	data   []byte
@@ -1279,11 +1891,43 @@ func alphaBlendNRGBA(src, dst color.NRGBA) color.NRGBA {
 }
 
 // applyDispose modifies the canvas based on the frame's dispose method.
-// Per the C libwebp reference, dispose-to-background fills with transparent
-// (0,0,0,0), not the container's background color.
-func applyDispose(canvas *image.NRGBA, f *Frame) {
+// Dispose-to-background fills with bg, which per the C libwebp reference is
+// transparent (0,0,0,0), not the container's background color, unless
+// AnimDecoder.UseBackgroundColor is set.
+func applyDispose(canvas *image.NRGBA, f *Frame, bg color.NRGBA) {
 	if f.Dispose == DisposeBackground {
-		fillRect(canvas, f.Bounds(), color.NRGBA{})
+		fillRect(canvas, f.Bounds(), bg)
+	}
+}
+
+// saveCanvasRect copies pixel data from the given rect of the canvas.
+func saveCanvasRect(canvas *image.NRGBA, r image.Rectangle) []uint8 {
+	r = r.Intersect(canvas.Bounds())
+	if r.Empty() {
+		return nil
+	}
+	w := r.Dx() * 4
+	saved := make([]uint8, r.Dy()*w)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		srcOff := canvas.PixOffset(r.Min.X, y)
+		dstOff := (y - r.Min.Y) * w
+		copy(saved[dstOff:dstOff+w], canvas.Pix[srcOff:srcOff+w])
+	}
+	return saved
+}
+
+// restoreCanvasRect pastes pixel data saved by saveCanvasRect back into the
+// canvas rect.
+func restoreCanvasRect(canvas *image.NRGBA, r image.Rectangle, saved []uint8) {
+	r = r.Intersect(canvas.Bounds())
+	if r.Empty() || saved == nil {
+		return
+	}
+	w := r.Dx() * 4
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		dstOff := canvas.PixOffset(r.Min.X, y)
+		srcOff := (y - r.Min.Y) * w
+		copy(canvas.Pix[dstOff:dstOff+w], saved[srcOff:srcOff+w])
 	}
 }
 
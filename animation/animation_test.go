@@ -6,6 +6,7 @@ import (
 	"errors"
 	"image"
 	"image/color"
+	"slices"
 	"testing"
 	"time"
 
@@ -230,6 +231,205 @@ func TestAnimDecoderDisposeBackground(t *testing.T) {
 	}
 }
 
+func TestAnimDecoderUseBackgroundColor(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	half := color.NRGBA{G: 255, A: 128}
+	gray := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+
+	anim := &Animation{
+		CanvasWidth:     4,
+		CanvasHeight:    4,
+		BackgroundColor: gray,
+		Frames: []Frame{
+			{Image: solidNRGBA(2, 2, red), Duration: 50 * time.Millisecond, Blend: BlendNone, Dispose: DisposeBackground},
+			{Image: solidNRGBA(2, 2, half), OffsetX: 2, OffsetY: 2, HasAlpha: true, Duration: 50 * time.Millisecond, Blend: BlendAlpha},
+		},
+	}
+	want := [][]struct {
+		x, y int
+		c    color.NRGBA
+	}{
+		{{0, 0, red}, {3, 3, gray}},
+		// Frame 0 is disposed to the background color; frame 1 is blended
+		// onto it.
+		{{0, 0, gray}, {1, 3, gray}, {3, 3, alphaBlendNRGBA(half, gray)}},
+	}
+
+	dec, err := NewAnimDecoder(anim)
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+	dec.UseBackgroundColor = true
+	for i := 0; i < 2; i++ {
+		// The second pass checks that Reset refills the background.
+		for n, pixels := range want {
+			snap, _, err := dec.NextFrame()
+			if err != nil {
+				t.Fatalf("NextFrame: %v", err)
+			}
+			for _, p := range pixels {
+				if got := snap.NRGBAAt(p.x, p.y); got != p.c {
+					t.Errorf("pass %d frame %d: (%d,%d) = %v, want %v", i, n, p.x, p.y, got, p.c)
+				}
+			}
+		}
+		dec.Reset()
+	}
+
+	// By default the background color is ignored.
+	dec, err = NewAnimDecoder(anim)
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+	snap, _, err := dec.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if got := snap.NRGBAAt(3, 3); got != (color.NRGBA{}) {
+		t.Errorf("default: (3,3) = %v, want transparent", got)
+	}
+}
+
+func TestAnimBackgroundColorRoundtrip(t *testing.T) {
+	bg := color.NRGBA{R: 0x11, G: 0x22, B: 0x33, A: 0x44}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, 100, 100, &EncodeOptions{BackgroundColor: bg})
+	for i := 0; i < 2; i++ {
+		if err := enc.AddRawFrame(makeVP8Keyframe(100, 100), 50*time.Millisecond, 0, 0, BlendNone, DisposeNone); err != nil {
+			t.Fatalf("AddRawFrame: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// The ANIM chunk stores the color in [Blue, Green, Red, Alpha] order.
+	i := bytes.Index(buf.Bytes(), []byte("ANIM"))
+	if i < 0 {
+		t.Fatal("no ANIM chunk")
+	}
+	if got := buf.Bytes()[i+8 : i+12]; !bytes.Equal(got, []byte{0x33, 0x22, 0x11, 0x44}) {
+		t.Errorf("ANIM background bytes = % x, want 33 22 11 44", got)
+	}
+	anim, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if anim.BackgroundColor != bg {
+		t.Errorf("BackgroundColor = %v, want %v", anim.BackgroundColor, bg)
+	}
+}
+
+func TestDecodeRawFrameInMemory(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	big := solidNRGBA(6, 6, red)
+	anim := &Animation{
+		CanvasWidth:  8,
+		CanvasHeight: 8,
+		Frames: []Frame{
+			{Image: big.SubImage(image.Rect(1, 2, 4, 4)), OffsetX: 2, OffsetY: 2},
+			{},
+		},
+	}
+	raw, err := anim.DecodeRawFrame(0)
+	if err != nil {
+		t.Fatalf("DecodeRawFrame: %v", err)
+	}
+	if want := solidNRGBA(3, 2, red); raw.Bounds() != want.Bounds() || !bytes.Equal(raw.Pix, want.Pix) {
+		t.Errorf("raw frame = %v %v, want a 3x2 red copy", raw.Bounds(), raw.Pix)
+	}
+	raw.Pix[0] = 0
+	if big.Pix[big.PixOffset(1, 2)] != 255 {
+		t.Error("DecodeRawFrame returned the frame's own pixels")
+	}
+	if _, err := anim.DecodeRawFrame(1); err != ErrNilImage {
+		t.Errorf("empty frame: err = %v, want ErrNilImage", err)
+	}
+}
+
+func TestAnimDecoderDisposePrevious(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	green := color.NRGBA{G: 255, A: 255}
+
+	anim := &Animation{
+		CanvasWidth:  4,
+		CanvasHeight: 4,
+		Frames: []Frame{
+			{Image: solidNRGBA(4, 4, red), Duration: 50 * time.Millisecond, Blend: BlendNone, Dispose: DisposeNone},
+			{Image: solidNRGBA(2, 2, blue), Duration: 50 * time.Millisecond, Blend: BlendNone, Dispose: DisposePrevious},
+			{Image: solidNRGBA(2, 2, green), OffsetX: 2, OffsetY: 2, Duration: 50 * time.Millisecond, Blend: BlendNone, Dispose: DisposeNone},
+		},
+	}
+
+	dec, err := NewAnimDecoder(anim)
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+	dec.NextFrame() // frame 0: red
+	snap, _, err := dec.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if got := snap.NRGBAAt(1, 1); got != blue {
+		t.Errorf("frame 1 (1,1) = %v, want blue %v", got, blue)
+	}
+	snap, _, err = dec.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+
+	// Frame 1's region is restored to red before frame 2 is drawn.
+	if got := snap.NRGBAAt(1, 1); got != red {
+		t.Errorf("frame 2 (1,1) = %v, want red %v", got, red)
+	}
+	if got := snap.NRGBAAt(3, 3); got != green {
+		t.Errorf("frame 2 (3,3) = %v, want green %v", got, green)
+	}
+}
+
+func TestAnimDecoderDisposePreviousKeyframeSeek(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	green := color.NRGBA{G: 255, A: 255}
+
+	// Frame 1 covers the canvas opaquely, so it is a keyframe, but its
+	// dispose-previous hands frame 0's canvas on to frame 2.
+	anim := &Animation{
+		CanvasWidth:  4,
+		CanvasHeight: 4,
+		Frames: []Frame{
+			{Image: solidNRGBA(4, 4, red), Duration: 50 * time.Millisecond, Blend: BlendNone},
+			{Image: solidNRGBA(4, 4, blue), Duration: 50 * time.Millisecond, Blend: BlendNone, Dispose: DisposePrevious},
+			{Image: solidNRGBA(2, 2, green), OffsetX: 2, OffsetY: 2, Duration: 50 * time.Millisecond, Blend: BlendNone},
+		},
+	}
+
+	dec, err := NewAnimDecoder(anim)
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+	var want *image.NRGBA
+	for dec.HasNext() {
+		if want, _, err = dec.NextFrame(); err != nil {
+			t.Fatalf("NextFrame: %v", err)
+		}
+	}
+	if got := want.NRGBAAt(0, 0); got != red {
+		t.Errorf("frame 2 (0,0) = %v, want red %v", got, red)
+	}
+
+	if err := dec.Seek(2); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	got, _, err := dec.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame after Seek: %v", err)
+	}
+	if !bytes.Equal(got.Pix, want.Pix) {
+		t.Error("canvas after Seek(2) differs from sequential decode")
+	}
+}
+
 func TestAnimDecoderPartialFrame(t *testing.T) {
 	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
 	red := color.NRGBA{R: 255, A: 255}
@@ -348,6 +548,105 @@ func TestAnimDecoderReset(t *testing.T) {
 	}
 }
 
+// seekTestAnimation builds an animation mixing keyframes, partial alpha
+// sub-frames and dispose-to-background so that Seek has to replay state.
+func seekTestAnimation() *Animation {
+	red := color.NRGBA{R: 255, A: 255}
+	green := color.NRGBA{G: 255, A: 128}
+	blue := color.NRGBA{B: 255, A: 255}
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	d := 10 * time.Millisecond
+	return &Animation{
+		CanvasWidth:  8,
+		CanvasHeight: 8,
+		Frames: []Frame{
+			{Image: solidNRGBA(8, 8, red), Duration: d, Blend: BlendNone},
+			{Image: solidNRGBA(4, 4, green), OffsetX: 2, OffsetY: 2, Duration: d, HasAlpha: true},
+			{Image: solidNRGBA(2, 2, blue), OffsetX: 6, OffsetY: 0, Duration: d, Dispose: DisposeBackground},
+			{Image: solidNRGBA(4, 4, green), OffsetX: 4, OffsetY: 4, Duration: d, HasAlpha: true},
+			{Image: solidNRGBA(8, 8, white), Duration: d, Blend: BlendNone, Dispose: DisposeBackground},
+			{Image: solidNRGBA(2, 2, blue), OffsetX: 1, OffsetY: 1, Duration: d},
+			{Image: solidNRGBA(4, 4, green), OffsetX: 0, OffsetY: 4, Duration: d, HasAlpha: true},
+		},
+	}
+}
+
+func TestAnimDecoderSeekMatchesSequential(t *testing.T) {
+	anim := seekTestAnimation()
+
+	seq, err := NewAnimDecoder(anim)
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+	var want []*image.NRGBA
+	for seq.HasNext() {
+		snap, _, err := seq.NextFrame()
+		if err != nil {
+			t.Fatalf("NextFrame: %v", err)
+		}
+		want = append(want, snap)
+	}
+
+	dec, err := NewAnimDecoder(anim)
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+	// Seek in a non-monotonic order to make sure no stale state leaks.
+	for _, k := range []int{3, 0, 6, 1, 5, 2, 4, 6} {
+		if err := dec.Seek(k); err != nil {
+			t.Fatalf("Seek(%d): %v", k, err)
+		}
+		snap, dur, err := dec.NextFrame()
+		if err != nil {
+			t.Fatalf("NextFrame after Seek(%d): %v", k, err)
+		}
+		if dur != anim.Frames[k].Duration {
+			t.Errorf("Seek(%d): duration = %v, want %v", k, dur, anim.Frames[k].Duration)
+		}
+		if !bytes.Equal(snap.Pix, want[k].Pix) {
+			t.Errorf("Seek(%d): canvas differs from sequential decode", k)
+		}
+		// Continuing after a seek must also match sequential decoding.
+		if k+1 < len(want) {
+			next, _, err := dec.NextFrame()
+			if err != nil {
+				t.Fatalf("NextFrame after Seek(%d)+1: %v", k, err)
+			}
+			if !bytes.Equal(next.Pix, want[k+1].Pix) {
+				t.Errorf("Seek(%d)+1: canvas differs from sequential decode", k)
+			}
+		}
+	}
+}
+
+func TestAnimDecoderSeekOutOfRange(t *testing.T) {
+	dec, err := NewAnimDecoder(seekTestAnimation())
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+	for _, k := range []int{-1, 7, 100} {
+		if err := dec.Seek(k); err == nil {
+			t.Errorf("Seek(%d): expected error", k)
+		}
+	}
+}
+
+func TestAnimDecoderSeekNilImage(t *testing.T) {
+	anim := seekTestAnimation()
+	anim.Frames[1].Image = nil
+	dec, err := NewAnimDecoder(anim)
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+	if err := dec.Seek(3); err != ErrNilImage {
+		t.Errorf("Seek(3) = %v, want ErrNilImage", err)
+	}
+	// Frame 4 is a full opaque keyframe, so frame 1 is never replayed.
+	if err := dec.Seek(5); err != nil {
+		t.Errorf("Seek(5): %v", err)
+	}
+}
+
 // --- Animation.TotalDuration ---
 
 func TestTotalDuration(t *testing.T) {
@@ -365,6 +664,65 @@ func TestTotalDuration(t *testing.T) {
 	}
 }
 
+func TestTotalDurationZero(t *testing.T) {
+	anim := &Animation{Frames: []Frame{{}, {}}}
+	if got := anim.TotalDuration(); got != 0 {
+		t.Errorf("TotalDuration = %v, want 0", got)
+	}
+}
+
+func TestFrameAtTime(t *testing.T) {
+	ms := time.Millisecond
+	anim := &Animation{
+		LoopCount: 2,
+		Frames: []Frame{
+			{Duration: 100 * ms},
+			{Duration: 0}, // treated as 100ms
+			{Duration: 50 * ms},
+		},
+	}
+	tests := []struct {
+		t    time.Duration
+		want int
+	}{
+		{0, 0},
+		{99 * ms, 0},
+		{100 * ms, 1},
+		{199 * ms, 1},
+		{200 * ms, 2},
+		{249 * ms, 2},
+		{250 * ms, 0}, // second loop
+		{450 * ms, 2},
+		{500 * ms, 2}, // loops exhausted: stay on last frame
+		{time.Hour, 2},
+	}
+	for _, tt := range tests {
+		got, err := anim.FrameAtTime(tt.t)
+		if err != nil {
+			t.Fatalf("FrameAtTime(%v): %v", tt.t, err)
+		}
+		if got != tt.want {
+			t.Errorf("FrameAtTime(%v) = %d, want %d", tt.t, got, tt.want)
+		}
+	}
+
+	// Infinite looping wraps forever.
+	anim.LoopCount = 0
+	if got, _ := anim.FrameAtTime(10*250*ms + 120*ms); got != 1 {
+		t.Errorf("infinite loop: FrameAtTime = %d, want 1", got)
+	}
+}
+
+func TestFrameAtTimeErrors(t *testing.T) {
+	if _, err := (&Animation{}).FrameAtTime(0); err != ErrNoFrames {
+		t.Errorf("no frames: err = %v, want ErrNoFrames", err)
+	}
+	anim := &Animation{Frames: []Frame{{Duration: time.Second}}}
+	if _, err := anim.FrameAtTime(-time.Millisecond); err == nil {
+		t.Error("negative time: expected error")
+	}
+}
+
 // --- Color conversion tests ---
 
 func TestArgbToNRGBA(t *testing.T) {
@@ -427,6 +785,39 @@ func makeVP8Keyframe(width, height int) []byte {
 	return data
 }
 
+func TestDecodeFrameCodec(t *testing.T) {
+	// Minimal VP8L header: signature byte, then 14-bit width-1 and height-1.
+	vp8l := make([]byte, 5)
+	vp8l[0] = 0x2f
+	binary.LittleEndian.PutUint32(vp8l[1:], uint32(16-1)|uint32(16-1)<<14)
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, 16, 16, nil)
+	for i, data := range [][]byte{makeVP8Keyframe(16, 16), vp8l, makeVP8Keyframe(16, 16)} {
+		if err := enc.AddFrame(NewBitstreamFrame(data, 16, 16), 100*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame %d: %v", i, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	anim, err := DecodeBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("DecodeBytes: %v", err)
+	}
+	want := []Codec{CodecLossy, CodecLossless, CodecLossy}
+	for i, f := range anim.Frames {
+		if f.Codec != want[i] {
+			t.Errorf("frame %d: Codec = %v, want %v", i, f.Codec, want[i])
+		}
+	}
+	h := anim.CodecHistogram()
+	if h[CodecLossy] != 2 || h[CodecLossless] != 1 || len(h) != 2 {
+		t.Errorf("CodecHistogram = %v, want 2 lossy and 1 lossless", h)
+	}
+}
+
 func writeChunkHeader(buf []byte, id uint32, size uint32) {
 	binary.LittleEndian.PutUint32(buf[0:4], id)
 	binary.LittleEndian.PutUint32(buf[4:8], size)
@@ -667,6 +1058,76 @@ func TestAnimEncoderMetadata(t *testing.T) {
 	}
 }
 
+func TestAnimEncoderReset(t *testing.T) {
+	oldFunc := FrameEncoderFunc
+	defer func() { FrameEncoderFunc = oldFunc }()
+	FrameEncoderFunc = sizeFrameEncoder
+
+	encodeB := func(enc *AnimEncoder) {
+		for i := 0; i < 4; i++ {
+			img := solidNRGBA(24, 16, color.NRGBA{A: 255})
+			fillRect(img, image.Rect(4*i, 2, 4*i+6, 8), color.NRGBA{R: 200, A: 255})
+			if err := enc.AddFrame(img, 40*time.Millisecond); err != nil {
+				t.Fatalf("AddFrame %d: %v", i, err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+	optsB := &EncodeOptions{Quality: 60, LoopCount: 2, Kmax: 3}
+
+	var want bytes.Buffer
+	encodeB(NewEncoder(&want, 24, 16, optsB))
+
+	for _, parallel := range []bool{false, true} {
+		var a bytes.Buffer
+		enc := NewEncoder(&a, 10, 10, &EncodeOptions{Quality: 90, Parallel: parallel})
+		enc.SetICCProfile([]byte("icc"))
+		for i := 0; i < 3; i++ {
+			if err := enc.AddFrame(solidNRGBA(10, 10, color.NRGBA{G: uint8(60 * i), A: 255}), time.Second); err != nil {
+				t.Fatalf("AddFrame: %v", err)
+			}
+		}
+		if err := enc.Reset(&bytes.Buffer{}, 24, 16, optsB); err == nil {
+			t.Error("Reset before Close should error")
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		var got bytes.Buffer
+		if err := enc.Reset(&got, 24, 16, optsB); err != nil {
+			t.Fatalf("Reset: %v", err)
+		}
+		encodeB(enc)
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("parallel=%v: output after Reset (%d bytes) differs from a new encoder (%d bytes)",
+				parallel, got.Len(), want.Len())
+		}
+	}
+}
+
+func TestAnimEncoderResetErrors(t *testing.T) {
+	enc := NewEncoder(&bytes.Buffer{}, 10, 10, nil)
+	// A fresh encoder can be reset without Close.
+	if err := enc.Reset(&bytes.Buffer{}, 20, 20, nil); err != nil {
+		t.Errorf("Reset of unused encoder: %v", err)
+	}
+	if err := enc.Reset(&bytes.Buffer{}, 0, 20, nil); err == nil {
+		t.Error("Reset with zero width should error")
+	}
+	if err := enc.Reset(&bytes.Buffer{}, 20, maxCanvasDimension+1, nil); err == nil {
+		t.Error("Reset with oversized height should error")
+	}
+	if err := enc.AddRawFrame(makeVP8Keyframe(10, 10), 10*time.Millisecond, 0, 0, BlendAlpha, DisposeNone); err != nil {
+		t.Fatalf("AddRawFrame: %v", err)
+	}
+	if err := enc.Reset(&bytes.Buffer{}, 20, 20, nil); err == nil {
+		t.Error("Reset after AddRawFrame without Close should error")
+	}
+}
+
 // --- Bounds clamping tests (DIFF-AN9) ---
 
 func TestNewEncoderLoopCountClamping(t *testing.T) {
@@ -1173,6 +1634,65 @@ func TestExtractSubImage(t *testing.T) {
 	}
 }
 
+func TestChangedRect(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	// Views into larger images: non-zero origin and differing strides.
+	prev := solidNRGBA(20, 20, red).SubImage(image.Rect(5, 4, 15, 12)).(*image.NRGBA)
+	cur := image.NewNRGBA(image.Rect(5, 4, 15, 12))
+	for y := 4; y < 12; y++ {
+		for x := 5; x < 15; x++ {
+			cur.SetNRGBA(x, y, red)
+		}
+	}
+	if r := ChangedRect(prev, cur); !r.Empty() {
+		t.Errorf("ChangedRect(identical) = %v, want empty", r)
+	}
+	cur.SetNRGBA(7, 9, blue)
+	cur.SetNRGBA(9, 10, blue)
+	want := image.Rect(7, 9, 10, 11)
+	if r := ChangedRect(prev, cur); r != want {
+		t.Errorf("ChangedRect = %v, want %v", r, want)
+	}
+	if r := SnapToEven(ChangedRect(prev, cur)); r != image.Rect(6, 8, 10, 11) {
+		t.Errorf("SnapToEven(ChangedRect) = %v, want (6,8)-(10,11)", r)
+	}
+
+	if r := ChangedRect(nil, cur); r != cur.Bounds() {
+		t.Errorf("ChangedRect(nil) = %v, want %v", r, cur.Bounds())
+	}
+	if r := ChangedRect(solidNRGBA(10, 8, red), cur); r != cur.Bounds() {
+		t.Errorf("ChangedRect(other bounds) = %v, want %v", r, cur.Bounds())
+	}
+}
+
+func TestSubImage(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	src := solidNRGBA(10, 10, red).SubImage(image.Rect(2, 2, 10, 10)).(*image.NRGBA)
+	src.SetNRGBA(4, 5, blue)
+
+	sub := SubImage(src, image.Rect(4, 5, 20, 7))
+	if got := sub.Bounds(); got != image.Rect(0, 0, 6, 2) {
+		t.Fatalf("SubImage bounds = %v, want (0,0)-(6,2) after clipping", got)
+	}
+	if got := sub.NRGBAAt(0, 0); got != blue {
+		t.Errorf("SubImage(0,0) = %v, want blue", got)
+	}
+	if got := sub.NRGBAAt(1, 1); got != red {
+		t.Errorf("SubImage(1,1) = %v, want red", got)
+	}
+	sub.SetNRGBA(1, 1, blue)
+	if got := src.NRGBAAt(5, 6); got != red {
+		t.Error("SubImage shares pixels with src")
+	}
+
+	if got := SubImage(src, image.Rect(0, 0, 2, 2)).Bounds(); !got.Empty() {
+		t.Errorf("SubImage outside src = %v, want empty", got)
+	}
+}
+
 func TestCloneNRGBA(t *testing.T) {
 	red := color.NRGBA{R: 255, A: 255}
 	src := solidNRGBA(4, 4, red)
@@ -1257,6 +1777,64 @@ func TestOptimizedEncoder_SubFrameDetection(t *testing.T) {
 	}
 }
 
+func TestAddKeyframe(t *testing.T) {
+	oldFunc := FrameEncoderFunc
+	defer func() { FrameEncoderFunc = oldFunc }()
+	FrameEncoderFunc = sizeFrameEncoder
+
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	for _, parallel := range []bool{false, true} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf, 100, 100, &EncodeOptions{Quality: 75, Kmax: 3, Parallel: parallel})
+
+		// Each frame adds one blue pixel to a red canvas; frame 3 repeats
+		// frame 2 exactly.
+		canvas := solidNRGBA(100, 100, red)
+		for i := 0; i < 7; i++ {
+			var err error
+			switch i {
+			case 2, 3:
+				if i == 2 {
+					canvas.SetNRGBA(2*i, 2*i, blue)
+				}
+				err = enc.AddKeyframe(canvas, 50*time.Millisecond)
+			default:
+				canvas.SetNRGBA(2*i, 2*i, blue)
+				err = enc.AddFrame(canvas, 50*time.Millisecond)
+			}
+			if err != nil {
+				t.Fatalf("frame %d: %v", i, err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		dmx, err := mux.NewDemuxer(buf.Bytes())
+		if err != nil {
+			t.Fatalf("NewDemuxer: %v", err)
+		}
+		if dmx.NumFrames() != 7 {
+			t.Fatalf("parallel=%v: got %d frames, want 7 (AddKeyframe must not merge)", parallel, dmx.NumFrames())
+		}
+		// Frames 0, 2 and 3 are keyframes. The keyframe at frame 3 restarts
+		// the Kmax count, so the next forced keyframe is frame 6.
+		for i := 0; i < 7; i++ {
+			fi, err := dmx.Frame(i)
+			if err != nil {
+				t.Fatalf("Frame %d: %v", i, err)
+			}
+			full := fi.Width == 100 && fi.Height == 100
+			wantFull := i == 0 || i == 2 || i == 3 || i == 6
+			if full != wantFull {
+				t.Errorf("parallel=%v: frame %d is %dx%d, full canvas = %v, want %v",
+					parallel, i, fi.Width, fi.Height, full, wantFull)
+			}
+		}
+	}
+}
+
 func TestOptimizedEncoder_IdenticalFrames(t *testing.T) {
 	// When consecutive frames are pixel-identical, the encoder should merge
 	// them by extending the previous frame's duration instead of encoding a
@@ -1469,6 +2047,177 @@ func TestOptimizedEncoder_KeyframePolicy(t *testing.T) {
 	}
 }
 
+func TestOptimizedEncoder_ForceKeyframes(t *testing.T) {
+	oldFunc := FrameEncoderFunc
+	defer func() { FrameEncoderFunc = oldFunc }()
+
+	mock := &mockFrameEncoder{}
+	FrameEncoderFunc = mock.encode
+
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, 50, 50, &EncodeOptions{
+		Quality:        75,
+		Kmax:           10,
+		ForceKeyframes: true,
+		DefaultDispose: DisposeBackground,
+	})
+	const numFrames = 5
+	for i := 0; i < numFrames; i++ {
+		f := solidNRGBA(50, 50, red)
+		f.SetNRGBA(10*i, 10*i, blue)
+		if err := enc.AddFrame(f, 50*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame %d: %v", i, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	dmx, err := mux.NewDemuxer(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NewDemuxer: %v", err)
+	}
+	if dmx.NumFrames() != numFrames {
+		t.Fatalf("got %d frames, want %d", dmx.NumFrames(), numFrames)
+	}
+	for i := 0; i < numFrames; i++ {
+		fi, err := dmx.Frame(i)
+		if err != nil {
+			t.Fatalf("Frame %d: %v", i, err)
+		}
+		// A full-canvas frame drawn without blending is a keyframe.
+		if fi.OffsetX != 0 || fi.OffsetY != 0 || fi.Width != 50 || fi.Height != 50 || fi.BlendMode != mux.BlendNone {
+			t.Errorf("frame %d: %dx%d+%d+%d blend=%d, want full-canvas keyframe",
+				i, fi.Width, fi.Height, fi.OffsetX, fi.OffsetY, fi.BlendMode)
+		}
+		if fi.DisposeMode != mux.DisposeBackground {
+			t.Errorf("frame %d: dispose = %d, want DisposeBackground", i, fi.DisposeMode)
+		}
+	}
+}
+
+func TestEncoderDefaultDisposeBlend(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, 16, 16, &EncodeOptions{
+		DefaultDispose: DisposeBackground,
+		DefaultBlend:   BlendNone,
+	})
+	for i := 0; i < 2; i++ {
+		if err := enc.AddFrame(NewBitstreamFrame(makeVP8Keyframe(16, 16), 16, 16), 100*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame %d: %v", i, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	anim, err := DecodeBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("DecodeBytes: %v", err)
+	}
+	for i, f := range anim.Frames {
+		if f.Dispose != DisposeBackground || f.Blend != BlendNone {
+			t.Errorf("frame %d: dispose=%d blend=%d, want DisposeBackground/BlendNone", i, f.Dispose, f.Blend)
+		}
+	}
+}
+
+func TestOptimizedEncoder_DefaultDisposeOverridden(t *testing.T) {
+	// Without ForceKeyframes the encoder picks the previous frame's dispose
+	// method per sub-frame, so DefaultDispose is only a starting value.
+	oldFunc := FrameEncoderFunc
+	defer func() { FrameEncoderFunc = oldFunc }()
+
+	mock := &mockFrameEncoder{}
+	FrameEncoderFunc = mock.encode
+
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, 50, 50, &EncodeOptions{Quality: 75, DefaultDispose: DisposeBackground})
+	enc.AddFrame(solidNRGBA(50, 50, red), 50*time.Millisecond)
+	f1 := solidNRGBA(50, 50, red)
+	f1.SetNRGBA(10, 10, blue)
+	enc.AddFrame(f1, 50*time.Millisecond)
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	anim, err := DecodeBytes(buf.Bytes())
+	if err != nil {
+		t.Fatalf("DecodeBytes: %v", err)
+	}
+	if len(anim.Frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(anim.Frames))
+	}
+	// Clearing the full-canvas keyframe would force a full-canvas sub-frame,
+	// so the small dispose-none candidate wins.
+	if anim.Frames[0].Dispose != DisposeNone {
+		t.Errorf("frame 0: dispose = %d, want DisposeNone", anim.Frames[0].Dispose)
+	}
+	if anim.Frames[1].Dispose != DisposeBackground {
+		t.Errorf("frame 1: dispose = %d, want DefaultDispose", anim.Frames[1].Dispose)
+	}
+}
+
+func TestOptimizedEncoder_DurationOverflowDispose(t *testing.T) {
+	// A repeated frame whose merged duration overflows the 24-bit field is
+	// split into the frame, capped, and a 1x1 filler for the remainder. The
+	// frame's DisposeBackground must move to the filler: left on the frame,
+	// it would clear the canvas while the filler is still showing it.
+	oldFunc := FrameEncoderFunc
+	defer func() { FrameEncoderFunc = oldFunc }()
+
+	mock := &mockFrameEncoder{}
+	FrameEncoderFunc = mock.encode
+
+	red := color.NRGBA{R: 255, A: 255}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, 50, 50, &EncodeOptions{
+		Quality:        75,
+		ForceKeyframes: true,
+		DefaultDispose: DisposeBackground,
+	})
+	if err := enc.AddFrame(solidNRGBA(50, 50, red), 100*time.Millisecond); err != nil {
+		t.Fatalf("AddFrame 0: %v", err)
+	}
+	if err := enc.AddFrame(solidNRGBA(50, 50, red), maxDuration*time.Millisecond); err != nil {
+		t.Fatalf("AddFrame 1: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	dmx, err := mux.NewDemuxer(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NewDemuxer: %v", err)
+	}
+	if dmx.NumFrames() != 2 {
+		t.Fatalf("got %d frames, want 2", dmx.NumFrames())
+	}
+	want := []struct {
+		duration int
+		dispose  mux.DisposeMode
+	}{
+		{maxDuration, mux.DisposeNone},
+		{100, mux.DisposeBackground},
+	}
+	for i, w := range want {
+		fi, err := dmx.Frame(i)
+		if err != nil {
+			t.Fatalf("Frame %d: %v", i, err)
+		}
+		if fi.Duration != w.duration || fi.DisposeMode != w.dispose {
+			t.Errorf("frame %d: duration=%d dispose=%d, want duration=%d dispose=%d",
+				i, fi.Duration, fi.DisposeMode, w.duration, w.dispose)
+		}
+	}
+}
+
 func TestOptimizedEncoder_Roundtrip(t *testing.T) {
 	oldFunc := FrameEncoderFunc
 	defer func() { FrameEncoderFunc = oldFunc }()
@@ -2452,3 +3201,233 @@ func buildSimpleRIFF(vp8Data []byte) []byte {
 	copy(buf[20:], vp8Data)
 	return buf
 }
+
+// --- EncodeAll tests ---
+
+func TestEncodeAll(t *testing.T) {
+	oldFunc := FrameEncoderFunc
+	defer func() { FrameEncoderFunc = oldFunc }()
+
+	mock := &mockFrameEncoder{}
+	FrameEncoderFunc = mock.encode
+
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	// Frame 1 is a 10x10 sub-rectangle patch placed at (50,50); frame 2
+	// repeats the composed canvas and should be merged into frame 1.
+	patch := image.NewNRGBA(image.Rect(50, 50, 60, 60))
+	for i := 0; i < len(patch.Pix); i += 4 {
+		copy(patch.Pix[i:], []uint8{blue.R, blue.G, blue.B, blue.A})
+	}
+	frames := []image.Image{solidNRGBA(100, 100, red), patch, patch}
+	delays := []time.Duration{50 * time.Millisecond, 30 * time.Millisecond, 20 * time.Millisecond}
+
+	var buf bytes.Buffer
+	if err := EncodeAll(&buf, frames, delays, &EncodeOptions{Quality: 75}); err != nil {
+		t.Fatalf("EncodeAll: %v", err)
+	}
+	if len(mock.calls) < 2 {
+		t.Fatalf("expected at least 2 encode calls, got %d", len(mock.calls))
+	}
+	if f1 := mock.calls[1]; f1.Dx() > 20 || f1.Dy() > 20 {
+		t.Errorf("frame 1 encoded as %dx%d, expected sub-frame <=20x20", f1.Dx(), f1.Dy())
+	}
+
+	anim, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if anim.CanvasWidth != 100 || anim.CanvasHeight != 100 {
+		t.Errorf("canvas = %dx%d, want 100x100", anim.CanvasWidth, anim.CanvasHeight)
+	}
+	if len(anim.Frames) != 2 {
+		t.Fatalf("expected 2 frames after merging, got %d", len(anim.Frames))
+	}
+	if anim.Frames[1].Duration != 50*time.Millisecond {
+		t.Errorf("merged frame duration = %v, want 50ms", anim.Frames[1].Duration)
+	}
+}
+
+func TestEncodeAllErrors(t *testing.T) {
+	oldFunc := FrameEncoderFunc
+	defer func() { FrameEncoderFunc = oldFunc }()
+	FrameEncoderFunc = (&mockFrameEncoder{}).encode
+
+	red := color.NRGBA{R: 255, A: 255}
+	ms := time.Millisecond
+	var buf bytes.Buffer
+
+	if err := EncodeAll(&buf, nil, nil, nil); err != ErrNoFrames {
+		t.Errorf("no frames: err = %v, want ErrNoFrames", err)
+	}
+	frames := []image.Image{solidNRGBA(10, 10, red), solidNRGBA(10, 10, red)}
+	if err := EncodeAll(&buf, frames, []time.Duration{ms}, nil); err == nil {
+		t.Error("mismatched delays: expected error")
+	}
+	frames[1] = solidNRGBA(12, 10, red)
+	if err := EncodeAll(&buf, frames, []time.Duration{ms, ms}, nil); !errors.Is(err, ErrFrameOutOfRect) {
+		t.Errorf("oversized frame: err = %v, want ErrFrameOutOfRect", err)
+	}
+	frames[1] = nil
+	if err := EncodeAll(&buf, frames, []time.Duration{ms, ms}, nil); !errors.Is(err, ErrNilImage) {
+		t.Errorf("nil frame: err = %v, want ErrNilImage", err)
+	}
+}
+
+// sizeFrameEncoder returns a VP8 header followed by padding that grows with
+// the number of non-transparent pixels, so candidate choices depend on frame
+// content. It is safe for concurrent use.
+func sizeFrameEncoder(img image.Image, lossless bool, quality int) ([]byte, error) {
+	nrgba := toNRGBA(img)
+	n := 0
+	for i := 3; i < len(nrgba.Pix); i += 4 {
+		if nrgba.Pix[i] != 0 {
+			n++
+		}
+	}
+	if lossless {
+		n += n/3 + 7
+	}
+	b := nrgba.Bounds()
+	return append(makeVP8Keyframe(b.Dx(), b.Dy()), make([]byte, n+quality/10)...), nil
+}
+
+// encodeParallelTestFrames encodes a sequence that exercises keyframes,
+// sub-frames, both dispose candidates, identical-frame merging, per-frame
+// options and a bitstream frame. reuse draws every frame into one buffer.
+func encodeParallelTestFrames(t *testing.T, opts EncodeOptions, reuse bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, 64, 48, &opts)
+	var img *image.NRGBA
+	for i := 0; i < 24; i++ {
+		if img == nil || !reuse {
+			img = image.NewNRGBA(image.Rect(0, 0, 64, 48))
+		}
+		for j := range img.Pix {
+			img.Pix[j] = 0
+		}
+		// A moving opaque square over a transparent background, which
+		// makes dispose-background the better candidate on some frames.
+		s := i / 2 // frames come in identical pairs
+		for y := 4 + s%10; y < 20+s%10; y++ {
+			for x := 2 * s; x < 2*s+14; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(10 * s), G: 200, A: 255})
+			}
+		}
+		if i%7 == 3 {
+			// A frame that repaints most of the canvas.
+			for y := 0; y < 48; y++ {
+				for x := 0; x < 60; x++ {
+					img.SetNRGBA(x, y, color.NRGBA{B: uint8(x * 4), A: 255})
+				}
+			}
+		}
+		var err error
+		switch {
+		case i == 17:
+			err = enc.AddFrame(NewBitstreamFrame(makeVP8Keyframe(64, 48), 64, 48), 30*time.Millisecond)
+		case i%5 == 4:
+			err = enc.AddFrameOpts(img, 30*time.Millisecond, &FrameOptions{Quality: 40, Lossless: true})
+		default:
+			err = enc.AddFrame(img, 30*time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncoderParallelMatchesSerial(t *testing.T) {
+	oldFunc := FrameEncoderFunc
+	defer func() { FrameEncoderFunc = oldFunc }()
+	FrameEncoderFunc = sizeFrameEncoder
+
+	for _, opts := range []EncodeOptions{
+		{Quality: 75},
+		{Quality: 75, AllowMixed: true, Kmin: 2, Kmax: 5},
+		{Quality: 30, Lossless: true, ForceKeyframes: true},
+	} {
+		want := encodeParallelTestFrames(t, opts, false)
+		popts := opts
+		popts.Parallel = true
+		for _, reuse := range []bool{false, true} {
+			if got := encodeParallelTestFrames(t, popts, reuse); !bytes.Equal(got, want) {
+				t.Errorf("%+v reuse=%v: Parallel output (%d bytes) differs from serial (%d bytes)",
+					opts, reuse, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestEncoderParallelError(t *testing.T) {
+	oldFunc := FrameEncoderFunc
+	defer func() { FrameEncoderFunc = oldFunc }()
+	errBoom := errors.New("boom")
+	FrameEncoderFunc = func(img image.Image, lossless bool, quality int) ([]byte, error) {
+		if quality == 13 {
+			return nil, errBoom
+		}
+		return sizeFrameEncoder(img, lossless, quality)
+	}
+
+	enc := NewEncoder(&bytes.Buffer{}, 16, 16, &EncodeOptions{Quality: 75, Parallel: true})
+	var firstErr error
+	for i := 0; i < 3; i++ {
+		fo := &FrameOptions{Quality: 75}
+		if i == 1 {
+			fo.Quality = 13
+		}
+		if err := enc.AddFrameOpts(solidNRGBA(16, 16, color.NRGBA{R: uint8(50 * i), A: 255}), time.Second, fo); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := enc.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if !errors.Is(firstErr, errBoom) {
+		t.Errorf("error = %v, want %v from AddFrame or Close", firstErr, errBoom)
+	}
+}
+
+func TestAddFrameOptsMethod(t *testing.T) {
+	oldFunc, oldMethodFunc := FrameEncoderFunc, FrameEncoderMethodFunc
+	defer func() { FrameEncoderFunc, FrameEncoderMethodFunc = oldFunc, oldMethodFunc }()
+	var methods []int
+	FrameEncoderFunc = func(img image.Image, lossless bool, quality int) ([]byte, error) {
+		methods = append(methods, -1)
+		return sizeFrameEncoder(img, lossless, quality)
+	}
+	FrameEncoderMethodFunc = func(img image.Image, lossless bool, quality, method int) ([]byte, error) {
+		methods = append(methods, method)
+		return sizeFrameEncoder(img, lossless, quality)
+	}
+
+	enc := NewEncoder(&bytes.Buffer{}, 16, 16, &EncodeOptions{Quality: 75, ForceKeyframes: true})
+	for i, fo := range []*FrameOptions{
+		nil,
+		{Quality: 80},             // Method unset: codec default.
+		{Quality: 80, Method: -1}, // Negative: codec default.
+		{Quality: 80, Method: 1},
+		{Quality: 80, Method: 6},
+	} {
+		img := solidNRGBA(16, 16, color.NRGBA{R: uint8(40 * i), A: 255})
+		if err := enc.AddFrameOpts(img, time.Second, fo); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+	}
+	if err := enc.AddFrameOpts(solidNRGBA(16, 16, color.NRGBA{A: 255}), time.Second, &FrameOptions{Quality: 80, Method: 7}); err == nil {
+		t.Error("Method 7: no error")
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if want := []int{-1, -1, -1, 1, 6}; !slices.Equal(methods, want) {
+		t.Errorf("methods = %v, want %v (-1 for the codec default)", methods, want)
+	}
+}
@@ -0,0 +1,459 @@
+package animation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"time"
+)
+
+// ErrNotAPNG is returned by FromAPNG when the input is a PNG without an
+// acTL chunk, i.e. a still image rather than an animation.
+var ErrNotAPNG = errors.New("animation: not an animated PNG")
+
+// pngSignature is the 8-byte PNG file signature.
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+// APNG frame control dispose and blend operations (fcTL).
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+
+	apngBlendSource = 0
+	apngBlendOver   = 1
+)
+
+// apngFrame is a frame parsed from an fcTL chunk and its image data.
+type apngFrame struct {
+	rect     image.Rectangle
+	duration time.Duration
+	dispose  byte
+	blend    byte
+	data     []byte // concatenated IDAT/fdAT payloads (zlib stream)
+}
+
+// FromAPNG reads an animated PNG from r and converts it to an Animation.
+//
+// APNG dispose and blend operations are mapped onto the WebP model:
+// APNG_DISPOSE_OP_NONE and APNG_DISPOSE_OP_BACKGROUND become DisposeNone and
+// DisposeBackground (both formats clear to transparent black), and
+// APNG_BLEND_OP_SOURCE and APNG_BLEND_OP_OVER become BlendNone and BlendAlpha.
+// WebP has no equivalent of APNG_DISPOSE_OP_PREVIOUS, and frame offsets must
+// be even. Where a frame cannot be expressed directly, FromAPNG widens it to
+// the region whose composited pixels differ and stores those pixels with
+// BlendNone, so the canvas reconstructed by AnimDecoder matches the APNG.
+//
+// The returned frames have decoded images and no bitstream data.
+func FromAPNG(r io.Reader) (*Animation, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxInputSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxInputSize {
+		return nil, fmt.Errorf("animation: input too large (exceeds %d bytes)", maxInputSize)
+	}
+	if len(data) < len(pngSignature) || !bytes.Equal(data[:len(pngSignature)], pngSignature) {
+		return nil, errors.New("animation: invalid PNG signature")
+	}
+
+	var (
+		ihdr      []byte
+		shared    [][]byte // PLTE/tRNS chunks (type + payload) shared by all frames
+		frames    []*apngFrame
+		cur       *apngFrame
+		hasACTL   bool
+		loopCount int
+		seenIDAT  bool
+	)
+
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if len(data)-pos < 12 {
+			return nil, errors.New("animation: truncated PNG chunk")
+		}
+		n := binary.BigEndian.Uint32(data[pos:])
+		if uint64(n) > uint64(len(data)-pos-12) {
+			return nil, errors.New("animation: truncated PNG chunk")
+		}
+		typ := string(data[pos+4 : pos+8])
+		body := data[pos+8 : pos+8+int(n)]
+		crc := binary.BigEndian.Uint32(data[pos+8+int(n):])
+		if crc32.ChecksumIEEE(data[pos+4:pos+8+int(n)]) != crc {
+			return nil, fmt.Errorf("animation: bad CRC in PNG %s chunk", typ)
+		}
+		pos += 12 + int(n)
+
+		switch typ {
+		case "IHDR":
+			if len(body) != 13 {
+				return nil, errors.New("animation: invalid IHDR chunk")
+			}
+			ihdr = body
+		case "PLTE", "tRNS":
+			if !seenIDAT {
+				shared = append(shared, data[pos-12-int(n)+4:pos-4])
+			}
+		case "acTL":
+			if len(body) != 8 {
+				return nil, errors.New("animation: invalid acTL chunk")
+			}
+			hasACTL = true
+			loopCount = clampLoopCount(int(binary.BigEndian.Uint32(body[4:])))
+		case "fcTL":
+			f, err := parseFCTL(body)
+			if err != nil {
+				return nil, err
+			}
+			if len(frames) >= maxAPNGFrames {
+				return nil, fmt.Errorf("animation: too many APNG frames (max %d)", maxAPNGFrames)
+			}
+			cur = f
+			frames = append(frames, cur)
+		case "IDAT":
+			seenIDAT = true
+			// The default image is only part of the animation when an
+			// fcTL chunk precedes it.
+			if cur != nil {
+				cur.data = append(cur.data, body...)
+			}
+		case "fdAT":
+			if len(body) < 4 {
+				return nil, errors.New("animation: invalid fdAT chunk")
+			}
+			if cur == nil {
+				return nil, errors.New("animation: fdAT chunk before fcTL")
+			}
+			cur.data = append(cur.data, body[4:]...)
+		case "IEND":
+			pos = len(data)
+		}
+	}
+
+	if ihdr == nil {
+		return nil, errors.New("animation: missing IHDR chunk")
+	}
+	if !hasACTL {
+		return nil, ErrNotAPNG
+	}
+	if len(frames) == 0 {
+		return nil, ErrNoFrames
+	}
+
+	canvasW := int(binary.BigEndian.Uint32(ihdr[0:]))
+	canvasH := int(binary.BigEndian.Uint32(ihdr[4:]))
+	if canvasW <= 0 || canvasH <= 0 || canvasW > maxCanvasDimension || canvasH > maxCanvasDimension {
+		return nil, ErrCanvasSize
+	}
+	canvasRect := image.Rect(0, 0, canvasW, canvasH)
+
+	anim := &Animation{
+		CanvasWidth:  canvasW,
+		CanvasHeight: canvasH,
+		LoopCount:    loopCount,
+	}
+	// sim replays the frames emitted so far with WebP semantics, so each new
+	// frame can be checked against what the WebP decoder will actually show.
+	sim, err := NewAnimDecoder(anim)
+	if err != nil {
+		return nil, err
+	}
+	// apngCanvas is the APNG canvas before the current frame is rendered.
+	apngCanvas := image.NewNRGBA(canvasRect)
+
+	for i, af := range frames {
+		if !af.rect.In(canvasRect) || af.rect.Empty() {
+			return nil, fmt.Errorf("animation: APNG frame %d %v outside canvas: %w", i, af.rect, ErrFrameOutOfRect)
+		}
+		img, err := decodeAPNGFrame(ihdr, shared, af)
+		if err != nil {
+			return nil, fmt.Errorf("animation: APNG frame %d: %w", i, err)
+		}
+		// The first frame's canvas has nothing to restore, so
+		// APNG_DISPOSE_OP_PREVIOUS is treated as BACKGROUND (per the spec).
+		if i == 0 && af.dispose == apngDisposePrevious {
+			af.dispose = apngDisposeBackground
+		}
+
+		src := &Frame{
+			Image:   img,
+			OffsetX: af.rect.Min.X,
+			OffsetY: af.rect.Min.Y,
+			Blend:   BlendAlpha,
+		}
+		if af.blend == apngBlendSource {
+			src.Blend = BlendNone
+		}
+
+		// Render the frame on the APNG canvas.
+		rendered := cloneNRGBA(apngCanvas)
+		compositeFrame(rendered, src)
+
+		// The frame can be emitted as-is only if the WebP canvas before it
+		// matches the APNG canvas and its offsets are even.
+		rect := af.rect
+		if i > 0 {
+			rect = rect.Union(findChangedRect(sim.prevFrameDisposed, apngCanvas))
+		}
+		rect = snapToEven(rect)
+
+		out := *src
+		out.Duration = af.duration
+		if rect != af.rect {
+			out.Image = extractSubImage(rendered, rect)
+			out.OffsetX = rect.Min.X
+			out.OffsetY = rect.Min.Y
+			out.Blend = BlendNone
+		}
+		if af.dispose == apngDisposeBackground {
+			out.Dispose = DisposeBackground
+		}
+		out.HasAlpha = !out.Image.(*image.NRGBA).Opaque()
+		out.IsKeyframe = i == 0
+
+		anim.Frames = append(anim.Frames, out)
+		if err := sim.advance(); err != nil {
+			return nil, err
+		}
+
+		// Apply the APNG dispose operation to get the next frame's canvas.
+		switch af.dispose {
+		case apngDisposeNone:
+			apngCanvas = rendered
+		case apngDisposeBackground:
+			fillRect(rendered, af.rect, color.NRGBA{})
+			apngCanvas = rendered
+		case apngDisposePrevious:
+			// apngCanvas already holds the canvas before this frame.
+		}
+	}
+
+	return anim, nil
+}
+
+// ToAPNG writes anim to w as an animated PNG, the inverse of FromAPNG.
+//
+// The canvas shown for each frame is reconstructed with AnimDecoder, so
+// WebP dispose and blend methods never need translating: every APNG frame
+// replaces the rectangle that changed since the previous canvas
+// (APNG_DISPOSE_OP_NONE, APNG_BLEND_OP_SOURCE), and a frame that changes
+// nothing is merged into the previous one by adding up their delays. The
+// first frame covers the whole canvas and doubles as the PNG default image,
+// so viewers without APNG support show it. Delays are written in
+// milliseconds, or in coarser units when that does not fit the 16-bit
+// numerator.
+func ToAPNG(w io.Writer, anim *Animation) error {
+	if anim == nil || len(anim.Frames) == 0 {
+		return ErrNoFrames
+	}
+	dec, err := NewAnimDecoder(anim)
+	if err != nil {
+		return err
+	}
+
+	type apngOut struct {
+		img      *image.NRGBA
+		rect     image.Rectangle
+		duration time.Duration
+	}
+	var (
+		frames []apngOut
+		prev   *image.NRGBA // canvas shown by the last frame in frames
+		opaque = true
+	)
+	for dec.HasNext() {
+		duration := anim.Frames[dec.pos].Duration
+		if err := dec.advance(); err != nil {
+			return err
+		}
+		canvas := dec.currFrame
+		rect := canvas.Bounds()
+		if prev != nil {
+			rect = findChangedRect(prev, canvas)
+			if rect.Empty() {
+				frames[len(frames)-1].duration += duration
+				continue
+			}
+		}
+		sub := extractSubImage(canvas, rect)
+		opaque = opaque && sub.Opaque()
+		frames = append(frames, apngOut{img: sub, rect: rect, duration: duration})
+		if prev == nil {
+			prev = cloneNRGBA(canvas)
+		} else {
+			copy(prev.Pix, canvas.Pix)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	seq := uint32(0)
+	for i, f := range frames {
+		ihdr, idat, err := encodeAPNGFrame(f.img, opaque)
+		if err != nil {
+			return fmt.Errorf("animation: APNG frame %d: %w", i, err)
+		}
+		if i == 0 {
+			writePNGChunk(&buf, "IHDR", ihdr)
+			actl := make([]byte, 8)
+			binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+			binary.BigEndian.PutUint32(actl[4:], uint32(anim.LoopCount))
+			writePNGChunk(&buf, "acTL", actl)
+		}
+
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		seq++
+		binary.BigEndian.PutUint32(fctl[4:], uint32(f.rect.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(f.rect.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(f.rect.Min.X))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(f.rect.Min.Y))
+		num, den := apngDelay(f.duration)
+		binary.BigEndian.PutUint16(fctl[20:], num)
+		binary.BigEndian.PutUint16(fctl[22:], den)
+		fctl[24] = apngDisposeNone
+		fctl[25] = apngBlendSource
+		writePNGChunk(&buf, "fcTL", fctl)
+
+		if i == 0 {
+			writePNGChunk(&buf, "IDAT", idat)
+			continue
+		}
+		fdat := make([]byte, 4, 4+len(idat))
+		binary.BigEndian.PutUint32(fdat, seq)
+		seq++
+		writePNGChunk(&buf, "fdAT", append(fdat, idat...))
+	}
+	writePNGChunk(&buf, "IEND", nil)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// apngDelay returns the fcTL delay fraction for d: milliseconds when they
+// fit in 16 bits, otherwise hundredths of a second or, at worst, seconds
+// (capped at 65535s).
+func apngDelay(d time.Duration) (num, den uint16) {
+	ms := max(d.Milliseconds(), 0)
+	switch {
+	case ms <= 0xFFFF:
+		return uint16(ms), 1000
+	case ms/10 <= 0xFFFF:
+		return uint16(ms / 10), 100
+	default:
+		return uint16(min(ms/1000, 0xFFFF)), 1
+	}
+}
+
+// apngImage fixes the color type png.Encode picks, since every frame of an
+// APNG shares the IHDR: 8-bit RGB if opaque, 8-bit RGBA otherwise.
+type apngImage struct {
+	*image.NRGBA
+	opaque bool
+}
+
+func (m apngImage) Opaque() bool { return m.opaque }
+
+// encodeAPNGFrame encodes img as PNG and returns its IHDR payload and the
+// concatenated IDAT payloads.
+func encodeAPNGFrame(img *image.NRGBA, opaque bool) (ihdr, idat []byte, err error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, apngImage{img, opaque}); err != nil {
+		return nil, nil, err
+	}
+	data := buf.Bytes()[len(pngSignature):]
+	for len(data) >= 12 {
+		n := int(binary.BigEndian.Uint32(data))
+		switch string(data[4:8]) {
+		case "IHDR":
+			ihdr = data[8 : 8+n]
+		case "IDAT":
+			idat = append(idat, data[8:8+n]...)
+		}
+		data = data[12+n:]
+	}
+	return ihdr, idat, nil
+}
+
+// maxAPNGFrames caps the number of APNG frames accepted by FromAPNG.
+const maxAPNGFrames = 10000
+
+// parseFCTL parses the payload of an APNG fcTL chunk.
+func parseFCTL(body []byte) (*apngFrame, error) {
+	if len(body) != 26 {
+		return nil, errors.New("animation: invalid fcTL chunk")
+	}
+	w := binary.BigEndian.Uint32(body[4:])
+	h := binary.BigEndian.Uint32(body[8:])
+	x := binary.BigEndian.Uint32(body[12:])
+	y := binary.BigEndian.Uint32(body[16:])
+	if w == 0 || h == 0 || w > maxCanvasDimension || h > maxCanvasDimension ||
+		x > maxCanvasDimension || y > maxCanvasDimension {
+		return nil, errors.New("animation: invalid fcTL frame region")
+	}
+	dispose := body[24]
+	blend := body[25]
+	if dispose > apngDisposePrevious || blend > apngBlendOver {
+		return nil, errors.New("animation: invalid fcTL dispose or blend op")
+	}
+
+	// The delay is delay_num/delay_den seconds; a zero denominator means 1/100s.
+	num := int64(binary.BigEndian.Uint16(body[20:]))
+	den := int64(binary.BigEndian.Uint16(body[22:]))
+	if den == 0 {
+		den = 100
+	}
+	return &apngFrame{
+		rect:     image.Rect(int(x), int(y), int(x+w), int(y+h)),
+		duration: time.Duration(num * int64(time.Second) / den).Truncate(time.Millisecond),
+		dispose:  dispose,
+		blend:    blend,
+	}, nil
+}
+
+// decodeAPNGFrame decodes one APNG frame by wrapping its image data in a
+// standalone PNG stream sharing the file's IHDR, PLTE and tRNS chunks.
+func decodeAPNGFrame(ihdr []byte, shared [][]byte, f *apngFrame) (*image.NRGBA, error) {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+
+	hdr := make([]byte, len(ihdr))
+	copy(hdr, ihdr)
+	binary.BigEndian.PutUint32(hdr[0:], uint32(f.rect.Dx()))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(f.rect.Dy()))
+	writePNGChunk(&buf, "IHDR", hdr)
+	for _, c := range shared {
+		writePNGChunk(&buf, string(c[:4]), c[4:])
+	}
+	writePNGChunk(&buf, "IDAT", f.data)
+	writePNGChunk(&buf, "IEND", nil)
+
+	img, err := png.Decode(&buf)
+	if err != nil {
+		return nil, err
+	}
+	nrgba := toNRGBA(img)
+	if nrgba.Bounds().Min != (image.Point{}) {
+		nrgba = extractSubImage(nrgba, nrgba.Bounds())
+	}
+	return nrgba, nil
+}
+
+// writePNGChunk writes a PNG chunk with its length and CRC to buf.
+func writePNGChunk(buf *bytes.Buffer, typ string, body []byte) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(len(body)))
+	buf.Write(b[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(body)
+	buf.WriteString(typ)
+	buf.Write(body)
+	binary.BigEndian.PutUint32(b[:], crc.Sum32())
+	buf.Write(b[:])
+}
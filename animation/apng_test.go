@@ -0,0 +1,313 @@
+package animation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// apngTestFrame describes one frame of a synthetic APNG.
+type apngTestFrame struct {
+	img            *image.NRGBA
+	x, y           int
+	delayNum       uint16
+	delayDen       uint16
+	dispose, blend byte
+}
+
+// forceAlpha makes png.Encode always write 8-bit RGBA, matching the IHDR
+// written by buildAPNG.
+type forceAlpha struct{ *image.NRGBA }
+
+func (forceAlpha) Opaque() bool { return false }
+
+// pngIDAT encodes img as PNG and returns the concatenated IDAT payloads.
+func pngIDAT(t *testing.T, img *image.NRGBA) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, forceAlpha{img}); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	data := buf.Bytes()[len(pngSignature):]
+	var idat []byte
+	for len(data) >= 12 {
+		n := int(binary.BigEndian.Uint32(data))
+		if string(data[4:8]) == "IDAT" {
+			idat = append(idat, data[8:8+n]...)
+		}
+		data = data[12+n:]
+	}
+	return idat
+}
+
+// buildAPNG assembles an APNG file whose first frame is the default image.
+func buildAPNG(t *testing.T, w, h int, plays uint32, frames []apngTestFrame) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(h))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // RGBA
+	writePNGChunk(&buf, "IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+	binary.BigEndian.PutUint32(actl[4:], plays)
+	writePNGChunk(&buf, "acTL", actl)
+
+	seq := uint32(0)
+	for i, f := range frames {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		seq++
+		b := f.img.Bounds()
+		binary.BigEndian.PutUint32(fctl[4:], uint32(b.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(b.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(f.x))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(f.y))
+		binary.BigEndian.PutUint16(fctl[20:], f.delayNum)
+		binary.BigEndian.PutUint16(fctl[22:], f.delayDen)
+		fctl[24] = f.dispose
+		fctl[25] = f.blend
+		writePNGChunk(&buf, "fcTL", fctl)
+
+		idat := pngIDAT(t, f.img)
+		if i == 0 {
+			writePNGChunk(&buf, "IDAT", idat)
+			continue
+		}
+		fdat := make([]byte, 4, 4+len(idat))
+		binary.BigEndian.PutUint32(fdat, seq)
+		seq++
+		writePNGChunk(&buf, "fdAT", append(fdat, idat...))
+	}
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+// renderAPNG composites frames with APNG semantics, returning the canvas
+// displayed for each frame.
+func renderAPNG(w, h int, frames []apngTestFrame) []*image.NRGBA {
+	canvas := image.NewNRGBA(image.Rect(0, 0, w, h))
+	var out []*image.NRGBA
+	for _, f := range frames {
+		prev := cloneNRGBA(canvas)
+		fr := &Frame{Image: f.img, OffsetX: f.x, OffsetY: f.y}
+		if f.blend == apngBlendSource {
+			fr.Blend = BlendNone
+		}
+		compositeFrame(canvas, fr)
+		out = append(out, cloneNRGBA(canvas))
+		switch f.dispose {
+		case apngDisposeBackground:
+			fillRect(canvas, fr.Bounds(), color.NRGBA{})
+		case apngDisposePrevious:
+			canvas = prev
+		}
+	}
+	return out
+}
+
+func TestFromAPNG(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	frames := []apngTestFrame{
+		{img: solidNRGBA(8, 8, red), delayNum: 1, delayDen: 10},
+		{img: solidNRGBA(4, 4, blue), x: 2, y: 2, delayNum: 25, delayDen: 0,
+			dispose: apngDisposeBackground, blend: apngBlendOver},
+	}
+	anim, err := FromAPNG(bytes.NewReader(buildAPNG(t, 8, 8, 3, frames)))
+	if err != nil {
+		t.Fatalf("FromAPNG: %v", err)
+	}
+	if anim.CanvasWidth != 8 || anim.CanvasHeight != 8 {
+		t.Errorf("canvas = %dx%d, want 8x8", anim.CanvasWidth, anim.CanvasHeight)
+	}
+	if anim.LoopCount != 3 {
+		t.Errorf("LoopCount = %d, want 3", anim.LoopCount)
+	}
+	if len(anim.Frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(anim.Frames))
+	}
+	f0, f1 := anim.Frames[0], anim.Frames[1]
+	if f0.Duration != 100*time.Millisecond || f0.Blend != BlendNone || f0.Dispose != DisposeNone {
+		t.Errorf("frame 0 = {%v, blend %d, dispose %d}, want {100ms, BlendNone, DisposeNone}", f0.Duration, f0.Blend, f0.Dispose)
+	}
+	if f1.Duration != 250*time.Millisecond || f1.Blend != BlendAlpha || f1.Dispose != DisposeBackground {
+		t.Errorf("frame 1 = {%v, blend %d, dispose %d}, want {250ms, BlendAlpha, DisposeBackground}", f1.Duration, f1.Blend, f1.Dispose)
+	}
+	if f1.OffsetX != 2 || f1.OffsetY != 2 || f1.Bounds().Dx() != 4 {
+		t.Errorf("frame 1 bounds = %v, want (2,2)-(6,6)", f1.Bounds())
+	}
+}
+
+func TestFromAPNGCompositing(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	green := color.NRGBA{G: 255, A: 128}
+	blue := color.NRGBA{B: 255, A: 255}
+	// Odd offsets and APNG_DISPOSE_OP_PREVIOUS have no direct WebP
+	// equivalent and must be emulated.
+	frames := []apngTestFrame{
+		{img: solidNRGBA(9, 7, red), delayNum: 1, delayDen: 10},
+		{img: solidNRGBA(3, 3, blue), x: 1, y: 1, delayNum: 1, delayDen: 10,
+			dispose: apngDisposePrevious, blend: apngBlendOver},
+		{img: solidNRGBA(4, 4, green), x: 3, y: 3, delayNum: 1, delayDen: 10,
+			dispose: apngDisposeBackground, blend: apngBlendOver},
+		{img: solidNRGBA(2, 5, blue), x: 5, y: 0, delayNum: 1, delayDen: 10,
+			dispose: apngDisposePrevious, blend: apngBlendSource},
+		{img: solidNRGBA(3, 2, green), x: 0, y: 5, delayNum: 1, delayDen: 10,
+			blend: apngBlendOver},
+	}
+	want := renderAPNG(9, 7, frames)
+
+	anim, err := FromAPNG(bytes.NewReader(buildAPNG(t, 9, 7, 0, frames)))
+	if err != nil {
+		t.Fatalf("FromAPNG: %v", err)
+	}
+	dec, err := NewAnimDecoder(anim)
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+	for i := range want {
+		f := &anim.Frames[i]
+		if f.OffsetX%2 != 0 || f.OffsetY%2 != 0 {
+			t.Errorf("frame %d has odd offset (%d,%d)", i, f.OffsetX, f.OffsetY)
+		}
+		snap, _, err := dec.NextFrame()
+		if err != nil {
+			t.Fatalf("NextFrame %d: %v", i, err)
+		}
+		if !bytes.Equal(snap.Pix, want[i].Pix) {
+			t.Errorf("frame %d: canvas differs from APNG composite", i)
+		}
+	}
+}
+
+func TestFromAPNGErrors(t *testing.T) {
+	var still bytes.Buffer
+	if err := png.Encode(&still, solidNRGBA(4, 4, color.NRGBA{A: 255})); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	if _, err := FromAPNG(bytes.NewReader(still.Bytes())); err != ErrNotAPNG {
+		t.Errorf("still PNG: err = %v, want ErrNotAPNG", err)
+	}
+	if _, err := FromAPNG(bytes.NewReader([]byte("not a png"))); err == nil {
+		t.Error("garbage input: expected error")
+	}
+
+	data := buildAPNG(t, 4, 4, 0, []apngTestFrame{{img: solidNRGBA(4, 4, color.NRGBA{A: 255})}})
+	data[len(pngSignature)+20] ^= 0xff // corrupt the IHDR CRC
+	if _, err := FromAPNG(bytes.NewReader(data)); err == nil {
+		t.Error("bad CRC: expected error")
+	}
+}
+
+func TestToAPNG(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 128}
+	still := solidNRGBA(2, 2, color.NRGBA{})
+	anim := &Animation{
+		CanvasWidth:  12,
+		CanvasHeight: 10,
+		LoopCount:    2,
+		Frames: []Frame{
+			{Image: solidNRGBA(12, 10, red), Duration: 100 * time.Millisecond, Blend: BlendNone},
+			// Blends nothing over the canvas: merged into the first frame.
+			{Image: still, OffsetX: 10, OffsetY: 8, Duration: 30 * time.Millisecond},
+			{Image: solidNRGBA(4, 4, blue), OffsetX: 2, OffsetY: 4, Duration: 70 * time.Millisecond, Dispose: DisposeBackground},
+			{Image: solidNRGBA(3, 1, red), OffsetX: 8, OffsetY: 0, Duration: 90 * time.Second, Blend: BlendNone},
+		},
+	}
+	var want []*image.NRGBA
+	dec, err := NewAnimDecoder(anim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dec.HasNext() {
+		canvas, _, err := dec.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, canvas)
+	}
+
+	var buf bytes.Buffer
+	if err := ToAPNG(&buf, anim); err != nil {
+		t.Fatalf("ToAPNG: %v", err)
+	}
+
+	// The fcTL chunks carry the delays; the merged frame adds its 30ms.
+	// 90s does not fit 16-bit milliseconds and is written in 1/100s.
+	var delays [][2]uint16
+	for data := buf.Bytes()[len(pngSignature):]; len(data) >= 12; {
+		n := int(binary.BigEndian.Uint32(data))
+		if string(data[4:8]) == "fcTL" {
+			delays = append(delays, [2]uint16{binary.BigEndian.Uint16(data[28:]), binary.BigEndian.Uint16(data[30:])})
+		}
+		data = data[12+n:]
+	}
+	wantDelays := [][2]uint16{{130, 1000}, {70, 1000}, {9000, 100}}
+	if len(delays) != len(wantDelays) {
+		t.Fatalf("got %d fcTL chunks, want %d", len(delays), len(wantDelays))
+	}
+	for i := range delays {
+		if delays[i] != wantDelays[i] {
+			t.Errorf("frame %d delay = %d/%d, want %d/%d", i, delays[i][0], delays[i][1], wantDelays[i][0], wantDelays[i][1])
+		}
+	}
+
+	// Viewers without APNG support show the first frame.
+	first, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	if got := toNRGBA(first); !bytes.Equal(got.Pix, want[0].Pix) {
+		t.Error("default image differs from the first canvas")
+	}
+
+	back, err := FromAPNG(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("FromAPNG: %v", err)
+	}
+	if back.LoopCount != 2 || len(back.Frames) != 3 {
+		t.Fatalf("round trip: LoopCount %d with %d frames, want 2 with 3", back.LoopCount, len(back.Frames))
+	}
+	dec, err = NewAnimDecoder(back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range []*image.NRGBA{want[0], want[2], want[3]} {
+		got, _, err := dec.NextFrame()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(got.Pix, w.Pix) {
+			t.Errorf("round trip frame %d canvas differs", i)
+		}
+	}
+}
+
+func TestAPNGDelay(t *testing.T) {
+	for _, tc := range []struct {
+		d        time.Duration
+		num, den uint16
+	}{
+		{0, 0, 1000},
+		{40 * time.Millisecond, 40, 1000},
+		{65535 * time.Millisecond, 65535, 1000},
+		{70 * time.Second, 7000, 100},
+		{1000 * time.Second, 1000, 1},
+		{100 * time.Hour, 65535, 1},
+	} {
+		if num, den := apngDelay(tc.d); num != tc.num || den != tc.den {
+			t.Errorf("apngDelay(%v) = %d/%d, want %d/%d", tc.d, num, den, tc.num, tc.den)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package animation
+
+import (
+	"bytes"
+	"image"
+	"time"
+)
+
+// MaxBytes re-encoding: the quality is lowered in budgetQualityStep steps
+// down to budgetMinQuality, then the least-changed adjacent frames are
+// merged at that quality.
+const (
+	budgetQualityStep = 10
+	budgetMinQuality  = 10
+)
+
+// budgetFrame is a frame added with MaxBytes set, kept so that Close can
+// encode it again.
+type budgetFrame struct {
+	canvas *image.NRGBA
+	durMS  int
+	fo     FrameOptions
+	key    bool
+}
+
+// fitBudget encodes the recorded frames again, with lower quality and then
+// fewer frames, until the file fits in MaxBytes.
+func (e *AnimEncoder) fitBudget() ([]byte, error) {
+	if e.budgetRaw || len(e.budgetFrames) == 0 {
+		// Pre-encoded frames cannot be encoded again.
+		return nil, ErrCannotMeetBudget
+	}
+	start := e.opts.Quality
+	if e.opts.Lossless {
+		start = 100
+	}
+	floor := min(budgetMinQuality, start)
+
+	frames := append([]budgetFrame(nil), e.budgetFrames...)
+	for q := start - budgetQualityStep; ; q -= budgetQualityStep {
+		q = max(q, floor)
+		data, err := e.encodeBudget(frames, q)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) <= e.opts.MaxBytes {
+			return data, nil
+		}
+		if q == floor {
+			break
+		}
+	}
+
+	diffs := make([]int64, len(frames)-1)
+	for i := range diffs {
+		diffs[i] = canvasDiff(frames[i].canvas, frames[i+1].canvas)
+	}
+	for len(frames) > 1 {
+		// Merge about an eighth of the frames per attempt.
+		for n := max(1, len(frames)/8); n > 0 && len(frames) > 1; n-- {
+			frames, diffs = mergeLeastChanged(frames, diffs)
+		}
+		data, err := e.encodeBudget(frames, floor)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) <= e.opts.MaxBytes {
+			return data, nil
+		}
+	}
+	return nil, ErrCannotMeetBudget
+}
+
+// encodeBudget encodes frames as a complete file at quality, with lossless
+// frames encoded lossy. It reuses e's muxer, whose metadata and animation
+// parameters are kept.
+func (e *AnimEncoder) encodeBudget(frames []budgetFrame, quality int) ([]byte, error) {
+	opts := e.opts
+	opts.Quality = quality
+	opts.Lossless = false
+	opts.MaxBytes = 0
+	// The recorded durations already follow FixedFPS; merged frames carry
+	// the sum of theirs, which FixedFPS would cut back to one frame's.
+	opts.FixedFPS = 0
+	opts.FrameInfoFunc = nil
+
+	var buf bytes.Buffer
+	e.muxer.ResetFrames()
+	sub := &AnimEncoder{muxer: e.muxer}
+	sub.init(&buf, e.width, e.height, &opts)
+	// e.opts holds the sanitized keyframe distances already.
+	sub.opts.Kmin, sub.opts.Kmax = e.opts.Kmin, e.opts.Kmax
+	for _, f := range frames {
+		fo := f.fo
+		if fo.Lossless {
+			// The quality of a lossless frame is its effort.
+			fo.Lossless = false
+			fo.Quality = quality
+		} else {
+			fo.Quality = min(fo.Quality, quality)
+		}
+		if err := sub.addFrame(f.canvas, time.Duration(f.durMS)*time.Millisecond, &fo, f.key); err != nil {
+			return nil, err
+		}
+	}
+	if err := sub.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mergeLeastChanged merges the frame that differs least from the one
+// before it into that frame, which is shown for both durations. diffs[i] is
+// the difference between frames i and i+1.
+func mergeLeastChanged(frames []budgetFrame, diffs []int64) ([]budgetFrame, []int64) {
+	i := 0
+	for j, d := range diffs {
+		if d < diffs[i] {
+			i = j
+		}
+	}
+	frames[i].durMS = min(frames[i].durMS+frames[i+1].durMS, maxDuration)
+	frames = append(frames[:i+1], frames[i+2:]...)
+	diffs = append(diffs[:i], diffs[i+1:]...)
+	if i < len(diffs) {
+		diffs[i] = canvasDiff(frames[i].canvas, frames[i+1].canvas)
+	}
+	return frames, diffs
+}
+
+// canvasDiff returns the sum of the absolute channel differences between
+// two canvases of the same size.
+func canvasDiff(a, b *image.NRGBA) int64 {
+	var sum int64
+	for i, v := range a.Pix {
+		d := int64(v) - int64(b.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return sum
+}
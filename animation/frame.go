@@ -22,6 +22,11 @@ const (
 	// DisposeBackground fills the frame region with the background color
 	// after this frame is rendered (before rendering the next frame).
 	DisposeBackground DisposeMethod = 1
+	// DisposePrevious restores the frame region to what it was before this
+	// frame was rendered. The WebP format has no such mode (an ANMF chunk
+	// stores it as DisposeNone); it is honored by AnimDecoder for
+	// animations assembled in memory from APNG or GIF sources.
+	DisposePrevious DisposeMethod = 2
 )
 
 // BlendMethod controls how a frame is composited onto the canvas.
@@ -34,6 +39,31 @@ const (
 	BlendNone BlendMethod = 1
 )
 
+// Codec identifies the bitstream format a frame was stored in.
+type Codec int
+
+const (
+	// CodecUnknown is the zero value, used for frames built in memory
+	// rather than read from a file.
+	CodecUnknown Codec = 0
+	// CodecLossy is a VP8 frame, possibly with an ALPH chunk.
+	CodecLossy Codec = 1
+	// CodecLossless is a VP8L frame.
+	CodecLossless Codec = 2
+)
+
+// String returns "lossy", "lossless" or "unknown".
+func (c Codec) String() string {
+	switch c {
+	case CodecLossy:
+		return "lossy"
+	case CodecLossless:
+		return "lossless"
+	default:
+		return "unknown"
+	}
+}
+
 // Frame holds a decoded animation frame and its rendering parameters.
 type Frame struct {
 	// Image is the decoded image for this frame.
@@ -64,6 +94,11 @@ type Frame struct {
 	// keyframe detection without scanning pixel data.
 	HasAlpha bool
 
+	// Codec is the frame's bitstream format, taken from the VP8/VP8L
+	// sub-chunk of its ANMF chunk. It lets callers see which codec each
+	// frame of an AllowMixed animation ended up with.
+	Codec Codec
+
 	// BitstreamData holds the raw VP8/VP8L bitstream for lazy decoding.
 	// May be nil after decoding.
 	BitstreamData []byte
@@ -0,0 +1,83 @@
+package animation
+
+import (
+	"fmt"
+	"image"
+)
+
+// suggestLoopTolerance is the tolerance SuggestLoopCount uses for
+// IsSeamlessLoop.
+const suggestLoopTolerance = 4
+
+// IsSeamlessLoop reports whether a loops without a visible jump: whether
+// the canvas after its last frame matches the canvas of its first frame,
+// which is shown next when the animation starts over.
+//
+// tolerance is in 8-bit levels of the non-premultiplied canvas and applies
+// to each of R, G, B and A separately: the canvases match if no channel of
+// any pixel differs by more than tolerance, so a difference of exactly
+// tolerance still matches. 0 requires identical canvases, and 255 or more
+// accepts any two. Pixels that are transparent on both are equal whatever
+// their color. A negative tolerance is an error. An animation of one frame
+// always loops seamlessly.
+//
+// The canvases are rebuilt with an AnimDecoder, so frames without an Image
+// are decoded first with DecodeFrames.
+func (a *Animation) IsSeamlessLoop(tolerance int) (bool, error) {
+	if tolerance < 0 {
+		return false, fmt.Errorf("animation: negative loop tolerance %d", tolerance)
+	}
+	if len(a.Frames) == 0 {
+		return false, ErrNoFrames
+	}
+	if err := a.DecodeFrames(); err != nil {
+		return false, err
+	}
+	dec, err := NewAnimDecoder(a)
+	if err != nil {
+		return false, err
+	}
+	first, _, err := dec.NextFrame()
+	if err != nil {
+		return false, err
+	}
+	for dec.HasNext() {
+		if err := dec.advance(); err != nil {
+			return false, err
+		}
+	}
+	return canvasesMatch(first, dec.currFrame, tolerance), nil
+}
+
+// SuggestLoopCount returns the LoopCount a suits: 0 (loop forever) if it
+// loops seamlessly within a small tolerance, or 1 so that an animation
+// that would jump on each repeat plays once and stops on its last frame.
+// If the frames cannot be decoded, a.LoopCount is returned unchanged.
+func (a *Animation) SuggestLoopCount() int {
+	seamless, err := a.IsSeamlessLoop(suggestLoopTolerance)
+	switch {
+	case err != nil:
+		return a.LoopCount
+	case seamless:
+		return 0
+	}
+	return 1
+}
+
+// canvasesMatch reports whether no channel of a and b, two canvases of the
+// same size, differs by more than tolerance, ignoring the color of pixels
+// that are transparent in both.
+func canvasesMatch(a, b *image.NRGBA, tolerance int) bool {
+	for i := 0; i < len(a.Pix); i += 4 {
+		p, q := a.Pix[i:i+4:i+4], b.Pix[i:i+4:i+4]
+		if p[3] == 0 && q[3] == 0 {
+			continue
+		}
+		for c := range p {
+			if d := int(p[c]) - int(q[c]); d > tolerance || -d > tolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
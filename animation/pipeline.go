@@ -0,0 +1,251 @@
+package animation
+
+import (
+	"image"
+	"image/color"
+)
+
+// encodeCall is one FrameEncoderFunc invocation. Without Parallel it runs
+// inline the first time its result is needed, so candidates that are never
+// looked at cost nothing; with Parallel it is queued on the worker pool as
+// soon as it is created.
+type encodeCall struct {
+	run  func()        // pending inline call; nil once run or when queued
+	done chan struct{} // closed by the worker (Parallel only)
+	bs   []byte
+	err  error
+}
+
+func (c *encodeCall) wait() ([]byte, error) {
+	if c.run != nil {
+		c.run()
+		c.run = nil
+	} else if c.done != nil {
+		<-c.done
+	}
+	return c.bs, c.err
+}
+
+// frameEncode is an encode of one image with the frame's codec and, with
+// AllowMixed, with the other codec as well.
+type frameEncode struct {
+	primary, alt *encodeCall
+}
+
+// wait returns the smaller of the two results. An error from the primary
+// codec is returned as-is; a failing alternate codec is ignored. This
+// matches the C libwebp allow_mixed behavior, where each frame is tried
+// with both codecs independently.
+func (f *frameEncode) wait() ([]byte, error) {
+	bs, err := f.primary.wait()
+	if err != nil {
+		return nil, err
+	}
+	if f.alt == nil {
+		return bs, nil
+	}
+	if bsAlt, errAlt := f.alt.wait(); errAlt == nil && len(bsAlt) < len(bs) {
+		return bsAlt, nil
+	}
+	return bs, nil
+}
+
+// startEncode creates the encode of img with the codec settings in fo.
+func (e *AnimEncoder) startEncode(img image.Image, fo FrameOptions) *frameEncode {
+	f := &frameEncode{primary: e.startCall(img, fo.Lossless, fo)}
+	if e.opts.AllowMixed {
+		f.alt = e.startCall(img, !fo.Lossless, fo)
+	}
+	return f
+}
+
+func (e *AnimEncoder) startCall(img image.Image, lossless bool, fo FrameOptions) *encodeCall {
+	c := &encodeCall{}
+	run := func() { c.bs, c.err = callFrameEncoder(img, lossless, fo) }
+	if e.workers == nil {
+		c.run = run
+		return c
+	}
+	c.done = make(chan struct{})
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		e.workers <- struct{}{}
+		defer func() { <-e.workers }()
+		defer close(c.done)
+		run()
+	}()
+	return c
+}
+
+// pendingFrame is a frame added with AddFrame whose encodes may still be
+// running. Everything that depends only on the input canvases is worked
+// out when the frame is added; choices that depend on how earlier frames
+// were stored (dispose method, keyframe fallback) are made when it is
+// committed to the muxer, in order.
+type pendingFrame struct {
+	canvas    *image.NRGBA // full canvas; a private copy with Parallel
+	durMS     int
+	fo        FrameOptions
+	first     bool // first image frame: always a full-canvas keyframe
+	forceKey  bool // added with AddKeyframe
+	identical bool // same (or, with MergeThreshold, similar) pixels as the previous frame: merged into it
+
+	// Dispose-none sub-frame candidate, diffed against the previous input.
+	rectNone  image.Rectangle
+	blendNone BlendMethod
+	none      *frameEncode
+
+	// Dispose-background candidate. It needs the previous frame's final
+	// rectangle, so it is only started once that frame is committed.
+	rectBG  image.Rectangle
+	blendBG BlendMethod
+	bg      *frameEncode
+
+	key *frameEncode // full-canvas encode, created when first needed
+}
+
+// prepareFrame does the per-frame work that does not depend on earlier
+// encode results: the identical-frame check, the dispose-none rectangle
+// and the encodes that are known to be needed. key forces a keyframe.
+func (e *AnimEncoder) prepareFrame(canvas *image.NRGBA, durMS int, fo FrameOptions, key bool) *pendingFrame {
+	p := &pendingFrame{canvas: canvas, durMS: durMS, fo: fo}
+	// Without Parallel every frame is committed before the next one is
+	// added, so the previous input is the committed canvas.
+	prev := e.prevCanvas
+	if e.workers != nil {
+		prev = e.lastCanvas
+	}
+	switch {
+	case prev == nil:
+		p.first = true
+		e.keyEncode(p)
+	case key:
+		p.forceKey = true
+		e.keyEncode(p)
+	case isCanvasIdentical(prev, canvas), e.isCanvasSimilar(prev, canvas, fo):
+		p.identical = true
+		return p
+	case e.opts.Kmax == 0:
+		// Every frame is a keyframe (ForceKeyframes or Kmax == 1).
+		e.keyEncode(p)
+	default:
+		p.rectNone, p.blendNone = e.subFrameRect(prev, canvas, fo)
+		p.none = e.startEncode(extractSubImage(canvas, p.rectNone), fo)
+		if e.workers != nil && isLargeRect(p.rectNone, e.width, e.height) {
+			// The keyframe fallback will probably be tried; start it early.
+			e.keyEncode(p)
+		}
+	}
+	if e.workers != nil {
+		e.lastCanvas = canvas
+	}
+	return p
+}
+
+// subFrameRect returns the even-aligned rectangle of pixels that differ
+// between prev and curr, clipped to the canvas, and the blend method that
+// reconstructs curr from prev within it. Matching C libwebp, the blend
+// method is BlendAlpha when blending validation passes and BlendNone
+// otherwise.
+func (e *AnimEncoder) subFrameRect(prev, curr *image.NRGBA, fo FrameOptions) (image.Rectangle, BlendMethod) {
+	rect := findChangedRect(prev, curr)
+	if rect.Empty() {
+		// No pixel changed -- encode a minimal 1x1 frame.
+		rect = image.Rect(0, 0, 1, 1)
+	}
+	rect = snapToEven(rect)
+	rect = rect.Intersect(image.Rect(0, 0, e.width, e.height))
+
+	blend := BlendNone
+	if fo.Lossless {
+		if isLosslessBlendingPossible(prev, curr, rect) {
+			blend = BlendAlpha
+		}
+	} else {
+		if isLossyBlendingPossible(prev, curr, rect, fo.Quality) {
+			blend = BlendAlpha
+		}
+	}
+	return rect, blend
+}
+
+// startBG creates p's dispose-background candidate: the previous frame's
+// rectangle is cleared to transparent and p is diffed against the result.
+// The previous frame must already be committed.
+func (e *AnimEncoder) startBG(p *pendingFrame) {
+	if p.bg != nil {
+		return
+	}
+	prevDisposedCanvas := cloneNRGBA(e.prevCanvas)
+	fillRect(prevDisposedCanvas, e.prevFrameRect, color.NRGBA{})
+	p.rectBG, p.blendBG = e.subFrameRect(prevDisposedCanvas, p.canvas, p.fo)
+	p.bg = e.startEncode(extractSubImage(p.canvas, p.rectBG), p.fo)
+}
+
+// keyEncode returns p's full-canvas encode, creating it if needed.
+func (e *AnimEncoder) keyEncode(p *pendingFrame) *frameEncode {
+	if p.key == nil {
+		p.key = e.startEncode(p.canvas, p.fo)
+	}
+	return p.key
+}
+
+// isLargeRect reports whether r covers more than 90% of a w x h canvas, the
+// point at which a full-canvas keyframe is also tried.
+func isLargeRect(r image.Rectangle, w, h int) bool {
+	return r.Dx()*r.Dy() > w*h*9/10
+}
+
+// maxPending is the number of frames Parallel lets run ahead of the muxer.
+func (e *AnimEncoder) maxPending() int {
+	return 2 * cap(e.workers)
+}
+
+// enqueue adds p to the Parallel pipeline and commits frames from the head
+// while too many are in flight.
+func (e *AnimEncoder) enqueue(p *pendingFrame) error {
+	e.pending = append(e.pending, p)
+	if len(e.pending) == 1 {
+		e.startHead()
+	}
+	for len(e.pending) > e.maxPending() {
+		if err := e.commitNext(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitNext commits the oldest pending frame and starts the
+// dispose-background candidate of the next one, which can only be built
+// now that the frame before it is final.
+func (e *AnimEncoder) commitNext() error {
+	p := e.pending[0]
+	e.pending[0] = nil
+	e.pending = e.pending[1:]
+	err := e.commitFrame(p)
+	if len(e.pending) > 0 {
+		e.startHead()
+	}
+	return err
+}
+
+// startHead starts the dispose-background candidate of the oldest pending
+// frame if it is going to be encoded as a sub-frame.
+func (e *AnimEncoder) startHead() {
+	p := e.pending[0]
+	if p.none != nil && e.countSinceKeyframe+1 < e.opts.Kmax {
+		e.startBG(p)
+	}
+}
+
+// flush commits every pending frame.
+func (e *AnimEncoder) flush() error {
+	for len(e.pending) > 0 {
+		if err := e.commitNext(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
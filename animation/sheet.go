@@ -0,0 +1,52 @@
+package animation
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+)
+
+// ContactSheet decodes the animation in r and lays out its composited
+// frames, as NextFrame returns them, in one image: left to right and top
+// to bottom in a grid cols frames wide, each cell the size of the canvas.
+// Cells past the last frame are transparent. If cols <= 0 a near-square
+// grid is used; cols is at most the number of frames.
+func ContactSheet(r io.Reader, cols int) (*image.NRGBA, error) {
+	anim, err := Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	n := len(anim.Frames)
+	if n == 0 {
+		return nil, ErrNoFrames
+	}
+	if err := anim.DecodeFrames(); err != nil {
+		return nil, err
+	}
+	dec, err := NewAnimDecoder(anim)
+	if err != nil {
+		return nil, err
+	}
+
+	if cols <= 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(n))))
+	}
+	cols = min(cols, n)
+	rows := (n + cols - 1) / cols
+	w, h := anim.CanvasWidth, anim.CanvasHeight
+	if area := uint64(cols*w) * uint64(rows*h); area > maxCanvasArea {
+		return nil, fmt.Errorf("animation: contact sheet too large (%dx%d = %d pixels, max %d)", cols*w, rows*h, area, maxCanvasArea)
+	}
+
+	sheet := image.NewNRGBA(image.Rect(0, 0, cols*w, rows*h))
+	for i := 0; dec.HasNext(); i++ {
+		if err := dec.advance(); err != nil {
+			return nil, err
+		}
+		cell := image.Rect(0, 0, w, h).Add(image.Pt(i%cols*w, i/cols*h))
+		draw.Draw(sheet, cell, dec.currFrame, image.Point{}, draw.Src)
+	}
+	return sheet, nil
+}
@@ -0,0 +1,316 @@
+package animation
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"iter"
+	"time"
+
+	"github.com/deepteams/webp/internal/container"
+	"github.com/deepteams/webp/mux"
+)
+
+// StreamDecoder decodes an animated WebP one frame at a time while reading
+// it from an io.Reader. Unlike Decode followed by DecodeFrames, it never
+// holds more than one frame's bitstream and pixels at once; memory use is
+// bounded by the two canvas buffers shared with AnimDecoder.
+type StreamDecoder struct {
+	r         io.Reader
+	remaining int64 // bytes left in the RIFF payload
+	dec       *AnimDecoder
+	loopCount int
+	bgColor   color.NRGBA
+	frames    int
+
+	// pending holds frames that were read before NextFrame was called:
+	// the ANMF found while looking for ANIM, or the image of a still file.
+	pending []*mux.FrameInfo
+	err     error
+}
+
+// NewStreamDecoder reads the WebP header from r and returns a decoder whose
+// NextFrame returns the reconstructed canvas of each frame in turn.
+// Non-animated files are reported as a single frame with zero duration.
+// FrameDecoderFunc must be set (importing the root webp package does this).
+func NewStreamDecoder(r io.Reader) (*StreamDecoder, error) {
+	if FrameDecoderFunc == nil {
+		return nil, ErrNoDecoder
+	}
+
+	var hdr [container.RIFFHeaderSize + container.ChunkHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("animation: reading header: %w", err)
+	}
+	if binary.LittleEndian.Uint32(hdr[0:4]) != mux.FourCCRIFF ||
+		binary.LittleEndian.Uint32(hdr[8:12]) != mux.FourCCWEBP {
+		return nil, mux.ErrInvalidRIFF
+	}
+	s := &StreamDecoder{
+		r:         r,
+		remaining: int64(binary.LittleEndian.Uint32(hdr[4:8])) - 4,
+	}
+
+	id, size := binary.LittleEndian.Uint32(hdr[12:16]), binary.LittleEndian.Uint32(hdr[16:20])
+	s.remaining -= container.ChunkHeaderSize
+	if id != mux.FourCCVP8X {
+		return s.initStill(hdr[:])
+	}
+
+	vp8x, err := s.readPayload(id, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(vp8x) < container.VP8XChunkSize {
+		return nil, mux.ErrInvalidVP8X
+	}
+	if vp8x[0]&byte(container.AnimationFlag) == 0 {
+		// Still image with extended header: rebuild the bytes read so far
+		// and let the demuxer handle it.
+		head := append(hdr[:], vp8x...)
+		if size%2 != 0 {
+			head = append(head, 0)
+		}
+		return s.initStill(head)
+	}
+
+	w := (int(vp8x[4]) | int(vp8x[5])<<8 | int(vp8x[6])<<16) + 1
+	h := (int(vp8x[7]) | int(vp8x[8])<<8 | int(vp8x[9])<<16) + 1
+	s.dec, err = NewAnimDecoder(&Animation{CanvasWidth: w, CanvasHeight: h})
+	if err != nil {
+		return nil, err
+	}
+
+	// Read up to the ANIM chunk so LoopCount and BackgroundColor are known
+	// before the first frame. A misplaced ANMF is kept for NextFrame.
+	for {
+		id, data, err := s.nextChunk()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if id == mux.FourCCANIM {
+			if len(data) < container.ANIMChunkSize {
+				return nil, mux.ErrInvalidANIM
+			}
+			s.bgColor = argbToNRGBA(binary.LittleEndian.Uint32(data[0:4]))
+			s.loopCount = int(binary.LittleEndian.Uint16(data[4:6]))
+			break
+		}
+		if id == mux.FourCCANMF {
+			fi, err := mux.ParseANMF(data)
+			if err != nil {
+				return nil, err
+			}
+			s.pending = append(s.pending, fi)
+			break
+		}
+	}
+	return s, nil
+}
+
+// initStill reads the rest of a non-animated file and queues its image as
+// the only frame. head holds the bytes already consumed from s.r.
+func (s *StreamDecoder) initStill(head []byte) (*StreamDecoder, error) {
+	rest, err := io.ReadAll(io.LimitReader(s.r, maxInputSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > maxInputSize {
+		return nil, fmt.Errorf("animation: input too large (exceeds %d bytes)", maxInputSize)
+	}
+	dmx, err := mux.NewDemuxer(append(head, rest...))
+	if err != nil {
+		return nil, err
+	}
+	feat := dmx.GetFeatures()
+	s.dec, err = NewAnimDecoder(&Animation{CanvasWidth: feat.Width, CanvasHeight: feat.Height})
+	if err != nil {
+		return nil, err
+	}
+	fi, err := dmx.Frame(0)
+	if err != nil {
+		return nil, err
+	}
+	s.pending = append(s.pending, fi)
+	s.remaining = 0
+	return s, nil
+}
+
+// CanvasWidth returns the canvas width in pixels.
+func (s *StreamDecoder) CanvasWidth() int { return s.dec.anim.CanvasWidth }
+
+// CanvasHeight returns the canvas height in pixels.
+func (s *StreamDecoder) CanvasHeight() int { return s.dec.anim.CanvasHeight }
+
+// LoopCount returns the animation loop count (0 = infinite).
+func (s *StreamDecoder) LoopCount() int { return s.loopCount }
+
+// BackgroundColor returns the background color from the ANIM chunk.
+func (s *StreamDecoder) BackgroundColor() color.NRGBA { return s.bgColor }
+
+// NextFrame reads and decodes the next frame, composites it onto the canvas
+// with the same blend and dispose rules as AnimDecoder, and returns a
+// snapshot of the canvas. It returns io.EOF after the last frame.
+func (s *StreamDecoder) NextFrame() (*image.NRGBA, time.Duration, error) {
+	if s.err != nil {
+		return nil, 0, s.err
+	}
+	fi, err := s.nextFrameInfo()
+	if err != nil {
+		s.err = err
+		return nil, 0, err
+	}
+	if s.frames >= maxStreamFrames {
+		s.err = fmt.Errorf("animation: too many frames (max %d)", maxStreamFrames)
+		return nil, 0, s.err
+	}
+
+	img, err := FrameDecoderFunc(fi.Data, fi.AlphaData)
+	if err != nil {
+		s.err = fmt.Errorf("animation: decoding frame %d: %w", s.frames, err)
+		return nil, 0, s.err
+	}
+	f := &Frame{
+		Image:    img,
+		Duration: time.Duration(fi.Duration) * time.Millisecond,
+		OffsetX:  fi.OffsetX,
+		OffsetY:  fi.OffsetY,
+		Dispose:  DisposeMethod(fi.DisposeMode),
+		Blend:    BlendMethod(fi.BlendMode),
+		HasAlpha: fi.HasAlpha,
+		Codec:    frameCodec(fi),
+	}
+	if err := s.dec.render(f, s.frames == 0); err != nil {
+		s.err = err
+		return nil, 0, err
+	}
+	s.frames++
+
+	snap := image.NewNRGBA(s.dec.currFrame.Bounds())
+	copy(snap.Pix, s.dec.currFrame.Pix)
+	return snap, f.Duration, nil
+}
+
+// Frames returns an iterator over the remaining frames, yielding what
+// NextFrame returns: a snapshot of the canvas and the frame duration. Each
+// snapshot is a freshly allocated image that the caller may keep. The
+// iteration stops after the last frame or at the first error; Err tells the
+// two apart.
+func (s *StreamDecoder) Frames() iter.Seq2[*image.NRGBA, time.Duration] {
+	return func(yield func(*image.NRGBA, time.Duration) bool) {
+		for {
+			img, d, err := s.NextFrame()
+			if err != nil || !yield(img, d) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error that stopped NextFrame or Frames, or nil if no
+// error occurred or the end of the animation was reached.
+func (s *StreamDecoder) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// RawFrames decodes the animated WebP read from r frame by frame and yields
+// the composited canvas of each frame with its duration, ready to be piped
+// to a video encoder: canvas.Pix is tightly packed RGBA (ffmpeg's
+// "-f rawvideo -pix_fmt rgba"). Each canvas is a fresh buffer, never reused
+// between iterations. A read or decode error, including a bad header, ends
+// the sequence early without being reported; use NewStreamDecoder with its
+// Frames and Err methods to detect truncated files.
+func RawFrames(r io.Reader) iter.Seq2[*image.NRGBA, time.Duration] {
+	return func(yield func(*image.NRGBA, time.Duration) bool) {
+		s, err := NewStreamDecoder(r)
+		if err != nil {
+			return
+		}
+		s.Frames()(yield)
+	}
+}
+
+// maxStreamFrames mirrors the demuxer's frame limit.
+const maxStreamFrames = container.MaxFrames
+
+// nextFrameInfo returns the next queued frame or reads chunks until the
+// next ANMF. It returns io.EOF when the RIFF payload is exhausted.
+func (s *StreamDecoder) nextFrameInfo() (*mux.FrameInfo, error) {
+	if len(s.pending) > 0 {
+		fi := s.pending[0]
+		s.pending = s.pending[1:]
+		return fi, nil
+	}
+	for {
+		id, data, err := s.nextChunk()
+		if err != nil {
+			return nil, err
+		}
+		if id == mux.FourCCANMF {
+			return mux.ParseANMF(data)
+		}
+	}
+}
+
+// nextChunk reads the next chunk of the RIFF payload. Chunks other than
+// ANIM and ANMF are skipped without being buffered.
+func (s *StreamDecoder) nextChunk() (mux.ChunkID, []byte, error) {
+	for {
+		if s.remaining < container.ChunkHeaderSize {
+			return 0, nil, io.EOF
+		}
+		var hdr [container.ChunkHeaderSize]byte
+		if _, err := io.ReadFull(s.r, hdr[:]); err != nil {
+			if err == io.EOF {
+				// Tolerate a RIFF size larger than the data, like the demuxer.
+				return 0, nil, io.EOF
+			}
+			return 0, nil, fmt.Errorf("animation: reading chunk header: %w", err)
+		}
+		s.remaining -= container.ChunkHeaderSize
+		id := binary.LittleEndian.Uint32(hdr[0:4])
+		size := binary.LittleEndian.Uint32(hdr[4:8])
+
+		if id != mux.FourCCANIM && id != mux.FourCCANMF {
+			n := int64(size) + int64(size&1)
+			if _, err := io.CopyN(io.Discard, s.r, n); err != nil && !(err == io.EOF && size&1 != 0) {
+				return 0, nil, fmt.Errorf("animation: skipping %s chunk: %w", container.FourCCString(id), err)
+			}
+			s.remaining -= n
+			continue
+		}
+		data, err := s.readPayload(id, size)
+		return id, data, err
+	}
+}
+
+// readPayload reads a chunk payload of the given size plus its padding byte.
+func (s *StreamDecoder) readPayload(id mux.ChunkID, size uint32) ([]byte, error) {
+	if size > maxInputSize {
+		return nil, fmt.Errorf("animation: %s chunk too large (%d bytes)", container.FourCCString(id), size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("animation: reading %s chunk: %w", container.FourCCString(id), err)
+	}
+	s.remaining -= int64(size)
+	if size%2 != 0 {
+		// The final padding byte may be missing; ignore a short read.
+		var pad [1]byte
+		if n, _ := io.ReadFull(s.r, pad[:]); n == 1 {
+			s.remaining--
+		}
+	}
+	return data, nil
+}
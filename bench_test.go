@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"runtime"
 	"testing"
 )
 
@@ -76,6 +77,22 @@ func BenchmarkDecodeLossy(b *testing.B) {
 	b.SetBytes(int64(len(data)))
 }
 
+func BenchmarkDecodeLossy_NoFilter(b *testing.B) {
+	img := loadTestImage(b)
+	buf := &bytes.Buffer{}
+	Encode(buf, img, &EncoderOptions{Quality: 75, Method: 4, Segments: 1})
+	data := buf.Bytes()
+	opts := DefaultDecodeOptions()
+	opts.NoFilter = true
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeWithOptions(bytes.NewReader(data), opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(len(data)))
+}
+
 func BenchmarkDecodeLossless(b *testing.B) {
 	img := loadTestImage(b)
 	buf := &bytes.Buffer{}
@@ -90,6 +107,30 @@ func BenchmarkDecodeLossless(b *testing.B) {
 	b.SetBytes(int64(len(data)))
 }
 
+// BenchmarkDecodeBytes_Thumbnail decodes a small in-memory image, where the
+// copy Decode makes of its input is a noticeable share of the work.
+func BenchmarkDecodeBytes_Thumbnail(b *testing.B) {
+	buf := &bytes.Buffer{}
+	Encode(buf, makeGradient(64, 64), &EncoderOptions{Lossless: true, Quality: 75})
+	data := buf.Bytes()
+	b.Run("Decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Decode(bytes.NewReader(data)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("DecodeBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := DecodeBytes(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
 // ---------------------------------------------------------------------------
 // Helper: create a large test image with a gradient pattern.
 // ---------------------------------------------------------------------------
@@ -172,6 +213,32 @@ func BenchmarkEncodeLossless_MethodSweep(b *testing.B) {
 	}
 }
 
+func BenchmarkEncodeLossless_Icon(b *testing.B) {
+	img := iconTestImage(128, 128)
+	noCache := false
+	for _, tc := range []struct {
+		name  string
+		cache *bool
+	}{
+		{"ColorCacheAuto", nil},
+		{"ColorCacheOff", &noCache},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			opts := &EncoderOptions{Lossless: true, Quality: 100, Method: 6, UseColorCache: tc.cache}
+			buf := &bytes.Buffer{}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := Encode(buf, img, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(buf.Len()))
+			b.ReportMetric(float64(buf.Len()), "bytes/op")
+		})
+	}
+}
+
 // ---------------------------------------------------------------------------
 // 4. Lossy encode with alpha channel
 // ---------------------------------------------------------------------------
@@ -460,3 +527,227 @@ func BenchmarkEncodeLossless_WithMetadata(b *testing.B) {
 	}
 	b.SetBytes(int64(buf.Len()))
 }
+
+// ---------------------------------------------------------------------------
+// 16. Lossless decode with and without DecodeOptions.LowMemory
+// ---------------------------------------------------------------------------
+
+func BenchmarkDecodeLossless_LowMemory(b *testing.B) {
+	img := richTestImage(1024, 1024)
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, img, &EncoderOptions{Lossless: true, Quality: 75, Method: 4}); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	for _, lowMem := range []bool{false, true} {
+		name := "default"
+		if lowMem {
+			name = "low_memory"
+		}
+		b.Run(name, func(b *testing.B) {
+			opts := &DecodeOptions{LowMemory: lowMem}
+
+			// Steady-state B/op hides the decoder's pooled buffers, so also
+			// report what a decode allocates with the pools emptied.
+			runtime.GC()
+			runtime.GC()
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			if _, err := DecodeWithOptions(bytes.NewReader(data), opts); err != nil {
+				b.Fatal(err)
+			}
+			runtime.ReadMemStats(&after)
+			cold := after.TotalAlloc - before.TotalAlloc
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := DecodeWithOptions(bytes.NewReader(data), opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(len(data)))
+			b.ReportMetric(float64(cold), "cold-B/op")
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// 17. Lossy encode of *image.RGBA versus the generic image.Image path
+// ---------------------------------------------------------------------------
+
+// genericImage hides the concrete type of an image so the encoder takes its
+// per-pixel At path.
+type genericImage struct{ image.Image }
+
+func BenchmarkEncodeLossy_RGBA(b *testing.B) {
+	rgba := image.NewRGBA(image.Rect(0, 0, 512, 512))
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 512; x++ {
+			rgba.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x ^ y), A: 255})
+		}
+	}
+	for _, bc := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"rgba", rgba},
+		{"generic", genericImage{rgba}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			buf := &bytes.Buffer{}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := Encode(buf, bc.img, &EncoderOptions{Quality: 75, Method: 4}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(buf.Len()))
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// 18. Lossy encode of *image.Gray and *image.YCbCr (JPEG transcoding)
+// ---------------------------------------------------------------------------
+
+func BenchmarkEncodeLossy_GrayYCbCr(b *testing.B) {
+	const w, h = 512, 512
+	gray := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gray.Pix[y*gray.Stride+x] = uint8(x ^ y)
+		}
+	}
+	ycbcr := func(ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+		img := image.NewYCbCr(image.Rect(0, 0, w, h), ratio)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				img.Y[img.YOffset(x, y)] = uint8(x ^ y)
+				ci := img.COffset(x, y)
+				img.Cb[ci] = uint8(x)
+				img.Cr[ci] = uint8(y)
+			}
+		}
+		return img
+	}
+	y420 := ycbcr(image.YCbCrSubsampleRatio420)
+	y444 := ycbcr(image.YCbCrSubsampleRatio444)
+	for _, bc := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"gray", gray},
+		{"gray_generic", genericImage{gray}},
+		{"ycbcr420", y420},
+		{"ycbcr420_generic", genericImage{y420}},
+		{"ycbcr444", y444},
+		{"ycbcr444_generic", genericImage{y444}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			buf := &bytes.Buffer{}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := Encode(buf, bc.img, &EncoderOptions{Quality: 75, Method: 4}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(buf.Len()))
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Lossy encode of a translucent 1080p image with and without TileSize. The
+// encoder's own buffers are pooled, so B/op is mostly the transient memory
+// on top of them: the full-size NRGBA copy made for transparent-area
+// cleanup, or a single tile-sized buffer with TileSize.
+// ---------------------------------------------------------------------------
+
+func BenchmarkEncodeLossy_TileSize(b *testing.B) {
+	img := makeLargeTestImage(1920, 1080)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = uint8(128 + i%128)
+	}
+	for _, tile := range []int{0, 4, 16} {
+		b.Run(fmt.Sprintf("tile=%d", tile), func(b *testing.B) {
+			buf := &bytes.Buffer{}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := Encode(buf, img, &EncoderOptions{Quality: 75, Method: 4, TileSize: tile}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(buf.Len()))
+		})
+	}
+}
+
+// BenchmarkDecoder decodes the same image over and over with one Decoder
+// into one destination, as for a stream of video frames; after the first
+// iteration the allocations should be close to zero.
+func BenchmarkDecoder(b *testing.B) {
+	img := loadTestImage(b)
+	for _, lossless := range []bool{false, true} {
+		buf := &bytes.Buffer{}
+		Encode(buf, img, &EncoderOptions{Lossless: lossless, Quality: 75, Method: 4})
+		data := buf.Bytes()
+		name := "lossy"
+		if lossless {
+			name = "lossless"
+		}
+		b.Run(name, func(b *testing.B) {
+			dec := NewDecoder()
+			dst := &image.NRGBA{}
+			r := bytes.NewReader(data)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r.Reset(data)
+				if err := dec.Decode(dst, r); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(len(data)))
+		})
+	}
+}
+
+// BenchmarkEncodeLossy_Analysis encodes the same image at several
+// qualities, computing the analysis for each encode or reusing one from
+// AnalyzeImage.
+func BenchmarkEncodeLossy_Analysis(b *testing.B) {
+	img := loadTestImage(b)
+	qualities := []float32{30, 50, 70, 90}
+	for _, cached := range []bool{false, true} {
+		name := "fresh"
+		if cached {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			var analysis *Analysis
+			if cached {
+				var err error
+				if analysis, err = AnalyzeImage(img); err != nil {
+					b.Fatal(err)
+				}
+			}
+			buf := &bytes.Buffer{}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, q := range qualities {
+					buf.Reset()
+					if err := Encode(buf, img, &EncoderOptions{Quality: q, Method: 4, Analysis: analysis}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
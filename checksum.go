@@ -0,0 +1,56 @@
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/deepteams/webp/internal/container"
+)
+
+// fourCCCKSM is the FourCC of the checksum chunk written by
+// EncoderOptions.EmbedChecksum. It is not part of the WebP specification:
+// other decoders skip it as an unknown chunk.
+const fourCCCKSM = 'C' | 'K'<<8 | 'S'<<16 | 'M'<<24
+
+// ErrChecksumMismatch is returned by [Validate] and the Decode functions
+// when a file carries a CKSM chunk, as written with
+// EncoderOptions.EmbedChecksum, that does not match its image data.
+var ErrChecksumMismatch = errors.New("webp: checksum mismatch")
+
+// imageChecksum returns the CRC-32 (IEEE) of the ALPH and VP8 or VP8L
+// payloads of each frame, in file order.
+func imageChecksum(frames []container.FrameInfo) uint32 {
+	var crc uint32
+	for _, f := range frames {
+		crc = crc32.Update(crc, crc32.IEEETable, f.AlphaData)
+		crc = crc32.Update(crc, crc32.IEEETable, f.Payload)
+	}
+	return crc
+}
+
+// checksumChunk returns the payload of the CKSM chunk for a still image.
+func checksumChunk(bitstream, alphaData []byte) []byte {
+	crc := imageChecksum([]container.FrameInfo{{Payload: bitstream, AlphaData: alphaData}})
+	return binary.LittleEndian.AppendUint32(nil, crc)
+}
+
+// verifyChecksum checks the CKSM chunk of the file parsed by p, if any,
+// against its image data. Files without one are accepted.
+func verifyChecksum(p *container.Parser) error {
+	for _, c := range p.Chunks() {
+		if c.FourCC != fourCCCKSM {
+			continue
+		}
+		if len(c.Payload) != 4 {
+			return fmt.Errorf("%w: CKSM chunk has %d bytes, want 4", ErrChecksumMismatch, len(c.Payload))
+		}
+		want := binary.LittleEndian.Uint32(c.Payload)
+		if got := imageChecksum(p.Frames()); got != want {
+			return fmt.Errorf("%w: image data has CRC-32 %#08x, CKSM chunk says %#08x", ErrChecksumMismatch, got, want)
+		}
+		return nil
+	}
+	return nil
+}
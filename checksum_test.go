@@ -0,0 +1,102 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestEncode_EmbedChecksum(t *testing.T) {
+	translucent := gradientTestImage(40, 30)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = uint8(i)
+	}
+	lossless := DefaultOptions()
+	lossless.Lossless = true
+
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+		opts *EncoderOptions
+		tags string
+	}{
+		{"lossy", gradientTestImage(40, 30), DefaultOptions(), "[VP8X VP8  CKSM]"},
+		{"lossy alpha", translucent, DefaultOptions(), "[VP8X ALPH VP8  CKSM]"},
+		{"lossless", translucent, lossless, "[VP8X VP8L CKSM]"},
+	} {
+		plain := mustEncode(t, tc.img, tc.opts)
+		opts := *tc.opts
+		opts.EmbedChecksum = true
+		data := mustEncode(t, tc.img, &opts)
+		if got := chunkTags(t, data); got != tc.tags {
+			t.Fatalf("%s: chunks = %s, want %s", tc.name, got, tc.tags)
+		}
+
+		// Round trip: the checksum verifies and the image is unchanged.
+		if err := Validate(bytes.NewReader(data)); err != nil {
+			t.Errorf("%s: Validate: %v", tc.name, err)
+		}
+		want, err := Decode(bytes.NewReader(plain))
+		if err != nil {
+			t.Fatalf("%s: Decode without checksum: %v", tc.name, err)
+		}
+		got, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", tc.name, err)
+		}
+		if !sameNRGBA(got, want) {
+			t.Errorf("%s: decoded image differs from the one without checksum", tc.name)
+		}
+		if _, _, _, _, err := DecodeRaw(bytes.NewReader(data), PixelFormatRGBA); err != nil {
+			t.Errorf("%s: DecodeRaw: %v", tc.name, err)
+		}
+		if err := NewDecoder().Decode(new(image.NRGBA), bytes.NewReader(data)); err != nil {
+			t.Errorf("%s: Decoder.Decode: %v", tc.name, err)
+		}
+
+		// A flipped bit in the last byte of the image data, which still
+		// decodes, and one in the checksum itself, both trip verification.
+		chunks, err := Chunks(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: Chunks: %v", tc.name, err)
+		}
+		bits := chunks[len(chunks)-2]
+		for _, off := range []int64{
+			bits.Offset + 8 + int64(bits.Size) - 1,
+			int64(len(data)) - 1,
+		} {
+			bad := bytes.Clone(data)
+			bad[off] ^= 0x01
+			if err := Validate(bytes.NewReader(bad)); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("%s: corrupt byte %d: Validate = %v, want ErrChecksumMismatch", tc.name, off, err)
+			}
+			if _, err := Decode(bytes.NewReader(bad)); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("%s: corrupt byte %d: Decode = %v, want ErrChecksumMismatch", tc.name, off, err)
+			}
+			if _, _, _, _, err := DecodeRaw(bytes.NewReader(bad), PixelFormatRGBA); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("%s: corrupt byte %d: DecodeRaw = %v, want ErrChecksumMismatch", tc.name, off, err)
+			}
+			if err := NewDecoder().Decode(new(image.NRGBA), bytes.NewReader(bad)); !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("%s: corrupt byte %d: Decoder.Decode = %v, want ErrChecksumMismatch", tc.name, off, err)
+			}
+		}
+	}
+
+	// Files without a checksum are not checked.
+	plain := mustEncode(t, gradientTestImage(40, 30), &EncoderOptions{Quality: 75, EXIF: []byte("exif")})
+	plain[len(plain)-20] ^= 0x01
+	if err := Validate(bytes.NewReader(plain)); err != nil {
+		t.Errorf("no checksum: Validate: %v", err)
+	}
+	if _, err := Decode(bytes.NewReader(plain)); errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("no checksum: Decode: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.EmbedChecksum = true
+	opts.NoExtendedFormat = true
+	if err := Encode(new(bytes.Buffer), gradientTestImage(8, 8), opts); !errors.Is(err, ErrNeedsExtendedFormat) {
+		t.Errorf("with NoExtendedFormat: err = %v, want ErrNeedsExtendedFormat", err)
+	}
+}
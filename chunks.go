@@ -0,0 +1,158 @@
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/deepteams/webp/internal/container"
+)
+
+// ChunkInfo describes one RIFF chunk of a WebP file, as returned by
+// [Chunks].
+type ChunkInfo struct {
+	FourCC string // Chunk tag, e.g. "VP8X" or "ANMF".
+	Offset int64  // Offset of the chunk header from the start of the file.
+	Size   uint32 // Payload size from the chunk header, without padding.
+	Padded bool   // The payload is followed by a padding byte (odd Size).
+
+	// Depth is 0 for chunks in the RIFF body and 1 for the sub-chunks of
+	// an ANMF frame. Parent is the index in the returned slice of the
+	// enclosing ANMF chunk, or -1 for top-level chunks.
+	Depth  int
+	Parent int
+}
+
+// Chunks lists the chunks of a WebP file in file order, without decoding
+// any image data. The sub-chunks of each ANMF frame (ALPH, VP8, VP8L and
+// any unknown ones) follow their frame. r is read once from start to end
+// and only chunk headers are kept in memory, so Chunks works on files of
+// any size.
+//
+// Chunks reports the container as it is, which makes it useful for
+// diagnosing malformed files: no chunk order or flag is validated (see
+// [Validate] for that). If the walk stops early because of an error, the
+// chunks read so far are returned along with it.
+//
+// If r is an *io.SectionReader, the payloads are seeked over rather than
+// read.
+func Chunks(r io.Reader) ([]ChunkInfo, error) {
+	if r == nil {
+		return nil, errors.New("webp: nil reader")
+	}
+	return readChunks(r, 0)
+}
+
+// readChunks implements Chunks, failing after max chunks if max > 0.
+func readChunks(r io.Reader, max int) ([]ChunkInfo, error) {
+	var hdr [container.RIFFHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("webp: reading data: %w", err)
+	}
+	h, _, err := container.ParseRIFFHeader(hdr[:])
+	if err != nil {
+		return nil, err
+	}
+
+	w := &chunkWalker{r: r, off: container.RIFFHeaderSize, max: max}
+	err = w.walk(container.ChunkHeaderSize+int64(h.FileSize), 0, -1)
+	return w.chunks, err
+}
+
+// chunkWalker reads chunk headers from a stream, skipping payloads.
+type chunkWalker struct {
+	r      io.Reader
+	off    int64 // current offset in the file
+	chunks []ChunkInfo
+	max    int // maximum number of chunks, 0 for no limit
+}
+
+// walk lists the chunks between the current offset and end.
+func (w *chunkWalker) walk(end int64, depth, parent int) error {
+	for w.off < end {
+		var hdr [container.ChunkHeaderSize]byte
+		if err := w.read(hdr[:]); err != nil {
+			return err
+		}
+		fourcc := binary.LittleEndian.Uint32(hdr[0:4])
+		size := binary.LittleEndian.Uint32(hdr[4:8])
+		idx := len(w.chunks)
+		if w.max > 0 && idx >= w.max {
+			return fmt.Errorf("%w: too many chunks (max %d)", container.ErrInvalidChunk, w.max)
+		}
+		w.chunks = append(w.chunks, ChunkInfo{
+			FourCC: container.FourCCString(fourcc),
+			Offset: w.off,
+			Size:   size,
+			Padded: size&1 != 0,
+			Depth:  depth,
+			Parent: parent,
+		})
+		w.off += container.ChunkHeaderSize
+
+		payloadEnd := w.off + int64(size)
+		if payloadEnd > end {
+			return fmt.Errorf("%w: %s chunk at offset %d extends past its container",
+				container.ErrInvalidChunk, container.FourCCString(fourcc), w.off-container.ChunkHeaderSize)
+		}
+		if fourcc == container.FourCCANMF && size >= container.ANMFChunkSize {
+			if err := w.skip(container.ANMFChunkSize); err != nil {
+				return err
+			}
+			if err := w.walk(payloadEnd, depth+1, idx); err != nil {
+				return err
+			}
+		} else if err := w.skip(int64(size)); err != nil {
+			return err
+		}
+		if size&1 != 0 && w.off < end {
+			if err := w.skip(1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// read reads a chunk header; the caller advances the offset past it.
+func (w *chunkWalker) read(b []byte) error {
+	_, err := io.ReadFull(w.r, b)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("webp: reading data: %w", err)
+	}
+	return nil
+}
+
+func (w *chunkWalker) skip(n int64) error {
+	if sr, ok := w.r.(*io.SectionReader); ok {
+		// Seek over the payload rather than reading it.
+		pos, err := sr.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("webp: reading data: %w", err)
+		}
+		if rest := sr.Size() - pos; n > rest {
+			sr.Seek(0, io.SeekEnd)
+			w.off += rest
+			return ErrUnexpectedEOF
+		}
+		sr.Seek(n, io.SeekCurrent)
+		w.off += n
+		return nil
+	}
+	m, err := io.CopyN(io.Discard, w.r, n)
+	w.off += m
+	if err == io.EOF {
+		return ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("webp: reading data: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/deepteams/webp/animation"
+)
+
+// checkChunkLayout verifies that consecutive chunks at each level are laid
+// out back to back.
+func checkChunkLayout(t *testing.T, chunks []ChunkInfo, fileSize int) {
+	t.Helper()
+	next := map[int]int64{-1: 12}
+	for i, c := range chunks {
+		if c.Offset != next[c.Parent] {
+			t.Errorf("chunk %d (%s) at offset %d, want %d", i, c.FourCC, c.Offset, next[c.Parent])
+		}
+		end := c.Offset + 8 + int64(c.Size)
+		if c.Padded {
+			end++
+		}
+		next[c.Parent] = end
+		if c.FourCC == "ANMF" {
+			next[i] = c.Offset + 8 + 16
+		}
+	}
+	if next[-1] != int64(fileSize) {
+		t.Errorf("chunks end at %d, file size %d", next[-1], fileSize)
+	}
+}
+
+func TestChunks_Still(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Lossless = true
+	opts.ICC = []byte("icc")
+	opts.EXIF = []byte("exif data")
+	var buf bytes.Buffer
+	if err := Encode(&buf, gradientTestImage(16, 16), opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	chunks, err := Chunks(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+	var tags []string
+	for _, c := range chunks {
+		tags = append(tags, c.FourCC)
+		if c.Depth != 0 || c.Parent != -1 {
+			t.Errorf("%s: depth %d parent %d, want top level", c.FourCC, c.Depth, c.Parent)
+		}
+	}
+	if got := fmt.Sprint(tags); got != "[VP8X ICCP VP8L EXIF]" {
+		t.Errorf("chunks = %s, want [VP8X ICCP VP8L EXIF]", got)
+	}
+	if chunks[1].Size != 3 || !chunks[1].Padded {
+		t.Errorf("ICCP size %d padded %v, want 3 padded", chunks[1].Size, chunks[1].Padded)
+	}
+	checkChunkLayout(t, chunks, buf.Len())
+}
+
+func TestChunks_Animation(t *testing.T) {
+	var buf bytes.Buffer
+	enc := animation.NewEncoder(&buf, 32, 32, nil)
+	for i := 0; i < 3; i++ {
+		img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+		for p := 0; p < len(img.Pix); p += 4 {
+			img.Pix[p], img.Pix[p+1], img.Pix[p+3] = uint8(60*i), 100, 255
+		}
+		img.SetNRGBA(i, i, color.NRGBA{A: 128})
+		if err := enc.AddFrame(img, 100*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	chunks, err := Chunks(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+	frames := 0
+	for i, c := range chunks {
+		switch c.FourCC {
+		case "ANMF":
+			frames++
+			if c.Depth != 0 {
+				t.Errorf("ANMF at depth %d", c.Depth)
+			}
+			if i+1 >= len(chunks) || chunks[i+1].Parent != i || chunks[i+1].Depth != 1 {
+				t.Errorf("ANMF %d is not followed by its sub-chunks", i)
+			}
+		case "VP8 ", "VP8L", "ALPH":
+			if c.Depth != 1 || chunks[c.Parent].FourCC != "ANMF" {
+				t.Errorf("%s: depth %d parent %d, want inside an ANMF", c.FourCC, c.Depth, c.Parent)
+			}
+		}
+	}
+	if frames != 3 {
+		t.Errorf("got %d ANMF chunks, want 3", frames)
+	}
+	if chunks[0].FourCC != "VP8X" || chunks[1].FourCC != "ANIM" {
+		t.Errorf("first chunks = %s %s, want VP8X ANIM", chunks[0].FourCC, chunks[1].FourCC)
+	}
+	checkChunkLayout(t, chunks, buf.Len())
+}
+
+func TestChunks_Truncated(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Lossless = true
+	opts.ICC = []byte("icc profile")
+	var buf bytes.Buffer
+	if err := Encode(&buf, gradientTestImage(16, 16), opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	data := buf.Bytes()
+
+	chunks, err := Chunks(bytes.NewReader(data[:len(data)-4]))
+	if !errors.Is(err, ErrUnexpectedEOF) {
+		t.Errorf("error = %v, want ErrUnexpectedEOF", err)
+	}
+	if len(chunks) != 3 {
+		t.Errorf("got %d chunks before the error, want 3", len(chunks))
+	}
+
+	if _, err := Chunks(bytes.NewReader([]byte("not a webp file"))); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("error = %v, want ErrInvalidFormat", err)
+	}
+}
@@ -4,7 +4,8 @@
 //
 //	gwebp enc [options] <input>        PNG/JPEG/GIF → WebP (use "-" for stdin)
 //	gwebp dec [options] <input.webp>   WebP → PNG/JPEG/GIF (use "-" for stdin, -o - for stdout)
-//	gwebp info <input.webp>            Display WebP metadata
+//	                                   (-frame N extracts one animation frame)
+//	gwebp info [-v] <input.webp>       Display WebP metadata (-v lists chunks and frames)
 package main
 
 import (
@@ -25,6 +26,8 @@ import (
 
 	"github.com/deepteams/webp"
 	"github.com/deepteams/webp/animation"
+	"github.com/deepteams/webp/internal/lossy"
+	"github.com/deepteams/webp/mux"
 )
 
 func main() {
@@ -83,6 +86,7 @@ func runEnc(args []string) error {
 	quality := fs.Float64("q", 75, "quality 0-100")
 	lossless := fs.Bool("lossless", false, "lossless VP8L encoding")
 	method := fs.Int("m", 4, "compression effort 0-6")
+	effort := fs.Int("z", -1, "lossless effort 0-9, implies -lossless (-1=use -m/-q)")
 	preset := fs.String("preset", "default", "preset: default/picture/photo/drawing/icon/text")
 	sharpYUV := fs.Bool("sharp_yuv", false, "sharp RGB→YUV conversion")
 	exact := fs.Bool("exact", false, "preserve RGB in transparent areas")
@@ -97,7 +101,7 @@ func runEnc(args []string) error {
 	pass := fs.Int("pass", -1, "analysis pass number 1-10 (-1=default)")
 	alphaQ := fs.Int("alpha_q", -1, "alpha quality 0-100 (-1=default)")
 	alphaMethod := fs.Int("alpha_method", -1, "alpha compression 0-1 (-1=default)")
-	alphaFilter := fs.String("alpha_filter", "", "alpha filter: none/fast/best")
+	alphaFilter := fs.String("alpha_filter", "", "alpha filter: none/fast/best, or a forced predictor: horizontal/vertical/gradient")
 	pre := fs.Int("pre", 0, "pre-processing filter 0-3")
 	qmin := fs.Int("qmin", 0, "minimum quality 0-100")
 	qmax := fs.Int("qmax", -1, "maximum quality 0-100 (-1=default)")
@@ -126,6 +130,13 @@ func runEnc(args []string) error {
 	opts.TargetSize = *targetSize
 	opts.TargetPSNR = float32(*targetPSNR)
 	opts.QMin = *qmin
+	if *effort >= 0 {
+		opts.Lossless = true
+		opts.LosslessEffort = *effort
+		if *effort == 0 {
+			opts.Method, opts.Quality = 0, 0
+		}
+	}
 	// Only override preset values when explicitly set by CLI flags.
 	if *sns >= 0 {
 		opts.SNSStrength = *sns
@@ -164,8 +175,14 @@ func runEnc(args []string) error {
 			opts.AlphaFiltering = 1
 		case "best":
 			opts.AlphaFiltering = 2
+		case "horizontal":
+			opts.AlphaFilterMethod = webp.AlphaFilterHorizontal
+		case "vertical":
+			opts.AlphaFilterMethod = webp.AlphaFilterVertical
+		case "gradient":
+			opts.AlphaFilterMethod = webp.AlphaFilterGradient
 		default:
-			return fmt.Errorf("enc: unknown alpha_filter %q (use none/fast/best)", *alphaFilter)
+			return fmt.Errorf("enc: unknown alpha_filter %q (use none/fast/best/horizontal/vertical/gradient)", *alphaFilter)
 		}
 	}
 	if *qmax >= 0 {
@@ -352,6 +369,10 @@ func runDec(args []string) error {
 	fs := flag.NewFlagSet("dec", flag.ContinueOnError)
 	output := fs.String("o", "", `output path (default: .png or .gif, "-" for stdout)`)
 	fmtFlag := fs.String("fmt", "", "output format: png, jpeg (auto-detect from extension if omitted)")
+	frameFlag := fs.Int("frame", 0, "decode only frame N of an animation as PNG/JPEG (0-based, -1 = last)")
+	iccPath := fs.String("icc", "", "write the ICC profile chunk to this path, if present")
+	exifPath := fs.String("exif", "", "write the EXIF chunk to this path, if present")
+	xmpPath := fs.String("xmp", "", "write the XMP chunk to this path, if present")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -359,6 +380,12 @@ func runDec(args []string) error {
 	if fs.NArg() < 1 {
 		return fmt.Errorf("dec: missing input file\nUsage: gwebp dec [options] <input.webp>")
 	}
+	frameSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "frame" {
+			frameSet = true
+		}
+	})
 	inputPath := fs.Arg(0)
 
 	in, err := openInput(inputPath)
@@ -377,12 +404,86 @@ func runDec(args []string) error {
 		return fmt.Errorf("dec: %w", err)
 	}
 
+	if *iccPath != "" || *exifPath != "" || *xmpPath != "" {
+		if err := dumpMetadata(data, *iccPath, *exifPath, *xmpPath); err != nil {
+			return err
+		}
+	}
+
+	if frameSet {
+		if !feat.HasAnimation {
+			return fmt.Errorf("dec: -frame requires an animated input")
+		}
+		return decodeAnimatedFrame(data, inputPath, *output, *fmtFlag, *frameFlag)
+	}
 	if feat.HasAnimation {
 		return decodeAnimated(data, inputPath, *output, feat)
 	}
 	return decodeStatic(data, inputPath, *output, *fmtFlag)
 }
 
+// dumpMetadata writes the raw ICC, EXIF and XMP chunks to the given paths.
+// Empty paths and chunks absent from the input are skipped.
+func dumpMetadata(data []byte, iccPath, exifPath, xmpPath string) error {
+	md, err := webp.ReadMetadata(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("dec: reading metadata: %w", err)
+	}
+	for _, c := range []struct {
+		name, path string
+		data       []byte
+	}{
+		{"ICC", iccPath, md.ICC},
+		{"EXIF", exifPath, md.EXIF},
+		{"XMP", xmpPath, md.XMP},
+	} {
+		if c.path == "" {
+			continue
+		}
+		if c.data == nil {
+			fmt.Fprintf(os.Stderr, "dec: no %s chunk, skipping %s\n", c.name, c.path)
+			continue
+		}
+		if err := os.WriteFile(c.path, c.data, 0o644); err != nil {
+			return fmt.Errorf("dec: writing %s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// decodeAnimatedFrame reconstructs frame n of an animation (negative n counts
+// from the end) and writes it as a still image.
+func decodeAnimatedFrame(data []byte, inputPath, outputPath, fmtFlag string, n int) error {
+	anim, err := animation.DecodeBytes(data)
+	if err != nil {
+		return fmt.Errorf("dec: %w", err)
+	}
+	count := len(anim.Frames)
+	idx := n
+	if idx < 0 {
+		idx += count
+	}
+	if idx < 0 || idx >= count {
+		return fmt.Errorf("dec: frame %d out of range (animation has %d frames)", n, count)
+	}
+
+	if err := anim.DecodeFrames(); err != nil {
+		return fmt.Errorf("dec: decoding frames: %w", err)
+	}
+	dec, err := animation.NewAnimDecoder(anim)
+	if err != nil {
+		return fmt.Errorf("dec: %w", err)
+	}
+	if err := dec.Seek(idx); err != nil {
+		return fmt.Errorf("dec: %w", err)
+	}
+	img, _, err := dec.NextFrame()
+	if err != nil {
+		return fmt.Errorf("dec: frame %d: %w", idx, err)
+	}
+	return writeStatic(img, inputPath, outputPath, fmtFlag)
+}
+
 // detectOutputFormat returns "png", "jpeg", or "gif" based on flag/extension.
 func detectOutputFormat(fmtFlag, outputPath string) string {
 	if fmtFlag != "" {
@@ -404,7 +505,12 @@ func decodeStatic(data []byte, inputPath, outputPath, fmtFlag string) error {
 	if err != nil {
 		return fmt.Errorf("dec: %w", err)
 	}
+	return writeStatic(img, inputPath, outputPath, fmtFlag)
+}
 
+// writeStatic writes img as PNG or JPEG to outputPath, deriving the default
+// output name from inputPath.
+func writeStatic(img image.Image, inputPath, outputPath, fmtFlag string) error {
 	outFmt := detectOutputFormat(fmtFlag, outputPath)
 
 	// Determine output writer.
@@ -572,18 +678,28 @@ func clearCanvasRect(canvas *image.NRGBA, r image.Rectangle) {
 // --- info ---
 
 func runInfo(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("info: missing input file\nUsage: gwebp info <input.webp>")
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	verbose := fs.Bool("v", false, "also list chunks, metadata sizes, VP8 header fields and animation frames")
+
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
-	inputPath := args[0]
+	if fs.NArg() < 1 {
+		return fmt.Errorf("info: missing input file\nUsage: gwebp info [-v] <input.webp>")
+	}
+	inputPath := fs.Arg(0)
 
 	in, err := openInput(inputPath)
 	if err != nil {
 		return err
 	}
-	defer in.Close()
+	data, err := io.ReadAll(in)
+	in.Close()
+	if err != nil {
+		return fmt.Errorf("info: reading input: %w", err)
+	}
 
-	feat, err := webp.GetFeatures(in)
+	feat, err := webp.GetFeatures(bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("info: %w", err)
 	}
@@ -593,26 +709,112 @@ func runInfo(args []string) error {
 		name = "<stdin>"
 	}
 
-	fmt.Printf("File:       %s\n", name)
-	fmt.Printf("Format:     %s\n", feat.Format)
-	fmt.Printf("Dimensions: %d x %d\n", feat.Width, feat.Height)
-	fmt.Printf("Alpha:      %v\n", feat.HasAlpha)
-	fmt.Printf("Animation:  %v\n", feat.HasAnimation)
+	fmt.Printf("File:        %s\n", name)
+	fmt.Printf("Format:      %s\n", formatDescription(feat))
+	fmt.Printf("Dimensions:  %d x %d\n", feat.Width, feat.Height)
+	fmt.Printf("Alpha:       %v\n", feat.HasAlpha)
+	fmt.Printf("Animation:   %v\n", feat.HasAnimation)
 	if feat.HasAnimation {
-		fmt.Printf("Frames:     %d\n", feat.FrameCount)
+		fmt.Printf("Frames:      %d\n", feat.FrameCount)
 		loop := "infinite"
 		if feat.LoopCount > 0 {
 			loop = fmt.Sprintf("%d", feat.LoopCount)
 		}
-		fmt.Printf("Loop count: %s\n", loop)
+		fmt.Printf("Loop count:  %s\n", loop)
+	}
+
+	fmt.Printf("File size:   %d bytes\n", len(data))
+	if pixels := feat.Width * feat.Height * max(feat.FrameCount, 1); pixels > 0 {
+		fmt.Printf("Bytes/pixel: %.4f\n", float64(len(data))/float64(pixels))
+	}
+
+	if *verbose {
+		return printVerboseInfo(data)
 	}
+	return nil
+}
 
-	if inputPath != "-" {
-		fi, err := os.Stat(inputPath)
-		if err == nil {
-			fmt.Printf("File size:  %d bytes\n", fi.Size())
+// formatDescription names the codec of feat and whether it has alpha:
+// "lossy", "lossless", "lossy+alpha", "lossless+alpha", or
+// "extended/animated" for animations.
+func formatDescription(feat *webp.Features) string {
+	if feat.HasAnimation {
+		return "extended/animated"
+	}
+	desc := "lossy"
+	if feat.Lossless {
+		desc = "lossless"
+	}
+	if feat.HasAlpha {
+		desc += "+alpha"
+	}
+	return desc
+}
+
+// printVerboseInfo prints the metadata sizes, the VP8 header fields of a
+// still lossy image, the chunk layout and the animation frames of data, one
+// key per line.
+func printVerboseInfo(data []byte) error {
+	md, err := webp.ReadMetadata(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("info: %w", err)
+	}
+	for _, m := range []struct {
+		key     string
+		present bool
+		data    []byte
+	}{
+		{"ICC:        ", md.HasICC, md.ICC},
+		{"EXIF:       ", md.HasEXIF, md.EXIF},
+		{"XMP:        ", md.HasXMP, md.XMP},
+	} {
+		if m.present {
+			fmt.Printf("%s %d bytes\n", m.key, len(m.data))
+		} else {
+			fmt.Printf("%s none\n", m.key)
 		}
 	}
 
+	chunks, err := webp.Chunks(bytes.NewReader(data))
+	for _, c := range chunks {
+		if c.FourCC == "VP8 " && c.Depth == 0 {
+			if hdr, err := lossy.ParseHeader(chunkPayload(data, c)); err == nil {
+				fmt.Printf("Profile:     %d\n", hdr.Frame.Profile)
+				fmt.Printf("Partitions:  %d\n", hdr.NumPartitions)
+			}
+		}
+	}
+	frame := 0
+	for _, c := range chunks {
+		indent := strings.Repeat("  ", c.Depth)
+		fmt.Printf("Chunk:       %s%s offset=%d size=%d\n", indent, c.FourCC, c.Offset, c.Size)
+		if c.FourCC != "ANMF" {
+			continue
+		}
+		f, err := mux.ParseANMF(chunkPayload(data, c))
+		if err != nil {
+			continue
+		}
+		blend, dispose := "alpha", "none"
+		if f.BlendMode == mux.BlendNone {
+			blend = "none"
+		}
+		if f.DisposeMode == mux.DisposeBackground {
+			dispose = "background"
+		}
+		fmt.Printf("Frame:       %d offset=%d duration=%dms blend=%s dispose=%s\n",
+			frame, c.Offset, f.Duration, blend, dispose)
+		frame++
+	}
+	if err != nil {
+		return fmt.Errorf("info: listing chunks: %w", err)
+	}
 	return nil
 }
+
+// chunkPayload returns the payload of c, or the part of it present in data.
+func chunkPayload(data []byte, c webp.ChunkInfo) []byte {
+	start := min(c.Offset+8, int64(len(data)))
+	end := min(start+int64(c.Size), int64(len(data)))
+	return data[start:end]
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"image"
 	"image/color"
+	"image/gif"
 	"image/png"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/deepteams/webp"
 )
 
 // binaryPath holds the path to the compiled gwebp binary. Set in TestMain.
@@ -416,6 +420,135 @@ func TestDec_LosslessRoundTrip(t *testing.T) {
 	}
 }
 
+// createTestAnimatedWebP encodes a 3-frame 8x8 GIF (red, green, blue) to a
+// lossless animated WebP with gwebp and returns the WebP path.
+func createTestAnimatedWebP(t *testing.T, dir string) string {
+	t.Helper()
+	pal := color.Palette{
+		color.NRGBA{R: 255, A: 255},
+		color.NRGBA{G: 255, A: 255},
+		color.NRGBA{B: 255, A: 255},
+	}
+	g := &gif.GIF{}
+	for i := range pal {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), pal)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i)
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	gifPath := filepath.Join(dir, "anim.gif")
+	f, err := os.Create(gifPath)
+	if err != nil {
+		t.Fatalf("creating test GIF: %v", err)
+	}
+	if err := gif.EncodeAll(f, g); err != nil {
+		f.Close()
+		t.Fatalf("encoding test GIF: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("closing test GIF: %v", err)
+	}
+
+	webpPath := filepath.Join(dir, "anim.webp")
+	if _, stderr, err := runGwebp(t, nil, "enc", "-lossless", "-o", webpPath, gifPath); err != nil {
+		t.Fatalf("enc GIF failed: %v\nstderr: %s", err, stderr)
+	}
+	return webpPath
+}
+
+func TestDec_Frame(t *testing.T) {
+	skipIfNoBinary(t)
+	dir := t.TempDir()
+	webpPath := createTestAnimatedWebP(t, dir)
+
+	tests := []struct {
+		frame string
+		want  color.NRGBA
+	}{
+		{"0", color.NRGBA{R: 255, A: 255}},
+		{"1", color.NRGBA{G: 255, A: 255}},
+		{"-1", color.NRGBA{B: 255, A: 255}},
+	}
+	for _, tt := range tests {
+		out, stderr, err := runGwebp(t, nil, "dec", "-frame", tt.frame, "-o", "-", webpPath)
+		if err != nil {
+			t.Fatalf("dec -frame %s failed: %v\nstderr: %s", tt.frame, err, stderr)
+		}
+		img, err := png.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("dec -frame %s: decoding PNG output: %v", tt.frame, err)
+		}
+		if got := color.NRGBAModel.Convert(img.At(4, 4)); got != tt.want {
+			t.Errorf("dec -frame %s: pixel = %v, want %v", tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestDec_FrameErrors(t *testing.T) {
+	skipIfNoBinary(t)
+	dir := t.TempDir()
+	webpPath := createTestAnimatedWebP(t, dir)
+
+	_, stderr, err := runGwebp(t, nil, "dec", "-frame", "3", "-o", "-", webpPath)
+	if err == nil {
+		t.Fatal("expected non-zero exit for out-of-range frame")
+	}
+	assertContains(t, string(stderr), "out of range", "out-of-range frame error")
+
+	pngPath := createTestPNG(t, dir)
+	stillPath := filepath.Join(dir, "still.webp")
+	if _, _, err := runGwebp(t, nil, "enc", "-o", stillPath, pngPath); err != nil {
+		t.Fatalf("enc setup failed: %v", err)
+	}
+	_, stderr, err = runGwebp(t, nil, "dec", "-frame", "0", "-o", "-", stillPath)
+	if err == nil {
+		t.Fatal("expected non-zero exit for -frame on a still image")
+	}
+	assertContains(t, string(stderr), "animated", "still image error")
+}
+
+func TestDec_DumpMetadata(t *testing.T) {
+	skipIfNoBinary(t)
+	dir := t.TempDir()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	opts := webp.DefaultOptions()
+	opts.ICC = []byte("icc profile bytes")
+	opts.EXIF = []byte("exif bytes")
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	webpPath := filepath.Join(dir, "meta.webp")
+	if err := os.WriteFile(webpPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	iccPath := filepath.Join(dir, "out.icc")
+	exifPath := filepath.Join(dir, "out.exif")
+	xmpPath := filepath.Join(dir, "out.xmp")
+	_, stderr, err := runGwebp(t, nil, "dec", "-icc", iccPath, "-exif", exifPath, "-xmp", xmpPath,
+		"-o", filepath.Join(dir, "out.png"), webpPath)
+	if err != nil {
+		t.Fatalf("dec failed: %v\nstderr: %s", err, stderr)
+	}
+
+	if got, err := os.ReadFile(iccPath); err != nil || !bytes.Equal(got, opts.ICC) {
+		t.Errorf("ICC file = %q, %v; want %q", got, err, opts.ICC)
+	}
+	if got, err := os.ReadFile(exifPath); err != nil || !bytes.Equal(got, opts.EXIF) {
+		t.Errorf("EXIF file = %q, %v; want %q", got, err, opts.EXIF)
+	}
+	if _, err := os.Stat(xmpPath); !os.IsNotExist(err) {
+		t.Errorf("XMP file should not be written when the chunk is absent (stat err: %v)", err)
+	}
+}
+
 // --- info tests ---
 
 func TestInfo_LossyFile(t *testing.T) {
@@ -489,6 +622,73 @@ func TestInfo_MissingInput(t *testing.T) {
 	}
 }
 
+func TestInfo_BytesPerPixel(t *testing.T) {
+	skipIfNoBinary(t)
+
+	// 68 bytes for a 4x4 image.
+	webpFile := filepath.Join(testdataDir(), "red_4x4_lossy.webp")
+	stdout, stderr, err := runGwebp(t, nil, "info", webpFile)
+	if err != nil {
+		t.Fatalf("info failed: %v\nstderr: %s", err, stderr)
+	}
+	assertContains(t, string(stdout), "Bytes/pixel: 4.2500", "expected bytes per pixel")
+
+	stdout, _, err = runGwebp(t, nil, "info", filepath.Join(testdataDir(), "red_4x4_lossless.webp"))
+	if err != nil {
+		t.Fatalf("info failed: %v", err)
+	}
+	assertContains(t, string(stdout), "Format:      lossless", "expected the lossless format")
+
+	stdout, _, err = runGwebp(t, nil, "info", createTestAnimatedWebP(t, t.TempDir()))
+	if err != nil {
+		t.Fatalf("info failed: %v", err)
+	}
+	assertContains(t, string(stdout), "Format:      extended/animated", "expected the animated format")
+}
+
+func TestInfo_Verbose(t *testing.T) {
+	skipIfNoBinary(t)
+
+	webpFile := filepath.Join(testdataDir(), "blue_16x16_lossy.webp")
+	stdout, stderr, err := runGwebp(t, nil, "info", "-v", webpFile)
+	if err != nil {
+		t.Fatalf("info -v failed: %v\nstderr: %s", err, stderr)
+	}
+
+	out := string(stdout)
+	assertContains(t, out, "Chunk:       VP8  offset=12 size=", "expected the VP8 chunk")
+	assertContains(t, out, "ICC:         none", "expected ICC presence")
+	assertContains(t, out, "Profile:     0", "expected the VP8 profile")
+	assertContains(t, out, "Partitions:  1", "expected the partition count")
+
+	// Every value starts in the same column, after the longest label,
+	// "Bytes/pixel:".
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if len(line) < 14 || line[12] != ' ' || line[13] == ' ' {
+			t.Errorf("misaligned line %q", line)
+		}
+	}
+}
+
+func TestInfo_VerboseAnimation(t *testing.T) {
+	skipIfNoBinary(t)
+
+	webpFile := createTestAnimatedWebP(t, t.TempDir())
+	stdout, stderr, err := runGwebp(t, nil, "info", "-v", webpFile)
+	if err != nil {
+		t.Fatalf("info -v failed: %v\nstderr: %s", err, stderr)
+	}
+
+	out := string(stdout)
+	assertContains(t, out, "Chunk:       ANIM", "expected the ANIM chunk")
+	assertContains(t, out, "Chunk:         VP8L", "expected nested frame bitstreams")
+	for i := 0; i < 3; i++ {
+		assertContains(t, out, fmt.Sprintf("Frame:       %d offset=", i), "expected one line per frame")
+	}
+	assertContains(t, out, "duration=100ms", "expected frame durations")
+	assertContains(t, out, "dispose=", "expected dispose methods")
+}
+
 // --- dec with testdata files ---
 
 func TestDec_TestdataLossy(t *testing.T) {
@@ -0,0 +1,110 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/deepteams/webp/internal/container"
+	"github.com/deepteams/webp/internal/lossless"
+	"github.com/deepteams/webp/internal/lossy"
+)
+
+// Decoder decodes a stream of WebP images, such as video frames stored as
+// separate files, keeping its memory from one image to the next: the input
+// buffer, the VP8 reconstruction planes and the VP8L pixel and transform
+// buffers are reused, and the pixels are written into an image the caller
+// provides. Once it has seen the largest image of the stream, decoding
+// allocates almost nothing, unlike [Decode], whose pooled buffers may be
+// dropped by any garbage collection and which returns a new image each
+// time. The alpha plane of lossy images is still decoded into a new
+// buffer.
+//
+// A Decoder is not safe for concurrent use: give each goroutine its own.
+type Decoder struct {
+	buf  bytes.Buffer
+	vp8  lossy.Decoder
+	vp8l lossless.Decoder
+	sub  image.NRGBA // first frame of an animation that does not fill the canvas
+}
+
+// NewDecoder returns a Decoder with no buffers allocated yet.
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// Decode reads a WebP image from r and decodes it into dst, with the pixels
+// [DecodeRaw] returns in PixelFormatRGBA: lossy images are converted with
+// fancy chroma upsampling, and only the first frame of an animation is
+// decoded, composited onto the canvas as by [Decode]. dst is resized to the image, with its origin at (0, 0); its Pix
+// is reused if it is large enough and replaced otherwise, so passing the
+// same dst for each image of a stream avoids reallocating it.
+func (d *Decoder) Decode(dst *image.NRGBA, r io.Reader) error {
+	if dst == nil {
+		return errors.New("webp: nil destination image")
+	}
+	if r == nil {
+		return errors.New("webp: nil reader")
+	}
+	d.buf.Reset()
+	if _, err := d.buf.ReadFrom(io.LimitReader(r, MaxInputSize+1)); err != nil {
+		return fmt.Errorf("webp: reading data: %w", err)
+	}
+	if d.buf.Len() > MaxInputSize {
+		return fmt.Errorf("webp: input too large (exceeds %d bytes)", MaxInputSize)
+	}
+	p, err := container.NewParser(d.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("webp: parsing container: %w", err)
+	}
+	frames := p.Frames()
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+	if err := verifyChecksum(p); err != nil {
+		return err
+	}
+	frame := frames[0]
+	if coversCanvas(p, frame) {
+		return d.decodeFrame(dst, frame)
+	}
+	if err := d.decodeFrame(&d.sub, frame); err != nil {
+		return err
+	}
+	feat := p.Features()
+	w, h := feat.CanvasWidth, feat.CanvasHeight
+	n := 4 * w * h
+	if cap(dst.Pix) < n {
+		dst.Pix = make([]uint8, n)
+	}
+	dst.Pix, dst.Stride, dst.Rect = dst.Pix[:n], 4*w, image.Rect(0, 0, w, h)
+	clear(dst.Pix)
+	draw.Draw(dst, frameRect(frame), &d.sub, image.Point{}, draw.Src)
+	return nil
+}
+
+// decodeFrame decodes frame into dst.
+func (d *Decoder) decodeFrame(dst *image.NRGBA, frame container.FrameInfo) error {
+	if frame.IsLossless {
+		if err := d.vp8l.DecodeInto(frame.Payload, dst); err != nil {
+			return fmt.Errorf("webp: lossless decode: %w", err)
+		}
+		return nil
+	}
+	width, height, yPlane, yStride, uPlane, vPlane, uvStride, err := d.vp8.DecodeFrame(frame.Payload, nil)
+	if err != nil {
+		return fmt.Errorf("webp: lossy decode: %w", err)
+	}
+	var alphaPlane []byte
+	if len(frame.AlphaData) > 0 {
+		alphaPlane, err = lossy.DecodeAlpha(frame.AlphaData, width, height)
+		if err != nil {
+			return fmt.Errorf("webp: alpha decode: %w", err)
+		}
+	}
+	buildNRGBAInto(dst, width, height, yPlane, yStride, uPlane, vPlane, uvStride, alphaPlane)
+	return nil
+}
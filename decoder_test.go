@@ -0,0 +1,78 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	translucent := gradientTestImage(37, 21)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = uint8(i)
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"lossy", mustEncode(t, gradientTestImage(64, 48), DefaultOptions())},
+		{"lossless", mustEncode(t, translucent, &EncoderOptions{Lossless: true, Exact: true})},
+		{"lossy_alpha", mustEncode(t, translucent, DefaultOptions())},
+		{"lossy_small", mustEncode(t, gradientTestImage(5, 3), DefaultOptions())},
+		{"lossless_icon", mustEncode(t, iconTestImage(16, 16), &EncoderOptions{Lossless: true})},
+	}
+
+	dec := NewDecoder()
+	// Stale pixels in dst must not leak into the decoded images.
+	dst := &image.NRGBA{Pix: bytes.Repeat([]byte{0x5a}, 4*64*48)}
+	pix := &dst.Pix[0]
+	for round := 0; round < 2; round++ {
+		for _, f := range files {
+			if err := dec.Decode(dst, bytes.NewReader(f.data)); err != nil {
+				t.Fatalf("%s: Decode: %v", f.name, err)
+			}
+			want, w, h, _, err := DecodeRaw(bytes.NewReader(f.data), PixelFormatRGBA)
+			if err != nil {
+				t.Fatalf("%s: DecodeRaw: %v", f.name, err)
+			}
+			if dst.Rect != image.Rect(0, 0, w, h) || dst.Stride != 4*w || !bytes.Equal(dst.Pix, want) {
+				t.Errorf("%s (round %d): decoded %v, stride %d; differs from DecodeRaw (%dx%d)", f.name, round, dst.Rect, dst.Stride, w, h)
+			}
+		}
+	}
+	if &dst.Pix[0] != pix {
+		t.Error("Decode reallocated a large enough dst.Pix")
+	}
+
+	if err := dec.Decode(nil, bytes.NewReader(files[0].data)); err == nil {
+		t.Error("Decode accepted a nil destination")
+	}
+	if err := dec.Decode(dst, bytes.NewReader([]byte("not a webp"))); err == nil {
+		t.Error("Decode accepted invalid data")
+	}
+	// A failed decode leaves the Decoder usable.
+	if err := dec.Decode(dst, bytes.NewReader(files[1].data)); err != nil {
+		t.Errorf("Decode after an error: %v", err)
+	}
+}
+
+func TestDecoder_Allocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping allocation count in short mode")
+	}
+	data := mustEncode(t, gradientTestImage(256, 256), DefaultOptions())
+	dec := NewDecoder()
+	dst := &image.NRGBA{}
+	r := bytes.NewReader(data)
+	decode := func() {
+		r.Reset(data)
+		if err := dec.Decode(dst, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decode()
+	// The container parser and bit readers still allocate a little.
+	if n := testing.AllocsPerRun(10, decode); n > 10 {
+		t.Errorf("%.0f allocations per decode, want at most 10", n)
+	}
+}
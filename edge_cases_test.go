@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"io"
 	"math/rand"
 	"sync"
 	"testing"
@@ -1100,6 +1101,200 @@ func TestEdge_Anim_MixedCodec(t *testing.T) {
 	}
 }
 
+func TestEdge_Anim_StreamDecoder(t *testing.T) {
+	// Build an animation with sub-frames, alpha and a merged duplicate.
+	var frames []image.Image
+	var delays []time.Duration
+	for i := 0; i < 6; i++ {
+		img := makeGradient(32, 24)
+		patch := makeNRGBA(6, 6, color.NRGBA{R: uint8(40 * i), B: 200, A: uint8(100 + 30*i)})
+		for y := 0; y < 6; y++ {
+			copy(img.Pix[(4+y)*img.Stride+(3*i)*4:], patch.Pix[y*patch.Stride:(y+1)*patch.Stride])
+		}
+		frames = append(frames, img)
+		delays = append(delays, time.Duration(40+10*i)*time.Millisecond)
+	}
+	frames = append(frames, frames[5])
+	delays = append(delays, 30*time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := animation.EncodeAll(&buf, frames, delays, &animation.EncodeOptions{
+		Lossless:  true,
+		LoopCount: 3,
+	}); err != nil {
+		t.Fatalf("EncodeAll: %v", err)
+	}
+
+	anim, err := animation.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if err := anim.DecodeFrames(); err != nil {
+		t.Fatalf("DecodeFrames: %v", err)
+	}
+	ref, err := animation.NewAnimDecoder(anim)
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+
+	sd, err := animation.NewStreamDecoder(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewStreamDecoder: %v", err)
+	}
+	if sd.CanvasWidth() != 32 || sd.CanvasHeight() != 24 || sd.LoopCount() != 3 {
+		t.Errorf("stream header = %dx%d loop %d, want 32x24 loop 3", sd.CanvasWidth(), sd.CanvasHeight(), sd.LoopCount())
+	}
+	n := 0
+	for ref.HasNext() {
+		want, wantDur, err := ref.NextFrame()
+		if err != nil {
+			t.Fatalf("AnimDecoder frame %d: %v", n, err)
+		}
+		got, gotDur, err := sd.NextFrame()
+		if err != nil {
+			t.Fatalf("StreamDecoder frame %d: %v", n, err)
+		}
+		if gotDur != wantDur {
+			t.Errorf("frame %d: duration %v, want %v", n, gotDur, wantDur)
+		}
+		if !bytes.Equal(got.Pix, want.Pix) {
+			t.Errorf("frame %d: stream canvas differs from AnimDecoder", n)
+		}
+		n++
+	}
+	if _, _, err := sd.NextFrame(); err != io.EOF {
+		t.Errorf("after %d frames: err = %v, want io.EOF", n, err)
+	}
+}
+
+func TestEdge_Anim_StreamDecoderStill(t *testing.T) {
+	img := makeGradient(16, 16)
+	data := mustEncode(t, img, &EncoderOptions{Lossless: true})
+	sd, err := animation.NewStreamDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewStreamDecoder: %v", err)
+	}
+	got, _, err := sd.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if !bytes.Equal(got.Pix, img.Pix) {
+		t.Error("still image pixels differ")
+	}
+	if _, _, err := sd.NextFrame(); err != io.EOF {
+		t.Errorf("second NextFrame: err = %v, want io.EOF", err)
+	}
+}
+
+func TestEdge_Anim_StreamDecoderTruncated(t *testing.T) {
+	frames := []image.Image{makeGradient(16, 16), makeNRGBA(16, 16, color.NRGBA{R: 255, A: 255})}
+	var buf bytes.Buffer
+	if err := animation.EncodeAll(&buf, frames, []time.Duration{time.Second, time.Second}, &animation.EncodeOptions{Lossless: true}); err != nil {
+		t.Fatalf("EncodeAll: %v", err)
+	}
+	data := buf.Bytes()[:buf.Len()-10]
+	sd, err := animation.NewStreamDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewStreamDecoder: %v", err)
+	}
+	var lastErr error
+	for i := 0; i < 3 && lastErr == nil; i++ {
+		_, _, lastErr = sd.NextFrame()
+	}
+	if lastErr == nil || lastErr == io.EOF {
+		t.Errorf("truncated stream: err = %v, want a read error", lastErr)
+	}
+}
+
+func TestEdge_Anim_RawFrames(t *testing.T) {
+	frames := []image.Image{
+		makeGradient(16, 16),
+		makeNRGBA(16, 16, color.NRGBA{R: 255, A: 255}),
+		makeNRGBA(16, 16, color.NRGBA{G: 255, A: 128}),
+	}
+	delays := []time.Duration{40 * time.Millisecond, 50 * time.Millisecond, 60 * time.Millisecond}
+	var buf bytes.Buffer
+	if err := animation.EncodeAll(&buf, frames, delays, &animation.EncodeOptions{Lossless: true}); err != nil {
+		t.Fatalf("EncodeAll: %v", err)
+	}
+
+	var got []*image.NRGBA
+	for img, d := range animation.RawFrames(bytes.NewReader(buf.Bytes())) {
+		if d != delays[len(got)] {
+			t.Errorf("frame %d: duration %v, want %v", len(got), d, delays[len(got)])
+		}
+		got = append(got, img)
+	}
+	if len(got) != len(frames) {
+		t.Fatalf("got %d frames, want %d", len(got), len(frames))
+	}
+	for i, img := range got {
+		// Kept canvases are not overwritten by later frames.
+		if !bytes.Equal(img.Pix, frames[i].(*image.NRGBA).Pix) {
+			t.Errorf("frame %d: canvas differs from the source", i)
+		}
+	}
+
+	n := 0
+	for range animation.RawFrames(bytes.NewReader(buf.Bytes())) {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("break: iterated %d frames, want 1", n)
+	}
+
+	sd, err := animation.NewStreamDecoder(bytes.NewReader(buf.Bytes()[:buf.Len()-10]))
+	if err != nil {
+		t.Fatalf("NewStreamDecoder: %v", err)
+	}
+	n = 0
+	for range sd.Frames() {
+		n++
+	}
+	if n == len(frames) || sd.Err() == nil {
+		t.Errorf("truncated: %d frames, Err = %v, want fewer frames and an error", n, sd.Err())
+	}
+	for range animation.RawFrames(bytes.NewReader([]byte("not a webp"))) {
+		t.Error("RawFrames yielded a frame for invalid data")
+	}
+}
+
+func TestEdge_Anim_PerFrameOptions(t *testing.T) {
+	photo := makeGradient(32, 32)
+	flat := makeNRGBA(32, 32, color.NRGBA{R: 20, G: 120, B: 220, A: 255})
+
+	var buf bytes.Buffer
+	enc := animation.NewEncoder(&buf, 32, 32, &animation.EncodeOptions{Quality: 75})
+	if err := enc.AddFrameOpts(photo, 100*time.Millisecond, &animation.FrameOptions{Lossless: true, Quality: 100, Method: 6}); err != nil {
+		t.Fatalf("AddFrameOpts 0: %v", err)
+	}
+	if err := enc.AddFrameOpts(flat, 100*time.Millisecond, &animation.FrameOptions{Quality: 50, Method: 2}); err != nil {
+		t.Fatalf("AddFrameOpts 1: %v", err)
+	}
+	if err := enc.AddFrameOpts(flat, 100*time.Millisecond, &animation.FrameOptions{Quality: 101}); err == nil {
+		t.Error("AddFrameOpts: expected error for quality 101")
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	anim, err := animation.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(anim.Frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(anim.Frames))
+	}
+	isVP8L := func(bs []byte) bool { return len(bs) > 0 && bs[0] == 0x2f }
+	if !isVP8L(anim.Frames[0].BitstreamData) {
+		t.Error("frame 0: want VP8L bitstream")
+	}
+	if isVP8L(anim.Frames[1].BitstreamData) {
+		t.Error("frame 1: want VP8 bitstream")
+	}
+}
+
 // --- Name helpers ---
 
 func intName(n int) string {
@@ -1,13 +1,18 @@
 package webp
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"io"
 	"math"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/deepteams/webp/internal/container"
 	"github.com/deepteams/webp/internal/lossless"
@@ -38,12 +43,95 @@ const (
 	PresetText
 )
 
+// LosslessTransform is a bitmask of VP8L transforms, used by
+// EncoderOptions.LosslessTransforms to restrict the lossless encoder.
+type LosslessTransform uint8
+
+const (
+	TransformPredictor     LosslessTransform = 1 << iota // spatial prediction
+	TransformCrossColor                                  // cross-color decorrelation
+	TransformSubtractGreen                               // subtract green from red/blue
+	TransformColorIndexing                               // palette (color indexing)
+
+	// TransformAll enables every transform; equivalent to zero.
+	TransformAll = TransformPredictor | TransformCrossColor | TransformSubtractGreen | TransformColorIndexing
+)
+
+// LosslessStats describes the transforms and color cache the VP8L encoder
+// chose for an image, as reported to EncoderOptions.LosslessStatsFunc.
+type LosslessStats struct {
+	UsedPredictor     bool // spatial prediction
+	UsedCrossColor    bool // cross-color decorrelation
+	UsedSubtractGreen bool // subtract green from red/blue
+	UsedColorIndex    bool // palette (color indexing)
+	PaletteSize       int  // colors in the palette; 0 without UsedColorIndex
+	CacheBits         int  // color cache size in bits; 0 without a cache
+}
+
+// AlphaFilter is a predictor applied to the alpha plane of a lossy image
+// before compression, used by EncoderOptions.AlphaFilterMethod.
+type AlphaFilter int
+
+const (
+	AlphaFilterAuto       AlphaFilter = iota // chosen by AlphaFiltering
+	AlphaFilterNone                          // no prediction
+	AlphaFilterHorizontal                    // predict from the left pixel
+	AlphaFilterVertical                      // predict from the pixel above
+	AlphaFilterGradient                      // predict from left + above - above-left
+)
+
+// IntraMode is a class of VP8 luma prediction the lossy encoder may use,
+// selected by EncoderOptions.IntraMode.
+type IntraMode int
+
+const (
+	IntraModeAuto    IntraMode = iota // 16x16 or 4x4, whichever is cheaper per macroblock
+	IntraModeI16Only                  // 16x16 prediction only
+	IntraModeI4Only                   // 4x4 prediction only
+)
+
 // EncoderOptions controls WebP encoding parameters.
 type EncoderOptions struct {
 	// Lossless enables VP8L lossless encoding.
 	// When false (default), VP8 lossy encoding is used.
 	Lossless bool
 
+	// AutoFormat makes Encode encode the image both lossy and lossless,
+	// with the other options unchanged, and write whichever is smaller;
+	// Lossless is ignored. Flat and graphic images usually come out
+	// lossless, photos lossy. It roughly doubles the encoding time.
+	// Ignored by EncodePaletted and EncodeGray.
+	AutoFormat bool
+
+	// LosslessFallback guards a Lossless encode against photos, which
+	// come out many times larger lossless than lossy: the image is also
+	// encoded lossy at LosslessFallbackQuality, and the smaller of the
+	// two files is written, lossless on a tie. Unlike AutoFormat, the
+	// other options keep their lossless meaning, Quality in particular.
+	// It roughly doubles the encoding time. Ignored without Lossless, with
+	// AutoFormat, and by EncodePaletted and EncodeGray.
+	LosslessFallback bool
+
+	// LosslessFallbackQuality is the lossy quality (0-100) of the
+	// LosslessFallback encode. 0 (or any value < 0) uses 75, the default
+	// lossy quality, so that an EncoderOptions literal keeps working.
+	LosslessFallbackQuality float32
+
+	// LosslessFallbackFunc, if set, is called by a LosslessFallback encode
+	// with the sizes of the two files and whether the lossy one was
+	// written.
+	LosslessFallbackFunc func(losslessSize, lossySize int, usedLossy bool)
+
+	// IgnoreColorModel makes Encode treat an *image.Paletted or *image.Gray
+	// like any other image. By default Encode writes an *image.Paletted as
+	// EncodePaletted does, lossless with its palette, whatever Lossless,
+	// AutoFormat and the lossy options say: indexed images are graphics that
+	// lossy compression both blurs and enlarges. Set IgnoreColorModel to
+	// encode one lossy. An *image.Gray still follows Lossless, since
+	// grayscale photos are far smaller lossy, but a lossless one is written
+	// as EncodeGray does, with the palette only tried for few gray levels.
+	IgnoreColorModel bool
+
 	// Quality is the compression quality (0-100, default 75).
 	// For lossy: lower means smaller files with more artifacts.
 	// For lossless: controls the compression effort.
@@ -56,28 +144,156 @@ type EncoderOptions struct {
 	//   6 = slowest, best compression
 	Method int
 
+	// TimeBudget, if positive, replaces Method with the highest effort that
+	// fits in the budget: Encode encodes at Method 0, then 1, 2, ... up to
+	// 6, starting each one only if, at the pace of the previous one, it
+	// would finish within TimeBudget of the call, and writes the last file
+	// completed. Method 0 always runs, so a budget too small for it still
+	// yields a file. Each encode is timed on the wall clock, so a loaded
+	// machine stops earlier. Pass and the other options are used as set.
+	// Ignored for lossless encoding with a LosslessEffort, which replaces
+	// Method, and by EncodePaletted and EncodeGray.
+	TimeBudget time.Duration
+
+	// IntraMode restricts the lossy encoder to one class of luma
+	// prediction: IntraModeI16Only (whole 16x16 macroblocks) or
+	// IntraModeI4Only (sixteen 4x4 sub-blocks, even at Methods below 2,
+	// which otherwise never try them). The default, IntraModeAuto, picks
+	// the cheaper per macroblock. A forced class gives simpler bitstreams
+	// for isolating decoder bugs and shows what the other class saves;
+	// files are usually larger. VP8Header reports the macroblocks of each
+	// class. Ignored for lossless.
+	IntraMode IntraMode
+
+	// LosslessEffort selects the lossless (VP8L) compression effort on
+	// libwebp's 0-9 scale (cwebp -z), 0 being fastest and 9 smallest.
+	// Each level maps to a Method/Quality pair that replaces Method and
+	// Quality for lossless encoding, matching WebPConfigLosslessPreset:
+	//   level:   0  1  2  3  4  5  6  7  8  9
+	//   method:  0  1  2  3  3  4  4  4  5  6
+	//   quality: 0 20 25 30 50 50 75 90 90 100
+	// Method picks the entropy-image and predictor/cross-color tile sizes;
+	// Quality enables the transforms (subtract-green from 25, cross-color
+	// from 50), widens the color-cache search (none up to 25, up to 7 bits
+	// below 90, up to 10 bits above) and turns on exhaustive LZ77 search
+	// at 90 and above.
+	// The default value 0 (or any value < 0) leaves Method and Quality in
+	// effect, so DefaultOptions behaves as level 6; level 0 itself is
+	// Method 0 with Quality 0.
+	LosslessEffort int
+
+	// LosslessTransforms restricts the VP8L encoder to the transforms whose
+	// bits are set. The encoder still decides per image whether each allowed
+	// transform pays off. Use it to produce files for decoders that mishandle
+	// a transform, e.g. TransformAll &^ TransformColorIndexing to avoid
+	// palettes. The zero value allows all transforms.
+	LosslessTransforms LosslessTransform
+
+	// UseColorCache controls the VP8L color cache. nil (the default) lets
+	// LosslessEffort/Quality decide which cache sizes to try; false disables
+	// the cache and skips its search, which speeds up encoding of icons and
+	// other images with few colors; true always uses a cache, of the size
+	// (1 to 10 bits) estimated to give the smallest output.
+	UseColorCache *bool
+
+	// LosslessStatsFunc, if set, is called after each lossless encode of
+	// the image with the transforms and color cache the VP8L encoder chose,
+	// to see why one image compresses better than another and whether
+	// forcing a transform (LosslessTransforms, UseColorCache) could help.
+	// AutoFormat and LosslessFallback may call it for an encode that is
+	// not written. It is not called for the alpha plane of lossy images.
+	LosslessStatsFunc func(LosslessStats)
+
 	// Preset selects encoding parameters tuned for specific content types.
 	Preset Preset
 
 	// UseSharpYUV enables sharp (and slow) RGB->YUV conversion.
 	UseSharpYUV bool
 
+	// SharpYUVIterations caps the number of gradient-descent refinement
+	// passes of the sharp RGB->YUV conversion (only used with UseSharpYUV).
+	// 0 uses libwebp's fixed count of 4; the refinement may stop earlier
+	// once it converges.
+	SharpYUVIterations int
+
+	// PreserveEdges reduces color bleed across sharp boundaries, such as
+	// flat-colored shapes in drawings and UI captures, in lossy output.
+	// VP8 always stores chroma at half resolution, so bleed cannot be
+	// removed entirely; this is a best-effort combination of the other
+	// knobs: it turns on UseSharpYUV and sets the loop filter to its
+	// sharpest setting (FilterSharpness 7) at half its FilterStrength,
+	// so that the deblocking filter smooths less across strong edges.
+	// Output is usually somewhat larger and slower to encode. Ignored for
+	// lossless.
+	PreserveEdges bool
+
+	// Grayscale stores only the luma of lossy images: both chroma planes
+	// are set to neutral (128) before encoding, so that they cost almost
+	// nothing, and color images are reduced to their luma. The image
+	// decodes to gray, with R, G and B within one of each other from the
+	// rounding of the YUV to RGB conversion. This shrinks black-and-white
+	// scans and photos whose color channels are slightly off; unlike
+	// EncodeGray, which stores lossless or alpha-only images, the output
+	// is lossy VP8. Ignored for lossless.
+	Grayscale bool
+
 	// Exact preserves the RGB values under transparent areas. In lossless
-	// mode, transparent pixels' RGB are kept as-is instead of being zeroed.
+	// mode, transparent pixels' RGB are kept as-is instead of being zeroed,
+	// so the decoded NRGBA image is bit-identical to the source; set it
+	// whenever the output must round-trip exactly (e.g. the RGB carries
+	// data, or is un-masked later). The cost is size: zeroed transparent
+	// areas compress to almost nothing, while kept RGB is coded like any
+	// other pixels, which can make files with large hidden areas several
+	// times larger. It is off by default, as in libwebp, for lossless too:
+	// every visible pixel round-trips exactly either way, and only callers
+	// that need the hidden RGB should pay for it.
 	// In lossy mode, it skips the transparent-area cleanup that normally
 	// flattens invisible pixels to reduce encoding cost. Note that lossy
 	// VP8 quantization will still modify pixel values regardless of this flag.
 	Exact bool
 
+	// AlphaThreshold, if non-zero, drops an alpha channel that is only
+	// there by accident, such as a few antialiasing leftovers in an
+	// otherwise opaque image: if fewer than 1 pixel in 200 has an alpha
+	// below AlphaThreshold, the image is composited onto FlattenBackground
+	// and encoded opaque, saving the ALPH chunk or the VP8L alpha plane.
+	// Pixels with an alpha of AlphaThreshold or more count as opaque, so 255
+	// tolerates only the rare pixel that is not fully opaque, and lower
+	// values also ignore any amount of slight translucency. 0 keeps the
+	// alpha channel whenever a pixel is not opaque. Applies to lossy and
+	// lossless encoding, which then no longer round-trips the alpha.
+	AlphaThreshold uint8
+
+	// FlattenBackground is the color the image is composited onto when
+	// AlphaThreshold drops its alpha channel; its own alpha is ignored.
+	// nil means white.
+	FlattenBackground color.Color
+
 	// TargetSize sets a target output size in bytes (0 = use quality instead).
 	TargetSize int
 
+	// TargetBPP sets a target size in bits per pixel (0 = disabled), for
+	// batches of images of different dimensions: the byte target is
+	// TargetBPP * width * height / 8, which then drives the same rate
+	// control as TargetSize. It cannot be combined with TargetSize or
+	// TargetPSNR. Ignored for lossless encoding.
+	TargetBPP float32
+
 	// TargetPSNR sets a target PSNR value (0 = disabled).
 	// When set (and TargetSize is 0), the encoder adjusts quality across
 	// multiple passes to converge toward this PSNR level.
 	// Matches C libwebp's WebPConfig::target_PSNR.
 	TargetPSNR float32
 
+	// TargetSSIM sets a target SSIM (0-1, 0 = disabled) for lossy encoding.
+	// When set (and TargetSize, TargetBPP and TargetPSNR are 0), the image
+	// is encoded at several qualities within QMin-QMax, each trial is
+	// decoded and compared to the source with SSIM, and the smallest
+	// quality reaching the target is kept. The search uses secant steps
+	// and at most 8 trial encodes; if no quality reaches the target, the
+	// QMax result is used. Ignored for lossless encoding.
+	TargetSSIM float32
+
 	// Preprocessing selects preprocessing applied before/during encoding
 	// (lossy encoding only). This is a bitmask matching C libwebp's
 	// WebPConfig::preprocessing field:
@@ -127,6 +343,16 @@ type EncoderOptions struct {
 	// The default value -1 (or any value < 0) is treated as 4.
 	Segments int
 
+	// SegmentFilterStrengths forces the deblocking filter level of each of
+	// the four segments, for matching a reference encode or tuning the
+	// filter per complexity class. Segments are numbered as the analysis
+	// pass assigns them (see SegmentMapFunc). An entry of 0 keeps the level
+	// derived from FilterStrength and the segment's quantizer, so that a
+	// partial literal such as [4]int{0: 40} leaves the other segments
+	// alone; 1-63 forces that level, and -1 (or any value < 0) turns the
+	// filter off for the segment. Ignored for lossless.
+	SegmentFilterStrengths [4]int
+
 	// Pass controls the number of entropy-analysis passes (1-10, default 1).
 	// Higher values improve compression at the cost of encoding speed.
 	// Matches C libwebp's WebPConfig::pass.
@@ -142,13 +368,32 @@ type EncoderOptions struct {
 
 	// QMin sets the minimum quantizer value (0-100, default 0).
 	// Must be <= QMax. Matches C libwebp's WebPConfig::qmin.
+	//
+	// In lossy mode QMin and QMax bound the quality searched by rate
+	// control (TargetSize, TargetBPP, TargetPSNR, TargetSSIM); a plain
+	// Quality is used as given. In lossless mode they clamp the effective
+	// VP8L quality, the encoding effort, whether it comes from Quality or
+	// LosslessEffort: a low QMax caps the effort (faster, usually larger
+	// files) and a high QMin raises it.
 	QMin int
 
 	// QMax sets the maximum quantizer value (0-100, default 100).
 	// Must be >= QMin. Matches C libwebp's WebPConfig::qmax.
-	// The default value -1 (or any value < 0) is treated as 100.
+	// The default value -1 (or any value < 0) is treated as 100, and so is
+	// 0 in lossless mode, as it is by lossy rate control.
 	QMax int
 
+	// QuantIndex sets the raw VP8 quantizer index (1-127, higher is
+	// coarser) instead of deriving it from Quality, e.g. to reproduce a
+	// reference bitstream. It is the base quantizer: with spatial noise
+	// shaping the segments are still offset around it (use SNSStrength 0
+	// for a single index everywhere). It cannot be combined with
+	// TargetSize, TargetBPP, TargetPSNR or TargetSSIM. Ignored for lossless.
+	// 0 (or any value < 0) derives the index from Quality, so that an
+	// EncoderOptions literal keeps working; index 0 is what Quality 100
+	// maps to.
+	QuantIndex int
+
 	// AlphaCompression selects the compression method for the alpha channel
 	// (lossy encoding only, ignored for lossless which handles alpha natively).
 	// Matches C libwebp's WebPConfig::alpha_compression.
@@ -166,6 +411,13 @@ type EncoderOptions struct {
 	// The default value -1 (or any value < 0) is treated as 1 (fast).
 	AlphaFiltering int
 
+	// AlphaFilterMethod forces a single alpha predictor instead of letting
+	// AlphaFiltering choose one (lossy encoding with AlphaCompression 1
+	// only; uncompressed alpha is never filtered). The default,
+	// AlphaFilterAuto, leaves the choice to AlphaFiltering, whose "best"
+	// mode tries all four predictors and keeps the smallest result.
+	AlphaFilterMethod AlphaFilter
+
 	// AlphaQuality controls the quality of the alpha channel encoding,
 	// independently of the main image quality (lossy encoding only).
 	// Range: 0-100. Values below 100 enable alpha level quantization
@@ -173,6 +425,69 @@ type EncoderOptions struct {
 	// The default value -1 (or any value < 0) is treated as 100.
 	AlphaQuality int
 
+	// AlphaOnly makes EncodeGray store the gray plane as the alpha channel
+	// (ALPH chunk) of a lossy image whose color is constant black, instead
+	// of as a lossless grayscale image. Ignored by Encode.
+	AlphaOnly bool
+
+	// ROIMap marks regions of interest for lossy encoding. Its bounds must
+	// have the same size as the image; pixel values are importance, 128
+	// being neutral: macroblocks with higher values get a lower quantizer
+	// (better quality) and lower values a higher one. VP8 quantizes per
+	// segment, not per pixel, so the map is averaged over each 16x16
+	// macroblock, the averages steer the segment assignment, and each
+	// segment's quantizer is offset by its mean importance. Effective only
+	// with more than one segment (see Segments). Ignored for lossless.
+	ROIMap *image.Gray
+
+	// Analysis, from AnalyzeImage, supplies the macroblock complexities
+	// that the lossy encoder otherwise computes from the image before
+	// assigning segments, saving that pass when the same image is encoded
+	// again, at other qualities for instance. It must have been computed
+	// for an image of the same size. Ignored for lossless.
+	Analysis *Analysis
+
+	// SingleThreaded runs the lossy encoder entirely on the calling
+	// goroutine, skipping the parallel RGB->YUV import, analysis and
+	// macroblock encoding. Parallel output is already deterministic, but
+	// the parallel macroblock pass is only used with more than one CPU and
+	// its output differs slightly from the serial pass. SingleThreaded
+	// makes the bytes independent of the machine and of scheduling, e.g.
+	// for reproducible builds. Ignored for lossless.
+	SingleThreaded bool
+
+	// NumThreads caps the number of goroutines the lossy encoder uses for
+	// its parallel stages, e.g. to match a container's CPU quota without
+	// changing GOMAXPROCS. 0 (or any value < 0) uses GOMAXPROCS; 1 is
+	// equivalent to SingleThreaded. Ignored for lossless.
+	NumThreads int
+
+	// TileSize, if positive, bounds the scratch memory of the lossy
+	// encoder's RGB to YUV conversion: the image is read and converted one
+	// tile of at most TileSize x TileSize macroblocks (16x16 pixels) at a
+	// time, through a single tile-sized buffer. Without it, images with
+	// transparency (whose transparent areas are cleaned up before
+	// encoding, unless Exact is set) and 16-bit images are first copied
+	// to a full-size NRGBA image, 4 bytes per pixel. The output is still a
+	// single VP8 frame, and the encoder still holds its full-frame YUV
+	// planes and per-macroblock state. The conversion is serial, so it is
+	// slower, and the cleanup runs per tile: fully transparent blocks at
+	// the left edge of a tile may be flattened to a different (invisible)
+	// color, which can cost a few bytes; otherwise the output is
+	// unchanged. Ignored for lossless, with UseSharpYUV or dithering, and
+	// for *image.Gray and *image.YCbCr, which are never copied.
+	TileSize int
+
+	// SegmentMapFunc, if set, is called by the lossy encoder after its
+	// analysis pass with the macroblock grid size, the number of segments
+	// left once segments with identical quantizer and filter strength are
+	// merged, and a copy of the segment (0 to numSegments-1) of each
+	// macroblock in row-major order, to visualize the adaptive
+	// quantization. It is called once per lossy encode of the image, so
+	// searches (TargetSSIM, AutoFormat) may call it for trials that are not
+	// written. Ignored for lossless.
+	SegmentMapFunc func(mbW, mbH, numSegments int, segments []uint8)
+
 	// ICC holds an ICC color profile to embed in the output.
 	// When non-nil, the encoder uses VP8X extended format with the ICCP chunk.
 	ICC []byte
@@ -184,6 +499,63 @@ type EncoderOptions struct {
 	// XMP holds XMP metadata to embed in the output.
 	// When non-nil, the encoder uses VP8X extended format with the XMP chunk.
 	XMP []byte
+
+	// Strict checks each encoded file against the container rules of the
+	// WebP specification, as Validate does, before writing it: chunk order
+	// and sizes, zero padding bytes, VP8X flags that match the chunks, ALPH
+	// before VP8, and a canvas of the image's size. A file that fails is
+	// not written and the encode returns an error wrapping
+	// ErrInvalidStructure; this is a safety net against encoder bugs, not
+	// a check of the input. The file is assembled in memory, even when w
+	// is an io.Seeker. Applies to Encode, EncodePaletted and EncodeGray.
+	Strict bool
+
+	// NoExtendedFormat guarantees a simple VP8 or VP8L container, for
+	// decoders that predate the extended (VP8X) format. Instead of
+	// upgrading to VP8X, the encode fails with an error wrapping
+	// ErrNeedsExtendedFormat if the file would need it: when ICC, EXIF or
+	// XMP is set, or when a lossy image has alpha (lossless images carry
+	// their alpha in the VP8L bitstream and are always accepted).
+	NoExtendedFormat bool
+
+	// EmbedChecksum adds a CKSM chunk holding the CRC-32 (IEEE) of the
+	// image data, the ALPH and VP8 or VP8L payloads, after the other
+	// chunks, so that Validate and the Decode functions can detect
+	// accidental corruption: they return an error wrapping
+	// ErrChecksumMismatch when it does not match. The chunk is not part of
+	// the WebP specification; other decoders skip it as an unknown chunk.
+	// It needs the extended (VP8X) format, so it cannot be combined with
+	// NoExtendedFormat.
+	EmbedChecksum bool
+}
+
+// ErrNeedsExtendedFormat is returned when EncoderOptions.NoExtendedFormat is
+// set and the image or the options require the extended (VP8X) format.
+var ErrNeedsExtendedFormat = errors.New("webp: image needs the extended (VP8X) format")
+
+// strictEncodeHook, if set, alters encoded files before the Strict check.
+// Tests use it to stand in for a broken encoder.
+var strictEncodeHook func(data []byte) []byte
+
+// encodeStrict implements EncoderOptions.Strict: it runs encode, with
+// Strict cleared, into memory, checks the result for a width x height
+// image, and writes it to w if it passes.
+func encodeStrict(w io.Writer, width, height int, opts *EncoderOptions, encode func(io.Writer, *EncoderOptions) error) error {
+	o := *opts
+	o.Strict = false
+	var buf bytes.Buffer
+	if err := encode(&buf, &o); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if strictEncodeHook != nil {
+		data = strictEncodeHook(data)
+	}
+	if err := checkEncoded(data, width, height); err != nil {
+		return fmt.Errorf("webp: encoded file failed the Strict check: %w", err)
+	}
+	_, err := w.Write(data)
+	return err
 }
 
 // Options is an alias for backward compatibility.
@@ -260,15 +632,39 @@ func validateConfig(opts *EncoderOptions) error {
 	if opts.Quality < 0 || opts.Quality > 100 || math.IsNaN(float64(opts.Quality)) || math.IsInf(float64(opts.Quality), 0) {
 		return fmt.Errorf("webp: invalid Quality %.2f (must be 0-100, finite)", opts.Quality)
 	}
+	if opts.LosslessFallbackQuality > 100 || math.IsNaN(float64(opts.LosslessFallbackQuality)) {
+		return fmt.Errorf("webp: invalid LosslessFallbackQuality %.2f (must be 0-100)", opts.LosslessFallbackQuality)
+	}
 	if opts.Method < 0 || opts.Method > 6 {
 		return fmt.Errorf("webp: invalid Method %d (must be 0-6)", opts.Method)
 	}
+	if opts.TimeBudget < 0 {
+		return fmt.Errorf("webp: invalid TimeBudget %v (must be >= 0)", opts.TimeBudget)
+	}
+	if opts.LosslessEffort > 9 {
+		return fmt.Errorf("webp: invalid LosslessEffort %d (must be 0-9)", opts.LosslessEffort)
+	}
+	if opts.SharpYUVIterations < 0 || opts.SharpYUVIterations > 100 {
+		return fmt.Errorf("webp: invalid SharpYUVIterations %d (must be 0-100)", opts.SharpYUVIterations)
+	}
+	if opts.LosslessTransforms&^TransformAll != 0 {
+		return fmt.Errorf("webp: invalid LosslessTransforms %#x", uint8(opts.LosslessTransforms))
+	}
 	if opts.TargetSize < 0 {
 		return fmt.Errorf("webp: invalid TargetSize %d (must be >= 0)", opts.TargetSize)
 	}
+	if opts.TargetBPP < 0 || math.IsNaN(float64(opts.TargetBPP)) || math.IsInf(float64(opts.TargetBPP), 0) {
+		return fmt.Errorf("webp: invalid TargetBPP %.2f (must be >= 0, finite)", opts.TargetBPP)
+	}
+	if opts.TargetBPP > 0 && (opts.TargetSize > 0 || opts.TargetPSNR > 0) {
+		return fmt.Errorf("webp: TargetBPP cannot be combined with TargetSize or TargetPSNR")
+	}
 	if opts.TargetPSNR < 0 || math.IsNaN(float64(opts.TargetPSNR)) || math.IsInf(float64(opts.TargetPSNR), 0) {
 		return fmt.Errorf("webp: invalid TargetPSNR %.2f (must be >= 0, finite)", opts.TargetPSNR)
 	}
+	if opts.TargetSSIM < 0 || opts.TargetSSIM > 1 || math.IsNaN(float64(opts.TargetSSIM)) {
+		return fmt.Errorf("webp: invalid TargetSSIM %.4f (must be 0-1)", opts.TargetSSIM)
+	}
 	if opts.Preprocessing < 0 || opts.Preprocessing > 3 {
 		return fmt.Errorf("webp: invalid Preprocessing %d (must be 0-3)", opts.Preprocessing)
 	}
@@ -287,6 +683,11 @@ func validateConfig(opts *EncoderOptions) error {
 	if opts.FilterStrength > 100 {
 		return fmt.Errorf("webp: invalid FilterStrength %d (must be 0-100 or negative sentinel)", opts.FilterStrength)
 	}
+	for i, f := range opts.SegmentFilterStrengths {
+		if f > 63 {
+			return fmt.Errorf("webp: invalid SegmentFilterStrengths[%d] %d (must be 1-63, 0 for the computed level or negative for off)", i, f)
+		}
+	}
 	if opts.FilterSharpness < 0 || opts.FilterSharpness > 7 {
 		return fmt.Errorf("webp: invalid FilterSharpness %d (must be 0-7)", opts.FilterSharpness)
 	}
@@ -307,6 +708,15 @@ func validateConfig(opts *EncoderOptions) error {
 	if qmin < 0 || qmax > 100 || qmin > qmax {
 		return fmt.Errorf("webp: invalid QMin/QMax %d/%d (must be 0-100, QMin <= QMax)", opts.QMin, opts.QMax)
 	}
+	if opts.IntraMode < IntraModeAuto || opts.IntraMode > IntraModeI4Only {
+		return fmt.Errorf("webp: invalid IntraMode %d (must be 0-2)", opts.IntraMode)
+	}
+	if opts.QuantIndex > 127 {
+		return fmt.Errorf("webp: invalid QuantIndex %d (must be 1-127, or 0 to use Quality)", opts.QuantIndex)
+	}
+	if opts.QuantIndex > 0 && !opts.Lossless && (opts.TargetSize > 0 || opts.TargetBPP > 0 || opts.TargetPSNR > 0 || opts.TargetSSIM > 0) {
+		return fmt.Errorf("webp: QuantIndex cannot be combined with TargetSize, TargetBPP, TargetPSNR or TargetSSIM")
+	}
 
 	// Validate alpha options.
 	if opts.AlphaCompression > 1 {
@@ -315,6 +725,9 @@ func validateConfig(opts *EncoderOptions) error {
 	if opts.AlphaFiltering > 2 {
 		return fmt.Errorf("webp: invalid AlphaFiltering %d (must be 0, 1 or 2)", opts.AlphaFiltering)
 	}
+	if opts.AlphaFilterMethod < AlphaFilterAuto || opts.AlphaFilterMethod > AlphaFilterGradient {
+		return fmt.Errorf("webp: invalid AlphaFilterMethod %d (must be 0-4)", opts.AlphaFilterMethod)
+	}
 	if opts.AlphaQuality > 100 {
 		return fmt.Errorf("webp: invalid AlphaQuality %d (must be 0-100)", opts.AlphaQuality)
 	}
@@ -330,9 +743,36 @@ func validateConfig(opts *EncoderOptions) error {
 	if len(opts.XMP) > maxEncoderMetadataSize {
 		return fmt.Errorf("webp: XMP data too large (%d bytes, max %d)", len(opts.XMP), maxEncoderMetadataSize)
 	}
+	if opts.NoExtendedFormat {
+		if err := checkNoExtendedFormat(nil, opts.ICC, opts.EXIF, opts.XMP, opts.EmbedChecksum); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// checkNoExtendedFormat returns an error wrapping ErrNeedsExtendedFormat
+// naming the first of alphaData, icc, exif, xmp and checksum that is
+// present.
+func checkNoExtendedFormat(alphaData, icc, exif, xmp []byte, checksum bool) error {
+	var what string
+	switch {
+	case len(alphaData) > 0:
+		what = "lossy alpha"
+	case len(icc) > 0:
+		what = "ICC profile"
+	case len(exif) > 0:
+		what = "EXIF metadata"
+	case len(xmp) > 0:
+		what = "XMP metadata"
+	case checksum:
+		what = "checksum"
+	default:
+		return nil
+	}
+	return fmt.Errorf("%w for %s", ErrNeedsExtendedFormat, what)
+}
+
 // resolveSNSStrength returns the effective SNS strength.
 // Negative values (sentinels) map to 50, matching C libwebp's default.
 func resolveSNSStrength(v int) int {
@@ -351,6 +791,17 @@ func resolveFilterStrength(v int) int {
 	return v
 }
 
+// preserveEdgesOptions returns a copy of opts with the settings of
+// EncoderOptions.PreserveEdges applied.
+func preserveEdgesOptions(opts *EncoderOptions) *EncoderOptions {
+	o := *opts
+	o.PreserveEdges = false
+	o.UseSharpYUV = true
+	o.FilterSharpness = 7
+	o.FilterStrength = resolveFilterStrength(o.FilterStrength) / 2
+	return &o
+}
+
 // resolveFilterType returns the effective filter type.
 // Negative values (sentinels) map to 1 (strong), matching C libwebp's default.
 func resolveFilterType(v int) int {
@@ -387,6 +838,55 @@ func resolveQMax(v int) int {
 	return v
 }
 
+// losslessPresets maps LosslessEffort levels to VP8L method and quality,
+// matching C libwebp's kLosslessPresets (config_enc.c).
+var losslessPresets = [10]struct{ method, quality int }{
+	{0, 0}, {1, 20}, {2, 25}, {3, 30}, {3, 50},
+	{4, 50}, {4, 75}, {4, 90}, {5, 90}, {6, 100},
+}
+
+// losslessConfig returns the VP8L encoder configuration for opts, applying
+// LosslessEffort when it is set (level 0 is indistinguishable from unset,
+// but it equals Method 0 with Quality 0 anyway) and clamping the quality to
+// QMin-QMax.
+func losslessConfig(opts *EncoderOptions) *lossless.EncoderConfig {
+	cfg := &lossless.EncoderConfig{
+		Quality:             int(opts.Quality),
+		Method:              opts.Method,
+		NearLosslessQuality: 100,
+		// The bit order matches the VP8L transform type codes.
+		Transforms: uint8(opts.LosslessTransforms),
+	}
+	if opts.LosslessEffort > 0 {
+		p := losslessPresets[opts.LosslessEffort]
+		cfg.Method, cfg.Quality = p.method, p.quality
+	}
+	qmax := resolveQMax(opts.QMax)
+	if qmax <= 0 {
+		qmax = 100 // Zero-value QMax, treated as unset like the lossy rate control does.
+	}
+	cfg.Quality = min(max(cfg.Quality, opts.QMin), qmax)
+	if opts.UseColorCache != nil {
+		cfg.ColorCache = lossless.ColorCacheOff
+		if *opts.UseColorCache {
+			cfg.ColorCache = lossless.ColorCacheOn
+		}
+	}
+	if fn := opts.LosslessStatsFunc; fn != nil {
+		cfg.StatsFunc = func(s lossless.Stats) {
+			fn(LosslessStats{
+				UsedPredictor:     s.Predictor,
+				UsedCrossColor:    s.CrossColor,
+				UsedSubtractGreen: s.SubtractGreen,
+				UsedColorIndex:    s.ColorIndexing,
+				PaletteSize:       s.PaletteSize,
+				CacheBits:         s.CacheBits,
+			})
+		}
+	}
+	return cfg
+}
+
 // resolveAlphaCompression returns the effective alpha compression method.
 // Negative values (sentinels) and the zero-value (for backward compatibility
 // with callers that don't set this field) map to 1 (lossless).
@@ -421,6 +921,10 @@ func resolveAlphaQuality(v int) int {
 //
 // Encode is safe for concurrent use and deterministic: the output bytes
 // depend only on img and opts.
+//
+// If w implements io.Seeker (an *os.File for a regular file, for instance)
+// the container is streamed to it and its size fields are patched in
+// afterwards, instead of assembling the whole file in memory first.
 func Encode(w io.Writer, img image.Image, opts *EncoderOptions) error {
 	if w == nil {
 		return fmt.Errorf("webp: nil writer")
@@ -442,9 +946,35 @@ func Encode(w io.Writer, img image.Image, opts *EncoderOptions) error {
 	if imgW > MaxDimension || imgH > MaxDimension {
 		return fmt.Errorf("webp: image dimension %dx%d exceeds maximum %d", imgW, imgH, MaxDimension)
 	}
+	if opts.Strict {
+		return encodeStrict(w, imgW, imgH, opts, func(w io.Writer, o *EncoderOptions) error {
+			return Encode(w, img, o)
+		})
+	}
 
+	if opts.AlphaThreshold > 0 {
+		img = flattenStrayAlpha(img, opts.AlphaThreshold, opts.FlattenBackground)
+	}
+	if opts.TimeBudget > 0 && !(opts.Lossless && opts.LosslessEffort > 0) {
+		return encodeTimeBudget(w, img, opts)
+	}
+	if p, ok := img.(*image.Paletted); ok && !opts.IgnoreColorModel {
+		return EncodePaletted(w, p, opts)
+	}
+	if opts.AutoFormat {
+		return encodeAutoFormat(w, img, opts)
+	}
+	if opts.Lossless && opts.LosslessFallback {
+		return encodeLosslessFallback(w, img, opts)
+	}
+	if g, ok := img.(*image.Gray); ok && opts.Lossless && !opts.IgnoreColorModel {
+		o := *opts
+		o.AlphaOnly = false
+		o.IgnoreColorModel = true
+		return EncodeGray(w, g, &o)
+	}
 	if opts.Lossless {
-		hasMetadata := len(opts.ICC) > 0 || len(opts.EXIF) > 0 || len(opts.XMP) > 0
+		hasMetadata := len(opts.ICC) > 0 || len(opts.EXIF) > 0 || len(opts.XMP) > 0 || opts.EmbedChecksum
 		if !hasMetadata {
 			// Fast streaming path: write RIFF header + bitstream directly to w,
 			// avoiding intermediate buffer copies.
@@ -458,86 +988,244 @@ func Encode(w io.Writer, img image.Image, opts *EncoderOptions) error {
 		return writeRIFF(w, fourcc, bitstream, nil, imgW, imgH, opts)
 	}
 
-	bitstream, alphaData, fourcc, err := encodeLossyWithAlpha(img, opts)
+	encodeLossyFn := encodeLossyWithAlpha
+	if opts.TargetSSIM > 0 && opts.TargetSize == 0 && opts.TargetBPP == 0 && opts.TargetPSNR == 0 {
+		encodeLossyFn = encodeLossyTargetSSIM
+	}
+	bitstream, alphaData, fourcc, err := encodeLossyFn(img, opts)
 	if err != nil {
 		return err
 	}
 	return writeRIFF(w, fourcc, bitstream, alphaData, imgW, imgH, opts)
 }
 
-// encodeLossyWithAlpha encodes the image as a VP8 lossy bitstream and,
-// if the source image has any non-opaque pixels, also encodes the alpha
-// plane as an ALPH chunk payload using VP8L lossless compression.
-// Returns (vp8Bitstream, alphChunkData, fourcc, error).
-func encodeLossyWithAlpha(img image.Image, opts *EncoderOptions) ([]byte, []byte, uint32, error) {
-	// Cache alpha detection result to avoid redundant full-image scans.
-	hasAlpha := imageHasAlpha(img)
-	if !opts.Exact {
-		img = cleanupTransparentAreaLossyWith(img, hasAlpha)
+// encodeAutoFormat implements EncoderOptions.AutoFormat: it encodes img
+// lossless and lossy and writes the smaller file, preferring lossless on a
+// tie.
+func encodeAutoFormat(w io.Writer, img image.Image, opts *EncoderOptions) error {
+	o := *opts
+	o.AutoFormat = false
+	o.Lossless = true
+	var ll bytes.Buffer
+	if err := Encode(&ll, img, &o); err != nil {
+		return err
 	}
-	cfg := lossy.DefaultConfig(int(opts.Quality))
-	cfg.Method = opts.Method
-	if opts.TargetSize > 0 {
-		cfg.TargetSize = opts.TargetSize
+	o.Lossless = false
+	var ly bytes.Buffer
+	if err := Encode(&ly, img, &o); err != nil {
+		return err
 	}
-	if opts.TargetPSNR > 0 {
-		cfg.TargetPSNR = opts.TargetPSNR
+	best := ll.Bytes()
+	if ly.Len() < ll.Len() {
+		best = ly.Bytes()
 	}
-	// Propagate QMin/QMax for rate control clamping (matching C libwebp).
-	cfg.QMin = opts.QMin
-	cfg.QMax = resolveQMax(opts.QMax)
-	// Propagate lossy encoding options from the public EncoderOptions to
-	// the internal EncodeConfig. Fields with sentinel values (< 0) keep
-	// the defaults already set by DefaultConfig().
-	if opts.SNSStrength >= 0 {
-		cfg.SNSStrength = opts.SNSStrength
+	_, err := w.Write(best)
+	return err
+}
+
+// encodeTimeBudget implements EncoderOptions.TimeBudget: it encodes img at
+// increasing Methods while the next one is expected to fit in the budget
+// and writes the last file completed.
+func encodeTimeBudget(w io.Writer, img image.Image, opts *EncoderOptions) error {
+	start := time.Now()
+	o := *opts
+	o.TimeBudget = 0
+	best, next := new(bytes.Buffer), new(bytes.Buffer)
+	var last time.Duration
+	for method := 0; method <= 6; method++ {
+		if method > 0 && time.Since(start)+last > opts.TimeBudget {
+			break
+		}
+		o.Method = method
+		next.Reset()
+		t := time.Now()
+		if err := Encode(next, img, &o); err != nil {
+			return err
+		}
+		last = time.Since(t)
+		best, next = next, best
 	}
-	if opts.FilterStrength >= 0 {
-		cfg.FilterStrength = opts.FilterStrength
+	_, err := w.Write(best.Bytes())
+	return err
+}
+
+// defaultFallbackQuality is the LosslessFallback quality used when
+// LosslessFallbackQuality is not positive.
+const defaultFallbackQuality = 75
+
+// encodeLosslessFallback implements EncoderOptions.LosslessFallback: it
+// encodes img lossless and lossy and writes the smaller file, preferring
+// lossless on a tie.
+func encodeLosslessFallback(w io.Writer, img image.Image, opts *EncoderOptions) error {
+	o := *opts
+	o.LosslessFallback = false
+	var ll bytes.Buffer
+	if err := Encode(&ll, img, &o); err != nil {
+		return err
 	}
-	cfg.FilterSharpness = opts.FilterSharpness // 0 == C default, no sentinel needed
-	if opts.FilterType >= 0 {
-		cfg.FilterType = opts.FilterType
+	o.Lossless = false
+	o.Quality = defaultFallbackQuality
+	if opts.LosslessFallbackQuality > 0 {
+		o.Quality = opts.LosslessFallbackQuality
 	}
-	cfg.Partitions = opts.Partitions // 0 == C default, no sentinel needed
-	if opts.Segments > 0 {
-		cfg.Segments = opts.Segments
+	var ly bytes.Buffer
+	if err := Encode(&ly, img, &o); err != nil {
+		return err
 	}
-	if opts.Pass > 0 {
-		cfg.Pass = opts.Pass
+	usedLossy := ly.Len() < ll.Len()
+	if opts.LosslessFallbackFunc != nil {
+		opts.LosslessFallbackFunc(ll.Len(), ly.Len(), usedLossy)
 	}
+	best := ll.Bytes()
+	if usedLossy {
+		best = ly.Bytes()
+	}
+	_, err := w.Write(best)
+	return err
+}
 
-	cfg.Preprocessing = opts.Preprocessing
+// EncodePaletted writes img to w as a lossless WebP using the VP8L color
+// indexing transform built directly from img.Palette and img.Pix, instead of
+// collecting the colors from ARGB pixels as Encode does. Palette entries that
+// no pixel uses are dropped, so small palettes (up to 16 colors) are
+// bit-packed several pixels per code. opts.Lossless is ignored; the other
+// lossless options (and metadata) apply as for Encode. If opts disallows
+// TransformColorIndexing, img is encoded with Encode.
+func EncodePaletted(w io.Writer, img *image.Paletted, opts *EncoderOptions) error {
+	if w == nil {
+		return fmt.Errorf("webp: nil writer")
+	}
+	if img == nil {
+		return fmt.Errorf("webp: nil image")
+	}
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if err := validateConfig(opts); err != nil {
+		return err
+	}
+	if opts.LosslessTransforms != 0 && opts.LosslessTransforms&TransformColorIndexing == 0 {
+		o := *opts
+		o.Lossless = true
+		o.AutoFormat = false
+		o.IgnoreColorModel = true
+		o.TimeBudget = 0
+		return Encode(w, img, &o)
+	}
 
-	// Compute dithering amplitude when preprocessing bit 2 is set.
-	// Matches C libwebp webp_enc.c:364-369:
-	//   x = quality / 100
-	//   dithering = 1.0 + (0.5 - 1.0) * x^4
-	// This gives max dithering (~1.0) at low quality, decreasing to 0.5 at q=100.
-	if opts.Preprocessing&2 != 0 {
-		x := opts.Quality / 100.0
-		x2 := x * x
-		cfg.Dithering = 1.0 + (0.5-1.0)*x2*x2
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("webp: invalid image dimensions %dx%d", width, height)
+	}
+	if width > MaxDimension || height > MaxDimension {
+		return fmt.Errorf("webp: image dimension %dx%d exceeds maximum %d", width, height, MaxDimension)
+	}
+	if opts.Strict {
+		return encodeStrict(w, width, height, opts, func(w io.Writer, o *EncoderOptions) error {
+			return EncodePaletted(w, img, o)
+		})
 	}
 
-	// Pass cached alpha detection to avoid redundant scan in importImage.
-	if hasAlpha {
-		cfg.HasAlpha = 1
-	} else {
-		cfg.HasAlpha = 0
+	// Collect the indices in use and validate them against the palette.
+	var used [256]bool
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):][:width]
+		for _, idx := range row {
+			used[idx] = true
+		}
+	}
+	for i := len(img.Palette); i < len(used); i++ {
+		if used[i] {
+			return fmt.Errorf("webp: palette index %d out of range (palette has %d colors)", i, len(img.Palette))
+		}
 	}
 
-	var enc *lossy.VP8Encoder
-	if opts.UseSharpYUV {
-		yuv, err := sharpYUVConvert(img)
-		if err != nil {
-			return nil, nil, 0, fmt.Errorf("webp: sharp yuv: %w", err)
+	// Convert the used entries to ARGB, merge duplicates and sort, as the
+	// VP8L encoder does for palettes it builds itself.
+	pal := img.Palette
+	if len(pal) > len(used) {
+		pal = pal[:len(used)] // entries past 255 are unreachable
+	}
+	var argb [256]uint32
+	palette := make([]uint32, 0, len(pal))
+	seen := make(map[uint32]bool, len(pal))
+	for i, c := range pal {
+		if !used[i] {
+			continue
+		}
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		argb[i] = uint32(n.A)<<24 | uint32(n.R)<<16 | uint32(n.G)<<8 | uint32(n.B)
+		if n.A == 0 && !opts.Exact {
+			argb[i] = 0
+		}
+		if !seen[argb[i]] {
+			seen[argb[i]] = true
+			palette = append(palette, argb[i])
+		}
+	}
+	sort.Slice(palette, func(i, j int) bool { return palette[i] < palette[j] })
+	var remap [256]uint8
+	for i := range pal {
+		if used[i] {
+			remap[i] = uint8(sort.Search(len(palette), func(j int) bool { return palette[j] >= argb[i] }))
 		}
-		enc = lossy.NewEncoderFromYUV(yuv, img.Bounds().Dx(), img.Bounds().Dy(), cfg)
-	} else {
-		enc = lossy.NewEncoder(img, cfg)
 	}
 
+	indices := make([]uint8, width*height)
+	for y := 0; y < height; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):][:width]
+		dst := indices[y*width:]
+		for x, idx := range row {
+			dst[x] = remap[idx]
+		}
+	}
+
+	bs, err := lossless.EncodeIndexed(indices, palette, width, height, losslessConfig(opts))
+	if err != nil {
+		return fmt.Errorf("webp: lossless encode: %w", err)
+	}
+	return writeRIFF(w, container.FourCCVP8L, bs, nil, width, height, opts)
+}
+
+// roiMacroblocks averages an ROI map over each 16x16 macroblock of a
+// width x height image. Edge macroblocks average only the pixels inside
+// the image.
+func roiMacroblocks(m *image.Gray, width, height int) ([]uint8, error) {
+	b := m.Bounds()
+	if b.Dx() != width || b.Dy() != height {
+		return nil, fmt.Errorf("webp: ROIMap size %dx%d does not match image size %dx%d", b.Dx(), b.Dy(), width, height)
+	}
+	mbW, mbH := (width+15)/16, (height+15)/16
+	sum := make([]int, mbW*mbH)
+	for y := 0; y < height; y++ {
+		row := m.Pix[m.PixOffset(b.Min.X, b.Min.Y+y):][:width]
+		mbRow := sum[(y/16)*mbW:]
+		for x, v := range row {
+			mbRow[x/16] += int(v)
+		}
+	}
+	roi := make([]uint8, len(sum))
+	for i, v := range sum {
+		mbX, mbY := i%mbW, i/mbW
+		n := min(16, width-mbX*16) * min(16, height-mbY*16)
+		roi[i] = uint8((v + n/2) / n)
+	}
+	return roi, nil
+}
+
+// encodeLossyWithAlpha encodes the image as a VP8 lossy bitstream and,
+// if the source image has any non-opaque pixels, also encodes the alpha
+// plane as an ALPH chunk payload using VP8L lossless compression.
+// Returns (vp8Bitstream, alphChunkData, fourcc, error).
+func encodeLossyWithAlpha(img image.Image, opts *EncoderOptions) ([]byte, []byte, uint32, error) {
+	if opts.PreserveEdges {
+		opts = preserveEdgesOptions(opts)
+	}
+	enc, img, hasAlpha, err := newLossyEncoder(img, opts)
+	if err != nil {
+		return nil, nil, 0, err
+	}
 	defer lossy.ReleaseEncoder(enc)
 
 	bs, err := enc.EncodeFrame()
@@ -552,12 +1240,70 @@ func encodeLossyWithAlpha(img image.Image, opts *EncoderOptions) ([]byte, []byte
 		return bs, nil, container.FourCCVP8, nil
 	}
 
-	// Encode the alpha plane using the resolved alpha options.
+	bounds := img.Bounds()
+	alphaData, err := encodeAlphaPlane(alpha, bounds.Dx(), bounds.Dy(), opts)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return bs, alphaData, container.FourCCVP8, nil
+}
+
+// newLossyEncoder converts img to YUV for the VP8 encoder as opts asks. It
+// returns the encoder, which the caller must release, and the image the
+// alpha plane is to be taken from, whose transparent areas may have been
+// cleaned up, with whether it has alpha.
+func newLossyEncoder(img image.Image, opts *EncoderOptions) (*lossy.VP8Encoder, image.Image, bool, error) {
+	// Cache alpha detection result to avoid redundant full-image scans.
+	hasAlpha := imageHasAlpha(img)
+	cfg, err := lossyConfig(opts, img.Bounds().Dx(), img.Bounds().Dy())
+	if err != nil {
+		return nil, nil, false, err
+	}
+	tiled := opts.TileSize > 0 && !opts.UseSharpYUV && cfg.Dithering == 0
+	switch img.(type) {
+	case *image.Gray, *image.YCbCr:
+		// Imported plane by plane without a copy already.
+		tiled = false
+	}
+	if !opts.Exact && !tiled {
+		img = cleanupTransparentAreaLossyWith(img, hasAlpha)
+	}
+
+	// Pass cached alpha detection to avoid redundant scan in importImage.
+	if hasAlpha {
+		cfg.HasAlpha = 1
+	} else {
+		cfg.HasAlpha = 0
+	}
+
+	var enc *lossy.VP8Encoder
+	if opts.UseSharpYUV {
+		yuv, err := sharpYUVConvert(img, opts.SharpYUVIterations)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("webp: sharp yuv: %w", err)
+		}
+		enc = lossy.NewEncoderFromYUV(yuv, img.Bounds().Dx(), img.Bounds().Dy(), cfg)
+	} else if tiled {
+		var prepare func(*image.NRGBA)
+		if hasAlpha && !opts.Exact {
+			// The cleanup runs per tile instead of on a full copy.
+			prepare = cleanupTransparentNRGBA
+		}
+		enc = lossy.NewEncoderFromTiles(img, opts.TileSize, cfg, prepare)
+	} else {
+		enc = lossy.NewEncoder(img, cfg)
+	}
+	return enc, img, hasAlpha, nil
+}
+
+// encodeAlphaPlane compresses a width x height alpha plane into ALPH chunk
+// data using the alpha options of opts.
+func encodeAlphaPlane(alpha []byte, width, height int, opts *EncoderOptions) ([]byte, error) {
 	// Resolve sentinel / zero-value defaults to match C libwebp:
 	//   alpha_compression: 1 (lossless)
 	//   alpha_filtering:   1 (fast)
 	//   alpha_quality:     100
-	bounds := img.Bounds()
 	alphaComp := resolveAlphaCompression(opts.AlphaCompression)
 	alphaFilt := resolveAlphaFiltering(opts.AlphaFiltering)
 	alphaQual := resolveAlphaQuality(opts.AlphaQuality)
@@ -579,6 +1325,11 @@ func encodeLossyWithAlpha(img image.Image, opts *EncoderOptions) ([]byte, []byte
 	default: // 1 = fast (default)
 		alphaFilterMode = lossy.AlphaFilterModeFast
 	}
+	if opts.AlphaFilterMethod != AlphaFilterAuto {
+		// AlphaFilterNone..AlphaFilterGradient map to the bitstream filter
+		// values 0..3.
+		alphaFilterMode = int(opts.AlphaFilterMethod - AlphaFilterNone)
+	}
 
 	alphaCfg := &lossy.AlphaEncoderConfig{
 		Quality:     alphaQual,
@@ -586,12 +1337,195 @@ func encodeLossyWithAlpha(img image.Image, opts *EncoderOptions) ([]byte, []byte
 		Filter:      alphaFilterMode,
 		EffortLevel: opts.Method,
 	}
-	alphaData, err := lossy.EncodeAlpha(alpha, bounds.Dx(), bounds.Dy(), alphaCfg)
+	alphaData, err := lossy.EncodeAlpha(alpha, width, height, alphaCfg)
 	if err != nil {
-		return nil, nil, 0, fmt.Errorf("webp: alpha encode: %w", err)
+		return nil, fmt.Errorf("webp: alpha encode: %w", err)
 	}
+	return alphaData, nil
+}
 
-	return bs, alphaData, container.FourCCVP8, nil
+// targetSSIMPasses caps the number of trial encodes of a TargetSSIM search.
+const targetSSIMPasses = 8
+
+// ssimTrial is one trial encode of a TargetSSIM search.
+type ssimTrial struct {
+	quality   float32
+	ssim      float64
+	bitstream []byte
+	alphaData []byte
+	fourcc    uint32
+}
+
+// encodeLossyTargetSSIM is encodeLossyWithAlpha with the quality chosen by
+// a search for opts.TargetSSIM. Each trial is decoded the way Decode would
+// return it and compared to img. The search keeps a bracket of the trials
+// just below and just above the target and picks the next quality by
+// secant interpolation between them (with the Illinois rule, so one end
+// cannot stall); until both ends are known it tries QMin or QMax.
+func encodeLossyTargetSSIM(img image.Image, opts *EncoderOptions) ([]byte, []byte, uint32, error) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	src := rgbPlanes(img)
+	target := float64(opts.TargetSSIM)
+	qmin, qmax := float32(opts.QMin), float32(resolveQMax(opts.QMax))
+	if qmax <= 0 {
+		qmax = 100 // Zero-value QMax, treated as unset like the lossy rate control does.
+	}
+
+	trialAt := func(q float32) (*ssimTrial, error) {
+		o := *opts
+		o.Quality = q
+		bs, alphaData, fourcc, err := encodeLossyWithAlpha(img, &o)
+		if err != nil {
+			return nil, err
+		}
+		out, err := decodeLossy(bs, alphaData, nil)
+		if err != nil {
+			return nil, fmt.Errorf("webp: decoding trial frame: %w", err)
+		}
+		return &ssimTrial{
+			quality:   q,
+			ssim:      ssimPlanes(src, rgbPlanes(out), w, h),
+			bitstream: bs,
+			alphaData: alphaData,
+			fourcc:    fourcc,
+		}, nil
+	}
+
+	// below misses the target and above meets it; every new trial lies
+	// between them (or at a bound), so it always replaces one of them.
+	// fBelow/fAbove are their SSIM distances to the target.
+	var below, above *ssimTrial
+	var fBelow, fAbove float64
+	lastAbove := false
+	q := min(max(opts.Quality, qmin), qmax)
+search:
+	for pass := 0; pass < targetSSIMPasses; pass++ {
+		t, err := trialAt(q)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if t.ssim >= target {
+			if above != nil && lastAbove {
+				fBelow /= 2
+			}
+			above, fAbove, lastAbove = t, t.ssim-target, true
+		} else {
+			if below != nil && !lastAbove {
+				fAbove /= 2
+			}
+			below, fBelow, lastAbove = t, t.ssim-target, false
+		}
+
+		switch {
+		case below != nil && above != nil:
+			dq := above.quality - below.quality
+			if dq <= 1 {
+				break search
+			}
+			q = below.quality + dq/2
+			if fAbove > fBelow {
+				q = below.quality + float32(-fBelow/(fAbove-fBelow))*dq
+			}
+			q = min(max(q, below.quality+0.5), above.quality-0.5)
+		case above != nil:
+			if above.quality <= qmin {
+				break search
+			}
+			q = qmin
+		default:
+			if below.quality >= qmax {
+				break search
+			}
+			q = qmax
+		}
+	}
+
+	if above != nil {
+		return above.bitstream, above.alphaData, above.fourcc, nil
+	}
+	return below.bitstream, below.alphaData, below.fourcc, nil
+}
+
+// lossyConfig builds the VP8 encoder configuration for opts. width and
+// height are the dimensions of the picture being encoded.
+func lossyConfig(opts *EncoderOptions, width, height int) (lossy.EncodeConfig, error) {
+	cfg := lossy.DefaultConfig(int(opts.Quality))
+	if opts.ROIMap != nil {
+		roi, err := roiMacroblocks(opts.ROIMap, width, height)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.ROI = roi
+	}
+	if a := opts.Analysis; a != nil {
+		if a.width != width || a.height != height {
+			return cfg, fmt.Errorf("webp: Analysis is for a %dx%d image, not %dx%d", a.width, a.height, width, height)
+		}
+		cfg.Analysis = a.mb
+	}
+	cfg.Method = opts.Method
+	cfg.SingleThreaded = opts.SingleThreaded
+	cfg.IntraMode = int(opts.IntraMode)
+	cfg.NumThreads = opts.NumThreads
+	cfg.SegmentMapFunc = opts.SegmentMapFunc
+	cfg.Grayscale = opts.Grayscale
+	if opts.TargetSize > 0 {
+		cfg.TargetSize = opts.TargetSize
+	}
+	if opts.TargetBPP > 0 {
+		cfg.TargetSize = max(1, int(float64(opts.TargetBPP)*float64(width)*float64(height)/8))
+	}
+	if opts.TargetPSNR > 0 {
+		cfg.TargetPSNR = opts.TargetPSNR
+	}
+	// Propagate QMin/QMax for rate control clamping (matching C libwebp).
+	cfg.QMin = opts.QMin
+	cfg.QMax = resolveQMax(opts.QMax)
+	if opts.QuantIndex > 0 {
+		cfg.QuantIndex = opts.QuantIndex
+	}
+	// Propagate lossy encoding options from the public EncoderOptions to
+	// the internal EncodeConfig. Fields with sentinel values (< 0) keep
+	// the defaults already set by DefaultConfig().
+	if opts.SNSStrength >= 0 {
+		cfg.SNSStrength = opts.SNSStrength
+	}
+	if opts.FilterStrength >= 0 {
+		cfg.FilterStrength = opts.FilterStrength
+	}
+	cfg.FilterSharpness = opts.FilterSharpness // 0 == C default, no sentinel needed
+	if opts.FilterType >= 0 {
+		cfg.FilterType = opts.FilterType
+	}
+	for i, f := range opts.SegmentFilterStrengths {
+		switch {
+		case f > 0:
+			cfg.SegmentFilterStrengths[i] = f
+		case f < 0:
+			cfg.SegmentFilterStrengths[i] = 0 // filter off
+		}
+	}
+	cfg.Partitions = opts.Partitions // 0 == C default, no sentinel needed
+	if opts.Segments > 0 {
+		cfg.Segments = opts.Segments
+	}
+	if opts.Pass > 0 {
+		cfg.Pass = opts.Pass
+	}
+
+	cfg.Preprocessing = opts.Preprocessing
+
+	// Compute dithering amplitude when preprocessing bit 2 is set.
+	// Matches C libwebp webp_enc.c:364-369:
+	//   x = quality / 100
+	//   dithering = 1.0 + (0.5 - 1.0) * x^4
+	// This gives max dithering (~1.0) at low quality, decreasing to 0.5 at q=100.
+	if opts.Preprocessing&2 != 0 {
+		x := opts.Quality / 100.0
+		x2 := x * x
+		cfg.Dithering = 1.0 + (0.5-1.0)*x2*x2
+	}
+	return cfg, nil
 }
 
 // encodeLossy encodes the image as a VP8 lossy bitstream (no alpha).
@@ -673,11 +1607,7 @@ func encodeLossless(img image.Image, opts *EncoderOptions) ([]byte, uint32, erro
 		cleanupTransparentAreaLossless(argb)
 	}
 
-	lcfg := &lossless.EncoderConfig{
-		Quality:             int(opts.Quality),
-		Method:              opts.Method,
-		NearLosslessQuality: 100,
-	}
+	lcfg := losslessConfig(opts)
 	bs, err := lossless.Encode(argb, width, height, lcfg)
 	argbPool.Put(ab)
 	if err != nil {
@@ -739,11 +1669,7 @@ func encodeLosslessToWriter(w io.Writer, img image.Image, opts *EncoderOptions)
 		cleanupTransparentAreaLossless(argb)
 	}
 
-	lcfg := &lossless.EncoderConfig{
-		Quality:             int(opts.Quality),
-		Method:              opts.Method,
-		NearLosslessQuality: 100,
-	}
+	lcfg := losslessConfig(opts)
 
 	fourcc := container.FourCCVP8L
 	err := lossless.EncodeToWriter(argb, width, height, lcfg, w,
@@ -834,6 +1760,15 @@ func cleanupTransparentAreaLossyWith(img image.Image, hasAlpha bool) image.Image
 		}
 	}
 
+	cleanupTransparentNRGBA(nrgba)
+	return nrgba
+}
+
+// cleanupTransparentNRGBA is the in-place part of
+// cleanupTransparentAreaLossyWith, for an image whose bounds start at
+// (0, 0).
+func cleanupTransparentNRGBA(nrgba *image.NRGBA) {
+	width, height := nrgba.Rect.Dx(), nrgba.Rect.Dy()
 	const blockSize = 8
 
 	for by := 0; by+blockSize <= height; by += blockSize {
@@ -885,8 +1820,6 @@ func cleanupTransparentAreaLossyWith(img image.Image, hasAlpha bool) image.Image
 			smoothenBlockNRGBA(nrgba, bx, by, remainder, remainderH)
 		}
 	}
-
-	return nrgba
 }
 
 // smoothenBlockNRGBA inspects a block of pixels. For transparent pixels
@@ -952,14 +1885,50 @@ func cleanupTransparentAreaLossless(argb []uint32) {
 // writeRIFF wraps a VP8/VP8L bitstream in a RIFF/WEBP container and writes it.
 // When alphaData or metadata (ICC/EXIF/XMP) is present, it emits the VP8X
 // extended format. Otherwise it emits the simple format.
+//
+// When w can seek (an *os.File for a regular file, for instance), the
+// chunks are streamed to it and the size fields patched afterwards;
+// otherwise the file is assembled in memory and written in one call.
 func writeRIFF(w io.Writer, fourcc uint32, bitstream, alphaData []byte, width, height int, opts *EncoderOptions) error {
-	hasMetadata := opts != nil && (len(opts.ICC) > 0 || len(opts.EXIF) > 0 || len(opts.XMP) > 0)
-	if len(alphaData) > 0 || hasMetadata {
-		var icc, exif, xmp []byte
-		if opts != nil {
-			icc, exif, xmp = opts.ICC, opts.EXIF, opts.XMP
+	var icc, exif, xmp, cksm []byte
+	if opts != nil {
+		icc, exif, xmp = opts.ICC, opts.EXIF, opts.XMP
+		if opts.EmbedChecksum {
+			cksm = checksumChunk(bitstream, alphaData)
 		}
-		return writeRIFFExtended(w, fourcc, bitstream, alphaData, width, height, icc, exif, xmp)
+	}
+	extended := len(alphaData) > 0 || len(icc) > 0 || len(exif) > 0 || len(xmp) > 0 || cksm != nil
+	if extended && opts != nil && opts.NoExtendedFormat {
+		return checkNoExtendedFormat(alphaData, icc, exif, xmp, cksm != nil)
+	}
+	if sw := container.NewSeekWriter(w); sw != nil {
+		if extended {
+			var vp8x [container.VP8XChunkSize]byte
+			binary.LittleEndian.PutUint32(vp8x[0:4], vp8xFlags(fourcc, bitstream, alphaData, icc, exif, xmp))
+			putLE24(vp8x[4:], uint32(width-1))
+			putLE24(vp8x[7:], uint32(height-1))
+			sw.WriteChunk(container.FourCCVP8X, vp8x[:])
+			if len(icc) > 0 {
+				sw.WriteChunk(container.FourCCICCP, icc)
+			}
+			if len(alphaData) > 0 {
+				sw.WriteChunk(container.FourCCALPH, alphaData)
+			}
+		}
+		sw.WriteChunk(fourcc, bitstream)
+		if len(exif) > 0 {
+			sw.WriteChunk(container.FourCCEXIF, exif)
+		}
+		if len(xmp) > 0 {
+			sw.WriteChunk(container.FourCCXMP, xmp)
+		}
+		if cksm != nil {
+			sw.WriteChunk(fourCCCKSM, cksm)
+		}
+		return sw.Close()
+	}
+	if extended {
+		return writeRIFFExtended(w, fourcc, bitstream, alphaData, width, height, icc, exif, xmp, cksm)
 	}
 	return writeRIFFSimple(w, fourcc, bitstream)
 }
@@ -999,32 +1968,11 @@ func writeRIFFSimple(w io.Writer, fourcc uint32, bitstream []byte) error {
 // writeRIFFExtended writes the VP8X extended RIFF/WEBP container.
 // The chunk order follows the WebP spec:
 //
-//	RIFF header -> VP8X -> [ICCP] -> [ALPH] -> VP8/VP8L -> [EXIF] -> [XMP]
-func writeRIFFExtended(w io.Writer, fourcc uint32, bitstreamData, alphaData []byte, width, height int, icc, exif, xmp []byte) error {
+//	RIFF header -> VP8X -> [ICCP] -> [ALPH] -> VP8/VP8L -> [EXIF] -> [XMP] -> [CKSM]
+func writeRIFFExtended(w io.Writer, fourcc uint32, bitstreamData, alphaData []byte, width, height int, icc, exif, xmp, cksm []byte) error {
 	const vp8xChunkSize = container.VP8XChunkSize // 10 bytes
 
-	// Build VP8X flags.
-	var flags uint32
-	if len(alphaData) > 0 {
-		flags |= 0x00000010 // bit 4 = alpha
-	}
-	// VP8L bitstream carries alpha in its header (bit 28 of the packed field).
-	if fourcc == container.FourCCVP8L && len(bitstreamData) >= 5 &&
-		bitstreamData[0] == container.VP8LMagicByte {
-		bits := binary.LittleEndian.Uint32(bitstreamData[1:5])
-		if (bits>>28)&0x1 != 0 {
-			flags |= 0x00000010
-		}
-	}
-	if len(icc) > 0 {
-		flags |= 0x00000020 // bit 5 = ICC (note: bit 0 in some docs, bit 5 per mux/demux.go flagICCP = 1<<5)
-	}
-	if len(exif) > 0 {
-		flags |= 0x00000008 // bit 3 = EXIF
-	}
-	if len(xmp) > 0 {
-		flags |= 0x00000004 // bit 2 = XMP
-	}
+	flags := vp8xFlags(fourcc, bitstreamData, alphaData, icc, exif, xmp)
 
 	// Helper: padded chunk size (header + data + padding), using uint64 to prevent overflow.
 	paddedChunkSize64 := func(dataLen int) uint64 {
@@ -1049,6 +1997,9 @@ func writeRIFFExtended(w io.Writer, fourcc uint32, bitstreamData, alphaData []by
 	if len(xmp) > 0 {
 		riffSize64 += paddedChunkSize64(len(xmp))
 	}
+	if cksm != nil {
+		riffSize64 += paddedChunkSize64(len(cksm))
+	}
 
 	if riffSize64 > uint64(math.MaxUint32)-8 {
 		return fmt.Errorf("webp: RIFF payload too large (%d bytes)", riffSize64)
@@ -1116,10 +2067,41 @@ func writeRIFFExtended(w io.Writer, fourcc uint32, bitstreamData, alphaData []by
 		writeChunk(container.FourCCXMP, xmp)
 	}
 
+	// CKSM chunk.
+	if cksm != nil {
+		writeChunk(fourCCCKSM, cksm)
+	}
+
 	_, err := w.Write(buf)
 	return err
 }
 
+// vp8xFlags returns the VP8X feature flags for a still image.
+func vp8xFlags(fourcc uint32, bitstreamData, alphaData, icc, exif, xmp []byte) uint32 {
+	var flags uint32
+	if len(alphaData) > 0 {
+		flags |= 0x00000010 // bit 4 = alpha
+	}
+	// VP8L bitstream carries alpha in its header (bit 28 of the packed field).
+	if fourcc == container.FourCCVP8L && len(bitstreamData) >= 5 &&
+		bitstreamData[0] == container.VP8LMagicByte {
+		bits := binary.LittleEndian.Uint32(bitstreamData[1:5])
+		if (bits>>28)&0x1 != 0 {
+			flags |= 0x00000010
+		}
+	}
+	if len(icc) > 0 {
+		flags |= 0x00000020 // bit 5 = ICC (note: bit 0 in some docs, bit 5 per mux/demux.go flagICCP = 1<<5)
+	}
+	if len(exif) > 0 {
+		flags |= 0x00000008 // bit 3 = EXIF
+	}
+	if len(xmp) > 0 {
+		flags |= 0x00000004 // bit 2 = XMP
+	}
+	return flags
+}
+
 // putLE24 writes a 24-bit little-endian integer to buf.
 func putLE24(buf []byte, v uint32) {
 	buf[0] = byte(v)
@@ -1155,6 +2137,10 @@ func imageHasAlpha(img image.Image) bool {
 		}
 		return false
 	}
+	switch img.(type) {
+	case *image.Gray, *image.YCbCr:
+		return false // no alpha channel
+	}
 	for y := b.Min.Y; y < b.Max.Y; y++ {
 		for x := b.Min.X; x < b.Max.X; x++ {
 			_, _, _, a := img.At(x, y).RGBA()
@@ -1166,12 +2152,60 @@ func imageHasAlpha(img image.Image) bool {
 	return false
 }
 
+// strayAlphaRatio is the inverse of the largest fraction of pixels below
+// EncoderOptions.AlphaThreshold that flattenStrayAlpha drops.
+const strayAlphaRatio = 200
+
+// flattenStrayAlpha implements EncoderOptions.AlphaThreshold: if fewer than
+// one pixel of img in strayAlphaRatio has an alpha below threshold, it
+// returns img composited onto bg (white if nil) as an opaque image.
+// Otherwise, or if img is opaque already, it returns img.
+func flattenStrayAlpha(img image.Image, threshold uint8, bg color.Color) image.Image {
+	if !imageHasAlpha(img) {
+		return img
+	}
+	b := img.Bounds()
+	below := 0
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		for y := 0; y < b.Dy(); y++ {
+			row := nrgba.Pix[y*nrgba.Stride:][:4*b.Dx()]
+			for i := 3; i < len(row); i += 4 {
+				if row[i] < threshold {
+					below++
+				}
+			}
+		}
+	} else {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if _, _, _, a := img.At(x, y).RGBA(); a>>8 < uint32(threshold) {
+					below++
+				}
+			}
+		}
+	}
+	if below*strayAlphaRatio >= b.Dx()*b.Dy() {
+		return img
+	}
+
+	if bg == nil {
+		bg = color.White
+	}
+	c := color.NRGBAModel.Convert(bg).(color.NRGBA)
+	c.A = 255
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, &image.Uniform{C: c}, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Over)
+	return dst
+}
+
 // sharpYUVConvert converts an image.Image to YCbCr 4:2:0 using the SharpYUV
 // algorithm, which preserves sharp edges during chroma subsampling.
 // This replaces the standard averaging-based RGB-to-YUV conversion when
 // EncoderOptions.UseSharpYUV is true, matching C libwebp's
-// WebPPictureSharpARGBToYUVA behavior.
-func sharpYUVConvert(img image.Image) (*image.YCbCr, error) {
+// WebPPictureSharpARGBToYUVA behavior. iterations caps the refinement
+// passes (0 = default).
+func sharpYUVConvert(img image.Image, iterations int) (*image.YCbCr, error) {
 	bounds := img.Bounds()
 	w := bounds.Dx()
 	h := bounds.Dy()
@@ -1227,6 +2261,7 @@ func sharpYUVConvert(img image.Image) (*image.YCbCr, error) {
 
 	// Run SharpYUV conversion with default options (WebP matrix, sRGB transfer).
 	opts := sharpyuv.DefaultOptions()
+	opts.Iterations = iterations
 	if err := sharpyuv.Convert(rgb, w, h, rgbStride, yuv, opts); err != nil {
 		return nil, err
 	}
@@ -3,13 +3,20 @@ package webp
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+	"io"
 	"math"
+	"os"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/deepteams/webp/internal/container"
 	"github.com/deepteams/webp/internal/lossless"
 )
 
@@ -58,6 +65,9 @@ func TestDefaultOptions(t *testing.T) {
 	if opts.QMax >= 0 {
 		t.Errorf("QMax = %d, want negative sentinel", opts.QMax)
 	}
+	if opts.LosslessEffort != 0 {
+		t.Errorf("LosslessEffort = %d, want 0", opts.LosslessEffort)
+	}
 }
 
 func TestPresetValues(t *testing.T) {
@@ -115,6 +125,20 @@ func gradientTestImage(w, h int) *image.NRGBA {
 	return img
 }
 
+// iconTestImage returns a w x h image using 64 colors in concentric rings,
+// the kind of flat artwork icons are made of.
+func iconTestImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x-w/2, y-h/2
+			c := (dx*dx + dy*dy) / 24 % 64
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(c * 4), G: uint8(255 - c*3), B: uint8(c * 37), A: 255})
+		}
+	}
+	return img
+}
+
 func findRIFFChunk(data []byte, fourcc string) ([]byte, bool) {
 	if len(data) < 12 {
 		return nil, false
@@ -261,6 +285,341 @@ func TestEncodeLossless_RoundtripGradient(t *testing.T) {
 	}
 }
 
+func TestEncodeLossless_Effort(t *testing.T) {
+	img := gradientTestImage(128, 128)
+
+	encode := func(opts *EncoderOptions) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.Bytes()
+	}
+	withEffort := func(level int) *EncoderOptions {
+		opts := DefaultOptions()
+		opts.Lossless = true
+		opts.LosslessEffort = level
+		return opts
+	}
+
+	fast := encode(withEffort(1))
+	best := encode(withEffort(9))
+	if len(best) >= len(fast) {
+		t.Errorf("effort 9 = %d bytes, want smaller than effort 1 = %d bytes", len(best), len(fast))
+	}
+
+	// Unset (0) keeps Method/Quality: the defaults (4, 75) are level 6,
+	// also for struct literals that never mention LosslessEffort.
+	effort6 := encode(withEffort(6))
+	for name, opts := range map[string]*EncoderOptions{
+		"default":  withEffort(0),
+		"negative": withEffort(-1),
+		"literal":  {Lossless: true, Quality: 75, Method: 4},
+	} {
+		if got := encode(opts); !bytes.Equal(got, effort6) {
+			t.Errorf("%s options (%d bytes) differ from effort 6 (%d bytes)", name, len(got), len(effort6))
+		}
+	}
+
+	decoded, err := Decode(bytes.NewReader(best))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	nrgba, ok := decoded.(*image.NRGBA)
+	if !ok || !bytes.Equal(nrgba.Pix, img.Pix) {
+		t.Error("effort 9 output is not pixel-exact")
+	}
+}
+
+// leadingVP8LTransforms returns the transform types at the start of a simple
+// VP8L file, stopping after the first transform that carries data (only
+// subtract-green can be skipped without decoding its payload).
+func leadingVP8LTransforms(t *testing.T, data []byte) []lossless.TransformType {
+	t.Helper()
+	if len(data) < 25 || string(data[12:16]) != "VP8L" {
+		t.Fatalf("not a simple VP8L file")
+	}
+	bs := data[20+5:] // skip the chunk header and the 5-byte VP8L header
+	pos := 0
+	readBits := func(n int) uint32 {
+		var v uint32
+		for i := 0; i < n; i++ {
+			v |= uint32(bs[pos>>3]>>(pos&7)&1) << i
+			pos++
+		}
+		return v
+	}
+	var types []lossless.TransformType
+	for readBits(1) == 1 {
+		typ := lossless.TransformType(readBits(2))
+		types = append(types, typ)
+		if typ != lossless.SubtractGreenTransform {
+			break
+		}
+	}
+	return types
+}
+
+func TestEncodeLossless_Transforms(t *testing.T) {
+	encode := func(img *image.NRGBA, mask LosslessTransform) []byte {
+		t.Helper()
+		opts := DefaultOptions()
+		opts.Lossless = true
+		opts.LosslessTransforms = mask
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if !bytes.Equal(decoded.(*image.NRGBA).Pix, img.Pix) {
+			t.Errorf("mask %#x: output is not pixel-exact", mask)
+		}
+		return buf.Bytes()
+	}
+
+	grad := gradientTestImage(64, 64)
+	got := leadingVP8LTransforms(t, encode(grad, TransformSubtractGreen))
+	if len(got) != 1 || got[0] != lossless.SubtractGreenTransform {
+		t.Errorf("SubtractGreen only: transforms = %v, want [%d]", got, lossless.SubtractGreenTransform)
+	}
+	if got := leadingVP8LTransforms(t, encode(grad, 0)); len(got) < 2 {
+		t.Errorf("zero mask: transforms = %v, want subtract-green followed by more", got)
+	}
+
+	// A two-color image uses a palette unless color indexing is disabled.
+	pal := solidImage(16, 16, color.NRGBA{R: 255, A: 255})
+	pal.SetNRGBA(3, 3, color.NRGBA{B: 255, A: 255})
+	if got := leadingVP8LTransforms(t, encode(pal, 0)); len(got) == 0 || got[0] != lossless.ColorIndexingTransform {
+		t.Errorf("zero mask on 2-color image: transforms = %v, want color indexing first", got)
+	}
+	for _, typ := range leadingVP8LTransforms(t, encode(pal, TransformAll&^TransformColorIndexing)) {
+		if typ == lossless.ColorIndexingTransform {
+			t.Error("color indexing used although disabled")
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.LosslessTransforms = 0x10
+	if err := Encode(io.Discard, grad, opts); err == nil {
+		t.Error("expected error for unknown transform bit")
+	}
+}
+
+func TestEncodePaletted(t *testing.T) {
+	pal := color.Palette{
+		color.NRGBA{R: 255, A: 255},
+		color.NRGBA{G: 255, A: 255},
+		color.NRGBA{B: 255, A: 255},
+		color.NRGBA{R: 255, A: 255}, // duplicate of entry 0
+		color.NRGBA{A: 0},
+		color.NRGBA{R: 1, G: 2, B: 3, A: 255}, // unused
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 67, 45), pal)
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 67; x++ {
+			img.SetColorIndex(x, y, uint8((x/5+y/3)%5))
+		}
+	}
+	nrgba := image.NewNRGBA(img.Bounds())
+	draw.Draw(nrgba, nrgba.Bounds(), img, image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	if err := EncodePaletted(&buf, img, nil); err != nil {
+		t.Fatalf("EncodePaletted: %v", err)
+	}
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got, ok := decoded.(*image.NRGBA); !ok || !bytes.Equal(got.Pix, nrgba.Pix) {
+		t.Error("EncodePaletted output is not pixel-exact")
+	}
+	// 4 distinct colors: the indices are packed 4 pixels per code.
+	if got := leadingVP8LTransforms(t, buf.Bytes()); len(got) == 0 || got[0] != lossless.ColorIndexingTransform {
+		t.Errorf("transforms = %v, want color indexing first", got)
+	}
+
+	opts := DefaultOptions()
+	opts.Lossless = true
+	var ref bytes.Buffer
+	if err := Encode(&ref, nrgba, opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	// Encode finds the same palette by scanning ARGB, so it can match but
+	// not beat EncodePaletted; without color indexing it must be larger.
+	if buf.Len() > ref.Len() {
+		t.Errorf("EncodePaletted = %d bytes, want <= %d (NRGBA)", buf.Len(), ref.Len())
+	}
+	opts.LosslessTransforms = TransformAll &^ TransformColorIndexing
+	ref.Reset()
+	if err := Encode(&ref, nrgba, opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if buf.Len() >= ref.Len() {
+		t.Errorf("EncodePaletted = %d bytes, want < %d (NRGBA without color indexing)", buf.Len(), ref.Len())
+	}
+
+	img.Pix[0] = 9
+	if err := EncodePaletted(io.Discard, img, nil); err == nil {
+		t.Error("expected error for out-of-range palette index")
+	}
+}
+
+func TestEncode_PalettedColorModel(t *testing.T) {
+	pal := color.Palette{
+		color.NRGBA{R: 200, G: 30, B: 30, A: 255},
+		color.NRGBA{R: 30, G: 160, B: 60, A: 255},
+		color.NRGBA{R: 40, G: 40, B: 220, A: 255},
+		color.NRGBA{R: 250, G: 250, B: 250, A: 255},
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 96, 64), pal)
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 96; x++ {
+			img.SetColorIndex(x, y, uint8((x/7+y/5)%len(pal)))
+		}
+	}
+	nrgba := image.NewNRGBA(img.Bounds())
+	draw.Draw(nrgba, nrgba.Bounds(), img, image.Point{}, draw.Src)
+
+	encode := func(img image.Image, opts *EncoderOptions) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.Bytes()
+	}
+	format := func(data []byte) string {
+		t.Helper()
+		feat, err := GetFeatures(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("GetFeatures: %v", err)
+		}
+		return feat.Format
+	}
+
+	// With the default (lossy) options, the indexed image is stored
+	// losslessly with its palette, pixel-exact and smaller than the same
+	// pixels encoded lossy.
+	got := encode(img, DefaultOptions())
+	if f := format(got); f != "lossless" {
+		t.Fatalf("Format = %q, want lossless", f)
+	}
+	var want bytes.Buffer
+	if err := EncodePaletted(&want, img, nil); err != nil {
+		t.Fatalf("EncodePaletted: %v", err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Error("Encode of *image.Paletted differs from EncodePaletted")
+	}
+	lossyNRGBA := encode(nrgba, DefaultOptions())
+	if len(got) >= len(lossyNRGBA) {
+		t.Errorf("paletted = %d bytes, want < %d (same pixels as NRGBA)", len(got), len(lossyNRGBA))
+	}
+	decoded, err := Decode(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if d, ok := decoded.(*image.NRGBA); !ok || !bytes.Equal(d.Pix, nrgba.Pix) {
+		t.Error("paletted output is not pixel-exact")
+	}
+
+	// Lossy options alone do not keep an indexed image lossy;
+	// IgnoreColorModel does, and the result is the lossy encode of the
+	// same pixels.
+	lossy := func() *EncoderOptions {
+		o := DefaultOptions()
+		o.Quality = 40
+		o.Method = 2
+		return o
+	}
+	if f := format(encode(img, lossy())); f != "lossless" {
+		t.Errorf("Quality 40: Format = %q, want lossless", f)
+	}
+	opts := lossy()
+	opts.IgnoreColorModel = true
+	got = encode(img, opts)
+	if f := format(got); f != "lossy" {
+		t.Errorf("IgnoreColorModel: Format = %q, want lossy", f)
+	}
+	if want := encode(nrgba, lossy()); !bytes.Equal(got, want) {
+		t.Errorf("IgnoreColorModel: %d bytes, want the %d of the NRGBA lossy encode", len(got), len(want))
+	}
+	// The EncodePaletted fallback without color indexing does not loop.
+	opts = DefaultOptions()
+	opts.LosslessTransforms = TransformAll &^ TransformColorIndexing
+	if f := format(encode(img, opts)); f != "lossless" {
+		t.Errorf("without color indexing: Format = %q, want lossless", f)
+	}
+}
+
+func TestEncode_GrayColorModel(t *testing.T) {
+	// A smooth ramp: 256 levels, which a palette would index instead of
+	// predicting.
+	img := image.NewGray(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(x + y)})
+		}
+	}
+	encode := func(opts *EncoderOptions) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.Bytes()
+	}
+	lossless := DefaultOptions()
+	lossless.Lossless = true
+
+	got := encode(lossless)
+	var want bytes.Buffer
+	if err := EncodeGray(&want, img, lossless); err != nil {
+		t.Fatalf("EncodeGray: %v", err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Error("lossless Encode of *image.Gray differs from EncodeGray")
+	}
+	decoded, err := DecodeGray(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("DecodeGray: %v", err)
+	}
+	if !bytes.Equal(decoded.Pix, img.Pix) {
+		t.Error("grayscale lossless output is not pixel-exact")
+	}
+
+	// IgnoreColorModel takes the generic path, which lets the encoder index
+	// the 256 levels with a palette.
+	ignore := DefaultOptions()
+	ignore.Lossless = true
+	ignore.IgnoreColorModel = true
+	if generic := encode(ignore); len(got) >= len(generic) {
+		t.Errorf("grayscale path = %d bytes, want < %d (IgnoreColorModel)", len(got), len(generic))
+	}
+
+	// Lossy requests stay lossy.
+	feat, err := GetFeatures(bytes.NewReader(encode(DefaultOptions())))
+	if err != nil {
+		t.Fatalf("GetFeatures: %v", err)
+	}
+	if feat.Format != "lossy" {
+		t.Errorf("default options: Format = %q, want lossy", feat.Format)
+	}
+
+	// AlphaOnly is an EncodeGray option: Encode ignores it.
+	alphaOnly := DefaultOptions()
+	alphaOnly.Lossless = true
+	alphaOnly.AlphaOnly = true
+	if !bytes.Equal(encode(alphaOnly), got) {
+		t.Error("AlphaOnly changed the lossless Encode of *image.Gray")
+	}
+}
+
 func TestEncodeLossless_WithAlpha(t *testing.T) {
 	img := solidImage(4, 4, color.NRGBA{R: 128, G: 64, B: 32, A: 200})
 
@@ -628,6 +987,21 @@ func TestValidateConfig(t *testing.T) {
 			opts:    EncoderOptions{Lossless: true, Quality: 75, Method: 0},
 			wantErr: "",
 		},
+		{
+			name:    "lossless effort too high",
+			opts:    EncoderOptions{Lossless: true, Quality: 75, Method: 4, LosslessEffort: 10},
+			wantErr: "invalid LosslessEffort",
+		},
+		{
+			name:    "target ssim above one",
+			opts:    EncoderOptions{Quality: 75, Method: 4, TargetSSIM: 1.5},
+			wantErr: "invalid TargetSSIM",
+		},
+		{
+			name:    "negative sharp yuv iterations",
+			opts:    EncoderOptions{Quality: 75, Method: 4, UseSharpYUV: true, SharpYUVIterations: -1},
+			wantErr: "invalid SharpYUVIterations",
+		},
 	}
 
 	for _, tt := range tests {
@@ -893,6 +1267,16 @@ func TestValidateConfig_AlphaOptions(t *testing.T) {
 			opts:    EncoderOptions{Quality: 75, Method: 4, AlphaQuality: 101},
 			wantErr: "invalid AlphaQuality",
 		},
+		{
+			name:    "alpha filter method gradient valid",
+			opts:    EncoderOptions{Quality: 75, Method: 4, AlphaFilterMethod: AlphaFilterGradient},
+			wantErr: "",
+		},
+		{
+			name:    "alpha filter method 5 invalid",
+			opts:    EncoderOptions{Quality: 75, Method: 4, AlphaFilterMethod: 5},
+			wantErr: "invalid AlphaFilterMethod",
+		},
 	}
 
 	for _, tt := range tests {
@@ -913,6 +1297,48 @@ func TestValidateConfig_AlphaOptions(t *testing.T) {
 	}
 }
 
+func TestEncodeLossy_AlphaFilterMethod(t *testing.T) {
+	// A diagonal alpha ramp with a ripple: each predictor leaves a
+	// different residual.
+	img := gradientTestImage(96, 64)
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 96; x++ {
+			img.Pix[img.PixOffset(x, y)+3] = uint8(2*x + y + 8*(x/7%2))
+		}
+	}
+	encodeALPH := func(opts *EncoderOptions) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		p, err := container.NewParser(buf.Bytes())
+		if err != nil {
+			t.Fatalf("parsing output: %v", err)
+		}
+		alph := p.Frames()[0].AlphaData
+		if len(alph) == 0 {
+			t.Fatal("no ALPH chunk")
+		}
+		return alph
+	}
+
+	best := DefaultOptions()
+	best.AlphaFiltering = 2
+	bestSize := len(encodeALPH(best))
+	for f := AlphaFilterNone; f <= AlphaFilterGradient; f++ {
+		opts := DefaultOptions()
+		opts.AlphaFilterMethod = f
+		alph := encodeALPH(opts)
+		if got := AlphaFilter(alph[0]>>2&3) + AlphaFilterNone; got != f {
+			t.Errorf("AlphaFilterMethod %d: ALPH header has filter %d", f, got)
+		}
+		if bestSize > len(alph) {
+			t.Errorf("best alpha filtering: %d bytes, larger than %d with predictor %d", bestSize, len(alph), f)
+		}
+	}
+}
+
 func TestEncodeLossy_AlphaCompression0_Raw_Roundtrip(t *testing.T) {
 	// Encode with AlphaCompression=0 (raw/uncompressed) and verify roundtrip.
 	img := solidImage(16, 16, color.NRGBA{R: 200, G: 100, B: 50, A: 128})
@@ -1516,6 +1942,236 @@ func computePSNR(mse float64) float64 {
 	return 10.0 * math.Log10(255.0*255.0/mse)
 }
 
+func TestEncodeLossy_ROIMap(t *testing.T) {
+	const W, H = 128, 64
+	// Uniformly textured content so quantization error is comparable
+	// between the two halves.
+	img := image.NewNRGBA(image.Rect(0, 0, W, H))
+	for y := 0; y < H; y++ {
+		for x := 0; x < W; x++ {
+			v := 128 + 80*math.Sin(float64(x)/2.5)*math.Cos(float64(y)/3.5)
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(v), G: uint8(v), B: uint8(v), A: 255})
+		}
+	}
+	// Left half important, right half not.
+	roi := image.NewGray(img.Bounds())
+	for y := 0; y < H; y++ {
+		for x := 0; x < W/2; x++ {
+			roi.Pix[y*roi.Stride+x] = 255
+		}
+	}
+
+	// halfPSNR returns the luma-ish PSNR of the left and right halves.
+	halfPSNR := func(opts *EncoderOptions) (left, right float64) {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		dec, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		var sse [2]float64
+		for y := 0; y < H; y++ {
+			for x := 0; x < W; x++ {
+				o := img.NRGBAAt(x, y)
+				r, _, _, _ := dec.At(x, y).RGBA()
+				d := float64(int(o.R) - int(r>>8))
+				sse[x*2/W] += d * d
+			}
+		}
+		n := float64(W / 2 * H)
+		return computePSNR(sse[0] / n), computePSNR(sse[1] / n)
+	}
+
+	opts := DefaultOptions()
+	baseL, baseR := halfPSNR(opts)
+	opts.ROIMap = roi
+	roiL, roiR := halfPSNR(opts)
+	if roiL <= baseL || roiR >= baseR {
+		t.Errorf("PSNR left/right = %.2f/%.2f with ROI, %.2f/%.2f without; want left up, right down",
+			roiL, roiR, baseL, baseR)
+	}
+
+	opts.ROIMap = image.NewGray(image.Rect(0, 0, W, H-1))
+	if err := Encode(io.Discard, img, opts); err == nil || !strings.Contains(err.Error(), "ROIMap") {
+		t.Errorf("mismatched ROIMap: err = %v, want size error", err)
+	}
+}
+
+func TestEncodeLossy_SegmentMapFunc(t *testing.T) {
+	const W, H = 128, 64
+	// Flat left half, busy right half: they land in different segments.
+	img := image.NewNRGBA(image.Rect(0, 0, W, H))
+	for y := 0; y < H; y++ {
+		for x := 0; x < W; x++ {
+			v := uint8(128)
+			if x >= W/2 {
+				v = uint8(128 + 100*math.Sin(float64(x)/1.5)*math.Cos(float64(y)/2.5))
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	type segmentMap struct {
+		mbW, mbH, n int
+		segments    []uint8
+	}
+	encode := func(opts *EncoderOptions) []segmentMap {
+		t.Helper()
+		var maps []segmentMap
+		opts.SegmentMapFunc = func(mbW, mbH, n int, segments []uint8) {
+			maps = append(maps, segmentMap{mbW, mbH, n, segments})
+		}
+		if err := Encode(io.Discard, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return maps
+	}
+
+	maps := encode(DefaultOptions())
+	if len(maps) != 1 {
+		t.Fatalf("SegmentMapFunc called %d times, want 1", len(maps))
+	}
+	m := maps[0]
+	if m.mbW != W/16 || m.mbH != H/16 || len(m.segments) != m.mbW*m.mbH {
+		t.Fatalf("map %dx%d with %d entries, want %dx%d", m.mbW, m.mbH, len(m.segments), W/16, H/16)
+	}
+	if m.n < 2 || m.n > 4 {
+		t.Errorf("numSegments = %d, want 2-4", m.n)
+	}
+	for i, s := range m.segments {
+		if int(s) >= m.n {
+			t.Fatalf("macroblock %d: segment %d, want < %d", i, s, m.n)
+		}
+	}
+	if m.segments[0] == m.segments[m.mbW-1] {
+		t.Errorf("flat and busy macroblocks share segment %d", m.segments[0])
+	}
+
+	opts := DefaultOptions()
+	opts.Segments = 1
+	if m := encode(opts)[0]; m.n != 1 || slices.ContainsFunc(m.segments, func(s uint8) bool { return s != 0 }) {
+		t.Errorf("Segments=1: numSegments %d, segments %v, want 1 and all 0", m.n, m.segments)
+	}
+	opts = DefaultOptions()
+	opts.Lossless = true
+	if maps := encode(opts); len(maps) != 0 {
+		t.Errorf("lossless: SegmentMapFunc called %d times, want 0", len(maps))
+	}
+}
+
+func TestEncodeLossy_16BitInput(t *testing.T) {
+	const W, H = 48, 40
+	ref := image.NewNRGBA(image.Rect(0, 0, W, H))
+	n64 := image.NewNRGBA64(ref.Bounds())
+	r64 := image.NewRGBA64(ref.Bounds())
+	for y := 0; y < H; y++ {
+		for x := 0; x < W; x++ {
+			// 16-bit values a little below the midpoint between two 8-bit
+			// levels: rounding gives the reference, truncation (v>>8) of
+			// the upper levels does not.
+			r, g, b := uint8(x*255/W), uint8(y*255/H), uint8((x+y)*2)
+			ref.SetNRGBA(x, y, color.NRGBA{R: r, G: g, B: b, A: 255})
+			c := color.NRGBA64{R: uint16(r)*257 + 120, G: uint16(g)*257 - min(uint16(g), 120), B: uint16(b) * 257, A: 0xffff}
+			n64.SetNRGBA64(x, y, c)
+			r64.SetRGBA64(x, y, color.RGBA64(c))
+		}
+	}
+	decode := func(img image.Image) *image.NRGBA {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, &EncoderOptions{Quality: 90, Method: 4}); err != nil {
+			t.Fatalf("Encode %T: %v", img, err)
+		}
+		dec, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Decode %T: %v", img, err)
+		}
+		out := image.NewNRGBA(dec.Bounds())
+		for y := 0; y < H; y++ {
+			for x := 0; x < W; x++ {
+				out.Set(x, y, dec.At(x, y))
+			}
+		}
+		return out
+	}
+
+	want := decode(ref)
+	for _, img := range []image.Image{n64, r64} {
+		got := decode(img)
+		for i := range want.Pix {
+			d := int(got.Pix[i]) - int(want.Pix[i])
+			if d < -1 || d > 1 {
+				t.Fatalf("%T: byte %d = %d, want %d +/- 1", img, i, got.Pix[i], want.Pix[i])
+			}
+		}
+	}
+}
+
+func TestEncodeLossy_SharpYUVChroma(t *testing.T) {
+	const W, H = 64, 64
+	// Red/blue stripes whose edges fall inside 2x2 chroma blocks, where
+	// plain averaging bleeds the two colors together.
+	img := image.NewNRGBA(image.Rect(0, 0, W, H))
+	for y := 0; y < H; y++ {
+		for x := 0; x < W; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if (x/5)%2 == 1 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// encode returns the encoded bytes and the Cb/Cr PSNR of the decoded
+	// image against img. Sharp YUV optimizes for the fancy chroma upsampling
+	// that libwebp's decoder applies, so the decoded planes are converted
+	// with it rather than through image.YCbCr.At.
+	encode := func(opts *EncoderOptions) ([]byte, float64) {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		dec, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		yc, ok := dec.(*image.YCbCr)
+		if !ok {
+			t.Fatalf("decoded type = %T, want *image.YCbCr", dec)
+		}
+		rgb := buildNRGBA(W, H, yc.Y, yc.YStride, yc.Cb, yc.Cr, yc.CStride, nil)
+		var sse float64
+		for y := 0; y < H; y++ {
+			for x := 0; x < W; x++ {
+				o, d := img.NRGBAAt(x, y), rgb.NRGBAAt(x, y)
+				_, ocb, ocr := color.RGBToYCbCr(o.R, o.G, o.B)
+				_, dcb, dcr := color.RGBToYCbCr(d.R, d.G, d.B)
+				eb := float64(int(ocb) - int(dcb))
+				er := float64(int(ocr) - int(dcr))
+				sse += eb*eb + er*er
+			}
+		}
+		return buf.Bytes(), computePSNR(sse / float64(2*W*H))
+	}
+
+	opts := DefaultOptions()
+	opts.Quality = 95
+	_, plain := encode(opts)
+	opts.UseSharpYUV = true
+	sharpData, sharp := encode(opts)
+	if sharp <= plain {
+		t.Errorf("chroma PSNR with sharp YUV = %.2f dB, without = %.2f dB; want sharp better", sharp, plain)
+	}
+
+	opts.SharpYUVIterations = 1
+	if oneData, _ := encode(opts); bytes.Equal(oneData, sharpData) {
+		t.Error("SharpYUVIterations = 1 produced the same output as the default")
+	}
+}
+
 func TestLossyRoundtrip_PSNR(t *testing.T) {
 	const W, H = 128, 64
 	orig := richTestImage(W, H)
@@ -1776,6 +2432,58 @@ func TestLossyRoundtrip_ColorBoundary(t *testing.T) {
 	}
 }
 
+// TestEncode_PreserveEdges checks that PreserveEdges reduces the error next
+// to a red/blue boundary, both on and off a macroblock edge.
+func TestEncode_PreserveEdges(t *testing.T) {
+	for _, edge := range []int{16, 13} {
+		img := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 32; x++ {
+				c := color.NRGBA{B: 255, A: 255}
+				if x < edge {
+					c = color.NRGBA{R: 255, A: 255}
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+		// boundaryError returns the mean channel error of the three
+		// columns on each side of the boundary.
+		boundaryError := func(preserve bool) float64 {
+			opts := DefaultOptions()
+			opts.PreserveEdges = preserve
+			dec, err := Decode(bytes.NewReader(mustEncode(t, img, opts)))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			sum, n := 0, 0
+			for y := 0; y < 16; y++ {
+				for x := edge - 3; x < edge+3; x++ {
+					r, g, b, _ := dec.At(x, y).RGBA()
+					want := img.NRGBAAt(x, y)
+					for _, d := range []int{int(r>>8) - int(want.R), int(g>>8) - int(want.G), int(b>>8) - int(want.B)} {
+						sum += max(d, -d)
+						n++
+					}
+				}
+			}
+			return float64(sum) / float64(n)
+		}
+		base, preserved := boundaryError(false), boundaryError(true)
+		t.Logf("edge at x=%d: mean boundary error %.1f, with PreserveEdges %.1f", edge, base, preserved)
+		if preserved >= base || preserved > 40 {
+			t.Errorf("edge at x=%d: PreserveEdges boundary error %.1f, want below %.1f and 40", edge, preserved, base)
+		}
+	}
+
+	// Lossless output is unchanged.
+	opts := &EncoderOptions{Lossless: true}
+	want := mustEncode(t, gradientTestImage(16, 16), opts)
+	opts.PreserveEdges = true
+	if got := mustEncode(t, gradientTestImage(16, 16), opts); !bytes.Equal(got, want) {
+		t.Error("PreserveEdges changed lossless output")
+	}
+}
+
 // TestLossyRoundtrip_SolidColors tests that solid pure-color 16x16 blocks
 // roundtrip reasonably through lossy encoding. This isolates the chroma issue.
 func TestEncodeLossless_LargeRoundtrip(t *testing.T) {
@@ -2040,11 +2748,100 @@ func TestEncodeLossy_TargetPSNR_And_TargetSize(t *testing.T) {
 	}
 }
 
-// --- Preprocessing=1 (smooth segment map) ---
-
-func TestEncodeLossy_Preprocessing1_SmoothSegmentMap(t *testing.T) {
-	// Encode a gradient image with Preprocessing=1 (segment smoothing)
-	// and Segments=4, verifying roundtrip is valid.
+func TestEncodeLossy_TargetSSIM(t *testing.T) {
+	// Gray texture: SSIM rises steadily with quality and chroma plays no part.
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(128 + 90*math.Sin(float64(x)/3)*math.Cos(float64(y)/4))
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	encode := func(opts *EncoderOptions) ([]byte, float64) {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		ssim, err := SSIM(img, decoded)
+		if err != nil {
+			t.Fatalf("SSIM: %v", err)
+		}
+		return buf.Bytes(), ssim
+	}
+
+	q75, _ := encode(&EncoderOptions{Quality: 75, Method: 4})
+	got, ssim := encode(&EncoderOptions{Quality: 75, Method: 4, TargetSSIM: 0.9})
+	if ssim < 0.9 {
+		t.Errorf("TargetSSIM=0.9: SSIM = %.4f, want >= 0.9", ssim)
+	}
+	if len(got) >= len(q75) {
+		t.Errorf("TargetSSIM=0.9: size = %d, want < %d (quality 75)", len(got), len(q75))
+	}
+
+	// An unreachable target settles on QMax.
+	capped, _ := encode(&EncoderOptions{Quality: 75, Method: 4, QMax: 50, TargetSSIM: 1})
+	q50, _ := encode(&EncoderOptions{Quality: 50, Method: 4, QMax: 50})
+	if !bytes.Equal(capped, q50) {
+		t.Errorf("TargetSSIM=1 with QMax=50: %d bytes, want the quality 50 encoding (%d bytes)", len(capped), len(q50))
+	}
+}
+
+func TestEncodeLossy_TargetBPP(t *testing.T) {
+	texture := func(w, h int) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				v := 128 + 90*math.Sin(float64(x)/3)*math.Cos(float64(y)/4)
+				img.SetNRGBA(x, y, color.NRGBA{R: uint8(v), G: uint8(255 - v), B: uint8(x * y), A: 255})
+			}
+		}
+		return img
+	}
+	const target = 1.5
+	var bpps []float64
+	for _, size := range []image.Point{{64, 48}, {160, 128}} {
+		img := texture(size.X, size.Y)
+		got := mustEncode(t, img, &EncoderOptions{Quality: 75, Method: 4, Pass: 6, TargetBPP: target})
+		bpp := float64(len(got)) * 8 / float64(size.X*size.Y)
+		t.Logf("%dx%d: %d bytes, %.2f bpp", size.X, size.Y, len(got), bpp)
+		if bpp < target*0.7 || bpp > target*1.3 {
+			t.Errorf("%dx%d: %.2f bpp, want about %.1f", size.X, size.Y, bpp, target)
+		}
+		bpps = append(bpps, bpp)
+
+		// The byte target drives the TargetSize rate control.
+		want := mustEncode(t, img, &EncoderOptions{Quality: 75, Method: 4, Pass: 6, TargetSize: int(target * float64(size.X*size.Y) / 8)})
+		if !bytes.Equal(got, want) {
+			t.Errorf("%dx%d: TargetBPP output differs from the equivalent TargetSize", size.X, size.Y)
+		}
+	}
+	if r := bpps[0] / bpps[1]; r < 0.75 || r > 1.33 {
+		t.Errorf("bpp = %.2f and %.2f, want them similar", bpps[0], bpps[1])
+	}
+
+	for _, opts := range []*EncoderOptions{
+		{Quality: 75, TargetBPP: -1},
+		{Quality: 75, TargetBPP: float32(math.NaN())},
+		{Quality: 75, TargetBPP: 1, TargetSize: 1000},
+		{Quality: 75, TargetBPP: 1, TargetPSNR: 40},
+		{Quality: 75, TargetBPP: 1, QuantIndex: 20},
+	} {
+		if err := Encode(&bytes.Buffer{}, texture(16, 16), opts); err == nil {
+			t.Errorf("Encode accepted %+v", *opts)
+		}
+	}
+}
+
+// --- Preprocessing=1 (smooth segment map) ---
+
+func TestEncodeLossy_Preprocessing1_SmoothSegmentMap(t *testing.T) {
+	// Encode a gradient image with Preprocessing=1 (segment smoothing)
+	// and Segments=4, verifying roundtrip is valid.
 	img := gradientTestImage(64, 64)
 	var buf bytes.Buffer
 	err := Encode(&buf, img, &EncoderOptions{
@@ -2325,3 +3122,989 @@ func TestEncodeLossless_HighEntropyRoundtrip(t *testing.T) {
 		}
 	}
 }
+
+// threadTestImage returns a textured image tall enough (8 macroblock rows)
+// for the lossy encoder's parallel macroblock pass.
+func threadTestImage() *image.NRGBA {
+	const W, H = 160, 128
+	img := image.NewNRGBA(image.Rect(0, 0, W, H))
+	for y := 0; y < H; y++ {
+		for x := 0; x < W; x++ {
+			v := 128 + 90*math.Sin(float64(x)/3.1)*math.Cos(float64(y)/4.3)
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(v), G: uint8(x + y), B: uint8(255 - v), A: 255})
+		}
+	}
+	return img
+}
+
+func TestEncodeLossy_SingleThreaded(t *testing.T) {
+	img := threadTestImage()
+	encode := func() []byte {
+		var buf bytes.Buffer
+		opts := &EncoderOptions{Quality: 75, Method: 4, SingleThreaded: true}
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	want := encode()
+	for i := 1; i < 50; i++ {
+		if got := encode(); !bytes.Equal(got, want) {
+			t.Fatalf("encode %d: %d bytes differ from the first encode (%d bytes)", i, len(got), len(want))
+		}
+	}
+}
+
+func TestEncodeLossy_NumThreads(t *testing.T) {
+	img := threadTestImage()
+	encode := func(opts *EncoderOptions) []byte {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	serial := encode(&EncoderOptions{Quality: 75, Method: 4, SingleThreaded: true})
+	if got := encode(&EncoderOptions{Quality: 75, Method: 4, NumThreads: 1}); !bytes.Equal(got, serial) {
+		t.Errorf("NumThreads=1 (%d bytes) differs from SingleThreaded (%d bytes)", len(got), len(serial))
+	}
+	// The parallel pass does not depend on how many workers it has.
+	two := encode(&EncoderOptions{Quality: 75, Method: 4, NumThreads: 2})
+	if got := encode(&EncoderOptions{Quality: 75, Method: 4, NumThreads: 5}); !bytes.Equal(got, two) {
+		t.Errorf("NumThreads=5 (%d bytes) differs from NumThreads=2 (%d bytes)", len(got), len(two))
+	}
+}
+
+func TestEncode_AutoFormat(t *testing.T) {
+	// A flat icon: a few solid shapes on a plain background.
+	icon := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.NRGBA{R: 240, G: 240, B: 240, A: 255}
+			switch {
+			case (x-32)*(x-32)+(y-32)*(y-32) < 20*20:
+				c = color.NRGBA{R: 220, G: 40, B: 40, A: 255}
+			case y > 52:
+				c = color.NRGBA{R: 30, G: 30, B: 120, A: 255}
+			}
+			icon.SetNRGBA(x, y, c)
+		}
+	}
+	// A photo-like gradient with sensor-style noise.
+	photo := image.NewNRGBA(image.Rect(0, 0, 96, 96))
+	seed := uint32(1)
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 96; x++ {
+			seed = seed*1664525 + 1013904223
+			n := int(seed>>27) - 16
+			photo.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(40 + 2*x + n), G: uint8(20 + x + y + n), B: uint8(220 - y + n), A: 255,
+			})
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+		want string
+	}{
+		{"icon", icon, "lossless"},
+		{"photo", photo, "lossy"},
+	} {
+		for _, lossless := range []bool{false, true} {
+			opts := DefaultOptions()
+			opts.AutoFormat = true
+			opts.Lossless = lossless // ignored
+			var buf bytes.Buffer
+			if err := Encode(&buf, tc.img, opts); err != nil {
+				t.Fatalf("%s: Encode: %v", tc.name, err)
+			}
+			feat, err := GetFeatures(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("%s: GetFeatures: %v", tc.name, err)
+			}
+			if feat.Format != tc.want {
+				t.Errorf("%s (Lossless=%v): Format = %q, want %q", tc.name, lossless, feat.Format, tc.want)
+			}
+
+			// The output is the smaller of the two plain encodes.
+			opts.AutoFormat = false
+			opts.Lossless = tc.want == "lossless"
+			var plain bytes.Buffer
+			if err := Encode(&plain, tc.img, opts); err != nil {
+				t.Fatalf("%s: Encode: %v", tc.name, err)
+			}
+			if !bytes.Equal(buf.Bytes(), plain.Bytes()) {
+				t.Errorf("%s: AutoFormat output (%d bytes) differs from the %s encode (%d bytes)",
+					tc.name, buf.Len(), tc.want, plain.Len())
+			}
+		}
+	}
+}
+
+func TestEncodeLossless_DeterministicAcrossEncodes(t *testing.T) {
+	// Pooled encoder scratch state must not leak into the next encode.
+	img := richTestImage(96, 64)
+	for x := 0; x < 96; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{R: uint8(x), G: 7, B: 99, A: 0})
+	}
+	other := threadTestImage()
+	for _, effort := range []int{6, 7, 9} {
+		opts := &EncoderOptions{Lossless: true, LosslessEffort: effort, Exact: true}
+		var first []byte
+		for i := 0; i < 4; i++ {
+			var buf bytes.Buffer
+			if err := Encode(&buf, img, opts); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if i == 0 {
+				first = buf.Bytes()
+			} else if !bytes.Equal(buf.Bytes(), first) {
+				t.Fatalf("effort %d, encode %d: %d bytes, first encode gave %d", effort, i, buf.Len(), len(first))
+			}
+			if err := Encode(io.Discard, other, opts); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+		}
+	}
+}
+
+func TestEncodeLossy_QuantIndex(t *testing.T) {
+	img := gradientTestImage(64, 64)
+	encode := func(qi int) []byte {
+		t.Helper()
+		opts := DefaultOptions()
+		opts.QuantIndex = qi
+		opts.SingleThreaded = true
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("QuantIndex %d: Encode: %v", qi, err)
+		}
+		return buf.Bytes()
+	}
+	fine, coarse := encode(5), encode(120)
+	if len(fine) <= len(coarse) {
+		t.Errorf("QuantIndex 5 gave %d bytes, 120 gave %d; want finer to be larger", len(fine), len(coarse))
+	}
+	if _, err := Decode(bytes.NewReader(coarse)); err != nil {
+		t.Errorf("Decode: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts EncoderOptions
+		want string
+	}{
+		{"too large", EncoderOptions{Quality: 75, QuantIndex: 128}, "invalid QuantIndex"},
+		{"with TargetSize", EncoderOptions{Quality: 75, QuantIndex: 20, TargetSize: 1000}, "cannot be combined"},
+		{"with TargetSSIM", EncoderOptions{Quality: 75, QuantIndex: 20, TargetSSIM: 0.9}, "cannot be combined"},
+	} {
+		err := validateConfig(&tt.opts)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	if err := validateConfig(&EncoderOptions{Quality: 75, QuantIndex: 127}); err != nil {
+		t.Errorf("QuantIndex 127: %v", err)
+	}
+}
+
+func TestEncode_SeekableWriter(t *testing.T) {
+	img := gradientTestImage(64, 48)
+	alpha := gradientTestImage(64, 48)
+	for i := 3; i < len(alpha.Pix); i += 4 {
+		alpha.Pix[i] = uint8(i)
+	}
+	withMeta := DefaultOptions()
+	withMeta.ICC = []byte("icc profile")
+	withMeta.EXIF = []byte("exif")
+	withMeta.XMP = []byte("<xmp/>")
+	losslessMeta := *withMeta
+	losslessMeta.Lossless = true
+	checksum := DefaultOptions()
+	checksum.EmbedChecksum = true
+
+	tests := []struct {
+		name string
+		img  image.Image
+		opts *EncoderOptions
+	}{
+		{"lossy", img, DefaultOptions()},
+		{"lossy_alpha", alpha, DefaultOptions()},
+		{"lossy_metadata", alpha, withMeta},
+		{"lossless_metadata", img, &losslessMeta},
+		{"lossy_checksum", alpha, checksum},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var want bytes.Buffer
+			if err := Encode(&want, tc.img, tc.opts); err != nil {
+				t.Fatalf("Encode to buffer: %v", err)
+			}
+
+			// The file does not start at offset 0: the size fields are
+			// patched relative to where the encode began.
+			f, err := os.CreateTemp(t.TempDir(), "*.webp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			prefix := []byte("prefix")
+			if _, err := f.Write(prefix); err != nil {
+				t.Fatal(err)
+			}
+			if err := Encode(f, tc.img, tc.opts); err != nil {
+				t.Fatalf("Encode to file: %v", err)
+			}
+			if _, err := f.Write([]byte("suffix")); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = got[len(prefix) : len(got)-len("suffix")]
+			if !bytes.Equal(got, want.Bytes()) {
+				t.Errorf("file output (%d bytes) differs from buffered output (%d bytes)", len(got), want.Len())
+			}
+		})
+	}
+
+	t.Run("pipe", func(t *testing.T) {
+		// An *os.File that cannot seek falls back to the buffered path.
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		done := make(chan []byte)
+		go func() {
+			data, _ := io.ReadAll(r)
+			done <- data
+		}()
+		err = Encode(w, alpha, withMeta)
+		w.Close()
+		got := <-done
+		if err != nil {
+			t.Fatalf("Encode to pipe: %v", err)
+		}
+		var want bytes.Buffer
+		if err := Encode(&want, alpha, withMeta); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want.Bytes()) {
+			t.Error("pipe output differs from buffered output")
+		}
+	})
+}
+
+func TestEncodeLossless_UseColorCache(t *testing.T) {
+	img := iconTestImage(128, 128)
+	off, on := false, true
+	sizes := map[string]int{}
+	for _, tc := range []struct {
+		name  string
+		cache *bool
+	}{
+		{"auto", nil},
+		{"off", &off},
+		{"on", &on},
+	} {
+		for _, effort := range []int{1, 6, 9} {
+			opts := DefaultOptions()
+			opts.Lossless = true
+			opts.LosslessEffort = effort
+			opts.UseColorCache = tc.cache
+			var buf bytes.Buffer
+			if err := Encode(&buf, img, opts); err != nil {
+				t.Fatalf("%s/effort %d: Encode: %v", tc.name, effort, err)
+			}
+			got, err := Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("%s/effort %d: Decode: %v", tc.name, effort, err)
+			}
+			if !bytes.Equal(got.(*image.NRGBA).Pix, img.Pix) {
+				t.Errorf("%s/effort %d: round trip is not lossless", tc.name, effort)
+			}
+			sizes[fmt.Sprintf("%s/%d", tc.name, effort)] = buf.Len()
+		}
+	}
+	// Effort 1 never uses a cache on its own, so forcing one must change the
+	// output, and disabling it must not.
+	if sizes["on/1"] == sizes["auto/1"] {
+		t.Errorf("forced color cache did not change the effort 1 output (%d bytes)", sizes["on/1"])
+	}
+	if sizes["off/1"] != sizes["auto/1"] {
+		t.Errorf("effort 1 output = %d bytes without cache, %d bytes auto", sizes["off/1"], sizes["auto/1"])
+	}
+}
+
+func TestEncodeLossless_ExactTransparentRGB(t *testing.T) {
+	// Transparent pixels carry RGB that pipelines may rely on (a mask
+	// applied later, data stored in the color channels).
+	img := gradientTestImage(64, 64)
+	for i := 0; i < len(img.Pix); i += 4 {
+		if (i/4)%64 < 40 {
+			n := uint32(i) * 2654435761
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(n>>8), uint8(n>>16), uint8(n>>24), 0
+		}
+	}
+
+	for _, effort := range []int{0, 6, 9} {
+		sizes := map[bool]int{}
+		for _, exact := range []bool{true, false} {
+			opts := DefaultOptions()
+			opts.Lossless = true
+			opts.LosslessEffort = effort
+			opts.Exact = exact
+			var buf bytes.Buffer
+			if err := Encode(&buf, img, opts); err != nil {
+				t.Fatalf("effort %d, Exact=%v: Encode: %v", effort, exact, err)
+			}
+			sizes[exact] = buf.Len()
+			dec, err := Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("effort %d, Exact=%v: Decode: %v", effort, exact, err)
+			}
+			got := dec.(*image.NRGBA)
+			for i := 0; i < len(img.Pix); i += 4 {
+				want := img.Pix[i : i+4]
+				if !exact && want[3] == 0 {
+					// The RGB of transparent pixels may be cleared.
+					want = []byte{got.Pix[i], got.Pix[i+1], got.Pix[i+2], 0}
+				}
+				if !bytes.Equal(got.Pix[i:i+4], want) {
+					t.Fatalf("effort %d, Exact=%v: pixel %d = %v, want %v",
+						effort, exact, i/4, got.Pix[i:i+4], want)
+				}
+			}
+		}
+		if sizes[false] >= sizes[true] {
+			t.Errorf("effort %d: %d bytes without Exact, want < %d with Exact", effort, sizes[false], sizes[true])
+		}
+	}
+}
+
+func TestEncode_QMinQMaxClamp(t *testing.T) {
+	img := richTestImage(96, 96)
+	encode := func(t *testing.T, opts *EncoderOptions) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	t.Run("lossless", func(t *testing.T) {
+		// The clamp applies to the effective quality, whichever option set it.
+		capped := encode(t, &EncoderOptions{Lossless: true, Quality: 100, Method: 4, QMax: 20})
+		if want := encode(t, &EncoderOptions{Lossless: true, Quality: 20, Method: 4}); !bytes.Equal(capped, want) {
+			t.Error("Quality 100 with QMax 20 differs from Quality 20")
+		}
+		opts := DefaultOptions()
+		opts.Lossless = true
+		opts.LosslessEffort = 9 // Method 6, quality 100
+		opts.QMax = 20
+		if want := encode(t, &EncoderOptions{Lossless: true, Quality: 20, Method: 6}); !bytes.Equal(encode(t, opts), want) {
+			t.Error("LosslessEffort 9 with QMax 20 differs from Method 6 Quality 20")
+		}
+		raised := encode(t, &EncoderOptions{Lossless: true, Quality: 0, Method: 4, QMin: 90, QMax: -1})
+		if want := encode(t, &EncoderOptions{Lossless: true, Quality: 90, Method: 4}); !bytes.Equal(raised, want) {
+			t.Error("Quality 0 with QMin 90 differs from Quality 90")
+		}
+		// An unset (zero) QMax does not clamp.
+		if !bytes.Equal(encode(t, &EncoderOptions{Lossless: true, Quality: 100, Method: 4}),
+			encode(t, &EncoderOptions{Lossless: true, Quality: 100, Method: 4, QMax: -1})) {
+			t.Error("zero QMax clamped the lossless quality")
+		}
+	})
+
+	t.Run("lossy", func(t *testing.T) {
+		// Rate control aiming at an unreachable size climbs to QMax.
+		opts := DefaultOptions()
+		opts.TargetSize = 1 << 20
+		opts.Pass = 6
+		full := encode(t, opts)
+		opts.QMax = 30
+		capped := encode(t, opts)
+		if len(capped) >= len(full) {
+			t.Errorf("QMax 30: %d bytes, want < %d without it", len(capped), len(full))
+		}
+	})
+}
+
+func TestEncodeLossy_TileSize(t *testing.T) {
+	// Tiles are macroblock-aligned, so without transparency the planes,
+	// and thus the bytes, are those of the whole-image conversion.
+	opaque := gradientTestImage(100, 70)
+	wide := image.NewNRGBA64(image.Rect(0, 0, 50, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 50; x++ {
+			wide.SetNRGBA64(x, y, color.NRGBA64{R: uint16(x * 1300), G: uint16(y * 1601), B: 0x8080, A: 0xffff})
+		}
+	}
+	premul := image.NewRGBA(opaque.Bounds())
+	draw.Draw(premul, premul.Bounds(), opaque, image.Point{}, draw.Src)
+	encode := func(img image.Image, tile int) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, &EncoderOptions{Quality: 75, Method: 4, TileSize: tile}); err != nil {
+			t.Fatalf("Encode(TileSize=%d): %v", tile, err)
+		}
+		return buf.Bytes()
+	}
+	for name, img := range map[string]image.Image{"nrgba": opaque, "nrgba64": wide, "rgba": premul} {
+		want := encode(img, 0)
+		for _, tile := range []int{1, 2, 5, 100} {
+			if got := encode(img, tile); !bytes.Equal(got, want) {
+				t.Errorf("%s: TileSize=%d gives %d bytes that differ from the untiled %d", name, tile, len(got), len(want))
+			}
+		}
+	}
+
+	// With transparency the cleanup runs per tile; the alpha is unchanged
+	// and the visible pixels stay close.
+	translucent := gradientTestImage(100, 70)
+	for y := 0; y < 70; y++ {
+		for x := 0; x < 100; x++ {
+			a := uint8(255)
+			if x > 60 {
+				a = 0
+			} else if y > 40 {
+				a = 128
+			}
+			translucent.Pix[translucent.PixOffset(x, y)+3] = a
+		}
+	}
+	want, err := Decode(bytes.NewReader(encode(translucent, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := encode(translucent, 2)
+	got, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode tiled: %v", err)
+	}
+	g, w := got.(*image.NRGBA), want.(*image.NRGBA)
+	var sum float64
+	var n int
+	for i := 0; i < len(g.Pix); i += 4 {
+		if g.Pix[i+3] != w.Pix[i+3] {
+			t.Fatalf("alpha differs at pixel %d: %d, want %d", i/4, g.Pix[i+3], w.Pix[i+3])
+		}
+		if w.Pix[i+3] == 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			d := float64(g.Pix[i+c]) - float64(w.Pix[i+c])
+			sum += d * d
+			n++
+		}
+	}
+	if psnr := computePSNR(sum / float64(n)); psnr < 40 {
+		t.Errorf("tiled vs untiled visible PSNR = %.1f dB, want >= 40", psnr)
+	}
+}
+
+func TestEncode_LosslessFallback(t *testing.T) {
+	// A photo-like gradient with sensor-style noise.
+	photo := image.NewNRGBA(image.Rect(0, 0, 96, 96))
+	seed := uint32(1)
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 96; x++ {
+			seed = seed*1664525 + 1013904223
+			n := int(seed>>27) - 16
+			photo.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(40 + 2*x + n), G: uint8(20 + x + y + n), B: uint8(220 - y + n), A: 255,
+			})
+		}
+	}
+	icon := solidImage(64, 64, color.NRGBA{R: 200, G: 30, B: 30, A: 255})
+
+	for _, tc := range []struct {
+		name      string
+		img       image.Image
+		wantLossy bool
+	}{
+		{"photo", photo, true},
+		{"icon", icon, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var llSize, lySize int
+			var usedLossy bool
+			opts := &EncoderOptions{
+				Lossless: true, Quality: 75, LosslessFallback: true, LosslessFallbackQuality: 60,
+				LosslessFallbackFunc: func(ll, ly int, lossy bool) { llSize, lySize, usedLossy = ll, ly, lossy },
+			}
+			var buf bytes.Buffer
+			if err := Encode(&buf, tc.img, opts); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if usedLossy != tc.wantLossy {
+				t.Errorf("usedLossy = %v (lossless %d bytes, lossy %d), want %v", usedLossy, llSize, lySize, tc.wantLossy)
+			}
+
+			// The output is the chosen plain encode.
+			plain := &EncoderOptions{Lossless: true, Quality: 75}
+			if tc.wantLossy {
+				plain = &EncoderOptions{Quality: 60}
+			}
+			want := mustEncode(t, tc.img, plain)
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("output (%d bytes) differs from the plain encode (%d bytes)", buf.Len(), len(want))
+			}
+			if got := min(llSize, lySize); buf.Len() != got {
+				t.Errorf("output is %d bytes, want the smaller of %d and %d", buf.Len(), llSize, lySize)
+			}
+		})
+	}
+
+	if err := Encode(io.Discard, photo, &EncoderOptions{Lossless: true, LosslessFallback: true, LosslessFallbackQuality: 101}); err == nil {
+		t.Error("LosslessFallbackQuality 101: expected error")
+	}
+}
+
+func TestEncode_LosslessStatsFunc(t *testing.T) {
+	encode := func(img image.Image, opts *EncoderOptions) (LosslessStats, int) {
+		t.Helper()
+		var got LosslessStats
+		calls := 0
+		opts.LosslessStatsFunc = func(s LosslessStats) {
+			got = s
+			calls++
+		}
+		mustEncode(t, img, opts)
+		return got, calls
+	}
+	on, off := true, false
+
+	s, calls := encode(iconTestImage(64, 64), &EncoderOptions{Lossless: true, Quality: 75})
+	t.Logf("icon: %+v", s)
+	if calls != 1 || !s.UsedColorIndex || s.PaletteSize != 64 || s.UsedSubtractGreen || s.UsedCrossColor {
+		t.Errorf("icon: %d calls, stats %+v, want one call with a 64-color palette", calls, s)
+	}
+
+	s, _ = encode(gradientTestImage(64, 64), &EncoderOptions{Lossless: true, Quality: 75})
+	t.Logf("gradient: %+v", s)
+	if s.UsedColorIndex || s.PaletteSize != 0 || !s.UsedPredictor {
+		t.Errorf("gradient: stats %+v, want prediction and no palette", s)
+	}
+
+	s, _ = encode(iconTestImage(64, 64), &EncoderOptions{Lossless: true, Quality: 75, LosslessTransforms: TransformAll &^ TransformColorIndexing})
+	if s.UsedColorIndex {
+		t.Errorf("LosslessTransforms without color indexing: stats %+v", s)
+	}
+	if s, _ = encode(gradientTestImage(64, 64), &EncoderOptions{Lossless: true, Quality: 75, UseColorCache: &off}); s.CacheBits != 0 {
+		t.Errorf("UseColorCache false: CacheBits = %d, want 0", s.CacheBits)
+	}
+	if s, _ = encode(gradientTestImage(64, 64), &EncoderOptions{Lossless: true, Quality: 75, UseColorCache: &on}); s.CacheBits == 0 {
+		t.Error("UseColorCache true: CacheBits = 0")
+	}
+
+	// Lossy encodes, including their alpha plane, do not report.
+	translucent := gradientTestImage(32, 32)
+	translucent.Pix[3] = 0
+	if _, calls := encode(translucent, &EncoderOptions{Quality: 75}); calls != 0 {
+		t.Errorf("lossy: %d calls, want 0", calls)
+	}
+}
+
+func TestEncode_IntraMode(t *testing.T) {
+	img := gradientTestImage(96, 80)
+	const mbs = 6 * 5
+	for _, method := range []int{0, 2, 4, 6} {
+		for _, single := range []bool{true, false} {
+			sizes := map[IntraMode]int{}
+			var autoPSNR float64
+			for _, mode := range []IntraMode{IntraModeAuto, IntraModeI16Only, IntraModeI4Only} {
+				opts := DefaultOptions()
+				opts.Method = method
+				opts.SingleThreaded = single
+				opts.IntraMode = mode
+				data := mustEncode(t, img, opts)
+				sizes[mode] = len(data)
+
+				hdr, err := VP8Header(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("method %d, mode %d: VP8Header: %v", method, mode, err)
+				}
+				if hdr.I16Macroblocks+hdr.I4Macroblocks != mbs {
+					t.Errorf("method %d, mode %d: %d+%d macroblocks, want %d", method, mode, hdr.I16Macroblocks, hdr.I4Macroblocks, mbs)
+				}
+				if (mode == IntraModeI16Only && hdr.I4Macroblocks != 0) || (mode == IntraModeI4Only && hdr.I16Macroblocks != 0) {
+					t.Errorf("method %d, mode %d: %d I16 and %d I4 macroblocks", method, mode, hdr.I16Macroblocks, hdr.I4Macroblocks)
+				}
+
+				dec, err := Decode(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("method %d, mode %d: Decode: %v", method, mode, err)
+				}
+				// Decodes as well as the auto mode (the first one).
+				_, _, _, psnr, err := PSNR(img, dec)
+				if mode == IntraModeAuto {
+					autoPSNR = psnr
+				}
+				if err != nil || psnr < autoPSNR-2 {
+					t.Errorf("method %d, mode %d: PSNR = %.1f dB, %v; auto mode %.1f dB", method, mode, psnr, err, autoPSNR)
+				}
+			}
+			t.Logf("method %d, single-threaded %v: sizes %v", method, single, sizes)
+		}
+	}
+
+	if err := Encode(&bytes.Buffer{}, img, &EncoderOptions{Quality: 75, IntraMode: 3}); err == nil {
+		t.Error("Encode accepted IntraMode 3")
+	}
+}
+
+func TestEncode_NoExtendedFormat(t *testing.T) {
+	opaque := gradientTestImage(48, 32)
+	translucent := gradientTestImage(48, 32)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = uint8(64 + i%128)
+	}
+
+	ok := []struct {
+		name     string
+		img      image.Image
+		lossless bool
+		want     string
+	}{
+		{"opaque lossy", opaque, false, "[VP8 ]"},
+		{"opaque lossless", opaque, true, "[VP8L]"},
+		{"translucent lossless", translucent, true, "[VP8L]"},
+	}
+	for _, tc := range ok {
+		opts := DefaultOptions()
+		opts.Lossless = tc.lossless
+		opts.NoExtendedFormat = true
+		if got := chunkTags(t, mustEncode(t, tc.img, opts)); got != tc.want {
+			t.Errorf("%s: chunks %s, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	fail := []struct {
+		name string
+		img  image.Image
+		set  func(*EncoderOptions)
+	}{
+		{"translucent lossy", translucent, func(*EncoderOptions) {}},
+		{"ICC", opaque, func(o *EncoderOptions) { o.ICC = []byte("icc profile") }},
+		{"EXIF", opaque, func(o *EncoderOptions) { o.EXIF = []byte("exif") }},
+		{"XMP lossless", opaque, func(o *EncoderOptions) { o.Lossless = true; o.XMP = []byte("<xmp/>") }},
+	}
+	for _, tc := range fail {
+		opts := DefaultOptions()
+		tc.set(opts)
+		// Without the flag the encoder upgrades to VP8X.
+		if got := chunkTags(t, mustEncode(t, tc.img, opts)); !strings.HasPrefix(got, "[VP8X") {
+			t.Errorf("%s: chunks %s without NoExtendedFormat, want VP8X", tc.name, got)
+		}
+		opts.NoExtendedFormat = true
+		var buf bytes.Buffer
+		err := Encode(&buf, tc.img, opts)
+		if !errors.Is(err, ErrNeedsExtendedFormat) {
+			t.Errorf("%s: err = %v, want ErrNeedsExtendedFormat", tc.name, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: wrote %d bytes on error", tc.name, buf.Len())
+		}
+	}
+}
+
+func TestEncode_SegmentFilterStrengths(t *testing.T) {
+	// Flat on the left, noisy on the right: the analysis pass gives the
+	// halves different segments.
+	img := gradientTestImage(128, 64)
+	for y := 0; y < 64; y++ {
+		for x := 64; x < 128; x++ {
+			v := uint8((x*7919 + y*104729) % 251)
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v ^ 0x5a, B: 255 - v, A: 255})
+		}
+	}
+	header := func(opts *EncoderOptions) *VP8HeaderInfo {
+		t.Helper()
+		data := mustEncode(t, img, opts)
+		if _, err := Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		hdr, err := VP8Header(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("VP8Header: %v", err)
+		}
+		return hdr
+	}
+
+	hdr := header(DefaultOptions())
+	if !hdr.Segmentation || !hdr.SegmentAbsolute {
+		t.Fatalf("default: VP8Header = %+v, want absolute segment values", *hdr)
+	}
+	computed := hdr.SegmentFilterLevels
+
+	for _, tc := range []struct {
+		set  int // SegmentFilterStrengths[0]
+		want int // level of segment 0
+	}{
+		{-1, 0},
+		{17, 17},
+		{63, 63},
+	} {
+		if tc.want == computed[0] {
+			continue
+		}
+		opts := DefaultOptions()
+		opts.SegmentFilterStrengths[0] = tc.set
+		hdr := header(opts)
+		if !hdr.Segmentation || hdr.SegmentFilterLevels[0] != tc.want {
+			t.Errorf("segment 0 set to %d: segment levels %v, want %d for segment 0", tc.set, hdr.SegmentFilterLevels, tc.want)
+		}
+		if hdr.FilterLevel == 0 && tc.want > 0 {
+			t.Errorf("segment 0 set to %d: loop filter off", tc.set)
+		}
+	}
+
+	// A partial literal forces only the segments it names: the zero
+	// entries keep their computed levels.
+	opts := DefaultOptions()
+	opts.SegmentFilterStrengths = [4]int{0: 40}
+	want := computed
+	want[0] = 40
+	if hdr := header(opts); hdr.SegmentFilterLevels != want {
+		t.Errorf("[4]int{0: 40}: segment levels %v, want %v", hdr.SegmentFilterLevels, want)
+	}
+
+	// Without segmentation the frame level is segment 0's.
+	opts = DefaultOptions()
+	opts.Segments = 1
+	opts.SegmentFilterStrengths = [4]int{0: 25}
+	if hdr := header(opts); hdr.Segmentation || hdr.FilterLevel != 25 {
+		t.Errorf("one segment forced to 25: Segmentation %v, FilterLevel %d", hdr.Segmentation, hdr.FilterLevel)
+	}
+
+	// An override applies even with FilterStrength 0.
+	opts = DefaultOptions()
+	opts.FilterStrength = 0
+	opts.SegmentFilterStrengths = [4]int{0, 30, 30, 30}
+	if hdr := header(opts); hdr.FilterLevel == 0 {
+		t.Errorf("FilterStrength 0 with segments forced to 30: loop filter off, levels %v", hdr.SegmentFilterLevels)
+	}
+
+	// An EncoderOptions literal leaves the levels computed.
+	lit := header(&EncoderOptions{Quality: 75, Method: 4, FilterStrength: 60})
+	if lit.FilterLevel == 0 {
+		t.Errorf("literal options: loop filter off")
+	}
+
+	opts = DefaultOptions()
+	opts.SegmentFilterStrengths[2] = 64
+	if err := Encode(io.Discard, img, opts); err == nil {
+		t.Error("SegmentFilterStrengths[2] = 64: no error")
+	}
+}
+
+func TestEncode_TimeBudget(t *testing.T) {
+	img := gradientTestImage(64, 48)
+	for _, lossless := range []bool{false, true} {
+		withMethod := func(method int, budget time.Duration) []byte {
+			opts := DefaultOptions()
+			opts.Lossless = lossless
+			opts.SingleThreaded = true
+			opts.Method = method
+			opts.TimeBudget = budget
+			return mustEncode(t, img, opts)
+		}
+		// Method 0 always runs; a nanosecond leaves no time for more.
+		if got, want := withMethod(4, time.Nanosecond), withMethod(0, 0); !bytes.Equal(got, want) {
+			t.Errorf("lossless %v: tiny budget: %d bytes, want the %d of Method 0", lossless, len(got), len(want))
+		}
+		if got, want := withMethod(0, time.Minute), withMethod(6, 0); !bytes.Equal(got, want) {
+			t.Errorf("lossless %v: large budget: %d bytes, want the %d of Method 6", lossless, len(got), len(want))
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.TimeBudget = -time.Second
+	if err := Encode(io.Discard, img, opts); err == nil {
+		t.Error("negative TimeBudget: no error")
+	}
+
+	// EncodeGray and EncodePaletted ignore the budget, including on the
+	// paths that hand the image on to Encode.
+	gray := image.NewGray(img.Bounds())
+	pal := image.NewPaletted(img.Bounds(), nil)
+	for i := 0; i < 64; i++ {
+		pal.Palette = append(pal.Palette, color.Gray{uint8(4 * i)})
+	}
+	for i := range gray.Pix {
+		gray.Pix[i] = img.Pix[4*i]
+		pal.Pix[i] = img.Pix[4*i] / 4
+	}
+	for name, enc := range map[string]func(io.Writer, *EncoderOptions) error{
+		"EncodeGray": func(w io.Writer, o *EncoderOptions) error { return EncodeGray(w, gray, o) },
+		"EncodePaletted": func(w io.Writer, o *EncoderOptions) error {
+			o.LosslessTransforms = TransformAll &^ TransformColorIndexing
+			return EncodePaletted(w, pal, o)
+		},
+	} {
+		encode := func(budget time.Duration) []byte {
+			var buf bytes.Buffer
+			opts := DefaultOptions()
+			opts.SingleThreaded = true
+			opts.TimeBudget = budget
+			if err := enc(&buf, opts); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			return buf.Bytes()
+		}
+		if got, want := encode(time.Nanosecond), encode(0); !bytes.Equal(got, want) {
+			t.Errorf("%s: tiny budget: %d bytes, want the %d without a budget", name, len(got), len(want))
+		}
+	}
+}
+
+func TestEncode_AlphaThreshold(t *testing.T) {
+	img := gradientTestImage(64, 64)
+	img.SetNRGBA(5, 7, color.NRGBA{R: 255, A: 0})     // a stray transparent pixel
+	img.SetNRGBA(9, 9, color.NRGBA{R: 255, A: 200})   // slightly translucent
+	img.SetNRGBA(20, 30, color.NRGBA{G: 255, A: 254}) // an antialiasing leftover
+
+	encode := func(lossless bool, threshold uint8, bg color.Color) []byte {
+		opts := DefaultOptions()
+		opts.Lossless = lossless
+		opts.Exact = true
+		opts.AlphaThreshold = threshold
+		opts.FlattenBackground = bg
+		return mustEncode(t, img, opts)
+	}
+
+	// Without a threshold the alpha channel is kept.
+	if got := chunkTags(t, encode(false, 0, nil)); got != "[VP8X ALPH VP8 ]" {
+		t.Errorf("no threshold: chunks %s, want an ALPH chunk", got)
+	}
+	// Two pixels below 255 in 4096: dropped, with no ALPH chunk.
+	if got := chunkTags(t, encode(false, 255, nil)); got != "[VP8 ]" {
+		t.Errorf("threshold 255: chunks %s, want a simple VP8 file", got)
+	}
+
+	// Lossless shows the composited pixels exactly.
+	for _, tc := range []struct {
+		bg   color.Color
+		want color.NRGBA // the stray pixel
+	}{
+		{nil, color.NRGBA{255, 255, 255, 255}},
+		{color.NRGBA{R: 10, G: 20, B: 30, A: 0}, color.NRGBA{10, 20, 30, 255}},
+	} {
+		dec, err := Decode(bytes.NewReader(encode(true, 255, tc.bg)))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		out := dec.(*image.NRGBA)
+		if !out.Opaque() {
+			t.Errorf("background %v: decoded image is not opaque", tc.bg)
+		}
+		if got := out.NRGBAAt(5, 7); got != tc.want {
+			t.Errorf("background %v: stray pixel %v, want %v", tc.bg, got, tc.want)
+		}
+		if got, want := out.NRGBAAt(40, 40), img.NRGBAAt(40, 40); got != want {
+			t.Errorf("background %v: opaque pixel %v, want %v", tc.bg, got, want)
+		}
+	}
+
+	// Pixels at or above the threshold count as opaque: a translucent band
+	// is kept at 255 but dropped at 200.
+	band := gradientTestImage(64, 64)
+	for x := 0; x < 64; x++ {
+		band.SetNRGBA(x, 10, color.NRGBA{B: 255, A: 200})
+	}
+	for _, tc := range []struct {
+		threshold uint8
+		opaque    bool
+	}{{255, false}, {201, false}, {200, true}, {1, true}} {
+		opts := DefaultOptions()
+		opts.Lossless = true
+		opts.AlphaThreshold = tc.threshold
+		dec, err := Decode(bytes.NewReader(mustEncode(t, band, opts)))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if got := dec.(*image.NRGBA).Opaque(); got != tc.opaque {
+			t.Errorf("threshold %d: opaque %v, want %v", tc.threshold, got, tc.opaque)
+		}
+	}
+
+	// Transparency that is not accidental is kept.
+	many := gradientTestImage(64, 64)
+	for x := 0; x < 64; x++ {
+		many.SetNRGBA(x, 0, color.NRGBA{A: 0})
+	}
+	opts := DefaultOptions()
+	opts.AlphaThreshold = 255
+	if got := chunkTags(t, mustEncode(t, many, opts)); got != "[VP8X ALPH VP8 ]" {
+		t.Errorf("64 transparent pixels: chunks %s, want an ALPH chunk", got)
+	}
+	opts.AlphaThreshold = 0
+	if got := chunkTags(t, mustEncode(t, many, opts)); got != "[VP8X ALPH VP8 ]" {
+		t.Errorf("64 transparent pixels, no threshold: chunks %s, want an ALPH chunk", got)
+	}
+}
+
+func TestEncode_Grayscale(t *testing.T) {
+	// A black-and-white scan whose red and blue channels are misregistered
+	// by a pixel, leaving color fringes along every edge.
+	page := func(x, y int) uint8 {
+		if (x/3+y/5)%4 == 0 || (x*x+y)%11 == 0 {
+			return 30
+		}
+		return 225
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 128, 96))
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 128; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			p[0], p[1], p[2], p[3] = page(x+1, y), page(x, y), page(x-1, y), 255
+		}
+	}
+
+	for _, q := range []float32{50, 90} {
+		color := mustEncode(t, img, &EncoderOptions{Quality: q, Method: 4})
+		gray := mustEncode(t, img, &EncoderOptions{Quality: q, Method: 4, Grayscale: true})
+		if len(gray) > len(color)*85/100 {
+			t.Errorf("q%.0f: Grayscale output is %d bytes, color %d", q, len(gray), len(color))
+		}
+
+		dec, err := Decode(bytes.NewReader(gray))
+		if err != nil {
+			t.Fatalf("q%.0f: Decode: %v", q, err)
+		}
+		ycc, ok := dec.(*image.YCbCr)
+		if !ok {
+			t.Fatalf("q%.0f: Decode returned %T, want *image.YCbCr", q, dec)
+		}
+		for i := range ycc.Cb {
+			if ycc.Cb[i] != 128 || ycc.Cr[i] != 128 {
+				t.Fatalf("q%.0f: chroma sample %d = (%d, %d), want neutral", q, i, ycc.Cb[i], ycc.Cr[i])
+			}
+		}
+		pix, _, _, _, err := DecodeRaw(bytes.NewReader(gray), PixelFormatRGBA)
+		if err != nil {
+			t.Fatalf("q%.0f: DecodeRaw: %v", q, err)
+		}
+		// The fixed-point YUV to RGB conversion rounds each channel on its
+		// own.
+		for i := 0; i < len(pix); i += 4 {
+			lo, hi := min(pix[i], pix[i+1], pix[i+2]), max(pix[i], pix[i+1], pix[i+2])
+			if hi-lo > 1 {
+				t.Fatalf("q%.0f: pixel %d = %v, want gray", q, i/4, pix[i:i+3])
+			}
+		}
+	}
+
+	// Lossless encodes ignore it.
+	want := mustEncode(t, img, &EncoderOptions{Lossless: true, Quality: 75})
+	if got := mustEncode(t, img, &EncoderOptions{Lossless: true, Quality: 75, Grayscale: true}); !bytes.Equal(got, want) {
+		t.Error("lossless: Grayscale changed the output")
+	}
+}
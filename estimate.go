@@ -0,0 +1,69 @@
+package webp
+
+import (
+	"image"
+)
+
+// EstimateSize returns the approximate size in bytes of the file that
+// [Encode] would write for img with opts, at a fraction of the cost of the
+// real encode. It is meant for quick decisions, such as whether WebP is
+// likely to beat an existing JPEG, not for exact budgeting.
+//
+// The estimate is the size of a single fast encode: lossy images are
+// encoded with Method 0 in one pass, and lossless images with Method 0 and
+// at most Quality 50, which keeps the transforms that matter most but skips
+// the exhaustive LZ77 search and limits the color cache search. With
+// AutoFormat the smaller of the two estimates is returned. TargetSize,
+// TargetBPP, TargetPSNR, TargetSSIM and UseSharpYUV are ignored.
+// For photographic content at the default options the estimate is usually
+// within about 20% of the real size. It tends to be high for lossy encodes
+// with Method 3 or more, whose rate-distortion search saves most on flat,
+// graphic content, and can be low for lossless encodes with Method 6.
+func EstimateSize(img image.Image, opts *EncoderOptions) (int, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if err := validateConfig(opts); err != nil {
+		return 0, err
+	}
+	if opts.AutoFormat {
+		o := *opts
+		o.AutoFormat = false
+		o.Lossless = true
+		ll, err := EstimateSize(img, &o)
+		if err != nil {
+			return 0, err
+		}
+		o.Lossless = false
+		ly, err := EstimateSize(img, &o)
+		if err != nil {
+			return 0, err
+		}
+		return min(ll, ly), nil
+	}
+	o := *opts
+	o.Method = 0
+	o.Pass = 1
+	o.TargetSize = 0
+	o.TargetBPP = 0
+	o.TargetPSNR = 0
+	o.TargetSSIM = 0
+	o.UseSharpYUV = false
+	if o.Lossless {
+		o.LosslessEffort = 0
+		o.Quality = min(o.Quality, 50)
+	}
+	var n byteCounter
+	if err := Encode(&n, img, &o); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// byteCounter is an io.Writer that only counts the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,59 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestEstimateSize(t *testing.T) {
+	img := threadTestImage()
+	for _, lossless := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.Lossless = lossless
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		est, err := EstimateSize(img, opts)
+		if err != nil {
+			t.Fatalf("EstimateSize: %v", err)
+		}
+		if r := float64(est) / float64(buf.Len()); r < 0.8 || r > 1.2 {
+			t.Errorf("lossless=%v: estimate %d is %.2fx the actual size %d", lossless, est, r, buf.Len())
+		}
+	}
+}
+
+func TestEstimateSizeMethod0IsExact(t *testing.T) {
+	// With options that already describe a single fast encode, the
+	// estimate is the real size.
+	img := threadTestImage()
+	opts := DefaultOptions()
+	opts.Method = 0
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	est, err := EstimateSize(img, opts)
+	if err != nil {
+		t.Fatalf("EstimateSize: %v", err)
+	}
+	if est != buf.Len() {
+		t.Errorf("estimate = %d, want %d", est, buf.Len())
+	}
+}
+
+func TestEstimateSizeErrors(t *testing.T) {
+	if _, err := EstimateSize(nil, nil); err == nil {
+		t.Error("nil image should error")
+	}
+	if _, err := EstimateSize(image.NewNRGBA(image.Rect(0, 0, 0, 4)), nil); err == nil {
+		t.Error("empty image should error")
+	}
+	opts := DefaultOptions()
+	opts.Method = 7
+	if _, err := EstimateSize(threadTestImage(), opts); err == nil {
+		t.Error("invalid Method should error")
+	}
+}
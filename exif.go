@@ -0,0 +1,135 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+
+	"github.com/deepteams/webp/internal/container"
+)
+
+// ErrNoThumbnail is returned by [ExifThumbnail] when the file has no EXIF
+// chunk or its EXIF data carries no JPEG thumbnail.
+var ErrNoThumbnail = errors.New("webp: no EXIF thumbnail")
+
+var errInvalidEXIF = errors.New("webp: invalid EXIF data")
+
+// EXIF tags of the thumbnail in IFD1.
+const (
+	exifTagJPEGOffset = 0x0201 // JPEGInterchangeFormat
+	exifTagJPEGLength = 0x0202 // JPEGInterchangeFormatLength
+)
+
+// ExifThumbnail decodes the JPEG thumbnail that cameras embed in the EXIF
+// metadata (IFD1) of an extended WebP file, without decoding the image
+// itself. It returns [ErrNoThumbnail] if there is no EXIF chunk or no
+// thumbnail in it.
+func ExifThumbnail(r io.Reader) (image.Image, error) {
+	if r == nil {
+		return nil, errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("webp: reading data: %w", err)
+	}
+	p, err := container.NewParser(data)
+	if err != nil {
+		return nil, fmt.Errorf("webp: parsing container: %w", err)
+	}
+	m := metadataFromParser(p)
+	if !m.HasEXIF {
+		return nil, ErrNoThumbnail
+	}
+	thumb, err := exifThumbnail(m.EXIF)
+	if err != nil {
+		return nil, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		return nil, fmt.Errorf("webp: decoding EXIF thumbnail: %w", err)
+	}
+	return img, nil
+}
+
+// exifThumbnail returns the JPEG thumbnail bytes referenced by IFD1 of the
+// TIFF-structured EXIF data. The "Exif\0\0" prefix some writers keep from
+// JPEG APP1 segments is accepted.
+func exifThumbnail(exif []byte) ([]byte, error) {
+	tiff := bytes.TrimPrefix(exif, []byte("Exif\x00\x00"))
+	if len(tiff) < 8 {
+		return nil, errInvalidEXIF
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, errInvalidEXIF
+	}
+
+	// IFD0 holds the main image tags; the offset after its entries links
+	// to IFD1, which describes the thumbnail.
+	ifd0 := order.Uint32(tiff[4:])
+	n, ok := exifIFDCount(tiff, ifd0, order)
+	if !ok {
+		return nil, errInvalidEXIF
+	}
+	next := uint64(ifd0) + 2 + 12*uint64(n)
+	if next+4 > uint64(len(tiff)) {
+		return nil, errInvalidEXIF
+	}
+	ifd1 := order.Uint32(tiff[next:])
+	if ifd1 == 0 {
+		return nil, ErrNoThumbnail
+	}
+	n, ok = exifIFDCount(tiff, ifd1, order)
+	if !ok {
+		return nil, errInvalidEXIF
+	}
+
+	var off, size uint32
+	var haveOff, haveSize bool
+	for i := 0; i < n; i++ {
+		e := tiff[ifd1+2+12*uint32(i):]
+		switch order.Uint16(e) {
+		case exifTagJPEGOffset:
+			off, haveOff = exifLong(e, order), true
+		case exifTagJPEGLength:
+			size, haveSize = exifLong(e, order), true
+		}
+	}
+	if !haveOff || !haveSize || size == 0 {
+		return nil, ErrNoThumbnail
+	}
+	if uint64(off)+uint64(size) > uint64(len(tiff)) {
+		return nil, errInvalidEXIF
+	}
+	return tiff[off : off+size], nil
+}
+
+// exifIFDCount returns the number of entries of the IFD at off, checking
+// that they fit in tiff.
+func exifIFDCount(tiff []byte, off uint32, order binary.ByteOrder) (int, bool) {
+	if uint64(off)+2 > uint64(len(tiff)) {
+		return 0, false
+	}
+	n := int(order.Uint16(tiff[off:]))
+	if uint64(off)+2+12*uint64(n) > uint64(len(tiff)) {
+		return 0, false
+	}
+	return n, true
+}
+
+// exifLong returns the value of a SHORT or LONG IFD entry e.
+func exifLong(e []byte, order binary.ByteOrder) uint32 {
+	if order.Uint16(e[2:]) == 3 { // SHORT
+		return uint32(order.Uint16(e[8:]))
+	}
+	return order.Uint32(e[8:])
+}
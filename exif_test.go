@@ -0,0 +1,108 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// buildEXIF returns TIFF-structured EXIF data with an IFD0 holding one
+// unrelated tag and, if thumb is not nil, an IFD1 pointing at thumb.
+func buildEXIF(order interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}, thumb []byte) []byte {
+	var b []byte
+	if order.String() == "LittleEndian" {
+		b = []byte("II*\x00")
+	} else {
+		b = []byte("MM\x00*")
+	}
+	b = order.AppendUint32(b, 8)
+
+	// IFD0: Orientation = 1.
+	b = order.AppendUint16(b, 1)
+	b = append(b, make([]byte, 12)...)
+	order.PutUint16(b[10:], 0x0112)
+	order.PutUint16(b[12:], 3)
+	order.PutUint32(b[14:], 1)
+	order.PutUint16(b[18:], 1)
+	if thumb == nil {
+		return order.AppendUint32(b, 0)
+	}
+	ifd1 := uint32(len(b) + 4)
+	b = order.AppendUint32(b, ifd1)
+
+	// IFD1: JPEGInterchangeFormat and JPEGInterchangeFormatLength.
+	data := ifd1 + 2 + 2*12 + 4
+	b = order.AppendUint16(b, 2)
+	for _, e := range [][2]uint32{{exifTagJPEGOffset, data}, {exifTagJPEGLength, uint32(len(thumb))}} {
+		b = order.AppendUint16(b, uint16(e[0]))
+		b = order.AppendUint16(b, 4) // LONG
+		b = order.AppendUint32(b, 1)
+		b = order.AppendUint32(b, e[1])
+	}
+	b = order.AppendUint32(b, 0)
+	return append(b, thumb...)
+}
+
+func TestExifThumbnail(t *testing.T) {
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, gradientTestImage(24, 16), nil); err != nil {
+		t.Fatal(err)
+	}
+	encodeWithEXIF := func(exif []byte) []byte {
+		t.Helper()
+		opts := DefaultOptions()
+		opts.EXIF = exif
+		var buf bytes.Buffer
+		if err := Encode(&buf, gradientTestImage(64, 64), opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, tc := range []struct {
+		name string
+		exif []byte
+	}{
+		{"little_endian", buildEXIF(binary.LittleEndian, jpg.Bytes())},
+		{"big_endian", buildEXIF(binary.BigEndian, jpg.Bytes())},
+		{"app1_prefix", append([]byte("Exif\x00\x00"), buildEXIF(binary.LittleEndian, jpg.Bytes())...)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			thumb, err := ExifThumbnail(bytes.NewReader(encodeWithEXIF(tc.exif)))
+			if err != nil {
+				t.Fatalf("ExifThumbnail: %v", err)
+			}
+			if got := thumb.Bounds(); got != image.Rect(0, 0, 24, 16) {
+				t.Errorf("thumbnail bounds = %v, want 24x16", got)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name string
+		file []byte
+		want error
+	}{
+		{"no_exif", encodeWithEXIF(nil), ErrNoThumbnail},
+		{"no_ifd1", encodeWithEXIF(buildEXIF(binary.LittleEndian, nil)), ErrNoThumbnail},
+		{"garbage", encodeWithEXIF([]byte("not exif data")), errInvalidEXIF},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ExifThumbnail(bytes.NewReader(tc.file)); !errors.Is(err, tc.want) {
+				t.Errorf("error = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	// A thumbnail that extends past the EXIF data is rejected.
+	exif := buildEXIF(binary.LittleEndian, jpg.Bytes())
+	if _, err := exifThumbnail(exif[:len(exif)-10]); !errors.Is(err, errInvalidEXIF) {
+		t.Errorf("truncated thumbnail: error = %v, want errInvalidEXIF", err)
+	}
+}
@@ -0,0 +1,203 @@
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/deepteams/webp/animation"
+	"github.com/deepteams/webp/internal/container"
+)
+
+// maxFileChunks bounds the chunk directory of a File: each frame of the
+// longest animation the parser accepts with an ALPH and a stray sub-chunk,
+// plus the metadata chunks.
+const maxFileChunks = 4*container.MaxFrames + container.MaxChunks + 8
+
+// File is a WebP file read through an io.ReaderAt, as returned by
+// [OpenReaderAt]. Opening it reads only the chunk headers; each method then
+// reads just the chunks it needs, in one ReadAt call per chunk. This suits
+// large animations in object storage, where a ranged read per chunk is far
+// cheaper than fetching the whole object to get at one frame.
+//
+// The methods of a File may be called concurrently if its ReaderAt allows
+// parallel ReadAt calls, as *os.File and *bytes.Reader do.
+type File struct {
+	r      io.ReaderAt
+	chunks []ChunkInfo
+	head   []int    // VP8X and ANIM chunks, which every frame needs
+	iccp   int      // ICCP chunk, or -1
+	frames [][2]int // range of top-level chunks holding each frame
+}
+
+// OpenReaderAt opens the WebP file of size bytes read from r. It reads the
+// RIFF header and walks the chunk headers, seeking over the payloads, so
+// only a few bytes per chunk are read. Chunk order and payloads are not
+// checked until a method needs them.
+func OpenReaderAt(r io.ReaderAt, size int64) (*File, error) {
+	if r == nil {
+		return nil, errors.New("webp: nil reader")
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("webp: invalid size %d", size)
+	}
+	chunks, err := readChunks(io.NewSectionReader(r, 0, size), maxFileChunks)
+	if err != nil {
+		return nil, fmt.Errorf("webp: parsing container: %w", err)
+	}
+
+	f := &File{r: r, chunks: chunks, iccp: -1}
+	still := false
+	for i, c := range chunks {
+		if c.Depth > 0 {
+			continue
+		}
+		switch c.FourCC {
+		case "VP8X", "ANIM":
+			f.head = append(f.head, i)
+		case "ICCP":
+			if f.iccp < 0 {
+				f.iccp = i
+			}
+		case "ANMF":
+			f.frames = append(f.frames, [2]int{i, i + 1})
+		case "VP8 ", "VP8L":
+			if still {
+				break
+			}
+			still = true
+			start := i
+			if i > 0 && chunks[i-1].FourCC == "ALPH" && chunks[i-1].Depth == 0 {
+				start = i - 1
+			}
+			f.frames = append(f.frames, [2]int{start, i + 1})
+		}
+	}
+	if len(f.frames) == 0 {
+		return nil, ErrNoFrames
+	}
+	if len(f.frames) > container.MaxFrames {
+		return nil, fmt.Errorf("webp: parsing container: %w: too many animation frames (max %d)", container.ErrInvalidChunk, container.MaxFrames)
+	}
+	return f, nil
+}
+
+// Chunks returns the chunks of the file, as [Chunks] lists them.
+func (f *File) Chunks() []ChunkInfo {
+	return slices.Clone(f.chunks)
+}
+
+// NumFrames returns the number of frames of the file: 1 for a still image.
+func (f *File) NumFrames() int {
+	return len(f.frames)
+}
+
+// Features returns the features of the file, as [GetFeatures] does. It
+// reads the VP8X, ANIM and ICCP chunks and the first frame.
+func (f *File) Features() (*Features, error) {
+	idx := f.frameChunks(0)
+	if f.iccp >= 0 {
+		idx = append(idx, f.iccp)
+		slices.Sort(idx)
+	}
+	p, err := f.parse(idx)
+	if err != nil {
+		return nil, err
+	}
+	feat := featuresFromParser(p)
+	feat.FrameCount = len(f.frames)
+	return feat, nil
+}
+
+// Frame reads and decodes frame i, reading only the VP8X and ANIM chunks
+// and the frame's own. The frame is decoded on its own, at its own size,
+// as [animation.Animation.DecodeRawFrame] does: OffsetX, OffsetY, Blend
+// and Dispose say how it applies to the canvas. A still image is frame 0,
+// at offset (0, 0) with no duration. IsKeyframe, which depends on the
+// previous frames, is left false.
+func (f *File) Frame(i int) (*animation.Frame, error) {
+	if i < 0 || i >= len(f.frames) {
+		return nil, fmt.Errorf("webp: frame index %d out of range [0, %d)", i, len(f.frames))
+	}
+	p, err := f.parse(f.frameChunks(i))
+	if err != nil {
+		return nil, err
+	}
+	frames := p.Frames()
+	if len(frames) == 0 {
+		return nil, ErrNoFrames
+	}
+	fi := frames[0]
+	img, err := decodeFrameForAnimation(fi.Payload, fi.AlphaData)
+	if err != nil {
+		return nil, fmt.Errorf("webp: frame %d: %w", i, err)
+	}
+	codec := animation.CodecLossy
+	if fi.IsLossless {
+		codec = animation.CodecLossless
+	}
+	return &animation.Frame{
+		Image:         img,
+		Duration:      time.Duration(fi.Duration) * time.Millisecond,
+		OffsetX:       fi.XOffset,
+		OffsetY:       fi.YOffset,
+		Dispose:       animation.DisposeMethod(fi.DisposeMethod),
+		Blend:         animation.BlendMethod(fi.BlendMethod),
+		HasAlpha:      fi.HasAlpha,
+		Codec:         codec,
+		BitstreamData: fi.Payload,
+		AlphaData:     fi.AlphaData,
+	}, nil
+}
+
+// frameChunks returns the indices, in file order, of the chunks needed to
+// parse frame i on its own.
+func (f *File) frameChunks(i int) []int {
+	idx := slices.Clone(f.head)
+	for j := f.frames[i][0]; j < f.frames[i][1]; j++ {
+		idx = append(idx, j)
+	}
+	slices.Sort(idx)
+	return idx
+}
+
+// parse reads the chunks at the given indices into a WebP file of their
+// own and parses it.
+func (f *File) parse(idx []int) (*container.Parser, error) {
+	n := int64(container.RIFFHeaderSize)
+	for _, i := range idx {
+		c := f.chunks[i]
+		n += container.ChunkHeaderSize + int64(c.Size) + int64(c.Size&1)
+	}
+	if n > MaxInputSize {
+		return nil, fmt.Errorf("webp: input too large (%d bytes, max %d)", n, MaxInputSize)
+	}
+
+	data := make([]byte, container.RIFFHeaderSize, n)
+	binary.LittleEndian.PutUint32(data[0:4], container.FourCCRIFF)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(n-container.ChunkHeaderSize))
+	binary.LittleEndian.PutUint32(data[8:12], container.FourCCWEBP)
+	for _, i := range idx {
+		c := f.chunks[i]
+		start := len(data)
+		data = data[:start+container.ChunkHeaderSize+int(c.Size)]
+		if m, err := f.r.ReadAt(data[start:], c.Offset); m < len(data)-start {
+			if err == io.EOF || err == nil {
+				return nil, ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("webp: reading data: %w", err)
+		}
+		if c.Padded {
+			data = append(data, 0)
+		}
+	}
+
+	p, err := container.NewParser(data)
+	if err != nil {
+		return nil, fmt.Errorf("webp: parsing container: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,167 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deepteams/webp/animation"
+)
+
+// countingReaderAt counts the bytes read through it.
+type countingReaderAt struct {
+	r *bytes.Reader
+	n atomic.Int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func TestOpenReaderAt(t *testing.T) {
+	// An animation of 12 distinct, noisy frames, so that each is a sizable
+	// part of the file.
+	var anim bytes.Buffer
+	enc := animation.NewEncoder(&anim, 48, 40, &animation.EncodeOptions{Quality: 90, LoopCount: 3})
+	for i := 0; i < 12; i++ {
+		frame := gradientTestImage(48, 40)
+		for p := 0; p < len(frame.Pix); p += 4 {
+			frame.Pix[p] = uint8(p*(i+3)) ^ uint8(p/7)
+		}
+		if err := enc.AddFrame(frame, time.Duration(40+i)*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	translucent := gradientTestImage(40, 30)
+	translucent.SetNRGBA(3, 4, color.NRGBA{R: 9, A: 100})
+	icc := DefaultOptions()
+	icc.ICC = buildICC(srgbTags(iccSRGBCurve()))
+	lossless := DefaultOptions()
+	lossless.Lossless = true
+
+	files := map[string][]byte{
+		"animation":         anim.Bytes(),
+		"lossy alpha":       mustEncode(t, translucent, nil),
+		"lossy ICC":         mustEncode(t, gradientTestImage(40, 30), icc),
+		"lossless":          mustEncode(t, iconTestImage(40, 30), lossless),
+		"lossless with ICC": mustEncode(t, translucent, &EncoderOptions{Lossless: true, Quality: 75, ICC: icc.ICC}),
+	}
+	for name, data := range files {
+		r := &countingReaderAt{r: bytes.NewReader(data)}
+		f, err := OpenReaderAt(r, int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: OpenReaderAt: %v", name, err)
+		}
+
+		want, err := Chunks(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: Chunks: %v", name, err)
+		}
+		if got := f.Chunks(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Chunks = %+v, want %+v", name, got, want)
+		}
+		// Opening reads the chunk headers and the ANMF frame headers only.
+		if read := r.n.Load(); read > int64(12+24*len(want)) {
+			t.Errorf("%s: OpenReaderAt read %d bytes for %d chunks", name, read, len(want))
+		}
+
+		wantFeat, err := GetFeatures(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: GetFeatures: %v", name, err)
+		}
+		feat, err := f.Features()
+		if err != nil {
+			t.Fatalf("%s: Features: %v", name, err)
+		}
+		if *feat != *wantFeat {
+			t.Errorf("%s: Features = %+v, want %+v", name, *feat, *wantFeat)
+		}
+
+		// A still image is a one-frame animation to animation.Decode.
+		a, err := animation.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: animation.Decode: %v", name, err)
+		}
+		if f.NumFrames() != len(a.Frames) {
+			t.Fatalf("%s: NumFrames = %d, want %d", name, f.NumFrames(), len(a.Frames))
+		}
+		for i := range a.Frames {
+			before := r.n.Load()
+			got, err := f.Frame(i)
+			if err != nil {
+				t.Fatalf("%s: Frame(%d): %v", name, i, err)
+			}
+			// The frame's ANMF chunk plus the VP8X and ANIM chunks.
+			if read := r.n.Load() - before; feat.HasAnimation && read > 100+int64(anmfSize(want, i)) {
+				t.Errorf("%s: Frame(%d) read %d bytes of %d", name, i, read, len(data))
+			}
+			wantImg, err := a.DecodeRawFrame(i)
+			if err != nil {
+				t.Fatalf("%s: DecodeRawFrame(%d): %v", name, i, err)
+			}
+			w := a.Frames[i]
+			if !sameNRGBA(got.Image, wantImg) || got.Duration != w.Duration || got.OffsetX != w.OffsetX ||
+				got.OffsetY != w.OffsetY || got.Dispose != w.Dispose || got.Blend != w.Blend || got.Codec != w.Codec {
+				t.Errorf("%s: Frame(%d) = %+v, want %+v", name, i, *got, w)
+			}
+		}
+	}
+
+	data := anim.Bytes()
+	f, err := OpenReaderAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("OpenReaderAt: %v", err)
+	}
+	if _, err := f.Frame(12); err == nil {
+		t.Error("Frame(12) of 12: no error")
+	}
+	if _, err := OpenReaderAt(bytes.NewReader(data), int64(len(data))-10); !errors.Is(err, ErrUnexpectedEOF) {
+		t.Errorf("truncated size: err = %v, want ErrUnexpectedEOF", err)
+	}
+	if _, err := OpenReaderAt(bytes.NewReader(data[:20]), 20); !errors.Is(err, ErrUnexpectedEOF) {
+		t.Errorf("truncated file: err = %v, want ErrUnexpectedEOF", err)
+	}
+	if _, err := OpenReaderAt(nil, 0); err == nil {
+		t.Error("nil reader: no error")
+	}
+}
+
+// anmfSize returns the payload size of the ANMF chunk of frame i.
+func anmfSize(chunks []ChunkInfo, i int) uint32 {
+	for _, c := range chunks {
+		if c.FourCC == "ANMF" {
+			if i == 0 {
+				return c.Size
+			}
+			i--
+		}
+	}
+	return 0
+}
+
+// sameNRGBA reports whether a and b have the same bounds and pixels.
+func sameNRGBA(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.NRGBAModel.Convert(a.At(x, y)) != color.NRGBAModel.Convert(b.At(x, y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
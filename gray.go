@@ -0,0 +1,242 @@
+package webp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/deepteams/webp/internal/container"
+	"github.com/deepteams/webp/internal/lossless"
+	"github.com/deepteams/webp/internal/lossy"
+)
+
+// EncodeGray writes a single-channel image, such as a UI mask, to w.
+// By default it is stored as a lossless grayscale image (R = G = B = value);
+// the VP8L encoder's transforms make the two redundant channels almost free.
+// Unless opts.LosslessTransforms says otherwise, a palette is only tried
+// for images with at most 16 gray levels: it cannot be bit-packed beyond
+// that, and it hides the smooth variation that spatial prediction codes
+// well, which can make gradients many times larger.
+// With opts.AlphaOnly the values are stored instead as the alpha plane of a
+// lossy image with constant black color, compressed according to the
+// Alpha* options. [DecodeGray] reads either form back.
+func EncodeGray(w io.Writer, img *image.Gray, opts *EncoderOptions) error {
+	if w == nil {
+		return errors.New("webp: nil writer")
+	}
+	if img == nil {
+		return errors.New("webp: nil image")
+	}
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	if err := validateConfig(opts); err != nil {
+		return err
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("webp: invalid image dimensions %dx%d", width, height)
+	}
+	if width > MaxDimension || height > MaxDimension {
+		return fmt.Errorf("webp: image dimension %dx%d exceeds maximum %d", width, height, MaxDimension)
+	}
+	if opts.Strict {
+		return encodeStrict(w, width, height, opts, func(w io.Writer, o *EncoderOptions) error {
+			return EncodeGray(w, img, o)
+		})
+	}
+
+	if !opts.AlphaOnly {
+		var used [256]bool
+		nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			src := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):][:width]
+			dst := nrgba.Pix[y*nrgba.Stride:]
+			for x, v := range src {
+				dst[4*x], dst[4*x+1], dst[4*x+2], dst[4*x+3] = v, v, v, 0xff
+				used[v] = true
+			}
+		}
+		o := *opts
+		o.Lossless = true
+		o.AutoFormat = false
+		o.TimeBudget = 0
+		if o.LosslessTransforms == 0 && grayLevels(&used) > 16 {
+			o.LosslessTransforms = TransformAll &^ TransformColorIndexing
+		}
+		return Encode(w, nrgba, &o)
+	}
+
+	alpha := make([]byte, width*height)
+	for y := 0; y < height; y++ {
+		copy(alpha[y*width:(y+1)*width], img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):])
+	}
+	alphaData, err := encodeAlphaPlane(alpha, width, height, opts)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := lossyConfig(opts, width, height)
+	if err != nil {
+		return err
+	}
+	cfg.HasAlpha = 0
+	enc := lossy.NewEncoder(image.NewGray(image.Rect(0, 0, width, height)), cfg)
+	defer lossy.ReleaseEncoder(enc)
+	bs, err := enc.EncodeFrame()
+	if err != nil {
+		return fmt.Errorf("webp: lossy encode: %w", err)
+	}
+	return writeRIFF(w, container.FourCCVP8, bs, alphaData, width, height, opts)
+}
+
+// grayLevels returns the number of gray levels marked in used.
+func grayLevels(used *[256]bool) int {
+	n := 0
+	for _, u := range used {
+		if u {
+			n++
+		}
+	}
+	return n
+}
+
+// DecodeGray reads a WebP image written by [EncodeGray] from r. For a lossy
+// image with an alpha channel (the AlphaOnly form) the alpha plane is
+// returned and the color is not decoded. Any other image is decoded as by
+// [Decode] and converted with color.GrayModel, which returns the stored
+// value exactly for R = G = B. Only the first frame of an animation is read,
+// composited onto the canvas as by [Decode]: 0 outside the frame.
+func DecodeGray(r io.Reader) (*image.Gray, error) {
+	if r == nil {
+		return nil, errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("webp: reading data: %w", err)
+	}
+	p, err := container.NewParser(data)
+	if err != nil {
+		return nil, fmt.Errorf("webp: parsing container: %w", err)
+	}
+	frames := p.Frames()
+	if len(frames) == 0 {
+		return nil, ErrNoFrames
+	}
+	frame := frames[0]
+
+	if !frame.IsLossless && len(frame.AlphaData) > 0 {
+		gray, err := decodeALPH(frame)
+		if err != nil {
+			return nil, err
+		}
+		return grayFirstFrameCanvas(p, frame, gray), nil
+	}
+
+	img, err := decodeFrame(frame, nil)
+	if err != nil {
+		return nil, err
+	}
+	return toGray(firstFrameCanvas(p, frame, img)), nil
+}
+
+// DecodeAlpha reads the alpha channel of the WebP image in r, e.g. to use
+// it as a mask. For a lossy image the alpha plane is stored in its own
+// chunk and is the only thing decoded, which is much faster than a full
+// decode; a lossless image stores alpha with the color and is decoded in
+// full. A fully opaque image gives an all-255 plane. Only the first frame
+// of an animation is read, composited onto the canvas as by [Decode]: the
+// alpha is 0 outside the frame.
+func DecodeAlpha(r io.Reader) (*image.Gray, error) {
+	if r == nil {
+		return nil, errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("webp: reading data: %w", err)
+	}
+	p, err := container.NewParser(data)
+	if err != nil {
+		return nil, fmt.Errorf("webp: parsing container: %w", err)
+	}
+	frames := p.Frames()
+	if len(frames) == 0 {
+		return nil, ErrNoFrames
+	}
+	frame := frames[0]
+	gray, err := decodeFrameAlpha(frame)
+	if err != nil {
+		return nil, err
+	}
+	return grayFirstFrameCanvas(p, frame, gray), nil
+}
+
+// decodeFrameAlpha decodes the alpha channel of frame.
+func decodeFrameAlpha(frame container.FrameInfo) (*image.Gray, error) {
+	switch {
+	case frame.IsLossless:
+		nrgba, err := lossless.DecodeVP8L(frame.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("webp: lossless decode: %w", err)
+		}
+		b := nrgba.Rect
+		gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		for y := 0; y < b.Dy(); y++ {
+			src := nrgba.Pix[y*nrgba.Stride:]
+			dst := gray.Pix[y*gray.Stride : y*gray.Stride+b.Dx()]
+			for x := range dst {
+				dst[x] = src[4*x+3]
+			}
+		}
+		return gray, nil
+	case len(frame.AlphaData) > 0:
+		return decodeALPH(frame)
+	default:
+		// Lossy without an ALPH chunk: opaque, nothing to decode.
+		gray := image.NewGray(image.Rect(0, 0, frame.Width, frame.Height))
+		for i := range gray.Pix {
+			gray.Pix[i] = 0xff
+		}
+		return gray, nil
+	}
+}
+
+// decodeALPH decodes the ALPH chunk of a lossy frame.
+func decodeALPH(frame container.FrameInfo) (*image.Gray, error) {
+	plane, err := lossy.DecodeAlpha(frame.AlphaData, frame.Width, frame.Height)
+	if err != nil {
+		return nil, fmt.Errorf("webp: alpha decode: %w", err)
+	}
+	return &image.Gray{
+		Pix:    plane[:frame.Width*frame.Height],
+		Stride: frame.Width,
+		Rect:   image.Rect(0, 0, frame.Width, frame.Height),
+	}, nil
+}
+
+// toGray converts img to an *image.Gray with color.GrayModel. Opaque NRGBA
+// pixels are converted directly.
+func toGray(img image.Image) *image.Gray {
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	nrgba, _ := img.(*image.NRGBA)
+	for y := 0; y < b.Dy(); y++ {
+		dst := gray.Pix[y*gray.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			if nrgba != nil {
+				p := nrgba.Pix[nrgba.PixOffset(b.Min.X+x, b.Min.Y+y):]
+				if p[3] == 0xff {
+					// color.GrayModel on the 16-bit (v * 0x101) channels.
+					sum := 19595*uint32(p[0]) + 38470*uint32(p[1]) + 7471*uint32(p[2])
+					dst[x] = uint8((sum*0x101 + 1<<15) >> 24)
+					continue
+				}
+			}
+			dst[x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+		}
+	}
+	return gray
+}
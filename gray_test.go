@@ -0,0 +1,125 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func grayTestImage(w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(x * 255 / w)
+			if (x/8+y/8)%2 == 0 {
+				v = 255 - v
+			}
+			img.Pix[y*img.Stride+x] = v
+		}
+	}
+	return img
+}
+
+func TestEncodeGrayRoundTrip(t *testing.T) {
+	src := grayTestImage(45, 30)
+	for _, alphaOnly := range []bool{false, true} {
+		var buf bytes.Buffer
+		opts := DefaultOptions()
+		opts.AlphaOnly = alphaOnly
+		if err := EncodeGray(&buf, src, opts); err != nil {
+			t.Fatalf("AlphaOnly=%v: EncodeGray: %v", alphaOnly, err)
+		}
+		feat, err := GetFeatures(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("AlphaOnly=%v: GetFeatures: %v", alphaOnly, err)
+		}
+		if want := map[bool]string{false: "lossless", true: "extended"}[alphaOnly]; feat.Format != want {
+			t.Errorf("AlphaOnly=%v: Format = %q, want %q", alphaOnly, feat.Format, want)
+		}
+		got, err := DecodeGray(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("AlphaOnly=%v: DecodeGray: %v", alphaOnly, err)
+		}
+		if got.Bounds() != src.Bounds() || !bytes.Equal(got.Pix, src.Pix) {
+			t.Errorf("AlphaOnly=%v: decoded plane differs from the source", alphaOnly)
+		}
+	}
+}
+
+func TestEncodeGraySubImage(t *testing.T) {
+	src := grayTestImage(40, 40).SubImage(image.Rect(5, 7, 29, 33)).(*image.Gray)
+	var buf bytes.Buffer
+	if err := EncodeGray(&buf, src, &EncoderOptions{AlphaOnly: true, AlphaQuality: 100}); err != nil {
+		t.Fatalf("EncodeGray: %v", err)
+	}
+	got, err := DecodeGray(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodeGray: %v", err)
+	}
+	for y := 0; y < 26; y++ {
+		for x := 0; x < 24; x++ {
+			if g, w := got.GrayAt(x, y).Y, src.GrayAt(5+x, 7+y).Y; g != w {
+				t.Fatalf("pixel (%d,%d) = %d, want %d", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestEncodeGrayErrors(t *testing.T) {
+	if err := EncodeGray(&bytes.Buffer{}, nil, nil); err == nil {
+		t.Error("nil image: expected error")
+	}
+	if err := EncodeGray(nil, grayTestImage(4, 4), nil); err == nil {
+		t.Error("nil writer: expected error")
+	}
+	if _, err := DecodeGray(nil); err == nil {
+		t.Error("nil reader: expected error")
+	}
+}
+
+func TestDecodeAlpha(t *testing.T) {
+	translucent := gradientTestImage(37, 23)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = uint8(i * 7)
+	}
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+		opts *EncoderOptions
+	}{
+		{"lossy_alpha", translucent, &EncoderOptions{Quality: 75}},
+		{"lossless_alpha", translucent, &EncoderOptions{Lossless: true, Quality: 75}},
+		{"lossy_opaque", gradientTestImage(37, 23), &EncoderOptions{Quality: 75}},
+		{"lossless_opaque", gradientTestImage(37, 23), &EncoderOptions{Lossless: true, Quality: 75}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := mustEncode(t, tc.img, tc.opts)
+			full, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			got, err := DecodeAlpha(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("DecodeAlpha: %v", err)
+			}
+			if got.Bounds() != full.Bounds() {
+				t.Fatalf("bounds = %v, want %v", got.Bounds(), full.Bounds())
+			}
+			for y := 0; y < 23; y++ {
+				for x := 0; x < 37; x++ {
+					_, _, _, a := full.At(x, y).RGBA()
+					if g := got.GrayAt(x, y).Y; g != uint8(a>>8) {
+						t.Fatalf("alpha at (%d,%d) = %d, want %d", x, y, g, a>>8)
+					}
+				}
+			}
+		})
+	}
+
+	if _, err := DecodeAlpha(nil); err == nil {
+		t.Error("nil reader: expected error")
+	}
+	if _, err := DecodeAlpha(bytes.NewReader([]byte("not a webp"))); err == nil {
+		t.Error("invalid data: expected error")
+	}
+}
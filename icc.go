@@ -0,0 +1,324 @@
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// iccTransform converts pixels described by a matrix/TRC RGB ICC profile
+// to sRGB: each channel is linearized with its tone reproduction curve, the
+// linear values are mapped to the profile connection space (CIE XYZ, D50)
+// with the colorant matrix, and from there to linear sRGB and back through
+// the sRGB curve.
+type iccTransform struct {
+	lin [3][256]float32 // per-channel TRC: 8-bit value to linear light
+	m   [3][3]float32   // linear profile RGB to linear sRGB
+}
+
+// xyzD50ToSRGB maps D50 XYZ to linear sRGB (the Bradford-adapted inverse of
+// the sRGB colorant matrix that ICC sRGB profiles carry).
+var xyzD50ToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// parseICC reads the colorants and tone curves of a matrix/TRC RGB profile
+// (ICC v2 or v4 with an XYZ connection space, e.g. a display profile).
+// Profiles that describe their transform only with lookup tables (A2B0),
+// and non-RGB profiles, are not supported.
+func parseICC(data []byte) (*iccTransform, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("webp: not an ICC profile")
+	}
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, errors.New("webp: unsupported ICC color space")
+	}
+	tags, err := iccTags(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var t iccTransform
+	var p [3][3]float64 // columns are the red, green and blue colorants
+	for c, sig := range [3]string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, err := iccXYZ(tags[sig])
+		if err != nil {
+			return nil, err
+		}
+		for k := 0; k < 3; k++ {
+			p[k][c] = xyz[k]
+		}
+	}
+	for c, sig := range [3]string{"rTRC", "gTRC", "bTRC"} {
+		if err := iccCurve(tags[sig], &t.lin[c]); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			var v float64
+			for k := 0; k < 3; k++ {
+				v += xyzD50ToSRGB[i][k] * p[k][j]
+			}
+			t.m[i][j] = float32(v)
+		}
+	}
+	return &t, nil
+}
+
+// iccTags returns the tags of the ICC profile data, whose header is
+// already checked, keyed by signature.
+func iccTags(data []byte) (map[string][]byte, error) {
+	n := binary.BigEndian.Uint32(data[128:])
+	if uint64(n)*12 > uint64(len(data)-132) {
+		return nil, errors.New("webp: truncated ICC tag table")
+	}
+	tags := make(map[string][]byte, n)
+	for i := 0; i < int(n); i++ {
+		e := data[132+12*i:]
+		off := binary.BigEndian.Uint32(e[4:])
+		size := binary.BigEndian.Uint32(e[8:])
+		if uint64(off)+uint64(size) > uint64(len(data)) {
+			return nil, errors.New("webp: ICC tag out of bounds")
+		}
+		tags[string(e[:4])] = data[off : off+size]
+	}
+	return tags, nil
+}
+
+// ColorSpaceHint classifies the color space an image declares with its ICC
+// profile, as reported by [Features].
+type ColorSpaceHint int
+
+const (
+	// ColorSpaceUnknown is an ICC profile that could not be classified:
+	// damaged, or an RGB profile described only by lookup tables.
+	ColorSpaceUnknown ColorSpaceHint = iota
+	// ColorSpaceSRGB is an image without a profile, which WebP defines as
+	// sRGB, or with an RGB profile using the sRGB primaries.
+	ColorSpaceSRGB
+	// ColorSpaceWideGamut is an RGB profile with other primaries, such as
+	// Display P3, Adobe RGB or Rec. 2020, which sRGB cannot represent.
+	ColorSpaceWideGamut
+	// ColorSpaceOther is a profile for a color space other than RGB, such
+	// as grayscale, CMYK or Lab.
+	ColorSpaceOther
+)
+
+// String returns the name of h, e.g. "sRGB".
+func (h ColorSpaceHint) String() string {
+	switch h {
+	case ColorSpaceUnknown:
+		return "unknown"
+	case ColorSpaceSRGB:
+		return "sRGB"
+	case ColorSpaceWideGamut:
+		return "wide-gamut"
+	case ColorSpaceOther:
+		return "other"
+	}
+	return fmt.Sprintf("ColorSpaceHint(%d)", int(h))
+}
+
+// iccSRGBPrimaries are the sRGB colorants adapted to D50, as ICC sRGB
+// profiles store them in their rXYZ, gXYZ and bXYZ tags.
+var iccSRGBPrimaries = [3][3]float64{
+	{0.4360747, 0.2225045, 0.0139322},
+	{0.3850649, 0.7168786, 0.0971045},
+	{0.1430804, 0.0606169, 0.7141733},
+}
+
+// iccColorSpaceHint classifies the ICC profile data from its header and, for
+// RGB profiles, its colorants. An empty profile is sRGB.
+func iccColorSpaceHint(data []byte) ColorSpaceHint {
+	if len(data) == 0 {
+		return ColorSpaceSRGB
+	}
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return ColorSpaceUnknown
+	}
+	if string(data[16:20]) != "RGB " {
+		return ColorSpaceOther
+	}
+	tags, err := iccTags(data)
+	if err != nil {
+		return ColorSpaceUnknown
+	}
+	for c, sig := range [3]string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, err := iccXYZ(tags[sig])
+		if err != nil {
+			return ColorSpaceUnknown
+		}
+		for k, v := range xyz {
+			// Profiles round the colorants differently.
+			if math.Abs(v-iccSRGBPrimaries[c][k]) > 0.002 {
+				return ColorSpaceWideGamut
+			}
+		}
+	}
+	return ColorSpaceSRGB
+}
+
+// s15Fixed16 decodes an ICC s15Fixed16Number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// iccXYZ decodes an XYZType tag.
+func iccXYZ(b []byte) ([3]float64, error) {
+	if len(b) < 20 || string(b[:4]) != "XYZ " {
+		return [3]float64{}, errors.New("webp: missing or invalid ICC colorant tag")
+	}
+	return [3]float64{s15Fixed16(b[8:]), s15Fixed16(b[12:]), s15Fixed16(b[16:])}, nil
+}
+
+// iccCurve evaluates a curveType or parametricCurveType tag at the 256
+// 8-bit input values.
+func iccCurve(b []byte, lin *[256]float32) error {
+	if len(b) < 12 {
+		return errors.New("webp: missing or invalid ICC tone curve tag")
+	}
+	var f func(x float64) float64
+	switch string(b[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(b[8:]))
+		if n > (len(b)-12)/2 {
+			return errors.New("webp: truncated ICC tone curve")
+		}
+		switch n {
+		case 0:
+			f = func(x float64) float64 { return x }
+		case 1:
+			g := float64(binary.BigEndian.Uint16(b[12:])) / 256
+			f = func(x float64) float64 { return math.Pow(x, g) }
+		default:
+			table := b[12 : 12+2*n]
+			f = func(x float64) float64 {
+				pos := x * float64(n-1)
+				i := min(int(pos), n-2)
+				frac := pos - float64(i)
+				v0 := float64(binary.BigEndian.Uint16(table[2*i:]))
+				v1 := float64(binary.BigEndian.Uint16(table[2*i+2:]))
+				return (v0 + (v1-v0)*frac) / 65535
+			}
+		}
+	case "para":
+		fn := int(binary.BigEndian.Uint16(b[8:]))
+		nParams := [5]int{1, 3, 4, 5, 7}
+		if fn >= len(nParams) {
+			return errors.New("webp: unsupported ICC parametric curve")
+		}
+		if len(b) < 12+4*nParams[fn] {
+			return errors.New("webp: truncated ICC tone curve")
+		}
+		var prm [7]float64
+		for i := 0; i < nParams[fn]; i++ {
+			prm[i] = s15Fixed16(b[12+4*i:])
+		}
+		g, a, bb, c, d, e, ff := prm[0], prm[1], prm[2], prm[3], prm[4], prm[5], prm[6]
+		// Types 1 and 2 switch at x = -b/a and types 3 and 4 at x = d;
+		// below the switch point type 1 is 0, type 2 is c, type 3 is c*x
+		// and type 4 is c*x + f. Type 0 is a pure power function.
+		pow := func(x, off float64) float64 {
+			if v := a*x + bb; v > 0 {
+				return math.Pow(v, g) + off
+			}
+			return off
+		}
+		switch fn {
+		case 0:
+			f = func(x float64) float64 { return math.Pow(x, g) }
+		case 1:
+			f = func(x float64) float64 { return pow(x, 0) }
+		case 2:
+			f = func(x float64) float64 { return pow(x, c) }
+		case 3:
+			f = func(x float64) float64 {
+				if x >= d {
+					return pow(x, 0)
+				}
+				return c * x
+			}
+		case 4:
+			f = func(x float64) float64 {
+				if x >= d {
+					return pow(x, e)
+				}
+				return c*x + ff
+			}
+		}
+	default:
+		return errors.New("webp: unsupported ICC tone curve type")
+	}
+	for i := range lin {
+		lin[i] = float32(f(float64(i) / 255))
+	}
+	return nil
+}
+
+var (
+	srgbEncodeOnce sync.Once
+	srgbEncodeLUT  [1 << 16]uint8 // linear light (16-bit) to 8-bit sRGB
+)
+
+func initSRGBEncodeLUT() {
+	for i := range srgbEncodeLUT {
+		v := float64(i) / (1<<16 - 1)
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		srgbEncodeLUT[i] = uint8(v*255 + 0.5)
+	}
+}
+
+// apply returns img converted to sRGB. The result is img itself when it is
+// an *image.NRGBA, which is then modified in place.
+func (t *iccTransform) apply(img image.Image) *image.NRGBA {
+	srgbEncodeOnce.Do(initSRGBEncodeLUT)
+	var nrgba *image.NRGBA
+	switch m := img.(type) {
+	case *image.NRGBA:
+		nrgba = m
+	case *image.YCbCr:
+		nrgba = ycbcrToNRGBA(m)
+	default:
+		b := img.Bounds()
+		nrgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				nrgba.Set(x, y, color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)))
+			}
+		}
+	}
+
+	b := nrgba.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := nrgba.Pix[nrgba.PixOffset(b.Min.X, y):][:4*b.Dx()]
+		for i := 0; i < len(row); i += 4 {
+			if row[i+3] == 0 {
+				continue
+			}
+			r, g, bl := t.lin[0][row[i]], t.lin[1][row[i+1]], t.lin[2][row[i+2]]
+			for c := 0; c < 3; c++ {
+				v := t.m[c][0]*r + t.m[c][1]*g + t.m[c][2]*bl
+				switch {
+				case v <= 0:
+					row[i+c] = 0
+				case v >= 1:
+					row[i+c] = 255
+				default:
+					row[i+c] = srgbEncodeLUT[int(v*(1<<16-1)+0.5)]
+				}
+			}
+		}
+	}
+	return nrgba
+}
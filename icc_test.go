@@ -0,0 +1,229 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// sRGB colorants adapted to D50, as stored in ICC sRGB profiles.
+var (
+	iccSRGBRed   = [3]float64{0.4360747, 0.2225045, 0.0139322}
+	iccSRGBGreen = [3]float64{0.3850649, 0.7168786, 0.0971045}
+	iccSRGBBlue  = [3]float64{0.1430804, 0.0606169, 0.7141733}
+)
+
+func iccFixed(v float64) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(int32(v*65536+0.5)))
+}
+
+func iccXYZTag(xyz [3]float64) []byte {
+	b := append([]byte("XYZ "), 0, 0, 0, 0)
+	for _, v := range xyz {
+		b = append(b, iccFixed(v)...)
+	}
+	return b
+}
+
+// iccSRGBCurve is the sRGB transfer function as a type 3 parametric curve.
+func iccSRGBCurve() []byte {
+	b := append([]byte("para"), 0, 0, 0, 0, 0, 3, 0, 0)
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		b = append(b, iccFixed(v)...)
+	}
+	return b
+}
+
+// iccLinearCurve is an identity curveType tag.
+func iccLinearCurve() []byte {
+	return append([]byte("curv"), 0, 0, 0, 0, 0, 0, 0, 0)
+}
+
+// buildICC assembles a display-class RGB profile from the given tags.
+func buildICC(tags map[string][]byte) []byte {
+	sigs := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	header := make([]byte, 128)
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(sigs)))
+	var body []byte
+	off := 128 + 4 + 12*len(sigs)
+	for _, sig := range sigs {
+		data := tags[sig]
+		table = append(table, sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(off+len(body)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(data)))
+		body = append(body, data...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	p := append(append(header, table...), body...)
+	binary.BigEndian.PutUint32(p, uint32(len(p)))
+	return p
+}
+
+func srgbTags(curve []byte) map[string][]byte {
+	return map[string][]byte{
+		"rXYZ": iccXYZTag(iccSRGBRed), "gXYZ": iccXYZTag(iccSRGBGreen), "bXYZ": iccXYZTag(iccSRGBBlue),
+		"rTRC": curve, "gTRC": curve, "bTRC": curve,
+	}
+}
+
+// decodeWithICC encodes img losslessly with the ICC profile icc and decodes
+// it with opts.
+func decodeWithICC(t *testing.T, img image.Image, icc []byte, lossless bool, opts *DecodeOptions) image.Image {
+	t.Helper()
+	eo := DefaultOptions()
+	eo.Lossless = lossless
+	eo.ICC = icc
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, eo); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	out, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), opts)
+	if err != nil {
+		t.Fatalf("DecodeWithOptions: %v", err)
+	}
+	return out
+}
+
+func iccTestImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(16 * x), G: uint8(16 * y), B: uint8(255 - 8*x - 7*y), A: 255})
+		}
+	}
+	return img
+}
+
+func TestApplyICC(t *testing.T) {
+	src := iccTestImage()
+	apply := &DecodeOptions{ApplyICC: true}
+
+	t.Run("srgb", func(t *testing.T) {
+		// An sRGB profile maps every pixel to itself.
+		out := decodeWithICC(t, src, buildICC(srgbTags(iccSRGBCurve())), true, apply)
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				got := out.(*image.NRGBA).NRGBAAt(x, y)
+				want := src.NRGBAAt(x, y)
+				if absDiff(got.R, want.R) > 1 || absDiff(got.G, want.G) > 1 || absDiff(got.B, want.B) > 1 {
+					t.Fatalf("(%d,%d) = %v, want %v", x, y, got, want)
+				}
+			}
+		}
+	})
+
+	t.Run("linear", func(t *testing.T) {
+		// Linear-light values are gamma-encoded: 50% gray becomes 188.
+		gray := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+		for i := range gray.Pix {
+			gray.Pix[i] = 128
+		}
+		out := decodeWithICC(t, gray, buildICC(srgbTags(iccLinearCurve())), true, apply)
+		if got := out.(*image.NRGBA).NRGBAAt(1, 1); got.R != 188 || got.G != 188 || got.B != 188 || got.A != 128 {
+			t.Errorf("pixel = %v, want {188 188 188 128}", got)
+		}
+	})
+
+	t.Run("swapped_primaries", func(t *testing.T) {
+		// A profile whose red channel has the sRGB green colorant.
+		tags := srgbTags(iccSRGBCurve())
+		tags["rXYZ"], tags["gXYZ"] = iccXYZTag(iccSRGBGreen), iccXYZTag(iccSRGBRed)
+		red := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+		for i := 0; i < len(red.Pix); i += 4 {
+			red.Pix[i], red.Pix[i+3] = 255, 255
+		}
+		out := decodeWithICC(t, red, buildICC(tags), true, apply)
+		if got := out.(*image.NRGBA).NRGBAAt(2, 2); got.R > 1 || got.G < 254 || got.B > 1 {
+			t.Errorf("pixel = %v, want pure green", got)
+		}
+	})
+
+	t.Run("lossy", func(t *testing.T) {
+		out := decodeWithICC(t, src, buildICC(srgbTags(iccLinearCurve())), false, apply)
+		if _, ok := out.(*image.NRGBA); !ok {
+			t.Fatalf("decoded %T, want *image.NRGBA", out)
+		}
+		plain := decodeWithICC(t, src, buildICC(srgbTags(iccLinearCurve())), false, nil)
+		if _, ok := plain.(*image.YCbCr); !ok {
+			t.Errorf("without ApplyICC decoded %T, want *image.YCbCr", plain)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		// A profile that cannot be interpreted leaves the pixels alone.
+		for _, icc := range [][]byte{
+			[]byte("not an ICC profile"),
+			buildICC(map[string][]byte{"rTRC": iccLinearCurve()}), // no colorants
+		} {
+			out := decodeWithICC(t, src, icc, true, apply)
+			if !bytes.Equal(out.(*image.NRGBA).Pix, src.Pix) {
+				t.Errorf("pixels changed for unsupported profile %q...", icc[:min(len(icc), 8)])
+			}
+		}
+	})
+
+	t.Run("off", func(t *testing.T) {
+		out := decodeWithICC(t, src, buildICC(srgbTags(iccLinearCurve())), true, nil)
+		if !bytes.Equal(out.(*image.NRGBA).Pix, src.Pix) {
+			t.Error("pixels changed without ApplyICC")
+		}
+	})
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestFeatures_ColorSpaceHint(t *testing.T) {
+	p3 := map[string][]byte{
+		// Display P3 colorants adapted to D50.
+		"rXYZ": iccXYZTag([3]float64{0.5151, 0.2412, -0.0011}),
+		"gXYZ": iccXYZTag([3]float64{0.2919, 0.6922, 0.0419}),
+		"bXYZ": iccXYZTag([3]float64{0.1571, 0.0666, 0.7841}),
+		"rTRC": iccSRGBCurve(), "gTRC": iccSRGBCurve(), "bTRC": iccSRGBCurve(),
+	}
+	gray := buildICC(map[string][]byte{"rTRC": iccSRGBCurve()})
+	copy(gray[16:], "GRAY")
+	cmyk := buildICC(nil)
+	copy(cmyk[12:], "prtrCMYKLab ")
+
+	tests := []struct {
+		name string
+		icc  []byte
+		want ColorSpaceHint
+	}{
+		{"none", nil, ColorSpaceSRGB},
+		{"srgb", buildICC(srgbTags(iccSRGBCurve())), ColorSpaceSRGB},
+		{"srgb_linear", buildICC(srgbTags(iccLinearCurve())), ColorSpaceSRGB},
+		{"display_p3", buildICC(p3), ColorSpaceWideGamut},
+		{"gray", gray, ColorSpaceOther},
+		{"cmyk", cmyk, ColorSpaceOther},
+		{"no_colorants", buildICC(map[string][]byte{"rTRC": iccLinearCurve()}), ColorSpaceUnknown},
+		{"garbage", []byte("not an ICC profile"), ColorSpaceUnknown},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.ICC = tc.icc
+			data := mustEncode(t, iccTestImage(), opts)
+			f, err := GetFeatures(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("GetFeatures: %v", err)
+			}
+			if f.ColorSpaceHint != tc.want {
+				t.Errorf("ColorSpaceHint = %v, want %v", f.ColorSpaceHint, tc.want)
+			}
+		})
+	}
+}
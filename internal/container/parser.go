@@ -35,6 +35,10 @@ type Parser struct {
 	features Features
 	frames   []FrameInfo
 	chunks   []Chunk // non-image metadata chunks (ICCP, EXIF, XMP, etc.)
+	lenient  bool
+
+	// maxFrames, if positive, lowers the frame limit (NewParserMaxFrames).
+	maxFrames int
 }
 
 // NewParser creates a parser and immediately parses the provided WebP data.
@@ -46,6 +50,31 @@ func NewParser(data []byte) (*Parser, error) {
 	return p, nil
 }
 
+// NewLenientParser is like NewParser but accepts files that browsers
+// display despite container errors: the RIFF size field is ignored when the
+// data runs past it, unknown chunks are skipped wherever they appear, the
+// padding byte of the last chunk may be missing, and damaged or garbage
+// chunks after the image data are ignored.
+func NewLenientParser(data []byte) (*Parser, error) {
+	p := &Parser{lenient: true}
+	if err := p.parse(data); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewParserMaxFrames is like NewParser, or NewLenientParser if lenient is
+// set, but stops parsing with an error wrapping ErrTooManyFrames as soon as
+// the file holds more than maxFrames animation frames, to bound the work
+// spent on untrusted files. maxFrames <= 0 means no limit beyond MaxFrames.
+func NewParserMaxFrames(data []byte, maxFrames int, lenient bool) (*Parser, error) {
+	p := &Parser{lenient: lenient, maxFrames: maxFrames}
+	if err := p.parse(data); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 // Features returns the parsed file features.
 func (p *Parser) Features() Features { return p.features }
 
@@ -57,15 +86,24 @@ func (p *Parser) Chunks() []Chunk { return p.chunks }
 
 // parse processes the complete WebP data buffer.
 func (p *Parser) parse(data []byte) error {
+	if len(data) < RIFFHeaderSize {
+		// Only the start of a RIFF/WEBP header counts as truncated.
+		if !isHeaderPrefix(data) {
+			return ErrInvalidRIFF
+		}
+		return ErrTruncated
+	}
 	hdr, consumed, err := ParseRIFFHeader(data)
 	if err != nil {
 		return err
 	}
 
-	// Limit parsing to the declared RIFF size.
+	// Limit parsing to the declared RIFF size. A file shorter than its
+	// header says is parsed as far as it goes: a chunk cut off by the end
+	// of the data is reported as ErrTruncated where it is needed.
 	riffEnd64 := uint64(hdr.FileSize) + uint64(ChunkHeaderSize)
 	riffEnd := int(riffEnd64)
-	if riffEnd64 > uint64(len(data)) {
+	if riffEnd64 > uint64(len(data)) || p.lenient {
 		riffEnd = len(data)
 	}
 	buf := data[consumed:riffEnd]
@@ -76,6 +114,21 @@ func (p *Parser) parse(data []byte) error {
 
 	// Peek at the first chunk's FourCC to determine format.
 	firstFourCC := binary.LittleEndian.Uint32(buf[0:4])
+	if p.lenient {
+		// Skip unknown chunks in front of the image.
+		for firstFourCC != FourCCVP8X && firstFourCC != FourCCVP8 && firstFourCC != FourCCVP8L {
+			_, payloadSize, err := ReadChunkHeader(buf)
+			if err != nil {
+				return err
+			}
+			n, ok := p.chunkSize(buf, payloadSize)
+			if !ok || len(buf)-n < ChunkHeaderSize {
+				return fmt.Errorf("%w: no image chunk", ErrUnsupported)
+			}
+			buf = buf[n:]
+			firstFourCC = binary.LittleEndian.Uint32(buf[0:4])
+		}
+	}
 
 	switch firstFourCC {
 	case FourCCVP8X:
@@ -91,14 +144,43 @@ func (p *Parser) parse(data []byte) error {
 	}
 }
 
+// chunkSize returns the size of the chunk at the start of buf, header and
+// padding included, given its payload size. ok is false if the chunk does
+// not fit in buf; in lenient mode the padding byte of the last chunk may be
+// missing.
+func (p *Parser) chunkSize(buf []byte, payloadSize uint32) (n int, ok bool) {
+	padded64 := uint64(ChunkHeaderSize) + uint64(payloadSize) + uint64(payloadSize&1)
+	if padded64 <= uint64(len(buf)) {
+		return int(padded64), true
+	}
+	if p.lenient && padded64-1 == uint64(len(buf)) && payloadSize&1 != 0 {
+		return len(buf), true
+	}
+	return 0, false
+}
+
+// isHeaderPrefix reports whether data, shorter than a RIFF header, matches
+// the start of one: "RIFF", four size bytes, "WEBP".
+func isHeaderPrefix(data []byte) bool {
+	const riff, webp = "RIFF", "WEBP"
+	for i, b := range data {
+		switch {
+		case i < 4 && b != riff[i]:
+			return false
+		case i >= 8 && b != webp[i-8]:
+			return false
+		}
+	}
+	return true
+}
+
 // parseSingleImage parses a non-extended WebP file (simple VP8 or VP8L).
 func (p *Parser) parseSingleImage(buf []byte) error {
 	fourcc, payloadSize, err := ReadChunkHeader(buf)
 	if err != nil {
 		return err
 	}
-	padded64 := uint64(payloadSize) + uint64(payloadSize&1)
-	if uint64(ChunkHeaderSize)+padded64 > uint64(len(buf)) {
+	if _, ok := p.chunkSize(buf, payloadSize); !ok {
 		return ErrTruncated
 	}
 
@@ -111,7 +193,7 @@ func (p *Parser) parseSingleImage(buf []byte) error {
 
 	// Extract dimensions from the bitstream header.
 	if fourcc == FourCCVP8L {
-		w, h, alpha, err := parseVP8LHeader(payload)
+		w, h, alpha, err := ParseVP8LHeader(payload)
 		if err != nil {
 			return err
 		}
@@ -120,7 +202,7 @@ func (p *Parser) parseSingleImage(buf []byte) error {
 		frame.HasAlpha = alpha
 		p.features.HasAlpha = alpha
 	} else {
-		w, h, err := parseVP8Header(payload)
+		w, h, err := ParseVP8Header(payload)
 		if err != nil {
 			return err
 		}
@@ -193,18 +275,23 @@ func (p *Parser) parseVP8X(buf []byte) error {
 func (p *Parser) parseVP8XChunks(buf []byte) error {
 	isAnim := p.features.HasAnim
 	animChunks := 0
+	haveStill := false
 
 	for len(buf) >= ChunkHeaderSize {
 		fourcc, payloadSize, err := ReadChunkHeader(buf)
 		if err != nil {
+			if haveStill || p.lenient && len(p.frames) > 0 {
+				return nil // ignore damaged trailing metadata
+			}
 			return err
 		}
-		padded64 := uint64(payloadSize) + uint64(payloadSize&1)
-		chunkTotal64 := uint64(ChunkHeaderSize) + padded64
-		if chunkTotal64 > uint64(len(buf)) {
+		chunkTotal, ok := p.chunkSize(buf, payloadSize)
+		if !ok {
+			if haveStill || p.lenient && len(p.frames) > 0 {
+				return nil // ignore damaged trailing metadata
+			}
 			return ErrTruncated
 		}
-		chunkTotal := int(chunkTotal64)
 
 		payload := buf[ChunkHeaderSize : ChunkHeaderSize+int(payloadSize)]
 
@@ -228,25 +315,32 @@ func (p *Parser) parseVP8XChunks(buf []byte) error {
 			if len(p.frames) >= MaxFrames {
 				return fmt.Errorf("%w: too many animation frames (max %d)", ErrInvalidChunk, MaxFrames)
 			}
-			frame, err := parseANMF(payload)
+			if p.maxFrames > 0 && len(p.frames) >= p.maxFrames {
+				return fmt.Errorf("%w: exceeded limit of %d", ErrTooManyFrames, p.maxFrames)
+			}
+			frame, err := parseANMF(payload, p.lenient)
 			if err != nil {
 				return err
 			}
 			p.frames = append(p.frames, frame)
 
-		case FourCCVP8, FourCCVP8L:
-			// In extended format, image data outside ANMF is only valid for stills.
+		case FourCCVP8, FourCCVP8L, FourCCALPH:
+			// In extended format, image data outside ANMF is only valid for
+			// stills (ALPH precedes VP8). Metadata chunks such as EXIF and
+			// XMP may follow the image, so keep scanning after it.
 			if animChunks > 0 || isAnim {
 				return ErrInvalidChunk
 			}
-			return p.parseExtSingleImage(buf)
-
-		case FourCCALPH:
-			// Alpha before VP8 in extended format, only valid for stills.
-			if animChunks > 0 || isAnim {
-				return ErrInvalidChunk
+			if haveStill {
+				return nil // stray image data after the still image
 			}
-			return p.parseExtSingleImage(buf)
+			n, err := p.parseExtSingleImage(buf)
+			if err != nil {
+				return err
+			}
+			haveStill = true
+			buf = buf[n:]
+			continue
 
 		case FourCCICCP:
 			if p.features.HasICCP {
@@ -273,6 +367,9 @@ func (p *Parser) parseVP8XChunks(buf []byte) error {
 			}
 
 		default:
+			if p.lenient && (len(p.chunks) >= MaxChunks || payloadSize > MaxMetadataSize) {
+				break // skip it
+			}
 			if len(p.chunks) >= MaxChunks {
 				return fmt.Errorf("%w: too many chunks (max %d)", ErrInvalidChunk, MaxChunks)
 			}
@@ -289,22 +386,22 @@ func (p *Parser) parseVP8XChunks(buf []byte) error {
 }
 
 // parseExtSingleImage parses a single image from an extended format file.
-// buf starts at the ALPH or VP8/VP8L chunk.
-func (p *Parser) parseExtSingleImage(buf []byte) error {
+// buf starts at the ALPH or VP8/VP8L chunk. It returns the number of bytes
+// consumed by the image chunks.
+func (p *Parser) parseExtSingleImage(buf []byte) (int, error) {
 	var frame FrameInfo
 	var alphPayload []byte
+	consumed := 0
 
 	for len(buf) >= ChunkHeaderSize {
 		fourcc, payloadSize, err := ReadChunkHeader(buf)
 		if err != nil {
-			return err
+			return 0, err
 		}
-		padded64 := uint64(payloadSize) + uint64(payloadSize&1)
-		chunkTotal64 := uint64(ChunkHeaderSize) + padded64
-		if chunkTotal64 > uint64(len(buf)) {
-			return ErrTruncated
+		chunkTotal, ok := p.chunkSize(buf, payloadSize)
+		if !ok {
+			return 0, ErrTruncated
 		}
-		chunkTotal := int(chunkTotal64)
 
 		payload := buf[ChunkHeaderSize : ChunkHeaderSize+int(payloadSize)]
 
@@ -314,15 +411,16 @@ func (p *Parser) parseExtSingleImage(buf []byte) error {
 			frame.HasAlpha = true
 			p.features.HasAlpha = true
 			buf = buf[chunkTotal:]
+			consumed += chunkTotal
 			continue
 
 		case FourCCVP8L:
 			if alphPayload != nil {
-				return ErrInvalidChunk // VP8L has its own alpha, no separate ALPH
+				return 0, ErrInvalidChunk // VP8L has its own alpha, no separate ALPH
 			}
-			w, h, alpha, err := parseVP8LHeader(payload)
+			w, h, alpha, err := ParseVP8LHeader(payload)
 			if err != nil {
-				return err
+				return 0, err
 			}
 			frame.Width = w
 			frame.Height = h
@@ -335,12 +433,12 @@ func (p *Parser) parseExtSingleImage(buf []byte) error {
 			p.features.Width = w
 			p.features.Height = h
 			p.frames = append(p.frames, frame)
-			return nil
+			return consumed + chunkTotal, nil
 
 		case FourCCVP8:
-			w, h, err := parseVP8Header(payload)
+			w, h, err := ParseVP8Header(payload)
 			if err != nil {
-				return err
+				return 0, err
 			}
 			frame.Width = w
 			frame.Height = h
@@ -350,20 +448,27 @@ func (p *Parser) parseExtSingleImage(buf []byte) error {
 			p.features.Width = w
 			p.features.Height = h
 			p.frames = append(p.frames, frame)
-			return nil
+			return consumed + chunkTotal, nil
 
 		default:
+			if p.lenient {
+				// Skip an unknown chunk between ALPH and VP8.
+				buf = buf[chunkTotal:]
+				consumed += chunkTotal
+				continue
+			}
 			// Not an image chunk, stop.
 			break
 		}
 		break
 	}
 
-	return ErrInvalidChunk
+	return 0, ErrInvalidChunk
 }
 
-// parseANMF parses an ANMF chunk payload into a FrameInfo.
-func parseANMF(payload []byte) (FrameInfo, error) {
+// parseANMF parses an ANMF chunk payload into a FrameInfo. In lenient mode
+// unknown sub-chunks are skipped.
+func parseANMF(payload []byte, lenient bool) (FrameInfo, error) {
 	if len(payload) < ANMFChunkSize {
 		return FrameInfo{}, ErrInvalidChunk
 	}
@@ -395,11 +500,11 @@ func parseANMF(payload []byte) (FrameInfo, error) {
 
 	// Parse sub-chunks within the ANMF payload (after the 16-byte header).
 	subBuf := payload[ANMFChunkSize:]
-	return parseFrameSubChunks(frame, subBuf)
+	return parseFrameSubChunks(frame, subBuf, lenient)
 }
 
 // parseFrameSubChunks parses the image data within an ANMF frame.
-func parseFrameSubChunks(frame FrameInfo, buf []byte) (FrameInfo, error) {
+func parseFrameSubChunks(frame FrameInfo, buf []byte, lenient bool) (FrameInfo, error) {
 	var alphPayload []byte
 
 	for len(buf) >= ChunkHeaderSize {
@@ -427,7 +532,7 @@ func parseFrameSubChunks(frame FrameInfo, buf []byte) (FrameInfo, error) {
 			if alphPayload != nil {
 				return FrameInfo{}, ErrInvalidChunk
 			}
-			_, _, alpha, err := parseVP8LHeader(payload)
+			_, _, alpha, err := ParseVP8LHeader(payload)
 			if err != nil {
 				return FrameInfo{}, err
 			}
@@ -445,6 +550,10 @@ func parseFrameSubChunks(frame FrameInfo, buf []byte) (FrameInfo, error) {
 			return frame, nil
 
 		default:
+			if lenient {
+				buf = buf[chunkTotal:]
+				continue
+			}
 			break
 		}
 		break
@@ -458,9 +567,9 @@ func parseFrameSubChunks(frame FrameInfo, buf []byte) (FrameInfo, error) {
 	return frame, nil
 }
 
-// parseVP8Header extracts width and height from a VP8 lossy bitstream header.
+// ParseVP8Header extracts width and height from a VP8 lossy bitstream header.
 // Minimal parsing: 10-byte frame header containing the VP8 signature.
-func parseVP8Header(data []byte) (width, height int, err error) {
+func ParseVP8Header(data []byte) (width, height int, err error) {
 	if len(data) < VP8FrameHeaderSize {
 		return 0, 0, ErrTruncated
 	}
@@ -488,9 +597,9 @@ func parseVP8Header(data []byte) (width, height int, err error) {
 	return width, height, nil
 }
 
-// parseVP8LHeader extracts width, height, and alpha presence from a VP8L
+// ParseVP8LHeader extracts width, height, and alpha presence from a VP8L
 // lossless bitstream header.
-func parseVP8LHeader(data []byte) (width, height int, hasAlpha bool, err error) {
+func ParseVP8LHeader(data []byte) (width, height int, hasAlpha bool, err error) {
 	if len(data) < VP8LFrameHeaderSize {
 		return 0, 0, false, ErrTruncated
 	}
@@ -507,7 +616,7 @@ func parseVP8LHeader(data []byte) (width, height int, hasAlpha bool, err error)
 	hasAlpha = (bits>>28)&1 != 0         // 1 bit
 	version := (bits >> 29) & 0x7        // 3 bits
 	if version != VP8LVersion {
-		return 0, 0, false, fmt.Errorf("webp: unsupported VP8L version: %d", version)
+		return 0, 0, false, fmt.Errorf("%w %d", ErrUnsupportedVP8LVersion, version)
 	}
 	if width == 0 || height == 0 {
 		return 0, 0, false, ErrInvalidImage
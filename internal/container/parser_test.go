@@ -112,7 +112,7 @@ func TestParseVP8Header(t *testing.T) {
 	// Height: 240 (14 bits LE)
 	binary.LittleEndian.PutUint16(data[8:10], 240)
 
-	w, h, err := parseVP8Header(data)
+	w, h, err := ParseVP8Header(data)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -131,7 +131,7 @@ func TestParseVP8LHeader(t *testing.T) {
 	bits := uint32(99) | (uint32(199) << 14) | (1 << 28) | (0 << 29)
 	binary.LittleEndian.PutUint32(data[1:5], bits)
 
-	w, h, alpha, err := parseVP8LHeader(data)
+	w, h, alpha, err := ParseVP8LHeader(data)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -307,6 +307,104 @@ func TestParserVP8X_Still(t *testing.T) {
 	}
 }
 
+func TestParserVP8X_StillTrailingMetadata(t *testing.T) {
+	// EXIF and XMP follow the image data in the canonical chunk order.
+	vp8x := make([]byte, VP8XChunkSize)
+	vp8x[0] = byte(EXIFFlag | XMPFlag)
+	vp8x[4] = 15 // canvas 16x16
+	vp8x[7] = 15
+
+	vp8Hdr := make([]byte, 10)
+	vp8Hdr[0] = 0x10
+	vp8Hdr[3] = 0x9d
+	vp8Hdr[4] = 0x01
+	vp8Hdr[5] = 0x2a
+	binary.LittleEndian.PutUint16(vp8Hdr[6:8], 16)
+	binary.LittleEndian.PutUint16(vp8Hdr[8:10], 16)
+
+	payload := concat(
+		makeChunk(FourCCVP8X, vp8x),
+		makeChunk(FourCCVP8, vp8Hdr),
+		makeChunk(FourCCEXIF, []byte("exif")),
+		makeChunk(FourCCXMP, []byte("<xmp/>")),
+	)
+	p, err := NewParser(wrapRIFF(payload))
+	if err != nil {
+		t.Fatalf("NewParser: %v", err)
+	}
+	if len(p.Frames()) != 1 {
+		t.Fatalf("got %d frames, want 1", len(p.Frames()))
+	}
+	chunks := p.Chunks()
+	if len(chunks) != 2 || chunks[0].FourCC != FourCCEXIF || chunks[1].FourCC != FourCCXMP {
+		t.Fatalf("metadata chunks = %v, want EXIF then XMP", chunks)
+	}
+	if string(chunks[1].Payload) != "<xmp/>" {
+		t.Errorf("XMP payload = %q", chunks[1].Payload)
+	}
+
+	// A truncated trailing chunk must not prevent decoding the image.
+	data := wrapRIFF(payload)
+	if _, err := NewParser(data[:len(data)-3]); err != nil {
+		t.Errorf("truncated trailing metadata: %v", err)
+	}
+}
+
+func TestLenientParser(t *testing.T) {
+	vp8 := make([]byte, 11) // odd size: the chunk has a padding byte
+	vp8[0] = 0x10
+	vp8[3], vp8[4], vp8[5] = 0x9d, 0x01, 0x2a
+	binary.LittleEndian.PutUint16(vp8[6:8], 16)
+	binary.LittleEndian.PutUint16(vp8[8:10], 16)
+	vp8x := make([]byte, VP8XChunkSize)
+	vp8x[0] = byte(AlphaFlag)
+	vp8x[4], vp8x[7] = 15, 15
+	simple := wrapRIFF(makeChunk(FourCCVP8, vp8))
+
+	shortSize := concat(simple)
+	binary.LittleEndian.PutUint32(shortSize[4:8], 12)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"riff_size_too_small", shortSize},
+		{"missing_padding", simple[:len(simple)-1]},
+		{"unknown_first_chunk", wrapRIFF(concat(makeChunk(0x4b4e554a, []byte("junk")), makeChunk(FourCCVP8, vp8)))},
+		{"unknown_chunk_before_vp8", wrapRIFF(concat(
+			makeChunk(FourCCVP8X, vp8x),
+			makeChunk(FourCCALPH, []byte{0}),
+			makeChunk(0x4b4e554a, []byte("junk")),
+			makeChunk(FourCCVP8, vp8),
+		))},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewParser(tc.data); err == nil {
+				t.Error("NewParser accepted the file, want an error")
+			}
+			p, err := NewLenientParser(tc.data)
+			if err != nil {
+				t.Fatalf("NewLenientParser: %v", err)
+			}
+			if len(p.Frames()) != 1 || len(p.Frames()[0].Payload) != len(vp8) {
+				t.Fatalf("frames = %d, want 1 with the VP8 payload", len(p.Frames()))
+			}
+		})
+	}
+
+	// Trailing garbage is ignored in both modes, both after the RIFF data
+	// and, for lenient mode, when the RIFF size is too small to exclude it.
+	garbage := append(concat(simple), "garbage bytes!"...)
+	if _, err := NewParser(garbage); err != nil {
+		t.Errorf("NewParser with trailing garbage: %v", err)
+	}
+	binary.LittleEndian.PutUint32(garbage[4:8], 12)
+	if _, err := NewLenientParser(garbage); err != nil {
+		t.Errorf("NewLenientParser with trailing garbage: %v", err)
+	}
+}
+
 func TestReadLE24(t *testing.T) {
 	b := []byte{0x56, 0x34, 0x12}
 	got := readLE24(b)
@@ -33,17 +33,28 @@ const (
 	BlendNone  AnimBlend = 1
 )
 
-// Common errors.
+// Common errors. ErrInvalidRIFF and ErrInvalidWebP wrap ErrNotWebP, and
+// ErrTruncated wraps io.ErrUnexpectedEOF, so callers can tell data that is
+// not a WebP file from a file that was cut short.
 var (
-	ErrInvalidRIFF    = errors.New("webp: invalid RIFF header")
-	ErrInvalidWebP    = errors.New("webp: invalid WEBP signature")
-	ErrTruncated      = errors.New("webp: truncated data")
+	ErrNotWebP        = errors.New("webp: not a WebP file")
+	ErrInvalidRIFF    = fmt.Errorf("%w: invalid RIFF header", ErrNotWebP)
+	ErrInvalidWebP    = fmt.Errorf("%w: invalid WEBP signature", ErrNotWebP)
+	ErrTruncated      = fmt.Errorf("webp: truncated data: %w", io.ErrUnexpectedEOF)
 	ErrInvalidChunk   = errors.New("webp: invalid chunk")
 	ErrTooLarge       = errors.New("webp: file too large")
 	ErrInvalidVP8X    = errors.New("webp: invalid VP8X chunk")
 	ErrInvalidFlags   = errors.New("webp: invalid feature flags")
 	ErrUnsupported    = errors.New("webp: unsupported format")
 	ErrInvalidImage   = errors.New("webp: invalid image dimensions")
+
+	// ErrTooManyFrames is returned by NewParserMaxFrames for a file with
+	// more animation frames than its limit.
+	ErrTooManyFrames = errors.New("webp: too many animation frames")
+
+	// ErrUnsupportedVP8LVersion is returned for a VP8L header whose version
+	// is not 0, the only one defined.
+	ErrUnsupportedVP8LVersion = errors.New("webp: unsupported VP8L version")
 )
 
 // Features describes the high-level properties of a WebP file, extracted from
@@ -0,0 +1,143 @@
+package container
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// SeekWriter streams a RIFF/WEBP container to a seekable writer. Chunk
+// headers are written with a placeholder size, the payload is written as it
+// comes, and the size fields are patched by seeking back once a chunk (and,
+// in Close, the whole file) is complete. Nothing is buffered, so the peak
+// memory of writing a file is independent of its size.
+//
+// Errors are sticky: after the first failure every call is a no-op and
+// Close returns the error.
+type SeekWriter struct {
+	w     io.WriteSeeker
+	start int64 // offset of the RIFF header
+	off   int64 // bytes written since start
+	chunk int64 // offset of the open chunk's header from start, or -1
+	err   error
+}
+
+// NewSeekWriter writes a RIFF/WEBP header with a placeholder size to w and
+// returns a writer for the chunks that follow. It returns nil, without
+// writing anything, when w does not implement io.Seeker or cannot seek (an
+// *os.File for a pipe, for instance); the caller should then assemble the
+// file in memory.
+func NewSeekWriter(w io.Writer) *SeekWriter {
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	start, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	sw := &SeekWriter{w: ws, start: start, chunk: -1}
+	var hdr [RIFFHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], FourCCRIFF)
+	binary.LittleEndian.PutUint32(hdr[8:12], FourCCWEBP)
+	sw.write(hdr[:])
+	return sw
+}
+
+// BeginChunk starts a chunk; its payload is written with Write.
+func (sw *SeekWriter) BeginChunk(fourcc uint32) {
+	if sw.err != nil {
+		return
+	}
+	if sw.chunk >= 0 {
+		sw.err = errors.New("webp: chunk already open")
+		return
+	}
+	sw.chunk = sw.off
+	var hdr [ChunkHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], fourcc)
+	sw.write(hdr[:])
+}
+
+// Write appends p to the payload of the open chunk.
+func (sw *SeekWriter) Write(p []byte) (int, error) {
+	if sw.err == nil && sw.chunk < 0 {
+		sw.err = errors.New("webp: no chunk open")
+	}
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	sw.write(p)
+	return len(p), sw.err
+}
+
+// EndChunk patches the size of the open chunk and pads it to an even
+// length.
+func (sw *SeekWriter) EndChunk() {
+	if sw.err != nil {
+		return
+	}
+	size := sw.off - sw.chunk - ChunkHeaderSize
+	if size > math.MaxUint32-1 {
+		sw.err = errors.New("webp: chunk too large")
+		return
+	}
+	sw.patch(sw.chunk+4, uint32(size))
+	sw.chunk = -1
+	if size&1 != 0 {
+		sw.write([]byte{0})
+	}
+}
+
+// WriteChunk writes a complete chunk.
+func (sw *SeekWriter) WriteChunk(fourcc uint32, payload []byte) {
+	sw.BeginChunk(fourcc)
+	if sw.err == nil {
+		sw.write(payload)
+	}
+	sw.EndChunk()
+}
+
+// Close patches the RIFF size and leaves w positioned after the file.
+func (sw *SeekWriter) Close() error {
+	if sw.err == nil && sw.chunk >= 0 {
+		sw.err = errors.New("webp: chunk not ended")
+	}
+	if sw.err != nil {
+		return sw.err
+	}
+	if sw.off-ChunkHeaderSize > math.MaxUint32 {
+		return errors.New("webp: RIFF payload too large")
+	}
+	sw.patch(4, uint32(sw.off-ChunkHeaderSize))
+	return sw.err
+}
+
+func (sw *SeekWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	n, err := sw.w.Write(p)
+	sw.off += int64(n)
+	sw.err = err
+}
+
+// patch overwrites the 32-bit size field at offset off from the start of
+// the file and returns to the end.
+func (sw *SeekWriter) patch(off int64, v uint32) {
+	if sw.err != nil {
+		return
+	}
+	if _, err := sw.w.Seek(sw.start+off, io.SeekStart); err != nil {
+		sw.err = err
+		return
+	}
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	if _, err := sw.w.Write(b[:]); err != nil {
+		sw.err = err
+		return
+	}
+	_, sw.err = sw.w.Seek(sw.start+sw.off, io.SeekStart)
+}
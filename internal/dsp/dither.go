@@ -0,0 +1,22 @@
+package dsp
+
+// Decoder-side dithering constants, matching libwebp dsp.h.
+const (
+	// DitherAmpBits is the number of bits of the random dither values.
+	DitherAmpBits        = 7
+	ditherAmpCenter      = 1 << DitherAmpBits
+	ditherDescale        = 4
+	ditherDescaleRounder = 1 << (ditherDescale - 1)
+)
+
+// DitherCombine8x8 adds the 8x8 block of centered dither values to dst.
+// Matches C VP8DitherCombine8x8.
+func DitherCombine8x8(dither *[64]uint8, dst []byte, stride int) {
+	for j := 0; j < 8; j++ {
+		row := dst[j*stride : j*stride+8]
+		for i := range row {
+			delta := (int(dither[8*j+i]) - ditherAmpCenter + ditherDescaleRounder) >> ditherDescale
+			row[i] = Clip8b(int(row[i]) + delta)
+		}
+	}
+}
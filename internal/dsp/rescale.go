@@ -87,7 +87,9 @@ func RescalerInit(r *Rescaler, srcWidth, srcHeight, dstWidth, dstHeight int) {
 	if !r.XExpand && r.XSub > 0 {
 		r.FXScale = rescalerFrac(1, r.XSub)
 	}
-	if r.YExpand && r.YSub > 0 {
+	if r.YSub > 0 {
+		// For shrinking, FYScale splits the source row that straddles two
+		// destination rows in rescalerExportRowShrink.
 		r.FYScale = rescalerFrac(1, r.YSub)
 	}
 	if !r.YExpand && r.XAdd > 0 && r.YAdd > 0 {
@@ -0,0 +1,43 @@
+package dsp
+
+import (
+	"math"
+	"testing"
+)
+
+// TestRescalerShrink checks a downscale of a ramp against the exact area
+// average, in particular for destination rows that split a source row.
+func TestRescalerShrink(t *testing.T) {
+	const srcW, srcH, dstW, dstH = 10, 97, 4, 26
+	var r Rescaler
+	RescalerInit(&r, srcW, srcH, dstW, dstH)
+	src := make([]byte, srcW)
+	dst := make([]byte, dstW)
+	y := 0
+	for sy := 0; sy < srcH; sy++ {
+		for x := range src {
+			src[x] = uint8(2 * sy)
+		}
+		RescalerImportRow(&r, src)
+		for RescalerHasDstRow(&r) {
+			RescalerExportRow(&r, dst)
+			// Exact mean of 2*sy over [y0, y1).
+			y0, y1 := float64(y)*srcH/dstH, float64(y+1)*srcH/dstH
+			var sum float64
+			for s := 0; s < srcH; s++ {
+				if lo, hi := math.Max(y0, float64(s)), math.Min(y1, float64(s+1)); hi > lo {
+					sum += (hi - lo) * float64(2*s)
+				}
+			}
+			for x, v := range dst {
+				if want := sum / (y1 - y0); math.Abs(float64(v)-want) > 1 {
+					t.Fatalf("row %d, column %d = %d, want %.1f", y, x, v, want)
+				}
+			}
+			y++
+		}
+	}
+	if y != dstH {
+		t.Errorf("exported %d rows, want %d", y, dstH)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/deepteams/webp/internal/bitio"
+	"github.com/deepteams/webp/internal/container"
 )
 
 // losslessDecoderPool caches Decoder structs between decode calls so that the
@@ -20,42 +21,55 @@ var losslessDecoderPool sync.Pool
 func acquireDecoder() *Decoder {
 	if v := losslessDecoderPool.Get(); v != nil {
 		dec := v.(*Decoder)
-		dec.br = nil
-		dec.Width = 0
-		dec.Height = 0
-		dec.HasAlpha = false
-		dec.transformWidth = 0
-		dec.nextTransform = 0
-		dec.transformsSeen = 0
-		dec.hdr = metadata{}
-		dec.recursionDepth = 0
-		// Keep: pixels, codeLengthsBuf, huffScratch (for reuse)
+		dec.reset()
 		return dec
 	}
 	return &Decoder{}
 }
 
-// releaseDecoder returns a Decoder to the pool for reuse.
-func releaseDecoder(dec *Decoder) {
-	if dec == nil {
-		return
-	}
-	// Nil external references to avoid holding onto input data.
+// reset zeroes the mutable state of dec before a decode.
+func (dec *Decoder) reset() {
+	dec.br = nil
+	dec.Width = 0
+	dec.Height = 0
+	dec.HasAlpha = false
+	dec.transformWidth = 0
+	dec.nextTransform = 0
+	dec.transformsSeen = 0
+	dec.hdr = metadata{}
+	dec.recursionDepth = 0
+	// Keep: pixels, codeLengthsBuf, huffScratch (for reuse)
+}
+
+// releaseRefs drops the references of dec to the input data.
+func (dec *Decoder) releaseRefs() {
 	dec.br = nil
 	dec.argbCache = nil
 	dec.hdr.htreeGroups = nil
 	dec.hdr.huffmanImage = nil
 	dec.hdr.colorCache = nil
 	// Keep pixels, transformBuf, and huffScratch for reuse.
+}
+
+// releaseDecoder returns a Decoder to the pool for reuse.
+func releaseDecoder(dec *Decoder) {
+	if dec == nil {
+		return
+	}
+	dec.releaseRefs()
 	losslessDecoderPool.Put(dec)
 }
 
 // VP8L decoder errors.
 var (
 	ErrBadSignature  = errors.New("lossless: bad VP8L signature")
-	ErrBadVersion    = errors.New("lossless: bad VP8L version")
+	ErrBadVersion    = fmt.Errorf("lossless: bad VP8L version: %w", container.ErrUnsupportedVP8LVersion)
 	ErrBitstream     = errors.New("lossless: bitstream error")
 	ErrTooManyGroups = errors.New("lossless: too many Huffman groups")
+
+	// ErrRepeatedTransform reports a transform list that uses a transform
+	// type twice, which the format forbids: the bitstream is corrupt.
+	ErrRepeatedTransform = fmt.Errorf("lossless: repeated transform: %w", ErrBitstream)
 )
 
 // Decoder decodes a VP8L lossless bitstream into an ARGB pixel buffer.
@@ -115,9 +129,36 @@ type metadata struct {
 // DecodeVP8L decodes a VP8L bitstream (the payload after the VP8L fourcc and
 // chunk size) and returns an NRGBA image.
 func DecodeVP8L(data []byte) (*image.NRGBA, error) {
+	return decodeVP8L(data, false)
+}
+
+// DecodeVP8LLowMemory is like DecodeVP8L but applies the inverse transforms
+// a few rows at a time, writing each batch straight into the output image.
+// It avoids the full-size intermediate ARGB buffer DecodeVP8L uses, at a
+// small cost in speed. The decoded image is identical.
+func DecodeVP8LLowMemory(data []byte) (*image.NRGBA, error) {
+	return decodeVP8L(data, true)
+}
+
+func decodeVP8L(data []byte, lowMemory bool) (*image.NRGBA, error) {
 	dec := acquireDecoder()
 	defer releaseDecoder(dec)
+	return dec.decode(data, lowMemory, nil)
+}
+
+// DecodeInto decodes a VP8L bitstream like DecodeVP8L, but with dec
+// instead of a pooled decoder, so that its buffers are reused from one
+// call to the next, and into dst, whose Pix is reused if it is large
+// enough. dst is resized to the image, at the origin.
+func (dec *Decoder) DecodeInto(data []byte, dst *image.NRGBA) error {
+	dec.reset()
+	defer dec.releaseRefs()
+	_, err := dec.decode(data, false, dst)
+	return err
+}
 
+// decode decodes data with dec into dst, or into a new image if dst is nil.
+func (dec *Decoder) decode(data []byte, lowMemory bool, dst *image.NRGBA) (*image.NRGBA, error) {
 	if err := dec.decodeHeader(data); err != nil {
 		return nil, err
 	}
@@ -170,6 +211,15 @@ func DecodeVP8L(data []byte) (*image.NRGBA, error) {
 	}
 	dec.argbCache = dec.pixels[numAlloc+dec.Width:]
 
+	// Decode the entropy-coded image data using the transform width.
+	if err := dec.decodeImageData(dec.pixels[:numPixTrans], tw, dec.Height, dec.Height); err != nil {
+		return nil, err
+	}
+
+	if lowMemory {
+		return dec.inverseTransformRowsToNRGBA(dec.pixels[:numPixTrans], tw), nil
+	}
+
 	// Reuse transform output buffer if large enough.
 	if cap(dec.transformBuf) >= numAlloc {
 		dec.transformBuf = dec.transformBuf[:numAlloc]
@@ -177,16 +227,64 @@ func DecodeVP8L(data []byte) (*image.NRGBA, error) {
 		dec.transformBuf = make([]uint32, numAlloc)
 	}
 
-	// Decode the entropy-coded image data using the transform width.
-	if err := dec.decodeImageData(dec.pixels[:numPixTrans], tw, dec.Height, dec.Height); err != nil {
-		return nil, err
-	}
-
 	// Apply inverse transforms. The transforms know the original width
 	// and will expand packed pixels back to the full image dimensions.
 	out := dec.applyInverseTransforms(dec.pixels[:numPixOrig])
 
-	return argbToNRGBA(out, dec.Width, dec.Height), nil
+	if dst == nil {
+		dst = &image.NRGBA{}
+	}
+	argbToNRGBAInto(out, dec.Width, dec.Height, dst)
+	return dst, nil
+}
+
+// StreamInfo describes a VP8L bitstream as declared by its header and
+// transform list.
+type StreamInfo struct {
+	Width, Height int
+	HasAlpha      bool
+	// Transforms lists the transforms in bitstream order.
+	Transforms []TransformType
+	// PaletteSize is the number of colors of the color indexing
+	// transform, or 0 if there is none.
+	PaletteSize int
+	// ColorCacheBits is the main image's color cache size in bits, or 0
+	// if it has no color cache.
+	ColorCacheBits int
+}
+
+// ReadStreamInfo parses the header and transforms of a VP8L bitstream
+// without decoding the main image. Transform sub-images are decoded, since
+// the bitstream offers no way to skip them.
+func ReadStreamInfo(data []byte) (*StreamInfo, error) {
+	dec := acquireDecoder()
+	defer releaseDecoder(dec)
+
+	if err := dec.decodeHeader(data); err != nil {
+		return nil, err
+	}
+	dec.huffScratch.slabOff = 0
+
+	info := &StreamInfo{Width: dec.Width, Height: dec.Height, HasAlpha: dec.HasAlpha}
+	xsize := dec.Width
+	for dec.br.ReadBits(1) == 1 {
+		var err error
+		if xsize, err = dec.readTransform(xsize, dec.Height); err != nil {
+			return nil, err
+		}
+		t := &dec.transforms[dec.nextTransform-1]
+		info.Transforms = append(info.Transforms, t.Type)
+		if t.Type == ColorIndexingTransform {
+			info.PaletteSize = t.NumColors
+		}
+	}
+	if dec.br.ReadBits(1) == 1 {
+		info.ColorCacheBits = int(dec.br.ReadBits(4))
+	}
+	if dec.br.IsEndOfStream() {
+		return nil, ErrBitstream
+	}
+	return info, nil
 }
 
 // decodeHeader reads the VP8L header: signature, width, height, alpha, version.
@@ -338,7 +436,19 @@ const numArgbCacheRows = 16
 // green 15..8, blue 7..0).
 // For large images, the conversion is parallelized across rows.
 func argbToNRGBA(pixels []uint32, width, height int) *image.NRGBA {
-	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	img := &image.NRGBA{}
+	argbToNRGBAInto(pixels, width, height, img)
+	return img
+}
+
+// argbToNRGBAInto is argbToNRGBA writing into img, whose Pix is reused if
+// it is large enough.
+func argbToNRGBAInto(pixels []uint32, width, height int, img *image.NRGBA) {
+	n := 4 * width * height
+	if cap(img.Pix) < n {
+		img.Pix = make([]uint8, n)
+	}
+	img.Pix, img.Stride, img.Rect = img.Pix[:n], 4*width, image.Rect(0, 0, width, height)
 	pix := img.Pix
 	stride := img.Stride
 
@@ -362,7 +472,6 @@ func argbToNRGBA(pixels []uint32, width, height int) *image.NRGBA {
 	} else {
 		argbToNRGBARows(pixels, pix, stride, width, 0, height)
 	}
-	return img
 }
 
 // argbToNRGBARows converts a range of rows from ARGB to NRGBA byte layout.
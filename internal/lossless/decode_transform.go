@@ -7,6 +7,8 @@ package lossless
 // and libwebp/src/dsp/lossless.c (VP8LInverseTransform).
 
 import (
+	"fmt"
+	"image"
 	"runtime"
 	"sync"
 
@@ -25,7 +27,7 @@ func (dec *Decoder) readTransform(xsize, ysize int) (int, error) {
 
 	// Each transform type can only appear once.
 	if dec.transformsSeen&(1<<transformType) != 0 {
-		return 0, ErrBitstream
+		return 0, fmt.Errorf("%w: type %d", ErrRepeatedTransform, transformType)
 	}
 	dec.transformsSeen |= 1 << transformType
 
@@ -34,6 +36,7 @@ func (dec *Decoder) readTransform(xsize, ysize int) (int, error) {
 	t.XSize = xsize
 	t.YSize = ysize
 	t.Data = nil
+	t.NumColors = 0
 	dec.nextTransform++
 
 	switch transformType {
@@ -61,6 +64,7 @@ func (dec *Decoder) readTransform(xsize, ysize int) (int, error) {
 			bits = 3
 		}
 		t.Bits = bits
+		t.NumColors = numColors
 
 		palette, err := dec.decodeSubImage(numColors, 1)
 		if err != nil {
@@ -152,7 +156,53 @@ func (dec *Decoder) applyInverseTransforms(pixels []uint32) []uint32 {
 	return out[:numPix]
 }
 
-// inverseTransform applies a single inverse transform to the pixel data.
+// inverseTransformRowsToNRGBA applies all transforms in reverse order to
+// batches of numArgbCacheRows rows and converts each finished batch into the
+// returned image, so no full-size intermediate buffer is needed. pixels
+// holds the decoded image at the transformed width tw.
+func (dec *Decoder) inverseTransformRowsToNRGBA(pixels []uint32, tw int) *image.NRGBA {
+	width, height := dec.Width, dec.Height
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if dec.nextTransform == 0 {
+		argbToNRGBARows(pixels, img.Pix, img.Stride, width, 0, height)
+		return img
+	}
+
+	// Two ping-pong batch buffers, each with a leading row that holds the
+	// predictor's previous output row. No transform is wider than the image.
+	batchSize := (numArgbCacheRows + 1) * width
+	scratch := make([]uint32, 2*batchSize)
+	prevRow := make([]uint32, width)
+
+	for ys := 0; ys < height; ys += numArgbCacheRows {
+		ye := min(ys+numArgbCacheRows, height)
+		rows := pixels[ys*tw : ye*tw]
+		buf := 0
+		for n := dec.nextTransform - 1; n >= 0; n-- {
+			t := &dec.transforms[n]
+			w := t.XSize
+			b := scratch[buf*batchSize : (buf+1)*batchSize]
+			out := b[w:]
+			if t.Type == PredictorTransform && ys > 0 {
+				copy(b[:w], prevRow[:w])
+				predictorInverseTransform(t, ys, ye, rows, b)
+			} else {
+				inverseTransform(t, ys, ye, rows, out)
+			}
+			if t.Type == PredictorTransform {
+				copy(prevRow[:w], out[(ye-ys-1)*w:(ye-ys)*w])
+			}
+			rows = out
+			buf ^= 1
+		}
+		argbToNRGBARows(rows, img.Pix[ys*img.Stride:], img.Stride, width, 0, ye-ys)
+	}
+	return img
+}
+
+// inverseTransform applies a single inverse transform to rows
+// [rowStart, rowEnd). in and out start at row rowStart; see
+// predictorInverseTransform for the extra row it needs when rowStart > 0.
 // Row-independent transforms (SubtractGreen, CrossColor) are parallelized
 // for large images.
 func inverseTransform(t *Transform, rowStart, rowEnd int, in, out []uint32) {
@@ -199,10 +249,17 @@ func addGreenToBlueAndRed(src []uint32, numPixels int, dst []uint32) {
 // The prediction mode switch is moved outside the inner loop so each tile
 // uses a specialized loop without per-pixel branch overhead.
 // Row slices are pre-computed for BCE elimination.
+//
+// in starts at row yStart. When yStart is 0, so does out; otherwise out
+// starts one row earlier, with the previously reconstructed row that the
+// first row is predicted from.
 func predictorInverseTransform(t *Transform, yStart, yEnd int, in, out []uint32) {
 	width := t.XSize
 	inOff := 0
 	outOff := 0
+	if yStart > 0 {
+		outOff = width
+	}
 
 	if yStart == 0 {
 		// First row: pixel 0 uses predictor 0 (black + residual = residual).
@@ -473,8 +530,8 @@ func colorSpaceInverseTransform(t *Transform, yStart, yEnd int, src, dst []uint3
 		return
 	}
 
-	srcOff := yStart * width
-	dstOff := yStart * width
+	srcOff := 0
+	dstOff := 0
 
 	for y := yStart; y < yEnd; y++ {
 		predRow := (y >> t.Bits) * tilesPerRow
@@ -555,10 +612,11 @@ func colorSpaceInverseTransformParallel(t *Transform, yStart, yEnd int, src, dst
 		if w == numWorkers-1 {
 			ye = yEnd
 		}
-		go func(ys, ye int) {
+		off := (ys - yStart) * t.XSize
+		go func(ys, ye int, src, dst []uint32) {
 			colorSpaceInverseTransform(t, ys, ye, src, dst)
 			wg.Done()
-		}(ys, ye)
+		}(ys, ye, src[off:], dst[off:])
 	}
 	wg.Wait()
 }
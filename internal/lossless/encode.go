@@ -29,6 +29,7 @@ func acquireEncoder(width, height int, config *EncoderConfig) *Encoder {
 	enc.usePalette = false
 	enc.paletteSize = 0
 	enc.palette = nil
+	enc.indices = nil
 	enc.predictorBits = 0
 	enc.crossColorBits = 0
 	enc.histogramBits = 0
@@ -43,6 +44,7 @@ func acquireEncoder(width, height int, config *EncoderConfig) *Encoder {
 func releaseEncoder(enc *Encoder) {
 	// Clear references to image data so it can be GC'd.
 	enc.argb = nil
+	enc.indices = nil
 	enc.argbOrig = nil
 	enc.config = nil
 	enc.palette = nil
@@ -67,6 +69,43 @@ type EncoderConfig struct {
 	Method int
 	// NearLosslessQuality is the near-lossless quality (100 = true lossless).
 	NearLosslessQuality int
+	// Transforms restricts the transforms the encoder may use to those whose
+	// bit (1 << TransformType) is set. Zero allows all transforms.
+	Transforms uint8
+	// ColorCache controls whether the color cache is used.
+	ColorCache ColorCacheMode
+	// StatsFunc, if set, receives the choices made for the image once its
+	// bitstream is encoded.
+	StatsFunc func(Stats)
+}
+
+// Stats describes the transforms and color cache the encoder chose.
+type Stats struct {
+	Predictor     bool
+	CrossColor    bool
+	SubtractGreen bool
+	ColorIndexing bool
+	PaletteSize   int // 0 without ColorIndexing
+	CacheBits     int // 0 without a color cache
+}
+
+// ColorCacheMode selects how the encoder uses the color cache.
+type ColorCacheMode int
+
+const (
+	// ColorCacheAuto searches a quality-dependent range of cache sizes,
+	// including none.
+	ColorCacheAuto ColorCacheMode = iota
+	// ColorCacheOff never uses a color cache and skips the search.
+	ColorCacheOff
+	// ColorCacheOn always uses a color cache, of the size in 1..10 bits
+	// with the lowest estimated cost.
+	ColorCacheOn
+)
+
+// allows reports whether the configuration permits transform t.
+func (c *EncoderConfig) allows(t TransformType) bool {
+	return c.Transforms == 0 || c.Transforms&(1<<t) != 0
 }
 
 // DefaultEncoderConfig returns a default encoder configuration.
@@ -96,6 +135,7 @@ type Encoder struct {
 	usePalette  bool
 	paletteSize int
 	palette     []uint32
+	indices     []uint8 // caller-supplied palette indices (EncodeIndexed)
 
 	// Transform parameters.
 	predictorBits   int
@@ -197,6 +237,40 @@ func Encode(argb []uint32, width, height int, config *EncoderConfig) ([]byte, er
 	return out, nil
 }
 
+// EncodeIndexed encodes an image given as palette indices (one byte per
+// pixel, row-major) as a VP8L bitstream using the color indexing transform
+// with the given palette, without scanning ARGB pixels to rebuild it.
+// Indices are bit-packed for palettes of up to 16 colors. The palette must
+// hold 1 to 256 distinct colors, sorted in ascending order, and every index
+// must be below len(palette).
+func EncodeIndexed(indices []uint8, palette []uint32, width, height int, config *EncoderConfig) ([]byte, error) {
+	if width <= 0 || height <= 0 || width > 16383 || height > 16383 {
+		return nil, ErrImageTooLarge
+	}
+	if len(palette) == 0 || len(palette) > MaxPaletteSize || len(indices) != width*height {
+		return nil, ErrEncoding
+	}
+	if config == nil {
+		config = DefaultEncoderConfig()
+	}
+
+	enc := acquireEncoder(width, height, config)
+	defer releaseEncoder(enc)
+	enc.indices = indices
+	enc.palette = palette
+
+	enc.analyze()
+	enc.applyTransforms()
+
+	bs, err := enc.encodeStream()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(bs))
+	copy(out, bs)
+	return out, nil
+}
+
 // EncodeToWriter encodes ARGB pixel data as a VP8L bitstream and writes it
 // directly to w, avoiding intermediate copies. The writeHeader callback is
 // invoked with the bitstream size before the bitstream is written, allowing
@@ -259,11 +333,16 @@ func (enc *Encoder) analyze() {
 	height := enc.height
 
 	// Try palette mode.
-	palette, paletteSize, ok := ColorIndexBuild(enc.argb, width, height)
-	if ok && paletteSize <= MaxPaletteSize {
+	if enc.indices != nil {
 		enc.usePalette = true
-		enc.paletteSize = paletteSize
-		enc.palette = palette
+		enc.paletteSize = len(enc.palette)
+	} else if enc.config.allows(ColorIndexingTransform) {
+		palette, paletteSize, ok := ColorIndexBuild(enc.argb, width, height)
+		if ok && paletteSize <= MaxPaletteSize {
+			enc.usePalette = true
+			enc.paletteSize = paletteSize
+			enc.palette = palette
+		}
 	}
 
 	// Determine transform parameters based on quality/method.
@@ -279,6 +358,9 @@ func (enc *Encoder) analyze() {
 		// kPaletteAndSpatial: combine palette + predictor transform.
 		enc.usePredict = true
 	}
+	enc.useSubtractGreen = enc.useSubtractGreen && enc.config.allows(SubtractGreenTransform)
+	enc.usePredict = enc.usePredict && enc.config.allows(PredictorTransform)
+	enc.useCrossColor = enc.useCrossColor && enc.config.allows(CrossColorTransform)
 
 	// Empirical bit sizes matching the C reference EncoderAnalyze:
 	// 1. Compute histogram bits from method and image size.
@@ -290,7 +372,14 @@ func (enc *Encoder) analyze() {
 
 	// Color cache bits: this sets the maximum search range for
 	// CalculateBestCacheSize which brute-force picks the optimal value.
-	enc.cacheBits = cacheBitsForEncoder(quality, enc.usePalette, enc.paletteSize)
+	switch enc.config.ColorCache {
+	case ColorCacheOff:
+		enc.cacheBits = 0
+	case ColorCacheOn:
+		enc.cacheBits = maxColorCacheBitsEnc
+	default:
+		enc.cacheBits = cacheBitsForEncoder(quality, enc.usePalette, enc.paletteSize)
+	}
 }
 
 // clampBits clamps bits to [minBits, maxBits], increases bits if the
@@ -438,16 +527,23 @@ func (enc *Encoder) applyPaletteTransform() {
 		return sortedPalette[i] < sortedPalette[j]
 	})
 
-	packed, packedWidth := ApplyPaletteTransform(enc.argb, enc.width, enc.height, sortedPalette)
+	var packed []uint32
+	var packedWidth int
+	if enc.indices != nil {
+		packed, packedWidth = PackPaletteIndices(enc.indices, enc.width, enc.height, enc.paletteSize)
+	} else {
+		packed, packedWidth = ApplyPaletteTransform(enc.argb, enc.width, enc.height, sortedPalette)
+	}
 	enc.argb = packed
 	enc.currentWidth = packedWidth
 
 	enc.transforms = append(enc.transforms, Transform{
-		Type:  ColorIndexingTransform,
-		Bits:  paletteCodeBits(enc.paletteSize),
-		XSize: enc.currentWidth,
-		YSize: enc.height,
-		Data:  sortedPalette,
+		Type:      ColorIndexingTransform,
+		Bits:      paletteCodeBits(enc.paletteSize),
+		XSize:     enc.currentWidth,
+		YSize:     enc.height,
+		Data:      sortedPalette,
+		NumColors: enc.paletteSize,
 	})
 
 	// kPaletteAndSpatial: apply predictor transform on the palette-indexed
@@ -562,8 +658,12 @@ func (enc *Encoder) encodeStream() ([]byte, error) {
 	enc.brScratch.Candidate = enc.candidateRefs
 	enc.brScratch.Trace = enc.traceRefs
 	enc.brScratch.DistArray = enc.traceDistArray
-	cacheBits := GetBackwardReferencesWithScratch(currentWidth, height, enc.argb,
-		quality, lz77Types, enc.cacheBits, hc, refs, &enc.brScratch)
+	minCacheBits := 0
+	if enc.config.ColorCache == ColorCacheOn {
+		minCacheBits = 1
+	}
+	cacheBits := getBackwardReferences(currentWidth, height, enc.argb,
+		quality, lz77Types, minCacheBits, enc.cacheBits, hc, refs, &enc.brScratch)
 
 	// Build histograms and get symbols.
 	symbols, histoSet := GetHistoImageSymbols(
@@ -656,9 +756,32 @@ func (enc *Encoder) encodeStream() ([]byte, error) {
 
 	result := bw.Finish()
 	enc.writerBuf = bw.Buf()
+	if fn := enc.config.StatsFunc; fn != nil {
+		fn(enc.stats(cacheBits))
+	}
 	return result, nil
 }
 
+// stats returns the Stats of the image once enc.transforms are applied and
+// cacheBits is chosen.
+func (enc *Encoder) stats(cacheBits int) Stats {
+	s := Stats{CacheBits: cacheBits}
+	for _, t := range enc.transforms {
+		switch t.Type {
+		case PredictorTransform:
+			s.Predictor = true
+		case CrossColorTransform:
+			s.CrossColor = true
+		case SubtractGreenTransform:
+			s.SubtractGreen = true
+		case ColorIndexingTransform:
+			s.ColorIndexing = true
+			s.PaletteSize = t.NumColors
+		}
+	}
+	return s
+}
+
 // writeTransformData writes transform-specific data to the bitstream.
 func (enc *Encoder) writeTransformData(bw *bitio.LosslessWriter, t *Transform) {
 	switch t.Type {
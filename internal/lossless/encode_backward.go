@@ -407,6 +407,12 @@ func CalculateBestCacheSize(argb []uint32, quality int, refs *BackwardRefs, cach
 	if quality <= 25 {
 		return 0
 	}
+	return calculateBestCacheSize(argb, refs, 0, cacheBitsMax, scratch)
+}
+
+// calculateBestCacheSize is CalculateBestCacheSize restricted to cache sizes
+// of at least minCacheBits, regardless of quality.
+func calculateBestCacheSize(argb []uint32, refs *BackwardRefs, minCacheBits, cacheBitsMax int, scratch *BackwardRefsScratch) int {
 	if cacheBitsMax <= 0 {
 		return 0
 	}
@@ -423,6 +429,9 @@ func CalculateBestCacheSize(argb []uint32, quality int, refs *BackwardRefs, cach
 	var histoSlab []Histogram
 	if scratch != nil && cap(scratch.CacheSizeHistoSlab) >= numHistos {
 		histoSlab = scratch.CacheSizeHistoSlab[:numHistos]
+		// The fixed-size symbol counts still hold the previous encode's
+		// values; Literal is cleared with its slab below.
+		clear(histoSlab)
 	} else {
 		histoSlab = make([]Histogram, numHistos)
 		if scratch != nil {
@@ -572,11 +581,12 @@ func CalculateBestCacheSize(argb []uint32, quality int, refs *BackwardRefs, cach
 	}
 
 	// Find the cache size with the lowest entropy estimate.
-	bestCacheBits := 0
+	minCacheBits = min(minCacheBits, cacheBitsMax)
+	bestCacheBits := minCacheBits
 	bestCost := uint64(math.MaxUint64)
-	for i := 0; i <= cacheBitsMax; i++ {
+	for i := minCacheBits; i <= cacheBitsMax; i++ {
 		cost := histogramEstimateBitsUint64(histos[i])
-		if i == 0 || cost < bestCost {
+		if i == minCacheBits || cost < bestCost {
 			bestCost = cost
 			bestCacheBits = i
 		}
@@ -695,6 +705,24 @@ func GetBackwardReferencesWithScratch(
 	hc *HashChain,
 	best *BackwardRefs,
 	scratch *BackwardRefsScratch,
+) int {
+	return getBackwardReferences(width, height, argb, quality, lz77Types,
+		0, cacheBitsMax, hc, best, scratch)
+}
+
+// getBackwardReferences is GetBackwardReferencesWithScratch with a lower
+// bound on the color cache size. A positive minCacheBits forces a color
+// cache: the full minCacheBits..cacheBitsMax range is searched whatever the
+// quality.
+func getBackwardReferences(
+	width, height int,
+	argb []uint32,
+	quality int,
+	lz77Types int,
+	minCacheBits, cacheBitsMax int,
+	hc *HashChain,
+	best *BackwardRefs,
+	scratch *BackwardRefsScratch,
 ) int {
 	bestCost := uint64(math.MaxUint64)
 	bestLz77Type := 0
@@ -756,7 +784,9 @@ func GetBackwardReferencesWithScratch(
 	// costs ~50ms. For photographic images, the optimal cache is nearly always
 	// close to cacheBitsMax, so using it directly is a safe speed/quality tradeoff.
 	var bestCacheBits int
-	if quality <= 75 && cacheBitsMax > 0 {
+	if minCacheBits > 0 {
+		bestCacheBits = calculateBestCacheSize(argb, best, minCacheBits, cacheBitsMax, scratch)
+	} else if quality <= 75 && cacheBitsMax > 0 {
 		bestCacheBits = cacheBitsMax
 	} else {
 		bestCacheBits = CalculateBestCacheSize(argb, quality, best, cacheBitsMax, scratch)
@@ -838,19 +838,7 @@ func ApplyPaletteTransform(argb []uint32, width, height int, palette []uint32) (
 
 	paletteSize := len(palette)
 
-	// Determine packing parameters.
-	var bitsPerPixel int
-	switch {
-	case paletteSize <= 2:
-		bitsPerPixel = 1
-	case paletteSize <= 4:
-		bitsPerPixel = 2
-	case paletteSize <= 16:
-		bitsPerPixel = 4
-	default:
-		bitsPerPixel = 8
-	}
-
+	bitsPerPixel := paletteBitsPerPixel(paletteSize)
 	pixelsPerWord := 8 / bitsPerPixel
 	packedWidth = (width + pixelsPerWord - 1) / pixelsPerWord
 
@@ -884,3 +872,41 @@ func ApplyPaletteTransform(argb []uint32, width, height int, palette []uint32) (
 
 	return packed, packedWidth
 }
+
+// PackPaletteIndices is ApplyPaletteTransform for pixels that are already
+// palette indices, packed with the same rules for a palette of paletteSize
+// colors.
+func PackPaletteIndices(indices []uint8, width, height, paletteSize int) (packed []uint32, packedWidth int) {
+	bitsPerPixel := paletteBitsPerPixel(paletteSize)
+	pixelsPerWord := 8 / bitsPerPixel
+	packedWidth = (width + pixelsPerWord - 1) / pixelsPerWord
+	packed = make([]uint32, packedWidth*height)
+
+	for y := 0; y < height; y++ {
+		src := indices[y*width : (y+1)*width]
+		dst := packed[y*packedWidth : (y+1)*packedWidth]
+		for x, idx := range src {
+			wordPos := x / pixelsPerWord
+			bitPos := uint((x % pixelsPerWord) * bitsPerPixel)
+			if bitPos == 0 {
+				dst[wordPos] = ARGBBlack
+			}
+			dst[wordPos] |= uint32(idx) << (8 + bitPos)
+		}
+	}
+	return packed, packedWidth
+}
+
+// paletteBitsPerPixel returns the packed index width for a palette size.
+func paletteBitsPerPixel(paletteSize int) int {
+	switch {
+	case paletteSize <= 2:
+		return 1
+	case paletteSize <= 4:
+		return 2
+	case paletteSize <= 16:
+		return 4
+	default:
+		return 8
+	}
+}
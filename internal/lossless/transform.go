@@ -20,4 +20,6 @@ type Transform struct {
 	XSize int       // transform window width
 	YSize int       // transform window height
 	Data  []uint32  // transform data (predictor modes, color transform, palette, etc.)
+
+	NumColors int // palette size for the color indexing transform
 }
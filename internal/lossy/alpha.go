@@ -292,8 +292,11 @@ func getFilterMap(alpha []byte, width, height, filter, effortLevel int) uint32 {
 		return bitMap
 	case filter == AlphaFilterModeNone || filter == AlphaFilterNone:
 		return filterTryNone
+	case filter >= AlphaFilterHorizontal && filter <= AlphaFilterGradient:
+		// Explicit predictor.
+		return 1 << uint(filter)
 	default:
-		// Best mode or explicit: try all.
+		// Best mode: try all.
 		return filterTryAll
 	}
 }
@@ -19,29 +19,35 @@ var lossyDecoderPool sync.Pool
 func acquireDecoder() *Decoder {
 	if v := lossyDecoderPool.Get(); v != nil {
 		dec := v.(*Decoder)
-		// Zero mutable state — keep slice backing arrays for reuse.
-		dec.frmHdr = FrameHeader{}
-		dec.picHdr = PictureHeader{}
-		dec.filterHdr = FilterHeader{}
-		dec.segHdr = SegmentHeader{}
-		dec.mbW = 0
-		dec.mbH = 0
-		dec.mbX = 0
-		dec.mbY = 0
-		dec.br = nil
-		for i := range dec.parts {
-			dec.parts[i] = nil
-		}
-		dec.numPartsMinusOne = 0
-		dec.useSkipProba = false
-		dec.skipP = 0
-		dec.filterType = 0
-		dec.AlphaData = nil
+		dec.reset()
 		return dec
 	}
 	return &Decoder{}
 }
 
+// reset zeroes the mutable state of dec before a decode.
+func (dec *Decoder) reset() {
+	// Zero mutable state — keep slice backing arrays for reuse.
+	dec.frmHdr = FrameHeader{}
+	dec.picHdr = PictureHeader{}
+	dec.filterHdr = FilterHeader{}
+	dec.segHdr = SegmentHeader{}
+	dec.mbW = 0
+	dec.mbH = 0
+	dec.mbX = 0
+	dec.mbY = 0
+	dec.br = nil
+	for i := range dec.parts {
+		dec.parts[i] = nil
+	}
+	dec.numPartsMinusOne = 0
+	dec.useSkipProba = false
+	dec.skipP = 0
+	dec.filterType = 0
+	dec.dither = false
+	dec.AlphaData = nil
+}
+
 // ReleaseDecoder returns a Decoder to the pool for reuse.
 // The caller must not reference any slices from the decoder after this call.
 func ReleaseDecoder(dec *Decoder) {
@@ -176,6 +182,10 @@ type Decoder struct {
 	filterType int // 0=off, 1=simple, 2=complex
 	fstrengths [NumMBSegments][2]FInfo
 
+	// Dithering.
+	dither   bool // some segment has a non-zero dithering amplitude
+	ditherRG dsp.VP8Random
+
 	// Boundary data.
 	intraT []uint8      // top intra modes (4 * mbW)
 	intraL [4]uint8     // left intra modes
@@ -207,28 +217,74 @@ type Decoder struct {
 // planes (Y, U, V) plus their strides. The caller must call
 // ReleaseDecoder(dec) after consuming the YUV planes.
 func DecodeFrame(data []byte) (dec *Decoder, width, height int, y []byte, yStride int, u, v []byte, uvStride int, err error) {
-	dec = acquireDecoder()
+	return DecodeFrameWithOptions(data, nil)
+}
 
-	if err = dec.parseHeaders(data); err != nil {
+// DecodeOptions holds the optional post-processing settings of
+// DecodeFrameWithOptions. The zero value decodes conformantly.
+type DecodeOptions struct {
+	// NoFilter skips the in-loop deblocking filter (libwebp's
+	// bypass_filtering). The result is the raw reconstruction, which
+	// differs from a conformant decode wherever the filter would have
+	// smoothed block edges.
+	NoFilter bool
+
+	// DitheringStrength adds random noise of up to this strength (0-100)
+	// to the chroma of smooth macroblocks, as libwebp's dithering_strength
+	// does, to hide banding.
+	DitheringStrength int
+}
+
+// DecodeFrameWithOptions is like DecodeFrame with the post-processing
+// settings in opts. A nil opts is the same as DecodeFrame.
+func DecodeFrameWithOptions(data []byte, opts *DecodeOptions) (dec *Decoder, width, height int, y []byte, yStride int, u, v []byte, uvStride int, err error) {
+	dec = acquireDecoder()
+	width, height, y, yStride, u, v, uvStride, err = dec.decode(data, opts)
+	if err != nil {
 		ReleaseDecoder(dec)
 		dec = nil
+	}
+	return
+}
+
+// DecodeFrame decodes data like DecodeFrameWithOptions, but with dec
+// instead of a pooled decoder, so that its buffers are reused from one
+// call to the next. The planes are valid until the next call.
+func (dec *Decoder) DecodeFrame(data []byte, opts *DecodeOptions) (width, height int, y []byte, yStride int, u, v []byte, uvStride int, err error) {
+	dec.reset()
+	width, height, y, yStride, u, v, uvStride, err = dec.decode(data, opts)
+	// Drop the references to data.
+	dec.br = nil
+	for i := range dec.parts {
+		dec.parts[i] = nil
+	}
+	return
+}
+
+// decode decodes the VP8 frame in data with dec.
+func (dec *Decoder) decode(data []byte, opts *DecodeOptions) (width, height int, y []byte, yStride int, u, v []byte, uvStride int, err error) {
+	if err = dec.parseHeaders(data); err != nil {
 		return
 	}
+	var ditherStrength int
+	if opts != nil {
+		if opts.NoFilter {
+			dec.filterType = 0
+		}
+		ditherStrength = opts.DitheringStrength
+	}
+	dec.initDithering(ditherStrength)
 
 	width = dec.picHdr.Width
 	height = dec.picHdr.Height
 
 	if err = dec.initFrame(); err != nil {
-		ReleaseDecoder(dec)
-		dec = nil
 		return
 	}
 
 	dec.precomputeFilterStrengths()
 
 	if err = dec.parseFrame(); err != nil {
-		ReleaseDecoder(dec)
-		dec = nil
 		return
 	}
 
@@ -240,6 +296,63 @@ func DecodeFrame(data []byte) (dec *Decoder, width, height int, y []byte, yStrid
 	return
 }
 
+// Header holds the VP8 frame header fields that precede the coefficient
+// data.
+type Header struct {
+	Frame         FrameHeader
+	Picture       PictureHeader
+	Segment       SegmentHeader
+	Filter        FilterHeader
+	NumPartitions int // DCT token partitions: 1, 2, 4 or 8
+}
+
+// ParseHeader parses the headers of the VP8 frame in data without decoding
+// any macroblocks.
+func ParseHeader(data []byte) (*Header, error) {
+	dec := acquireDecoder()
+	defer ReleaseDecoder(dec)
+	if err := dec.parseHeaders(data); err != nil {
+		return nil, err
+	}
+	return &Header{
+		Frame:         dec.frmHdr,
+		Picture:       dec.picHdr,
+		Segment:       dec.segHdr,
+		Filter:        dec.filterHdr,
+		NumPartitions: int(dec.numPartsMinusOne) + 1,
+	}, nil
+}
+
+// CountIntraModes parses the macroblock modes of the VP8 frame in data,
+// which are all in its first partition, and returns how many macroblocks
+// use 16x16 and 4x4 luma prediction. No coefficients are decoded.
+func CountIntraModes(data []byte) (i16, i4 int, err error) {
+	dec := acquireDecoder()
+	defer ReleaseDecoder(dec)
+	if err := dec.parseHeaders(data); err != nil {
+		return 0, 0, err
+	}
+	if err := dec.initFrame(); err != nil {
+		return 0, 0, err
+	}
+	for dec.mbY = 0; dec.mbY < dec.mbH; dec.mbY++ {
+		if err := dec.parseIntraModeRow(); err != nil {
+			return 0, 0, err
+		}
+		for i := range dec.mbData[:dec.mbW] {
+			if dec.mbData[i].IsI4x4 {
+				i4++
+			} else {
+				i16++
+			}
+		}
+		for i := range dec.intraL {
+			dec.intraL[i] = BDCPred
+		}
+	}
+	return i16, i4, nil
+}
+
 // parseHeaders reads the VP8 frame and picture headers, segment/filter info,
 // partitions, quantizers, and probability tables.
 func (dec *Decoder) parseHeaders(data []byte) error {
@@ -555,6 +668,9 @@ func (dec *Decoder) parseFrame() error {
 		if dec.filterType > 0 {
 			dec.filterRowAt(dec.mbY)
 		}
+		if dec.dither {
+			dec.ditherRow(dec.mbY)
+		}
 	}
 	return nil
 }
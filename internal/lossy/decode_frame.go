@@ -216,6 +216,60 @@ func (dec *Decoder) reconstructRow() {
 	}
 }
 
+// minDitherAmp is the smallest macroblock dithering amplitude applied.
+const minDitherAmp = 4
+
+// kQuantToDitherAmp maps the chroma AC quantizer index to a dithering
+// amplitude (roughly the chroma AC step). Coarser quantizers get none.
+var kQuantToDitherAmp = [12]uint8{8, 7, 6, 4, 4, 2, 2, 2, 1, 1, 1, 1}
+
+// initDithering sets the per-segment dithering amplitudes for a strength
+// in [0, 100]. Corresponds to VP8InitDithering.
+func (dec *Decoder) initDithering(strength int) {
+	const maxAmp = 1<<8 - 1
+	f := 0
+	switch {
+	case strength > 100:
+		f = maxAmp
+	case strength > 0:
+		f = strength * maxAmp / 100
+	}
+	allAmp := 0
+	for s := range dec.dqm {
+		dqm := &dec.dqm[s]
+		dqm.Dither = 0
+		if f > 0 && dqm.UVQuant < len(kQuantToDitherAmp) {
+			dqm.Dither = f * int(kQuantToDitherAmp[max(dqm.UVQuant, 0)]) >> 3
+		}
+		allAmp |= dqm.Dither
+	}
+	dec.dither = allAmp != 0
+	if dec.dither {
+		dsp.InitRandom(&dec.ditherRG, 1.0)
+	}
+}
+
+// ditherRow adds random noise to the chroma of the macroblocks of row mbY
+// that have no chroma AC coefficients. It runs after the row is filtered.
+// Corresponds to DitherRow in libwebp's frame_dec.c.
+func (dec *Decoder) ditherRow(mbY int) {
+	var dither [64]uint8
+	uvStride := dec.cacheUVStride
+	for mbX := dec.tlMBX; mbX < dec.brMBX; mbX++ {
+		amp := int(dec.mbData[mbX].Dither)
+		if amp < minDitherAmp {
+			continue
+		}
+		off := mbY*8*uvStride + mbX*8
+		for _, plane := range [2][]byte{dec.cacheU, dec.cacheV} {
+			for i := range dither {
+				dither[i] = uint8(dsp.RandomBits2(&dec.ditherRG, dsp.DitherAmpBits+1, amp))
+			}
+			dsp.DitherCombine8x8(&dither, plane[off:], uvStride)
+		}
+	}
+}
+
 // precomputeFilterStrengths computes per-segment, per-mode filter levels.
 func (dec *Decoder) precomputeFilterStrengths() {
 	if dec.filterType <= 0 {
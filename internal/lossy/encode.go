@@ -59,9 +59,41 @@ type EncodeConfig struct {
 	Dithering       float32 // Dithering amplitude [0..1] for RGB->YUV conversion.
 	QMin            int     // 0-100, minimum quantizer value. Matches C libwebp's qmin.
 	QMax            int     // 0-100, maximum quantizer value. Matches C libwebp's qmax. -1 = use default (100).
+	QuantIndex      int     // 1-127, base VP8 quantizer index; 0 = derive from Quality.
 	HasAlpha        int     // -1 = unknown (will scan), 0 = no alpha, 1 = has alpha. Avoids redundant imageHasAlpha scans.
+	ROI             []uint8 // Per-macroblock importance (mbW*mbH, row-major), 128 = neutral; nil = none.
+	SingleThreaded  bool    // Run import, analysis and encoding on the calling goroutine only.
+	NumThreads      int     // Max goroutines for the parallel stages; <= 0 = GOMAXPROCS.
+	IntraMode       int     // IntraModeAuto, IntraModeI16Only or IntraModeI4Only.
+
+	// SegmentFilterStrengths overrides the loop filter level, 0-63, of each
+	// segment as assigned by the analysis pass; -1 keeps the level computed
+	// from FilterStrength and the segment's quantizer and complexity.
+	SegmentFilterStrengths [NumMBSegments]int
+
+	// SegmentMapFunc, if set, receives a copy of the macroblock segment map
+	// after the analysis pass; numSegments is the count left by
+	// simplifySegments.
+	SegmentMapFunc func(mbW, mbH, numSegments int, segments []uint8)
+
+	// Analysis, if set and made for an image of the same macroblock size,
+	// supplies the macroblock complexities of the analysis pass instead of
+	// computing them from the source planes.
+	Analysis *Analysis
+
+	// Grayscale sets both chroma planes to neutral (128) before encoding,
+	// whatever the source, so that only luma is stored.
+	Grayscale bool
 }
 
+// Luma prediction classes the encoder may choose from, for
+// EncodeConfig.IntraMode.
+const (
+	IntraModeAuto    = iota // I16 or I4, whichever scores better per macroblock
+	IntraModeI16Only        // 16x16 prediction only
+	IntraModeI4Only         // 4x4 prediction only, at every Method
+)
+
 // DefaultConfig returns sensible encoding defaults (quality 75, method 4).
 func DefaultConfig(quality int) EncodeConfig {
 	if quality < 0 {
@@ -82,6 +114,8 @@ func DefaultConfig(quality int) EncodeConfig {
 		Pass:            1,
 		QMin:            0,
 		QMax:            100,
+
+		SegmentFilterStrengths: [NumMBSegments]int{-1, -1, -1, -1},
 	}
 }
 
@@ -538,6 +572,155 @@ func NewEncoderFromYUV(yuv *image.YCbCr, width, height int, cfg EncodeConfig) *V
 	return enc
 }
 
+// NewEncoderFromTiles creates a VP8 encoder from img like NewEncoder, but
+// converts img to YUV one tile of tileMB x tileMB macroblocks at a time
+// through a single tile-sized NRGBA buffer, so that no full-size copy of
+// the image is made (NewEncoder narrows 16-bit images to a new NRGBA).
+// If prepare is not nil, it is called on each tile, whose bounds start at
+// (0, 0), before the tile is converted, e.g. to clean up transparent
+// areas. The tiles are converted serially and without dithering.
+func NewEncoderFromTiles(img image.Image, tileMB int, cfg EncodeConfig, prepare func(tile *image.NRGBA)) *VP8Encoder {
+	bounds := img.Bounds()
+	w := bounds.Dx()
+	h := bounds.Dy()
+	mbW := (w + 15) >> 4
+	mbH := (h + 15) >> 4
+
+	// Try to reuse a pooled encoder with matching dimensions.
+	if v := encoderPool.Get(); v != nil {
+		enc := v.(*VP8Encoder)
+		if enc.mbW == mbW && enc.mbH == mbH {
+			enc.resetForReuse(cfg, w, h)
+			enc.importTiles(img, tileMB, prepare)
+			enc.initSegments()
+			enc.initEncoderParams()
+			ResetProba(&enc.proba)
+			enc.tokens.Reset()
+			return enc
+		}
+	}
+
+	enc := &VP8Encoder{
+		config: cfg,
+		width:  w,
+		height: h,
+		mbW:    mbW,
+		mbH:    mbH,
+	}
+
+	enc.numParts = 1 << uint(cfg.Partitions)
+	if enc.numParts > MaxNumPartitions {
+		enc.numParts = MaxNumPartitions
+	}
+
+	enc.allocateBuffers()
+	enc.importTiles(img, tileMB, prepare)
+	enc.initSegments()
+	enc.initEncoderParams()
+	ResetProba(&enc.proba)
+	enc.tokens.Init(enc.mbW * enc.mbH)
+
+	return enc
+}
+
+// importTiles fills the encoder's Y/U/V planes from img one tile at a time;
+// see NewEncoderFromTiles. Tiles are macroblock-aligned, so the 2x2 chroma
+// averaging never crosses a tile edge and the planes are the same as
+// importImage's (without dithering) when prepare is nil.
+func (enc *VP8Encoder) importTiles(img image.Image, tileMB int, prepare func(*image.NRGBA)) {
+	dsp.InitGammaTables()
+	padW := enc.mbW * 16
+	padH := enc.mbH * 16
+	size := tileMB * 16
+	tile := image.NewNRGBA(image.Rect(0, 0, min(size, padW), min(size, padH)))
+	for ty := 0; ty < padH; ty += size {
+		for tx := 0; tx < padW; tx += size {
+			// Edge tiles use the top-left part of the buffer.
+			origin := image.Pt(tx, ty)
+			tile.Rect = image.Rect(0, 0, min(size, padW-tx), min(size, padH-ty))
+			readTile(tile, img, origin)
+			if prepare != nil {
+				prepare(tile)
+			}
+			enc.importTile(tile, origin)
+		}
+	}
+}
+
+// readTile sets tile to the pixels of img at origin in the padded image,
+// converted as importImage converts them, replicating the last column and
+// row of img into the padding.
+func readTile(tile *image.NRGBA, img image.Image, origin image.Point) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := tile.Rect.Dx(), tile.Rect.Dy()
+	n := min(tw, w-origin.X) // columns inside img
+	x0 := b.Min.X + origin.X
+	for y := 0; y < th; y++ {
+		sy := b.Min.Y + min(origin.Y+y, h-1)
+		d := tile.Pix[y*tile.Stride : y*tile.Stride+tw*4]
+		switch src := img.(type) {
+		case *image.NRGBA:
+			copy(d, src.Pix[src.PixOffset(x0, sy):][:n*4])
+		case *image.RGBA:
+			s := src.Pix[src.PixOffset(x0, sy):]
+			for x := 0; x < n; x++ {
+				d[4*x], d[4*x+1], d[4*x+2] = unpremultiply(s[4*x], s[4*x+1], s[4*x+2], s[4*x+3])
+				d[4*x+3] = s[4*x+3]
+			}
+		case *image.NRGBA64, *image.RGBA64:
+			// Rounded like narrowTo8Bit.
+			for x := 0; x < n; x++ {
+				c := color.NRGBA64Model.Convert(img.At(x0+x, sy)).(color.NRGBA64)
+				d[4*x] = round16To8(uint32(c.R))
+				d[4*x+1] = round16To8(uint32(c.G))
+				d[4*x+2] = round16To8(uint32(c.B))
+				d[4*x+3] = round16To8(uint32(c.A))
+			}
+		default:
+			for x := 0; x < n; x++ {
+				c := color.NRGBAModel.Convert(img.At(x0+x, sy)).(color.NRGBA)
+				d[4*x], d[4*x+1], d[4*x+2], d[4*x+3] = c.R, c.G, c.B, c.A
+			}
+		}
+		for x := n; x < tw; x++ {
+			copy(d[4*x:4*x+4], d[4*(n-1):4*n])
+		}
+	}
+}
+
+// importTile converts tile, placed at origin in the padded image, into the
+// encoder's planes.
+func (enc *VP8Encoder) importTile(tile *image.NRGBA, origin image.Point) {
+	tw, th := tile.Rect.Dx(), tile.Rect.Dy()
+	for y := 0; y < th; y++ {
+		s := tile.Pix[y*tile.Stride:]
+		d := enc.yPlane[(origin.Y+y)*enc.yStride+origin.X:]
+		for x := 0; x < tw; x++ {
+			d[x] = dsp.RGBToY(int(s[4*x]), int(s[4*x+1]), int(s[4*x+2]))
+		}
+	}
+
+	// Chroma two rows at a time, as in importImage, reusing its serial
+	// buffers (sized for the full padded width).
+	planarR := enc.serialPlanarR[:tw*2]
+	planarG := enc.serialPlanarG[:tw*2]
+	planarB := enc.serialPlanarB[:tw*2]
+	planarA := enc.serialPlanarA[:tw*2]
+	for y := 0; y < th; y += 2 {
+		for row := 0; row < 2; row++ {
+			s := tile.Pix[(y+row)*tile.Stride:]
+			for x := 0; x < tw; x++ {
+				i := row*tw + x
+				planarR[i], planarG[i], planarB[i], planarA[i] = s[4*x], s[4*x+1], s[4*x+2], s[4*x+3]
+			}
+		}
+		dsp.AccumulateRGBA(planarR, planarG, planarB, planarA, tw, enc.serialTmpRGB, tw)
+		off := (origin.Y+y)/2*enc.uvStride + origin.X/2
+		dsp.ConvertRGBA32ToUV(enc.serialTmpRGB, enc.uPlane[off:], enc.vPlane[off:], tw/2)
+	}
+}
+
 // importYCbCr copies pre-computed YCbCr 4:2:0 planes into the encoder's
 // internal Y/U/V plane buffers, padding to macroblock boundaries by
 // replicating edge pixels.
@@ -669,6 +852,27 @@ func (enc *VP8Encoder) allocateBuffers() {
 // rounding values during RGB->YUV conversion, matching C libwebp's
 // ImportYUVAFromRGBA dithered path (picture_csp_enc.c:202-250).
 func (enc *VP8Encoder) importImage(img image.Image) {
+	// 16-bit images are narrowed to 8-bit NRGBA once, with rounding, so
+	// they take the direct pixel path below instead of per-pixel At calls.
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64:
+		img = narrowTo8Bit(img)
+	}
+
+	// Gray and Y'CbCr images are always opaque and already carry luma, so
+	// they are remapped to VP8's range plane by plane without going through
+	// RGB. Dithering is only implemented on the RGB paths.
+	if enc.config.Dithering <= 0 {
+		switch src := img.(type) {
+		case *image.Gray:
+			enc.importGray(src)
+			return
+		case *image.YCbCr:
+			enc.importJFIF(src)
+			return
+		}
+	}
+
 	bounds := img.Bounds()
 	w := bounds.Dx()
 	h := bounds.Dy()
@@ -700,10 +904,12 @@ func (enc *VP8Encoder) importImage(img image.Image) {
 
 	// Type-assert for direct pixel access (avoids interface boxing heap allocs).
 	// Both *image.NRGBA and *image.RGBA share the same Pix layout (R,G,B,A per pixel).
-	// For opaque images (the common case), premultiplied == non-premultiplied.
+	// For opaque images (the common case), premultiplied == non-premultiplied;
+	// otherwise *image.RGBA pixels are un-premultiplied as they are read.
 	nrgba, isNRGBA := img.(*image.NRGBA)
 	rgba, isRGBA := img.(*image.RGBA)
 	isDirect := isNRGBA || isRGBA
+	premultiplied := isRGBA && hasAlpha
 	var pix []uint8
 	var pixStride int
 	var pixRect image.Rectangle
@@ -735,6 +941,9 @@ func (enc *VP8Encoder) importImage(img image.Image) {
 				gBuf[x] = pix[off+1]
 				bBuf[x] = pix[off+2]
 				aBuf[x] = pix[off+3]
+				if premultiplied {
+					rBuf[x], gBuf[x], bBuf[x] = unpremultiply(rBuf[x], gBuf[x], bBuf[x], aBuf[x])
+				}
 			}
 		} else {
 			for x := 0; x < padW; x++ {
@@ -756,7 +965,7 @@ func (enc *VP8Encoder) importImage(img image.Image) {
 	// YUV_HALF, matching C ConvertRowToY with VP8RandomBits(rg, YUV_FIX).
 	if isDirect && rg == nil {
 		// Fast parallel path for non-dithered direct pixel access (NRGBA/RGBA).
-		nWorkers := runtime.GOMAXPROCS(0)
+		nWorkers := enc.workers()
 		if nWorkers > padH {
 			nWorkers = padH
 		}
@@ -777,7 +986,11 @@ func (enc *VP8Encoder) importImage(img image.Image) {
 					dstBase := y * enc.yStride
 					for x := 0; x < w; x++ {
 						off := rowOff + x*4
-						enc.yPlane[dstBase+x] = dsp.RGBToY(int(pix[off]), int(pix[off+1]), int(pix[off+2]))
+						r, g, b := pix[off], pix[off+1], pix[off+2]
+						if premultiplied {
+							r, g, b = unpremultiply(r, g, b, pix[off+3])
+						}
+						enc.yPlane[dstBase+x] = dsp.RGBToY(int(r), int(g), int(b))
 					}
 					// Edge replication for padding.
 					if padW > w {
@@ -803,8 +1016,11 @@ func (enc *VP8Encoder) importImage(img image.Image) {
 					sx = w - 1
 				}
 				off := rowOff + sx*4
-				ri, gi, bi := int(pix[off]), int(pix[off+1]), int(pix[off+2])
-				enc.yPlane[y*enc.yStride+x] = dsp.RGBToYRounding(ri, gi, bi, dsp.RandomBits(rg, dsp.YUVFix))
+				r, g, b := pix[off], pix[off+1], pix[off+2]
+				if premultiplied {
+					r, g, b = unpremultiply(r, g, b, pix[off+3])
+				}
+				enc.yPlane[y*enc.yStride+x] = dsp.RGBToYRounding(int(r), int(g), int(b), dsp.RandomBits(rg, dsp.YUVFix))
 			}
 		}
 	} else {
@@ -835,7 +1051,7 @@ func (enc *VP8Encoder) importImage(img image.Image) {
 
 	if isDirect && rg == nil {
 		// Fast parallel path for non-dithered direct pixel access (NRGBA/RGBA).
-		nUVWorkers := runtime.GOMAXPROCS(0)
+		nUVWorkers := enc.workers()
 		if nUVWorkers > halfPadH {
 			nUVWorkers = halfPadH
 		}
@@ -873,6 +1089,9 @@ func (enc *VP8Encoder) importImage(img image.Image) {
 							gBuf[x] = pix[off+1]
 							bBuf[x] = pix[off+2]
 							aBuf[x] = pix[off+3]
+							if premultiplied {
+								rBuf[x], gBuf[x], bBuf[x] = unpremultiply(rBuf[x], gBuf[x], bBuf[x], aBuf[x])
+							}
 						}
 						if padW > w {
 							for x := w; x < padW; x++ {
@@ -941,6 +1160,198 @@ func (enc *VP8Encoder) importImage(img image.Image) {
 	}
 }
 
+// grayToY maps a full-range gray (or JFIF luma) value to VP8's
+// limited-range Y, i.e. dsp.RGBToY(v, v, v).
+// jfifToC maps a full-range JFIF chroma value to VP8's limited range.
+var grayToY, jfifToC = func() (y, c [256]uint8) {
+	for v := 0; v < 256; v++ {
+		y[v] = dsp.RGBToY(v, v, v)
+		d := (v - 128) * 224
+		if d >= 0 {
+			c[v] = uint8(128 + (d+127)/255)
+		} else {
+			c[v] = uint8(128 - (-d+127)/255)
+		}
+	}
+	return y, c
+}()
+
+// importGray fills the encoder planes from a grayscale image: luma goes
+// through grayToY and both chroma planes are neutral (128), which is what
+// the RGB path produces for R == G == B.
+func (enc *VP8Encoder) importGray(img *image.Gray) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	padW := enc.mbW * 16
+	padH := enc.mbH * 16
+
+	for y := 0; y < h; y++ {
+		src := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		dst := enc.yPlane[y*enc.yStride : y*enc.yStride+padW]
+		for x := 0; x < w; x++ {
+			dst[x] = grayToY[src[x]]
+		}
+		for x := w; x < padW; x++ {
+			dst[x] = dst[w-1]
+		}
+	}
+	enc.padRowsY(h, padH, padW)
+	enc.neutralChroma()
+}
+
+// neutralChroma sets both chroma planes to 128.
+func (enc *VP8Encoder) neutralChroma() {
+	uvSize := enc.uvStride * (enc.mbH * 8)
+	for i := range enc.uPlane[:uvSize] {
+		enc.uPlane[i] = 128
+		enc.vPlane[i] = 128
+	}
+}
+
+// importJFIF fills the encoder planes from a full-range (JFIF) Y'CbCr image,
+// such as one produced by image/jpeg, converting each plane to VP8's limited
+// range. 4:2:0 chroma is copied sample for sample; other subsample ratios
+// are box-filtered down to 4:2:0 over each 2x2 luma block.
+func (enc *VP8Encoder) importJFIF(img *image.YCbCr) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	padW := enc.mbW * 16
+	padH := enc.mbH * 16
+
+	for y := 0; y < h; y++ {
+		src := img.Y[img.YOffset(b.Min.X, b.Min.Y+y):]
+		dst := enc.yPlane[y*enc.yStride : y*enc.yStride+padW]
+		for x := 0; x < w; x++ {
+			dst[x] = grayToY[src[x]]
+		}
+		for x := w; x < padW; x++ {
+			dst[x] = dst[w-1]
+		}
+	}
+	enc.padRowsY(h, padH, padW)
+
+	uvW := (w + 1) >> 1
+	uvH := (h + 1) >> 1
+	padUVW := padW >> 1
+	padUVH := padH >> 1
+	is420 := img.SubsampleRatio == image.YCbCrSubsampleRatio420 && b.Min.X&1 == 0 && b.Min.Y&1 == 0
+	for cy := 0; cy < uvH; cy++ {
+		du := enc.uPlane[cy*enc.uvStride : cy*enc.uvStride+padUVW]
+		dv := enc.vPlane[cy*enc.uvStride : cy*enc.uvStride+padUVW]
+		y0 := b.Min.Y + 2*cy
+		y1 := y0 + 1
+		if y1 >= b.Max.Y {
+			y1 = y0
+		}
+		for cx := 0; cx < uvW; cx++ {
+			x0 := b.Min.X + 2*cx
+			if is420 {
+				ci := img.COffset(x0, y0)
+				du[cx] = jfifToC[img.Cb[ci]]
+				dv[cx] = jfifToC[img.Cr[ci]]
+				continue
+			}
+			x1 := x0 + 1
+			if x1 >= b.Max.X {
+				x1 = x0
+			}
+			c00, c01 := img.COffset(x0, y0), img.COffset(x1, y0)
+			c10, c11 := img.COffset(x0, y1), img.COffset(x1, y1)
+			cb := (int(img.Cb[c00]) + int(img.Cb[c01]) + int(img.Cb[c10]) + int(img.Cb[c11]) + 2) >> 2
+			cr := (int(img.Cr[c00]) + int(img.Cr[c01]) + int(img.Cr[c10]) + int(img.Cr[c11]) + 2) >> 2
+			du[cx] = jfifToC[cb]
+			dv[cx] = jfifToC[cr]
+		}
+		for cx := uvW; cx < padUVW; cx++ {
+			du[cx] = du[uvW-1]
+			dv[cx] = dv[uvW-1]
+		}
+	}
+	for cy := uvH; cy < padUVH; cy++ {
+		copy(enc.uPlane[cy*enc.uvStride:cy*enc.uvStride+padUVW], enc.uPlane[(uvH-1)*enc.uvStride:])
+		copy(enc.vPlane[cy*enc.uvStride:cy*enc.uvStride+padUVW], enc.vPlane[(uvH-1)*enc.uvStride:])
+	}
+}
+
+// padRowsY replicates luma row h-1 into rows h..padH-1.
+func (enc *VP8Encoder) padRowsY(h, padH, padW int) {
+	last := enc.yPlane[(h-1)*enc.yStride : (h-1)*enc.yStride+padW]
+	for y := h; y < padH; y++ {
+		copy(enc.yPlane[y*enc.yStride:], last)
+	}
+}
+
+// narrowTo8Bit converts an *image.NRGBA64 or *image.RGBA64 to an
+// *image.NRGBA, rounding each 16-bit channel to the nearest 8-bit value
+// (color.NRGBAModel truncates). RGBA64 pixels are un-premultiplied at 16-bit
+// precision first. Other image types are returned unchanged.
+func narrowTo8Bit(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	switch src := img.(type) {
+	case *image.NRGBA64:
+		dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			d := dst.Pix[y*dst.Stride : y*dst.Stride+w*4]
+			for i := range d {
+				d[i] = round16To8(uint32(s[2*i])<<8 | uint32(s[2*i+1]))
+			}
+		}
+		return dst
+	case *image.RGBA64:
+		dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			d := dst.Pix[y*dst.Stride : y*dst.Stride+w*4]
+			for x := 0; x < w; x++ {
+				p := s[8*x : 8*x+8]
+				a := uint32(p[6])<<8 | uint32(p[7])
+				if a == 0 {
+					continue // NewNRGBA is zeroed.
+				}
+				for c := 0; c < 3; c++ {
+					v := uint32(p[2*c])<<8 | uint32(p[2*c+1])
+					if a != 0xffff {
+						if v = v * 0xffff / a; v > 0xffff {
+							v = 0xffff
+						}
+					}
+					d[4*x+c] = round16To8(v)
+				}
+				d[4*x+3] = round16To8(a)
+			}
+		}
+		return dst
+	}
+	return img
+}
+
+// unpremultiply converts a premultiplied 8-bit color to non-premultiplied,
+// matching the *image.RGBA conversion in the encoder's transparent-area
+// cleanup. Channels above alpha (invalid premultiplied input) give 255.
+func unpremultiply(r, g, b, a uint8) (uint8, uint8, uint8) {
+	if a == 0xff {
+		return r, g, b
+	}
+	return unpremultiplyChannel(r, a), unpremultiplyChannel(g, a), unpremultiplyChannel(b, a)
+}
+
+func unpremultiplyChannel(c, a uint8) uint8 {
+	if c >= a {
+		if a == 0 {
+			return 0
+		}
+		return 0xff
+	}
+	return uint8(uint16(c) * 255 / uint16(a))
+}
+
+// round16To8 maps a 16-bit channel value to the nearest 8-bit value.
+func round16To8(v uint32) uint8 {
+	return uint8((v*255 + 0x7fff) / 0xffff)
+}
+
 // imageHasAlpha reports whether any pixel in the image has a non-opaque alpha value.
 func imageHasAlpha(img image.Image) bool {
 	// Fast path: direct pixel access for *image.NRGBA.
@@ -1011,6 +1422,9 @@ func imageHasAlpha(img image.Image) bool {
 // the proper SNS-modulated per-segment quantizers.
 func (enc *VP8Encoder) initSegments() {
 	q := qualityToQIndex(enc.config.Quality)
+	if enc.config.QuantIndex > 0 {
+		q = enc.config.QuantIndex
+	}
 	numSegs := enc.config.Segments
 	if numSegs < 1 {
 		numSegs = 1
@@ -1189,6 +1603,19 @@ func computeLambda(q int) int {
 	return q >> 2
 }
 
+// workers returns the number of goroutines the encoder may use for its
+// parallel stages: 1 with SingleThreaded, otherwise NumThreads if set, or
+// GOMAXPROCS.
+func (enc *VP8Encoder) workers() int {
+	if enc.config.SingleThreaded {
+		return 1
+	}
+	if enc.config.NumThreads > 0 {
+		return enc.config.NumThreads
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
 // maxInt returns the larger of a and b.
 func maxInt(a, b int) int {
 	if a > b {
@@ -1284,13 +1711,8 @@ func (enc *VP8Encoder) setupFilterStrength() {
 	fhdr.ModeLFDelta = [4]int{0, 0, 0, 0}
 	fhdr.UseLFDelta = false
 
-	if enc.config.FilterStrength <= 0 {
-		fhdr.Level = 0
-		return
-	}
-
 	// level0 is in [0..500]. Using '-f 50' as filter_strength is mid-filtering.
-	level0 := 5 * enc.config.FilterStrength
+	level0 := 5 * max(enc.config.FilterStrength, 0)
 	numSegs := enc.config.Segments
 	if numSegs < 1 {
 		numSegs = 1
@@ -1312,6 +1734,9 @@ func (enc *VP8Encoder) setupFilterStrength() {
 		if f > 63 {
 			f = 63
 		}
+		if o := enc.config.SegmentFilterStrengths[i]; o >= 0 {
+			f = min(o, 63)
+		}
 		m.FStrength = f
 	}
 
@@ -1322,9 +1747,19 @@ func (enc *VP8Encoder) setupFilterStrength() {
 // EncodeFrame is the main entry point: encodes the image and returns the
 // VP8 bitstream (without RIFF container).
 func (enc *VP8Encoder) EncodeFrame() ([]byte, error) {
+	if enc.config.Grayscale {
+		enc.neutralChroma()
+	}
 	// Analysis pass: assign segments and choose global parameters.
 	enc.analysis()
 	enc.setSegmentProbas()
+	if fn := enc.config.SegmentMapFunc; fn != nil {
+		segments := make([]uint8, len(enc.mbInfo))
+		for i := range enc.mbInfo {
+			segments[i] = enc.mbInfo[i].Segment
+		}
+		fn(enc.mbW, enc.mbH, enc.numSegments, segments)
+	}
 
 	// For the token-buffer path (method >= 3), C libwebp uses VP8EncTokenLoop
 	// which does NOT call StatLoop — instead, it relies on mid-stream probability
@@ -1349,11 +1784,11 @@ func (enc *VP8Encoder) EncodeFrame() ([]byte, error) {
 		maxPasses = 3 // ensure enough passes for rate control convergence
 	}
 	// Use parallel encoding when:
-	// - Multiple CPU cores available (GOMAXPROCS > 1)
+	// - Multiple CPU cores available (GOMAXPROCS > 1, not SingleThreaded)
 	// - Enough rows for meaningful parallelism (mbH >= 4)
 	// - Method >= 3 (RD-based mode selection, which is the hot path)
 	// - Single-pass quality mode (no rate control iteration)
-	useParallel := runtime.GOMAXPROCS(0) > 1 && enc.mbH >= 4 && enc.config.Method >= 3 && !doSearch
+	useParallel := enc.workers() > 1 && enc.mbH >= 4 && enc.config.Method >= 3 && !doSearch
 
 	var stats ProbaStats
 	for pass := 0; pass < maxPasses; pass++ {
@@ -2,7 +2,6 @@ package lossy
 
 import (
 	"math"
-	"runtime"
 	"sync"
 	"sync/atomic"
 
@@ -20,6 +19,32 @@ type analysisWorker struct {
 	predV     [256]byte // 8*BPS chroma V prediction
 }
 
+// Analysis holds the macroblock complexities computed by the analysis pass.
+// They depend only on the source planes, not on the quality or the other
+// settings, so they can be reused across encodes of the same image through
+// EncodeConfig.Analysis.
+type Analysis struct {
+	mbW, mbH int
+	alphas   []uint8 // mixed luma and chroma complexity of each macroblock
+	uvAlpha  int     // mean chroma complexity
+}
+
+// Analyze computes the macroblock complexities of the image held by enc,
+// as the analysis pass of EncodeFrame would.
+func (enc *VP8Encoder) Analyze() *Analysis {
+	alphas := enc.analysisAlphas[:len(enc.mbInfo)]
+	a := &Analysis{
+		mbW:     enc.mbW,
+		mbH:     enc.mbH,
+		alphas:  make([]uint8, len(alphas)),
+		uvAlpha: computeAlphas(enc, alphas),
+	}
+	for i, v := range alphas {
+		a.alphas[i] = uint8(v)
+	}
+	return a
+}
+
 // analysis performs the pre-encoding analysis pass:
 // - Computes per-macroblock complexity/alpha
 // - Assigns segments via k-means clustering
@@ -38,10 +63,22 @@ func (enc *VP8Encoder) analysis() {
 	// Compute alpha (complexity) for each macroblock.
 	// Use pre-allocated buffer from enc.analysisAlphas.
 	alphas := enc.analysisAlphas[:len(enc.mbInfo)]
-	for i := range alphas {
-		alphas[i] = 0
+	var globalUVAlpha int
+	if a := enc.config.Analysis; a != nil && a.mbW == enc.mbW && a.mbH == enc.mbH {
+		for i, v := range a.alphas {
+			alphas[i] = int(v)
+			enc.mbInfo[i].Alpha = int(v)
+		}
+		globalUVAlpha = a.uvAlpha
+	} else {
+		for i := range alphas {
+			alphas[i] = 0
+		}
+		globalUVAlpha = computeAlphas(enc, alphas)
+	}
+	if len(enc.config.ROI) == len(alphas) {
+		applyROIAlphas(enc, alphas)
 	}
-	globalUVAlpha := computeAlphas(enc, alphas)
 
 	// Store global alpha and UV alpha (matching C enc->alpha, enc->uv_alpha).
 	enc.globalAlpha = 0
@@ -70,6 +107,42 @@ func (enc *VP8Encoder) analysis() {
 	enc.buildSegmentHeader(enc.numSegments)
 }
 
+// roiMaxDQ is the quantizer delta applied to a segment whose macroblocks
+// all have ROI value 255 (negative) or 0 (positive).
+const roiMaxDQ = 16
+
+// applyROIAlphas shifts each macroblock's alpha by its ROI value so that
+// k-means places important and unimportant regions in different segments.
+// Values above 128 raise alpha (finer quantization under SNS), values below
+// lower it.
+func applyROIAlphas(enc *VP8Encoder, alphas []int) {
+	for i, r := range enc.config.ROI {
+		a := clampInt(alphas[i]+int(r)-128, 0, maxAlpha)
+		alphas[i] = a
+		enc.mbInfo[i].Alpha = a
+	}
+}
+
+// applyROIQuant offsets each segment's quantizer by the mean ROI value of
+// its macroblocks, independently of SNS strength, so that regions with
+// higher ROI values get a lower quantizer.
+func (enc *VP8Encoder) applyROIQuant(numSegs int) {
+	var sum, count [NumMBSegments]int
+	for i, r := range enc.config.ROI {
+		s := enc.mbInfo[i].Segment
+		sum[s] += int(r)
+		count[s]++
+	}
+	for s := 0; s < numSegs; s++ {
+		if count[s] == 0 {
+			continue
+		}
+		mean := (sum[s] + count[s]/2) / count[s]
+		dq := (mean - 128) * roiMaxDQ / 128
+		enc.dqm[s].Quant = clampInt(enc.dqm[s].Quant-dq, 0, 127)
+	}
+}
+
 // smoothSegmentMap applies a 3x3 majority-vote filter to the segment map,
 // reducing noise in segment assignment. This matches libwebp's SmoothSegmentMap
 // from analysis_enc.c.
@@ -130,6 +203,11 @@ func (enc *VP8Encoder) setSegmentParams(numSegs int) {
 
 	// c_base = QualityToCompression(Q) — matching C libwebp.
 	cBase := qualityToCompression(enc.config.Quality)
+	qIndex := enc.config.QuantIndex
+	if qIndex > 0 {
+		// A raw quantizer index: the compression factor it corresponds to.
+		cBase = 1.0 - float64(qIndex)/127.0
+	}
 
 	// Compute per-segment quantizer via power-law modulation.
 	for i := 0; i < numSegs; i++ {
@@ -138,9 +216,18 @@ func (enc *VP8Encoder) setSegmentParams(numSegs int) {
 		expn := 1.0 - amp*float64(enc.dqm[i].Alpha)
 		c := math.Pow(cBase, expn)
 		q := int(127.0 * (1.0 - c))
+		if qIndex > 0 {
+			// Offset the exact index by the segment's modulation, so that
+			// rounding never moves an unmodulated segment off qIndex.
+			q = qIndex + q - int(127.0*(1.0-cBase))
+		}
 		enc.dqm[i].Quant = clampInt(q, 0, 127)
 	}
 
+	if len(enc.config.ROI) == len(enc.mbInfo) {
+		enc.applyROIQuant(numSegs)
+	}
+
 	// Purely indicative in the bitstream (except for the 1-segment case).
 	enc.baseQuant = enc.dqm[0].Quant
 
@@ -248,7 +335,7 @@ func computeAlphas(enc *VP8Encoder, alphas []int) int {
 		return 0
 	}
 
-	numWorkers := runtime.GOMAXPROCS(0)
+	numWorkers := enc.workers()
 	if numWorkers > total {
 		numWorkers = total
 	}
@@ -856,14 +943,17 @@ func (enc *VP8Encoder) buildSegmentHeader(numSegs int) {
 	hdr.AbsoluteDelta = true
 
 	if hdr.UseSegment {
+		level := 0
 		for i := 0; i < numSegs; i++ {
 			hdr.Quantizer[i] = int8(clampInt(enc.dqm[i].Quant, -127, 127))
-			// Per-segment filter strength delta (use qstep, not raw q).
-			qstep0 := int(KAcTable[clampInt(enc.dqm[0].Quant, 0, 127)]) >> 2
-			qstepI := int(KAcTable[clampInt(enc.dqm[i].Quant, 0, 127)]) >> 2
-			fDelta := (qstepI - qstep0) * enc.config.FilterStrength / 100
-			hdr.FilterStrength[i] = int8(clampInt(fDelta, -63, 63))
-		}
+			// Absolute per-segment filter level, as libwebp's PutSegmentHeader
+			// writes it.
+			hdr.FilterStrength[i] = int8(clampInt(enc.dqm[i].FStrength, 0, 63))
+			level = max(level, enc.dqm[i].FStrength)
+		}
+		// With absolute segment levels the frame level only switches the
+		// loop filter on or off: keep it on if any segment is filtered.
+		enc.filterHdr.Level = level
 	}
 }
 
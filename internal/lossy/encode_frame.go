@@ -129,9 +129,15 @@ func (enc *VP8Encoder) pickBestMode(it *MBIterator, info *MBEncInfo, seg *Segmen
 
 		var bestScore4 uint64 = ^uint64(0)
 		var modes4 [16]uint8
-		bestScore4 = enc.tryI4ModesRD(it, info, seg, &modes4, bestScore16)
+		switch enc.config.IntraMode {
+		case IntraModeAuto:
+			bestScore4 = enc.tryI4ModesRD(it, info, seg, &modes4, bestScore16)
+		case IntraModeI4Only:
+			// No early exit against I16.
+			bestScore4 = enc.tryI4ModesRD(it, info, seg, &modes4, ^uint64(0))
+		}
 
-		if bestScore4 < bestScore16 {
+		if bestScore4 < bestScore16 || enc.config.IntraMode == IntraModeI4Only {
 			info.MBType = 1
 			info.Modes = modes4
 			info.Score = bestScore4
@@ -170,11 +176,12 @@ func (enc *VP8Encoder) pickBestMode(it *MBIterator, info *MBEncInfo, seg *Segmen
 
 		var bestScore4 uint64 = ^uint64(0)
 		var modes4 [16]uint8
-		if enc.config.Method >= 2 {
+		i4Only := enc.config.IntraMode == IntraModeI4Only
+		if (enc.config.Method >= 2 && enc.config.IntraMode == IntraModeAuto) || i4Only {
 			bestScore4 = enc.tryI4Modes(it, info, seg, &modes4)
 		}
 
-		if bestScore4 < bestScore16 {
+		if bestScore4 < bestScore16 || i4Only {
 			info.MBType = 1
 			info.Modes = modes4
 			info.Score = bestScore4
@@ -308,8 +315,8 @@ func (enc *VP8Encoder) tryI4ModesRD(it *MBIterator, info *MBEncInfo, seg *Segmen
 				earlyExit = true
 				break
 			}
-			// Header bits budget check.
-			if totalHeaderBits > maxI4HeaderBits {
+			// Header bits budget check, waived when I4 is forced.
+			if totalHeaderBits > maxI4HeaderBits && enc.config.IntraMode != IntraModeI4Only {
 				earlyExit = true
 				break
 			}
@@ -1,7 +1,6 @@
 package lossy
 
 import (
-	"runtime"
 	"sync"
 	"sync/atomic"
 
@@ -172,7 +171,7 @@ func (enc *VP8Encoder) encodeFrameParallel(stats *ProbaStats) {
 	// Determine number of workers. Cap at 6 to reduce idle goroutine
 	// overhead — beyond 6 workers the pipeline depth (3 rows) limits
 	// parallelism and extra goroutines just add sync contention.
-	numWorkers := runtime.GOMAXPROCS(0)
+	numWorkers := enc.workers()
 	if numWorkers > 6 {
 		numWorkers = 6
 	}
@@ -567,9 +566,14 @@ func pickBestModeParallel(enc *VP8Encoder, w *RowWorker, mbX, mbY int, info *MBE
 
 		var bestScore4 uint64 = ^uint64(0)
 		var modes4 [16]uint8
-		bestScore4 = tryI4ModesRDParallel(enc, w, mbX, mbY, info, seg, &modes4, topModes, leftModes, bestScore16, topNzVal, leftNzVal)
+		switch enc.config.IntraMode {
+		case IntraModeAuto:
+			bestScore4 = tryI4ModesRDParallel(enc, w, mbX, mbY, info, seg, &modes4, topModes, leftModes, bestScore16, topNzVal, leftNzVal)
+		case IntraModeI4Only:
+			bestScore4 = tryI4ModesRDParallel(enc, w, mbX, mbY, info, seg, &modes4, topModes, leftModes, ^uint64(0), topNzVal, leftNzVal)
+		}
 
-		if bestScore4 < bestScore16 {
+		if bestScore4 < bestScore16 || enc.config.IntraMode == IntraModeI4Only {
 			info.MBType = 1
 			info.Modes = modes4
 			info.Score = bestScore4
@@ -601,11 +605,12 @@ func pickBestModeParallel(enc *VP8Encoder, w *RowWorker, mbX, mbY int, info *MBE
 
 		var bestScore4 uint64 = ^uint64(0)
 		var modes4 [16]uint8
-		if enc.config.Method >= 2 {
+		i4Only := enc.config.IntraMode == IntraModeI4Only
+		if (enc.config.Method >= 2 && enc.config.IntraMode == IntraModeAuto) || i4Only {
 			bestScore4 = tryI4ModesParallel(enc, w, mbX, mbY, info, seg, &modes4, topModes, leftModes)
 		}
 
-		if bestScore4 < bestScore16 {
+		if bestScore4 < bestScore16 || i4Only {
 			info.MBType = 1
 			info.Modes = modes4
 			info.Score = bestScore4
@@ -810,7 +815,7 @@ func tryI4ModesRDParallel(enc *VP8Encoder, w *RowWorker, mbX, mbY int, info *MBE
 				earlyExit = true
 				break
 			}
-			if totalHeaderBits > maxI4HeaderBits {
+			if totalHeaderBits > maxI4HeaderBits && enc.config.IntraMode != IntraModeI4Only {
 				earlyExit = true
 				break
 			}
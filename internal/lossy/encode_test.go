@@ -1,6 +1,7 @@
 package lossy
 
 import (
+	"bytes"
 	"image"
 	"image/color"
 	"math/rand"
@@ -143,6 +144,120 @@ func TestImportImageGraySolid(t *testing.T) {
 	}
 }
 
+func TestNarrowTo8Bit(t *testing.T) {
+	n := image.NewNRGBA64(image.Rect(0, 0, 2, 1))
+	n.SetNRGBA64(0, 0, color.NRGBA64{R: 0x0081, G: 0xfe00, B: 0xffff, A: 0xffff})
+	n.SetNRGBA64(1, 0, color.NRGBA64{R: 0x80ff, G: 0x007f, B: 0, A: 0x8000})
+	got := narrowTo8Bit(n).(*image.NRGBA)
+	// Truncating (v>>8) would give 0 and 254 for the first two channels:
+	// 0x0081/257 = 0.502 and 0xfe00/257 = 253.01.
+	want := []uint8{1, 253, 255, 255, 128, 0, 0, 128}
+	if !bytes.Equal(got.Pix, want) {
+		t.Errorf("NRGBA64: Pix = %v, want %v", got.Pix, want)
+	}
+
+	r := image.NewRGBA64(image.Rect(0, 0, 2, 1))
+	r.SetRGBA64(0, 0, color.RGBA64{R: 0x4000, G: 0x2000, B: 0, A: 0x8000})
+	r.SetRGBA64(1, 0, color.RGBA64{R: 0x1234, A: 0})
+	got = narrowTo8Bit(r).(*image.NRGBA)
+	// Un-premultiplied: R = 0x4000*0xffff/0x8000 = 0x7fff -> 127.498 -> 127.
+	want = []uint8{127, 64, 0, 128, 0, 0, 0, 0}
+	if !bytes.Equal(got.Pix, want) {
+		t.Errorf("RGBA64: Pix = %v, want %v", got.Pix, want)
+	}
+}
+
+func TestImportImageRGBAPremultiplied(t *testing.T) {
+	const w, h = 24, 20
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	nrgba := image.NewNRGBA(rgba.Bounds())
+	alphas := []uint8{51, 85, 255} // 255/5, 255/3: exact for multiples of 15.
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: uint8(x % 17 * 15), G: uint8(y % 17 * 15), B: 210, A: alphas[(x+y)%3]}
+			nrgba.SetNRGBA(x, y, c)
+			a := uint16(c.A)
+			rgba.SetRGBA(x, y, color.RGBA{
+				R: uint8(uint16(c.R) * a / 255),
+				G: uint8(uint16(c.G) * a / 255),
+				B: uint8(uint16(c.B) * a / 255),
+				A: c.A,
+			})
+		}
+	}
+	cfg := DefaultConfig(75)
+	cfg.HasAlpha = -1
+	want := NewEncoder(nrgba, cfg)
+	defer ReleaseEncoder(want)
+	got := NewEncoder(rgba, cfg)
+	defer ReleaseEncoder(got)
+	if !bytes.Equal(got.yPlane, want.yPlane) {
+		t.Error("Y plane of *image.RGBA differs from the equivalent *image.NRGBA")
+	}
+	if !bytes.Equal(got.uPlane, want.uPlane) || !bytes.Equal(got.vPlane, want.vPlane) {
+		t.Error("U/V planes of *image.RGBA differ from the equivalent *image.NRGBA")
+	}
+}
+
+// opaqueImage hides the concrete type of an image so importImage takes its
+// generic color.Model path.
+type opaqueImage struct{ image.Image }
+
+func TestImportImageGray(t *testing.T) {
+	const w, h = 37, 23
+	gray := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 7)
+	}
+	cfg := DefaultConfig(75)
+	want := NewEncoder(opaqueImage{gray}, cfg)
+	defer ReleaseEncoder(want)
+	got := NewEncoder(gray, cfg)
+	defer ReleaseEncoder(got)
+	if !bytes.Equal(got.yPlane, want.yPlane) {
+		t.Error("Y plane of *image.Gray differs from the generic path")
+	}
+	if !bytes.Equal(got.uPlane, want.uPlane) || !bytes.Equal(got.vPlane, want.vPlane) {
+		t.Error("U/V planes of *image.Gray differ from the generic path")
+	}
+}
+
+func TestImportImageYCbCr(t *testing.T) {
+	const w, h = 37, 23
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio444,
+	} {
+		t.Run(ratio.String(), func(t *testing.T) {
+			ycc := image.NewYCbCr(image.Rect(0, 0, w+4, h+2), ratio)
+			for y := 0; y < h+2; y++ {
+				for x := 0; x < w+4; x++ {
+					ycc.Y[ycc.YOffset(x, y)] = uint8(60 + x*2 + y*2)
+					ci := ycc.COffset(x, y)
+					ycc.Cb[ci] = uint8(110 + x/2)
+					ycc.Cr[ci] = uint8(140 - y/2)
+				}
+			}
+			sub := ycc.SubImage(image.Rect(2, 2, w+2, h+2)).(*image.YCbCr)
+			cfg := DefaultConfig(75)
+			want := NewEncoder(opaqueImage{sub}, cfg)
+			defer ReleaseEncoder(want)
+			got := NewEncoder(sub, cfg)
+			defer ReleaseEncoder(got)
+			if d := maxAbsError(got.yPlane, want.yPlane); d > 1 {
+				t.Errorf("Y plane max error vs generic path = %d, want <= 1", d)
+			}
+			if d := maxAbsError(got.uPlane, want.uPlane); d > 2 {
+				t.Errorf("U plane max error vs generic path = %d, want <= 2", d)
+			}
+			if d := maxAbsError(got.vPlane, want.vPlane); d > 2 {
+				t.Errorf("V plane max error vs generic path = %d, want <= 2", d)
+			}
+		})
+	}
+}
+
 // --- Quantization tests ---
 
 func TestQuantizeCoeffs(t *testing.T) {
@@ -404,6 +519,138 @@ func TestAnalysisMultiSegment(t *testing.T) {
 	}
 }
 
+func TestQuantIndexOverride(t *testing.T) {
+	img := gradientImage(64, 64)
+	for _, qi := range []int{1, 40, 127} {
+		cfg := DefaultConfig(75)
+		cfg.SNSStrength = 0
+		cfg.QuantIndex = qi
+		enc := NewEncoder(img, cfg)
+		enc.analysis()
+		for s := 0; s < NumMBSegments; s++ {
+			if enc.dqm[s].Quant != qi {
+				t.Errorf("QuantIndex %d: segment %d quant = %d", qi, s, enc.dqm[s].Quant)
+			}
+		}
+	}
+
+	// The index that Quality maps to gives the same segment quantizers,
+	// spatial noise shaping included, up to rounding: the index itself is
+	// truncated from the compression factor the modulation starts from.
+	for _, q := range []int{10, 50, 75, 95} {
+		want := NewEncoder(img, DefaultConfig(q))
+		want.analysis()
+		cfg := DefaultConfig(q)
+		cfg.QuantIndex = qualityToQIndex(q)
+		got := NewEncoder(img, cfg)
+		got.analysis()
+		for s := 0; s < NumMBSegments; s++ {
+			if d := got.dqm[s].Quant - want.dqm[s].Quant; d < -1 || d > 1 {
+				t.Errorf("quality %d: segment %d quant = %d, want %d", q, s, got.dqm[s].Quant, want.dqm[s].Quant)
+			}
+		}
+	}
+}
+
+func TestSegmentFilterLevels(t *testing.T) {
+	// Flat on the left, noisy on the right, so the analysis pass uses
+	// several segments.
+	img := gradientImage(128, 64)
+	for y := 0; y < 64; y++ {
+		for x := 64; x < 128; x++ {
+			v := uint8((x*7919 + y*104729) % 251)
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v ^ 0x5a, B: 255 - v, A: 255})
+		}
+	}
+	enc := NewEncoder(img, DefaultConfig(75))
+	bs, err := enc.EncodeFrame()
+	if err != nil {
+		t.Fatalf("EncodeFrame: %v", err)
+	}
+	hdr, err := ParseHeader(bs)
+	if err != nil {
+		t.Fatalf("ParseHeader: %v", err)
+	}
+	if !hdr.Segment.UseSegment || !hdr.Segment.AbsoluteDelta {
+		t.Fatalf("segment header = %+v, want absolute segment values", hdr.Segment)
+	}
+	if hdr.Filter.Level == 0 {
+		t.Error("loop filter off")
+	}
+	// With absolute values the decoder filters each segment at the level
+	// in the header, which must be the one the encoder chose for it.
+	for s := 0; s < enc.config.Segments; s++ {
+		if got, want := int(hdr.Segment.FilterStrength[s]), enc.dqm[s].FStrength; got != want {
+			t.Errorf("segment %d: filter level %d in the header, want %d", s, got, want)
+		}
+	}
+}
+
+func TestEncodeMultiplePartitions(t *testing.T) {
+	img := gradientImage(48, 80) // 5 macroblock rows
+	encode := func(partitions int) []byte {
+		t.Helper()
+		cfg := DefaultConfig(75)
+		cfg.Partitions = partitions
+		cfg.SingleThreaded = true
+		bs, err := NewEncoder(img, cfg).EncodeFrame()
+		if err != nil {
+			t.Fatalf("Partitions %d: EncodeFrame: %v", partitions, err)
+		}
+		return bs
+	}
+	decodeY := func(bs []byte) []byte {
+		t.Helper()
+		dec, w, h, y, stride, _, _, _, err := DecodeFrame(bs)
+		if err != nil {
+			t.Fatalf("DecodeFrame: %v", err)
+		}
+		defer ReleaseDecoder(dec)
+		out := make([]byte, 0, w*h)
+		for r := 0; r < h; r++ {
+			out = append(out, y[r*stride:r*stride+w]...)
+		}
+		return out
+	}
+
+	single := encode(0)
+	want := decodeY(single)
+	for partitions := 1; partitions <= 3; partitions++ {
+		bs := encode(partitions)
+		hdr, err := ParseHeader(bs)
+		if err != nil {
+			t.Fatalf("ParseHeader: %v", err)
+		}
+		n := 1 << partitions
+		if hdr.NumPartitions != n {
+			t.Fatalf("Partitions %d: header has %d partitions, want %d", partitions, hdr.NumPartitions, n)
+		}
+
+		// The n-1 partition sizes follow partition 0; the last partition
+		// takes the rest. Rows go to partitions round-robin, so with 5
+		// rows every partition up to the fifth holds data.
+		sizes := bs[10+int(hdr.Frame.PartitionLength):]
+		total := 3 * (n - 1)
+		for i := 0; i < n-1; i++ {
+			sz := int(sizes[3*i]) | int(sizes[3*i+1])<<8 | int(sizes[3*i+2])<<16
+			if sz == 0 && i < 5 {
+				t.Errorf("Partitions %d: partition %d is empty", partitions, i)
+			}
+			total += sz
+		}
+		if total >= len(sizes) {
+			t.Errorf("Partitions %d: sizes add up to %d of %d bytes, leaving none for the last partition", partitions, total, len(sizes))
+		}
+		if len(bs) <= len(single) {
+			t.Errorf("Partitions %d: %d bytes, want more than the %d of a single partition", partitions, len(bs), len(single))
+		}
+
+		if got := decodeY(bs); !bytes.Equal(got, want) {
+			t.Errorf("Partitions %d: decoded luma differs from the single-partition encode", partitions)
+		}
+	}
+}
+
 // --- Token buffer tests ---
 
 func TestTokenBufferBasic(t *testing.T) {
@@ -0,0 +1,83 @@
+package webp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/deepteams/webp/internal/container"
+)
+
+// Metadata holds the raw metadata chunks of an extended (VP8X) WebP file.
+// Simple lossy and lossless files carry no metadata.
+type Metadata struct {
+	ICC  []byte // ICC color profile (ICCP chunk), nil if absent.
+	EXIF []byte // EXIF metadata (EXIF chunk), nil if absent.
+	XMP  []byte // XMP metadata (XMP chunk), nil if absent.
+
+	// HasICC, HasEXIF and HasXMP report whether each chunk is present,
+	// which distinguishes an empty chunk from a missing one.
+	HasICC  bool
+	HasEXIF bool
+	HasXMP  bool
+}
+
+// ReadMetadata reads the ICC profile, EXIF and XMP chunks of a WebP file
+// from r without decoding pixel data. It works for both still and animated
+// files.
+func ReadMetadata(r io.Reader) (*Metadata, error) {
+	if r == nil {
+		return nil, errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("webp: reading data: %w", err)
+	}
+
+	p, err := container.NewParser(data)
+	if err != nil {
+		return nil, fmt.Errorf("webp: parsing container: %w", err)
+	}
+	return metadataFromParser(p), nil
+}
+
+// DecodeWithMetadata decodes a WebP image like [Decode] and also returns its
+// ICC profile, EXIF and XMP chunks, so they can be carried over when the
+// image is re-encoded (see [EncoderOptions]). For animations the first frame
+// is decoded.
+func DecodeWithMetadata(r io.Reader) (image.Image, *Metadata, error) {
+	if r == nil {
+		return nil, nil, errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("webp: reading data: %w", err)
+	}
+
+	p, err := container.NewParser(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("webp: parsing container: %w", err)
+	}
+	img, err := decodeFirstFrame(p, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, metadataFromParser(p), nil
+}
+
+// metadataFromParser collects the metadata chunks found by p.
+func metadataFromParser(p *container.Parser) *Metadata {
+	m := &Metadata{}
+	for _, c := range p.Chunks() {
+		switch c.FourCC {
+		case container.FourCCICCP:
+			m.ICC, m.HasICC = c.Payload, true
+		case container.FourCCEXIF:
+			m.EXIF, m.HasEXIF = c.Payload, true
+		case container.FourCCXMP:
+			m.XMP, m.HasXMP = c.Payload, true
+		}
+	}
+	return m
+}
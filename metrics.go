@@ -0,0 +1,130 @@
+package webp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/deepteams/webp/internal/dsp"
+)
+
+// PSNR returns the peak signal-to-noise ratio in dB between two images of
+// the same size, for the red, green and blue channels and for all three
+// combined. Channels are compared as non-premultiplied 8-bit values; alpha
+// is ignored. Identical channels report 99 dB, matching libwebp.
+func PSNR(a, b image.Image) (r, g, b2, global float64, err error) {
+	pa, pb, err := metricPlanes(a, b)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	n := len(pa[0])
+	var sse [3]uint64
+	for c := 0; c < 3; c++ {
+		sse[c] = dsp.SSE(pa[c], pb[c], n, 1, n, n)
+	}
+	return dsp.PSNRFromSSE(sse[0], n),
+		dsp.PSNRFromSSE(sse[1], n),
+		dsp.PSNRFromSSE(sse[2], n),
+		dsp.PSNRFromSSE(sse[0]+sse[1]+sse[2], 3*n),
+		nil
+}
+
+// SSIM returns the structural similarity between two images of the same
+// size, averaged over the red, green and blue channels. Like libwebp's
+// picture distortion it uses a 7x7 hat-weighted window centred on every
+// pixel, clipped at the image borders. The result is 1 for identical
+// images. Alpha is ignored.
+func SSIM(a, b image.Image) (float64, error) {
+	pa, pb, err := metricPlanes(a, b)
+	if err != nil {
+		return 0, err
+	}
+	return ssimPlanes(pa, pb, a.Bounds().Dx(), a.Bounds().Dy()), nil
+}
+
+// ssimPlanes returns the SSIM of two sets of w x h RGB planes, averaged
+// over the three channels.
+func ssimPlanes(pa, pb [3][]byte, w, h int) float64 {
+	var sum float64
+	for c := 0; c < 3; c++ {
+		sum += accumulateSSIM(pa[c], pb[c], w, h)
+	}
+	return sum / float64(3*w*h)
+}
+
+// accumulateSSIM sums the per-pixel SSIM of two w x h planes. Windows that
+// fit inside the plane use dsp.SSIMGet; border windows are clipped.
+// Matches libwebp's AccumulateSSIM.
+func accumulateSSIM(src, ref []byte, w, h int) float64 {
+	const kernel = 3 // VP8_SSIM_KERNEL
+	var sum float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x >= kernel && x < w-kernel-1 && y >= kernel && y < h-kernel-1 {
+				off := (x - kernel) + (y-kernel)*w
+				sum += dsp.SSIMGet(src[off:], w, ref[off:], w)
+			} else {
+				sum += dsp.SSIMGetClipped(src, w, ref, w, x, y, w, h)
+			}
+		}
+	}
+	return sum
+}
+
+// metricPlanes checks that a and b have the same size and returns their
+// red, green and blue channels as tightly packed planes.
+func metricPlanes(a, b image.Image) (pa, pb [3][]byte, err error) {
+	if a == nil || b == nil {
+		return pa, pb, errors.New("webp: nil image")
+	}
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() {
+		return pa, pb, fmt.Errorf("webp: image sizes differ (%dx%d vs %dx%d)", ab.Dx(), ab.Dy(), bb.Dx(), bb.Dy())
+	}
+	if ab.Empty() {
+		return pa, pb, errors.New("webp: empty image")
+	}
+	return rgbPlanes(a), rgbPlanes(b), nil
+}
+
+// rgbPlanes splits img into non-premultiplied red, green and blue planes.
+func rgbPlanes(img image.Image) [3][]byte {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	var p [3][]byte
+	for c := range p {
+		p[c] = make([]byte, w*h)
+	}
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		for y := 0; y < h; y++ {
+			row := nrgba.Pix[nrgba.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				i := y*w + x
+				p[0][i] = row[4*x]
+				p[1][i] = row[4*x+1]
+				p[2][i] = row[4*x+2]
+			}
+		}
+		return p
+	}
+	if ycc, ok := img.(*image.YCbCr); ok {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				px, py := bounds.Min.X+x, bounds.Min.Y+y
+				ci := ycc.COffset(px, py)
+				i := y*w + x
+				p[0][i], p[1][i], p[2][i] = color.YCbCrToRGB(ycc.Y[ycc.YOffset(px, py)], ycc.Cb[ci], ycc.Cr[ci])
+			}
+		}
+		return p
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			i := y*w + x
+			p[0][i], p[1][i], p[2][i] = c.R, c.G, c.B
+		}
+	}
+	return p
+}
@@ -0,0 +1,65 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestPSNR(t *testing.T) {
+	a := solidImage(16, 16, color.NRGBA{R: 100, G: 50, B: 25, A: 255})
+	b := solidImage(16, 16, color.NRGBA{R: 110, G: 50, B: 25, A: 255})
+
+	r, g, bl, global, err := PSNR(a, b)
+	if err != nil {
+		t.Fatalf("PSNR: %v", err)
+	}
+	wantR := 10 * math.Log10(255*255/100.0)
+	wantGlobal := 10 * math.Log10(255*255/(100.0/3))
+	if math.Abs(r-wantR) > 1e-9 || math.Abs(global-wantGlobal) > 1e-9 {
+		t.Errorf("PSNR r=%.4f global=%.4f, want %.4f and %.4f", r, global, wantR, wantGlobal)
+	}
+	if g != 99 || bl != 99 {
+		t.Errorf("identical channels: g=%.2f b=%.2f, want 99", g, bl)
+	}
+}
+
+func TestSSIM(t *testing.T) {
+	src := richTestImage(64, 48)
+	if s, err := SSIM(src, src); err != nil || s != 1 {
+		t.Fatalf("SSIM(src, src) = %v, %v; want 1", s, err)
+	}
+
+	ssimAt := func(q float32) float64 {
+		var buf bytes.Buffer
+		if err := Encode(&buf, src, &EncoderOptions{Quality: q, Method: 4}); err != nil {
+			t.Fatalf("Encode q=%v: %v", q, err)
+		}
+		dec, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Decode q=%v: %v", q, err)
+		}
+		s, err := SSIM(src, dec)
+		if err != nil {
+			t.Fatalf("SSIM q=%v: %v", q, err)
+		}
+		return s
+	}
+	low, high := ssimAt(5), ssimAt(95)
+	if !(low < high && high <= 1 && low > 0) {
+		t.Errorf("SSIM q5=%.4f q95=%.4f, want 0 < q5 < q95 <= 1", low, high)
+	}
+}
+
+func TestMetricsSizeMismatch(t *testing.T) {
+	a := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	b := image.NewNRGBA(image.Rect(0, 0, 8, 9))
+	if _, _, _, _, err := PSNR(a, b); err == nil {
+		t.Error("PSNR: expected error for different sizes")
+	}
+	if _, err := SSIM(a, b); err == nil {
+		t.Error("SSIM: expected error for different sizes")
+	}
+}
@@ -80,6 +80,7 @@ type FrameInfo struct {
 	Duration    int // Milliseconds (0 for still images).
 	IsKeyframe  bool
 	HasAlpha    bool // True if the frame's bitstream signals alpha (ALPH chunk or VP8L alpha bit).
+	IsLossless  bool // True if the image sub-chunk is VP8L, false if VP8.
 	BlendMode   BlendMode
 	DisposeMode DisposeMode
 }
@@ -97,6 +98,8 @@ type Demuxer struct {
 	// ANIM parameters.
 	bgColor   uint32
 	loopCount int
+	// maxFrames, if positive, lowers the frame limit (NewDemuxerMaxFrames).
+	maxFrames int
 }
 
 // maxMetadataSize is the maximum allowed size for a single metadata chunk
@@ -130,6 +133,18 @@ func NewDemuxer(data []byte) (*Demuxer, error) {
 	return d, nil
 }
 
+// NewDemuxerMaxFrames is like NewDemuxer but stops parsing with an error
+// wrapping ErrTooManyFrames as soon as the file holds more than maxFrames
+// animation frames, to bound the work spent on untrusted files. maxFrames
+// <= 0, or above the limit of 10000 that always applies, means 10000.
+func NewDemuxerMaxFrames(data []byte, maxFrames int) (*Demuxer, error) {
+	d := &Demuxer{data: data, maxFrames: maxFrames}
+	if err := d.parse(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
 // GetFeatures returns the features extracted from the WebP file.
 func (d *Demuxer) GetFeatures() Features {
 	return d.features
@@ -303,6 +318,7 @@ func (d *Demuxer) parseSimpleVP8L(payload []byte) error {
 		Height:     h,
 		HasAlpha:   hasAlpha,
 		IsKeyframe: true,
+		IsLossless: true,
 	}}
 	d.chunks = []Chunk{c}
 	return nil
@@ -399,8 +415,28 @@ func (d *Demuxer) parseANIM(data []byte) error {
 
 // parseANMF extracts a single animation frame from an ANMF chunk payload.
 func (d *Demuxer) parseANMF(data []byte) error {
+	limit := maxFrames
+	if d.maxFrames > 0 {
+		limit = min(d.maxFrames, maxFrames)
+	}
+	if len(d.frames) >= limit {
+		return fmt.Errorf("%w: exceeded limit of %d", ErrTooManyFrames, limit)
+	}
+	fi, err := ParseANMF(data)
+	if err != nil {
+		return err
+	}
+	fi.IsKeyframe = len(d.frames) == 0
+	d.frames = append(d.frames, *fi)
+	return nil
+}
+
+// ParseANMF parses an ANMF chunk payload (without the chunk header) into a
+// FrameInfo. The returned Data and AlphaData alias data. IsKeyframe is left
+// false since it depends on the frame's position in the animation.
+func ParseANMF(data []byte) (*FrameInfo, error) {
 	if len(data) < container.ANMFChunkSize {
-		return ErrInvalidANMF
+		return nil, ErrInvalidANMF
 	}
 	offsetX := (int(data[0]) | int(data[1])<<8 | int(data[2])<<16) * 2
 	offsetY := (int(data[3]) | int(data[4])<<8 | int(data[5])<<16) * 2
@@ -411,12 +447,12 @@ func (d *Demuxer) parseANMF(data []byte) error {
 
 	// Validate offsets are non-negative.
 	if offsetX < 0 || offsetY < 0 {
-		return fmt.Errorf("%w: negative frame offset", ErrInvalidANMF)
+		return nil, fmt.Errorf("%w: negative frame offset", ErrInvalidANMF)
 	}
 
 	// Validate frame area to prevent excessive memory allocation.
 	if uint64(width)*uint64(height) >= container.MaxImageArea {
-		return fmt.Errorf("%w: frame dimensions %dx%d too large", ErrInvalidANMF, width, height)
+		return nil, fmt.Errorf("%w: frame dimensions %dx%d too large", ErrInvalidANMF, width, height)
 	}
 
 	dispose := DisposeNone
@@ -432,6 +468,7 @@ func (d *Demuxer) parseANMF(data []byte) error {
 	framePayload := data[container.ANMFChunkSize:]
 	var imageData []byte
 	var alphaData []byte
+	isLossless := false
 
 	pos := 0
 	for pos+container.ChunkHeaderSize <= len(framePayload) {
@@ -450,6 +487,7 @@ func (d *Demuxer) parseANMF(data []byte) error {
 		switch subID {
 		case FourCCVP8, FourCCVP8L:
 			imageData = subData
+			isLossless = subID == FourCCVP8L
 		case FourCCALPH:
 			alphaData = subData
 		}
@@ -471,11 +509,7 @@ func (d *Demuxer) parseANMF(data []byte) error {
 		hasAlpha = frameDataHasAlpha(imageData)
 	}
 
-	if len(d.frames) >= maxFrames {
-		return fmt.Errorf("%w: exceeded limit of %d", ErrTooManyFrames, maxFrames)
-	}
-
-	fi := FrameInfo{
+	return &FrameInfo{
 		Data:        imageData,
 		AlphaData:   alphaData,
 		Width:       width,
@@ -483,13 +517,11 @@ func (d *Demuxer) parseANMF(data []byte) error {
 		OffsetX:     offsetX,
 		OffsetY:     offsetY,
 		Duration:    duration,
-		IsKeyframe:  len(d.frames) == 0,
 		HasAlpha:    hasAlpha,
+		IsLossless:  isLossless,
 		BlendMode:   blend,
 		DisposeMode: dispose,
-	}
-	d.frames = append(d.frames, fi)
-	return nil
+	}, nil
 }
 
 // parseSingleExtendedFrame parses a non-animated VP8X file's image data.
@@ -497,6 +529,7 @@ func (d *Demuxer) parseANMF(data []byte) error {
 func (d *Demuxer) parseSingleExtendedFrame(payload []byte) error {
 	var imageData []byte
 	var alphaData []byte
+	isLossless := false
 
 	pos := 0
 	for pos+container.ChunkHeaderSize <= len(payload) {
@@ -511,6 +544,7 @@ func (d *Demuxer) parseSingleExtendedFrame(payload []byte) error {
 			imageData = c.Data
 		case FourCCVP8L:
 			imageData = c.Data
+			isLossless = true
 		case FourCCEXIF, FourCCXMP, FourCCICCP:
 			// Metadata — skip, already captured at top level.
 		default:
@@ -536,6 +570,7 @@ func (d *Demuxer) parseSingleExtendedFrame(payload []byte) error {
 		Height:     d.features.Height,
 		HasAlpha:   hasAlpha,
 		IsKeyframe: true,
+		IsLossless: isLossless,
 	}}
 	return nil
 }
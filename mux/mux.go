@@ -38,6 +38,9 @@ type Muxer struct {
 	// libwebp behavior where the VP8X canvas size is authoritative.
 	canvasWidth  int
 	canvasHeight int
+	// VP8X feature flags set regardless of the frames and metadata.
+	forceAlpha bool
+	forceICC   bool
 }
 
 // maxDuration is the maximum frame duration in milliseconds (24-bit max).
@@ -60,6 +63,34 @@ func NewMuxer() *Muxer {
 	return &Muxer{}
 }
 
+// Reset discards all frames, metadata and animation parameters so the
+// Muxer can assemble a new file. The frame list keeps its capacity.
+func (m *Muxer) Reset() {
+	clear(m.frames)
+	*m = Muxer{frames: m.frames[:0]}
+}
+
+// ResetFrames discards the frames but keeps the metadata and animation
+// parameters, so the same file can be assembled again from new frames.
+func (m *Muxer) ResetFrames() {
+	clear(m.frames)
+	m.frames = m.frames[:0]
+}
+
+// SetForceAlphaFlag sets whether the VP8X alpha flag is written even when
+// no frame has alpha. Forcing the flag also forces the extended format.
+func (m *Muxer) SetForceAlphaFlag(force bool) {
+	m.forceAlpha = force
+}
+
+// SetForceICCFlag sets whether the VP8X ICC profile flag is written and
+// the extended format forced. The flag needs a profile set with
+// SetICCProfile: Assemble fails with ErrMuxValidation otherwise, as a
+// VP8X ICC flag without an ICCP chunk is invalid.
+func (m *Muxer) SetForceICCFlag(force bool) {
+	m.forceICC = force
+}
+
 // SetICCProfile sets the ICC color profile data.
 func (m *Muxer) SetICCProfile(data []byte) {
 	m.iccData = data
@@ -175,6 +206,15 @@ func (m *Muxer) FrameBlendMode(index int) BlendMode {
 	return BlendAlpha
 }
 
+// FrameDisposeMode returns the dispose mode of the frame at the given 0-based
+// index. Returns DisposeNone (0) if the index is out of range.
+func (m *Muxer) FrameDisposeMode(index int) DisposeMode {
+	if index >= 0 && index < len(m.frames) {
+		return m.frames[index].opts.DisposeMode
+	}
+	return DisposeNone
+}
+
 // NumFrames returns the number of frames added so far.
 func (m *Muxer) NumFrames() int {
 	return len(m.frames)
@@ -212,7 +252,8 @@ func (m *Muxer) isAnimated() bool {
 
 // needsVP8X returns true if the file requires the extended format header.
 func (m *Muxer) needsVP8X() bool {
-	return m.isAnimated() || m.iccData != nil || m.exifData != nil || m.xmpData != nil
+	return m.isAnimated() || m.iccData != nil || m.exifData != nil || m.xmpData != nil ||
+		m.forceAlpha || m.forceICC
 }
 
 // Assemble writes the complete WebP file to w.
@@ -234,6 +275,9 @@ func (m *Muxer) validate() error {
 	if len(m.frames) == 0 {
 		return ErrNoFrames
 	}
+	if m.forceICC && m.iccData == nil {
+		return fmt.Errorf("%w: ICC profile flag forced without an ICC profile", ErrMuxValidation)
+	}
 	animated := m.isAnimated()
 	if animated {
 		// Animated: must have at least 1 frame.
@@ -336,7 +380,7 @@ func (m *Muxer) assembleExtended(w io.Writer) error {
 	if animated {
 		flags |= flagAnimation
 	}
-	if m.iccData != nil {
+	if m.iccData != nil || m.forceICC {
 		flags |= flagICCP
 	}
 	if m.exifData != nil {
@@ -345,7 +389,7 @@ func (m *Muxer) assembleExtended(w io.Writer) error {
 	if m.xmpData != nil {
 		flags |= flagXMP
 	}
-	if m.hasAlpha() {
+	if m.hasAlpha() || m.forceAlpha {
 		flags |= flagAlpha
 	}
 
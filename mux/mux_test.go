@@ -3,6 +3,7 @@ package mux
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"testing"
 
 	"github.com/deepteams/webp/internal/container"
@@ -363,6 +364,31 @@ func TestDemuxAnimatedFrames(t *testing.T) {
 	}
 }
 
+func TestDemuxAnimatedFrameCodec(t *testing.T) {
+	animData := make([]byte, container.ANIMChunkSize)
+	anmf1 := buildANMFData(0, 0, 16, 16, 50, BlendNone, DisposeNone, FourCCVP8, makeVP8Keyframe(16, 16))
+	anmf2 := buildANMFData(0, 0, 16, 16, 50, BlendNone, DisposeNone, FourCCVP8L, makeVP8LData(16, 16, false))
+	webp := buildVP8XWebP(byte(flagAnimation), 16, 16,
+		Chunk{ID: FourCCANIM, Size: uint32(len(animData)), Data: animData},
+		Chunk{ID: FourCCANMF, Size: uint32(len(anmf1)), Data: anmf1},
+		Chunk{ID: FourCCANMF, Size: uint32(len(anmf2)), Data: anmf2},
+	)
+
+	d, err := NewDemuxer(webp)
+	if err != nil {
+		t.Fatalf("NewDemuxer: %v", err)
+	}
+	for i, want := range []bool{false, true} {
+		fi, err := d.Frame(i)
+		if err != nil {
+			t.Fatalf("Frame(%d): %v", i, err)
+		}
+		if fi.IsLossless != want {
+			t.Errorf("frame %d IsLossless = %v, want %v", i, fi.IsLossless, want)
+		}
+	}
+}
+
 func TestDemuxFrameIterator(t *testing.T) {
 	frame1 := makeVP8Keyframe(50, 50)
 	frame2 := makeVP8Keyframe(50, 50)
@@ -607,6 +633,77 @@ func TestMuxAnimated(t *testing.T) {
 	}
 }
 
+func TestMuxReset(t *testing.T) {
+	m := NewMuxer()
+	m.SetICCProfile([]byte("icc"))
+	m.SetLoopCount(3)
+	m.SetCanvasSize(100, 100)
+	for i := 0; i < 2; i++ {
+		if err := m.AddFrame(makeVP8Keyframe(100, 100), &FrameOptions{Duration: 50}); err != nil {
+			t.Fatalf("AddFrame: %v", err)
+		}
+	}
+	m.Reset()
+	if m.NumFrames() != 0 {
+		t.Errorf("NumFrames after Reset = %d, want 0", m.NumFrames())
+	}
+	if err := m.Assemble(&bytes.Buffer{}); err != ErrNoFrames {
+		t.Errorf("Assemble after Reset: got %v, want ErrNoFrames", err)
+	}
+
+	// A reset Muxer produces the same file as a new one.
+	bs := makeVP8Keyframe(64, 48)
+	if err := m.AddFrame(bs, nil); err != nil {
+		t.Fatalf("AddFrame: %v", err)
+	}
+	var got, want bytes.Buffer
+	if err := m.Assemble(&got); err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	fresh := NewMuxer()
+	fresh.AddFrame(bs, nil)
+	if err := fresh.Assemble(&want); err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Error("reset Muxer output differs from a new Muxer")
+	}
+}
+
+func TestMuxResetFrames(t *testing.T) {
+	build := func(m *Muxer, dur int) []byte {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			if err := m.AddFrame(makeVP8Keyframe(64, 48), &FrameOptions{Duration: dur}); err != nil {
+				t.Fatalf("AddFrame: %v", err)
+			}
+		}
+		var buf bytes.Buffer
+		if err := m.Assemble(&buf); err != nil {
+			t.Fatalf("Assemble: %v", err)
+		}
+		return buf.Bytes()
+	}
+	setup := func() *Muxer {
+		m := NewMuxer()
+		m.SetICCProfile([]byte("icc"))
+		m.SetLoopCount(3)
+		m.SetCanvasSize(64, 48)
+		return m
+	}
+
+	// Frames are replaced; metadata and animation parameters are kept.
+	m := setup()
+	build(m, 50)
+	m.ResetFrames()
+	if m.NumFrames() != 0 {
+		t.Errorf("NumFrames after ResetFrames = %d, want 0", m.NumFrames())
+	}
+	if got, want := build(m, 80), build(setup(), 80); !bytes.Equal(got, want) {
+		t.Error("output after ResetFrames differs from a new Muxer with the same settings")
+	}
+}
+
 func TestMuxNoFrames(t *testing.T) {
 	m := NewMuxer()
 	var buf bytes.Buffer
@@ -616,6 +713,30 @@ func TestMuxNoFrames(t *testing.T) {
 	}
 }
 
+func TestMuxForceICCFlagNeedsProfile(t *testing.T) {
+	m := NewMuxer()
+	m.SetForceICCFlag(true)
+	if err := m.AddFrame(makeVP8Keyframe(16, 16), nil); err != nil {
+		t.Fatalf("AddFrame: %v", err)
+	}
+	if err := m.Assemble(&bytes.Buffer{}); !errors.Is(err, ErrMuxValidation) {
+		t.Fatalf("Assemble without profile: got %v, want ErrMuxValidation", err)
+	}
+
+	m.SetICCProfile([]byte("icc"))
+	var buf bytes.Buffer
+	if err := m.Assemble(&buf); err != nil {
+		t.Fatalf("Assemble with profile: %v", err)
+	}
+	d, err := NewDemuxer(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NewDemuxer: %v", err)
+	}
+	if !d.GetFeatures().HasICC {
+		t.Error("HasICC = false with ForceICCFlag and a profile")
+	}
+}
+
 func TestMuxEmptyFrame(t *testing.T) {
 	m := NewMuxer()
 	err := m.AddFrame(nil, nil)
@@ -0,0 +1,66 @@
+package webp
+
+import (
+	"image"
+
+	"github.com/deepteams/webp/internal/dsp"
+)
+
+// UnpremultiplyInto converts src, whose color channels are premultiplied
+// by alpha, to straight alpha in dst, with the rounding the encoder applies
+// when it is given an *image.RGBA: each channel becomes c*255/a rounded
+// down, and channels above alpha, which are not valid premultiplied
+// values, become 255. Encoding dst therefore gives the same file as
+// encoding src. Fully transparent pixels keep the RGB stored in src, for
+// EncoderOptions.Exact; fully opaque ones are copied. Because of the
+// rounding down, [PremultiplyInto] gives back src only to within one per
+// channel.
+//
+// dst is resized to src, with its origin at (0, 0); its Pix is reused if
+// it is large enough and replaced otherwise.
+func UnpremultiplyInto(dst *image.NRGBA, src *image.RGBA) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	n := 4 * w * h
+	if cap(dst.Pix) < n {
+		dst.Pix = make([]uint8, n)
+	}
+	dst.Pix, dst.Stride, dst.Rect = dst.Pix[:n], 4*w, image.Rect(0, 0, w, h)
+	for y := 0; y < h; y++ {
+		s := src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):][:4*w]
+		d := dst.Pix[y*dst.Stride:][:4*w]
+		copy(d, s)
+		for x := 0; x < len(d); x += 4 {
+			a := uint16(d[x+3])
+			if a == 0 || a == 0xff {
+				continue
+			}
+			for c := x; c < x+3; c++ {
+				if uint16(d[c]) >= a {
+					d[c] = 0xff
+				} else {
+					d[c] = uint8(uint16(d[c]) * 255 / a)
+				}
+			}
+		}
+	}
+}
+
+// PremultiplyInto converts src, with straight alpha, to premultiplied alpha
+// in dst, with the rounding of DecodeOptions.PremultipliedRGBA and of
+// libwebp's premultiplied output modes: each channel becomes c*a/255
+// rounded to nearest. Fully transparent pixels become transparent black.
+//
+// dst is resized to src, with its origin at (0, 0); its Pix is reused if
+// it is large enough and replaced otherwise.
+func PremultiplyInto(dst *image.RGBA, src *image.NRGBA) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	n := 4 * w * h
+	if cap(dst.Pix) < n {
+		dst.Pix = make([]uint8, n)
+	}
+	dst.Pix, dst.Stride, dst.Rect = dst.Pix[:n], 4*w, image.Rect(0, 0, w, h)
+	for y := 0; y < h; y++ {
+		copy(dst.Pix[y*dst.Stride:][:4*w], src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y):])
+	}
+	dsp.ApplyAlphaMultiply(dst.Pix, false, w, h, dst.Stride, false)
+}
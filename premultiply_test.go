@@ -0,0 +1,102 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestUnpremultiplyInto(t *testing.T) {
+	for _, tc := range []struct {
+		in, want [4]uint8
+	}{
+		{[4]uint8{10, 20, 30, 255}, [4]uint8{10, 20, 30, 255}},
+		{[4]uint8{64, 128, 0, 128}, [4]uint8{127, 255, 0, 128}}, // 127.5 rounds down; c = a is 255
+		{[4]uint8{1, 2, 3, 3}, [4]uint8{85, 170, 255, 3}},
+		{[4]uint8{200, 50, 100, 100}, [4]uint8{255, 127, 255, 100}}, // c > a is invalid: 255
+		{[4]uint8{10, 20, 30, 0}, [4]uint8{10, 20, 30, 0}},          // kept for Exact
+		{[4]uint8{0, 0, 0, 0}, [4]uint8{0, 0, 0, 0}},
+	} {
+		src := image.NewRGBA(image.Rect(3, 4, 4, 5))
+		copy(src.Pix, tc.in[:])
+		var dst image.NRGBA
+		UnpremultiplyInto(&dst, src)
+		if dst.Rect != image.Rect(0, 0, 1, 1) {
+			t.Fatalf("%v: dst bounds %v", tc.in, dst.Rect)
+		}
+		if got := [4]uint8(dst.Pix); got != tc.want {
+			t.Errorf("UnpremultiplyInto(%v) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestPremultiplyInto(t *testing.T) {
+	for _, tc := range []struct {
+		in, want [4]uint8
+	}{
+		{[4]uint8{10, 20, 30, 255}, [4]uint8{10, 20, 30, 255}},
+		{[4]uint8{255, 128, 0, 128}, [4]uint8{128, 64, 0, 128}}, // 128.5 rounds up, 64.25 down
+		{[4]uint8{200, 100, 50, 100}, [4]uint8{78, 39, 20, 100}},
+		{[4]uint8{10, 20, 30, 0}, [4]uint8{0, 0, 0, 0}},
+	} {
+		src := image.NewNRGBA(image.Rect(3, 4, 4, 5))
+		copy(src.Pix, tc.in[:])
+		var dst image.RGBA
+		PremultiplyInto(&dst, src)
+		if got := [4]uint8(dst.Pix); got != tc.want {
+			t.Errorf("PremultiplyInto(%v) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+
+	// Premultiplying undoes UnpremultiplyInto, within the rounding down of
+	// the latter, for every valid premultiplied color.
+	src := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for a := 0; a < 256; a++ {
+		for c := 0; c <= a; c++ {
+			copy(src.Pix[src.PixOffset(c, a):], []uint8{uint8(c), uint8(c / 2), 0, uint8(a)})
+		}
+	}
+	var straight image.NRGBA
+	var back image.RGBA
+	UnpremultiplyInto(&straight, src)
+	PremultiplyInto(&back, &straight)
+	for a := 0; a < 256; a++ {
+		for c := 0; c <= a; c++ {
+			got, want := back.RGBAAt(c, a), src.RGBAAt(c, a)
+			dr, dg := int(want.R)-int(got.R), int(want.G)-int(got.G)
+			if dr < 0 || dr > 1 || dg < 0 || dg > 1 || got.A != want.A {
+				t.Fatalf("round trip of %v = %v", want, got)
+			}
+		}
+	}
+
+	// The destination is resized, reusing its buffer.
+	big := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	pix := &big.Pix[0]
+	PremultiplyInto(big, image.NewNRGBA(image.Rect(0, 0, 2, 3)))
+	if big.Rect != image.Rect(0, 0, 2, 3) || big.Stride != 8 || &big.Pix[0] != pix {
+		t.Errorf("resized dst: bounds %v, stride %d, reused %v", big.Rect, big.Stride, &big.Pix[0] == pix)
+	}
+}
+
+func TestUnpremultiplyInto_MatchesEncoder(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			a := uint8(x * 7)
+			src.SetRGBA(x, y, color.RGBA{R: a / 2, G: uint8(y * int(a) / 30), B: a, A: a})
+		}
+	}
+	var straight image.NRGBA
+	UnpremultiplyInto(&straight, src)
+	for _, opts := range []*EncoderOptions{
+		{Quality: 75},
+		{Lossless: true, Quality: 75},
+		{Lossless: true, Quality: 75, Exact: true},
+	} {
+		if got, want := mustEncode(t, &straight, opts), mustEncode(t, src, opts); !bytes.Equal(got, want) {
+			t.Errorf("lossless %v, exact %v: encoding the unpremultiplied image differs", opts.Lossless, opts.Exact)
+		}
+	}
+}
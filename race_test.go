@@ -149,6 +149,44 @@ func TestConcurrentEncodeRace(t *testing.T) {
 	wg.Wait()
 }
 
+// TestConcurrentEncodeGammaRace runs lossy encodes, which import the image
+// through the shared gamma tables, from many goroutines at once. Run alone
+// with -race, the encodes are the first to use the tables. Every result must
+// match a later encode of the same image.
+func TestConcurrentEncodeGammaRace(t *testing.T) {
+	img := gradient(96, 64, 3)
+	for i := 3; i < len(img.Pix); i += 4 * 7 {
+		img.Pix[i] = 0x80 // some translucent pixels for the alpha-weighted path
+	}
+
+	for _, sharp := range []bool{false, true} {
+		opts := &webp.EncoderOptions{Quality: 80, UseSharpYUV: sharp}
+		const n = 16
+		out := make([]bytes.Buffer, n)
+		var wg sync.WaitGroup
+		wg.Add(n)
+		for i := range n {
+			go func() {
+				defer wg.Done()
+				if err := webp.Encode(&out[i], img, opts); err != nil {
+					t.Errorf("Encode: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		var want bytes.Buffer
+		if err := webp.Encode(&want, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		for i := range out {
+			if !bytes.Equal(out[i].Bytes(), want.Bytes()) {
+				t.Errorf("UseSharpYUV %v: concurrent encode %d differs from a later encode", sharp, i)
+			}
+		}
+	}
+}
+
 func TestConcurrentEncodeLosslessRace(t *testing.T) {
 	img := gradient(64, 64, 0)
 
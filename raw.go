@@ -0,0 +1,194 @@
+package webp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/deepteams/webp/internal/container"
+	"github.com/deepteams/webp/internal/lossy"
+)
+
+// PixelFormat is the byte layout of a raw pixel buffer, as used by
+// [EncodeRaw] and [DecodeRaw]. Channels are 8 bits, in the order of the name, and alpha is
+// not premultiplied.
+type PixelFormat int
+
+const (
+	PixelFormatRGBA PixelFormat = iota
+	PixelFormatBGRA
+	PixelFormatRGB
+	PixelFormatBGR
+)
+
+// String returns the name of the format, e.g. "RGBA".
+func (f PixelFormat) String() string {
+	switch f {
+	case PixelFormatRGBA:
+		return "RGBA"
+	case PixelFormatBGRA:
+		return "BGRA"
+	case PixelFormatRGB:
+		return "RGB"
+	case PixelFormatBGR:
+		return "BGR"
+	}
+	return fmt.Sprintf("PixelFormat(%d)", int(f))
+}
+
+// BytesPerPixel returns the size of a pixel in f: 4 with alpha, 3 without,
+// or 0 for an unknown format.
+func (f PixelFormat) BytesPerPixel() int {
+	switch f {
+	case PixelFormatRGBA, PixelFormatBGRA:
+		return 4
+	case PixelFormatRGB, PixelFormatBGR:
+		return 3
+	}
+	return 0
+}
+
+// EncodeRaw writes a width x height image held in pix to w, with opts as in
+// [Encode]. Row y starts at pix[y*stride], so stride must be at least
+// width times the size of a pixel in format; the bytes past the last row
+// need not be present.
+//
+// RGBA data is encoded in place, without a copy, and is not modified; the
+// other formats are first converted to an RGBA copy.
+func EncodeRaw(w io.Writer, pix []byte, width, height, stride int, format PixelFormat, opts *EncoderOptions) error {
+	bpp := format.BytesPerPixel()
+	if bpp == 0 {
+		return fmt.Errorf("webp: invalid PixelFormat %d", int(format))
+	}
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("webp: invalid image dimensions %dx%d", width, height)
+	}
+	if width > MaxDimension || height > MaxDimension {
+		return fmt.Errorf("webp: image dimension %dx%d exceeds maximum %d", width, height, MaxDimension)
+	}
+	if stride < width*bpp {
+		return fmt.Errorf("webp: stride %d is less than %d bytes for %d %s pixels", stride, width*bpp, width, format)
+	}
+	n := (height-1)*stride + width*bpp
+	if len(pix) < n {
+		return fmt.Errorf("webp: pixel buffer too short: %d bytes, want %d", len(pix), n)
+	}
+
+	if format == PixelFormatRGBA {
+		img := &image.NRGBA{Pix: pix[:n:n], Stride: stride, Rect: image.Rect(0, 0, width, height)}
+		return Encode(w, img, opts)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	r, b := 0, 2
+	if format == PixelFormatBGRA || format == PixelFormatBGR {
+		r, b = 2, 0
+	}
+	for y := 0; y < height; y++ {
+		src := pix[y*stride:][:width*bpp]
+		dst := img.Pix[y*img.Stride:][:width*4]
+		for x := 0; x < width; x++ {
+			s, d := src[x*bpp:][:bpp], dst[4*x:][:4]
+			d[0], d[1], d[2], d[3] = s[r], s[1], s[b], 0xff
+			if bpp == 4 {
+				d[3] = s[3]
+			}
+		}
+	}
+	return Encode(w, img, opts)
+}
+
+// DecodeRaw reads a WebP image from r and returns its pixels in format,
+// tightly packed: row y starts at pix[y*stride] and stride is width times
+// the size of a pixel. RGB and BGR drop the alpha channel. Only the first
+// frame of an animation is decoded, composited onto the canvas as with
+// [Decode].
+//
+// Lossy images are converted to RGB with the same chroma upsampling
+// [Decode] uses for lossy images with alpha, and the conversion to format
+// is done in place in the decoded buffer.
+func DecodeRaw(r io.Reader, format PixelFormat) (pix []byte, width, height, stride int, err error) {
+	bpp := format.BytesPerPixel()
+	if bpp == 0 {
+		return nil, 0, 0, 0, fmt.Errorf("webp: invalid PixelFormat %d", int(format))
+	}
+	if r == nil {
+		return nil, 0, 0, 0, errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return nil, 0, 0, 0, fmt.Errorf("webp: reading data: %w", err)
+	}
+	img, err := decodeRawNRGBA(data)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	width, height = img.Rect.Dx(), img.Rect.Dy()
+	pix = img.Pix[:4*width*height]
+
+	r0, b0 := 0, 2
+	if format == PixelFormatBGRA || format == PixelFormatBGR {
+		r0, b0 = 2, 0
+	}
+	if bpp == 4 && r0 == 0 {
+		return pix, width, height, 4 * width, nil
+	}
+	// Each pixel is written at or before where it is read from.
+	for i := 0; i < width*height; i++ {
+		s, d := pix[4*i:][:4], pix[bpp*i:][:bpp]
+		cr, cg, cb, ca := s[0], s[1], s[2], s[3]
+		d[r0], d[1], d[b0] = cr, cg, cb
+		if bpp == 4 {
+			d[3] = ca
+		}
+	}
+	return pix[:bpp*width*height], width, height, bpp * width, nil
+}
+
+// decodeRawNRGBA decodes the first frame of a WebP file as an *image.NRGBA
+// whose rows are tightly packed.
+func decodeRawNRGBA(data []byte) (*image.NRGBA, error) {
+	p, err := container.NewParser(data)
+	if err != nil {
+		return nil, fmt.Errorf("webp: parsing container: %w", err)
+	}
+	frames := p.Frames()
+	if len(frames) == 0 {
+		return nil, ErrNoFrames
+	}
+	if err := verifyChecksum(p); err != nil {
+		return nil, err
+	}
+	frame := frames[0]
+
+	var img image.Image
+	if frame.IsLossless {
+		if img, err = decodeLossless(frame.Payload, nil); err != nil {
+			return nil, err
+		}
+	} else {
+		dec, width, height, yPlane, yStride, uPlane, vPlane, uvStride, err := lossy.DecodeFrameWithOptions(frame.Payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("webp: lossy decode: %w", err)
+		}
+		defer lossy.ReleaseDecoder(dec)
+		var alphaPlane []byte
+		if len(frame.AlphaData) > 0 {
+			alphaPlane, err = lossy.DecodeAlpha(frame.AlphaData, width, height)
+			if err != nil {
+				return nil, fmt.Errorf("webp: alpha decode: %w", err)
+			}
+		}
+		img = buildNRGBA(width, height, yPlane, yStride, uPlane, vPlane, uvStride, alphaPlane)
+	}
+	img = firstFrameCanvas(p, frame, img)
+
+	if m, ok := img.(*image.NRGBA); ok && m.Rect.Min == (image.Point{}) && m.Stride == 4*m.Rect.Dx() {
+		return m, nil
+	}
+	b := img.Bounds()
+	m := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(m, m.Rect, img, b.Min, draw.Src)
+	return m, nil
+}
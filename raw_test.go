@@ -0,0 +1,155 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+)
+
+// rawPixels returns img in format, with rows padded to stride bytes.
+func rawPixels(img *image.NRGBA, format PixelFormat, stride int) []byte {
+	b := img.Bounds()
+	bpp := format.BytesPerPixel()
+	pix := make([]byte, stride*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := img.NRGBAAt(x, y)
+			p := pix[y*stride+x*bpp:]
+			switch format {
+			case PixelFormatRGBA:
+				p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+			case PixelFormatBGRA:
+				p[0], p[1], p[2], p[3] = c.B, c.G, c.R, c.A
+			case PixelFormatRGB:
+				p[0], p[1], p[2] = c.R, c.G, c.B
+			case PixelFormatBGR:
+				p[0], p[1], p[2] = c.B, c.G, c.R
+			}
+		}
+	}
+	return pix
+}
+
+func TestEncodeRaw(t *testing.T) {
+	const w, h = 37, 21
+	opaque := gradientTestImage(w, h)
+	translucent := gradientTestImage(w, h)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = uint8(i)
+	}
+
+	for _, format := range []PixelFormat{PixelFormatRGBA, PixelFormatBGRA, PixelFormatRGB, PixelFormatBGR} {
+		src := opaque
+		if format.BytesPerPixel() == 4 {
+			src = translucent
+		}
+		for _, opts := range []*EncoderOptions{DefaultOptions(), {Lossless: true, Exact: true}} {
+			name := format.String() + "/lossy"
+			if opts.Lossless {
+				name = format.String() + "/lossless"
+			}
+			t.Run(name, func(t *testing.T) {
+				stride := w*format.BytesPerPixel() + 5
+				pix := rawPixels(src, format, stride)
+				// The padding after the last row is optional.
+				pix = pix[:len(pix)-5]
+				orig := append([]byte(nil), pix...)
+
+				var got bytes.Buffer
+				if err := EncodeRaw(&got, pix, w, h, stride, format, opts); err != nil {
+					t.Fatalf("EncodeRaw: %v", err)
+				}
+				if !bytes.Equal(pix, orig) {
+					t.Error("EncodeRaw modified its input")
+				}
+				if want := mustEncode(t, src, opts); !bytes.Equal(got.Bytes(), want) {
+					t.Errorf("EncodeRaw wrote %d bytes, Encode %d bytes of the same image", got.Len(), len(want))
+				}
+			})
+		}
+	}
+
+	pix := make([]byte, 4*w*h)
+	tests := []struct {
+		name                  string
+		pix                   []byte
+		width, height, stride int
+		format                PixelFormat
+		msg                   string
+	}{
+		{"bad_format", pix, w, h, 4 * w, PixelFormat(7), "invalid PixelFormat 7"},
+		{"empty", pix, 0, h, 4 * w, PixelFormatRGBA, "invalid image dimensions"},
+		{"too_large", pix, MaxDimension + 1, 1, 4 * (MaxDimension + 1), PixelFormatRGBA, "exceeds maximum"},
+		{"short_stride", pix, w, h, 3 * w, PixelFormatRGBA, "stride"},
+		{"short_buffer", pix[:4*w*h-1], w, h, 4 * w, PixelFormatRGBA, "too short"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := EncodeRaw(&bytes.Buffer{}, tc.pix, tc.width, tc.height, tc.stride, tc.format, nil)
+			if err == nil || !strings.Contains(err.Error(), tc.msg) {
+				t.Errorf("EncodeRaw = %v, want an error mentioning %q", err, tc.msg)
+			}
+		})
+	}
+}
+
+func TestDecodeRaw(t *testing.T) {
+	const w, h = 37, 21
+	translucent := gradientTestImage(w, h)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = uint8(i)
+	}
+
+	files := map[string][]byte{
+		"lossless":    mustEncode(t, translucent, &EncoderOptions{Lossless: true, Exact: true}),
+		"lossy_alpha": mustEncode(t, translucent, DefaultOptions()),
+		"lossy":       mustEncode(t, gradientTestImage(w, h), DefaultOptions()),
+	}
+	for name, data := range files {
+		t.Run(name, func(t *testing.T) {
+			rgba, width, height, stride, err := DecodeRaw(bytes.NewReader(data), PixelFormatRGBA)
+			if err != nil {
+				t.Fatalf("DecodeRaw: %v", err)
+			}
+			if width != w || height != h || stride != 4*w || len(rgba) != 4*w*h {
+				t.Fatalf("DecodeRaw = %d bytes, %dx%d, stride %d; want %d bytes, %dx%d, stride %d",
+					len(rgba), width, height, stride, 4*w*h, w, h, 4*w)
+			}
+			img, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if m, ok := img.(*image.NRGBA); ok {
+				if !bytes.Equal(rgba, m.Pix) {
+					t.Error("RGBA pixels differ from Decode")
+				}
+			} else {
+				// Opaque lossy images decode to *image.YCbCr.
+				for i := 0; i < len(rgba); i += 4 {
+					if rgba[i+3] != 0xff {
+						t.Fatalf("alpha of pixel %d = %d, want 255", i/4, rgba[i+3])
+					}
+				}
+			}
+
+			ref := &image.NRGBA{Pix: rgba, Stride: 4 * w, Rect: image.Rect(0, 0, w, h)}
+			for _, format := range []PixelFormat{PixelFormatBGRA, PixelFormatRGB, PixelFormatBGR} {
+				pix, _, _, stride, err := DecodeRaw(bytes.NewReader(data), format)
+				if err != nil {
+					t.Fatalf("DecodeRaw(%s): %v", format, err)
+				}
+				if want := rawPixels(ref, format, format.BytesPerPixel()*w); stride != format.BytesPerPixel()*w || !bytes.Equal(pix, want) {
+					t.Errorf("%s pixels (stride %d) differ from the RGBA ones", format, stride)
+				}
+			}
+		})
+	}
+
+	if _, _, _, _, err := DecodeRaw(bytes.NewReader(files["lossy"]), PixelFormat(-1)); err == nil {
+		t.Error("DecodeRaw accepted an invalid PixelFormat")
+	}
+	if _, _, _, _, err := DecodeRaw(bytes.NewReader([]byte("not a webp")), PixelFormatRGBA); err == nil {
+		t.Error("DecodeRaw accepted invalid data")
+	}
+}
@@ -0,0 +1,42 @@
+package webp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"runtime/debug"
+)
+
+// ErrCorrupt is returned by [SafeDecode] when the decoder fails on
+// malformed data in a way it does not detect as an ordinary error.
+var ErrCorrupt = errors.New("webp: corrupt data")
+
+// SafeDecode decodes a WebP image held in memory, like [DecodeBytes], with
+// a hard guarantee that it does not panic, for fuzzers and services that
+// decode untrusted files. The decoder is meant to return an error for any
+// malformed input; should a damaged Huffman or boolean-coded stream still
+// make it index out of range, the panic is recovered and returned as an
+// error wrapping [ErrCorrupt]. Built with the webpdebug tag, the stack of
+// such a panic is logged.
+func SafeDecode(data []byte) (image.Image, error) {
+	if len(data) > MaxInputSize {
+		return nil, fmt.Errorf("webp: input too large (exceeds %d bytes)", MaxInputSize)
+	}
+	return recoverDecode(func() (image.Image, error) {
+		return decodeBytes(data, nil)
+	})
+}
+
+// recoverDecode calls decode, turning a panic into an ErrCorrupt error.
+func recoverDecode(decode func() (image.Image, error)) (img image.Image, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if debugPanics {
+				log.Printf("webp: recovered decoder panic: %v\n%s", r, debug.Stack())
+			}
+			img, err = nil, fmt.Errorf("%w: %v", ErrCorrupt, r)
+		}
+	}()
+	return decode()
+}
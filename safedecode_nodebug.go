@@ -0,0 +1,6 @@
+//go:build !webpdebug
+
+package webp
+
+// debugPanics makes SafeDecode log the stack of the panics it recovers.
+const debugPanics = false
@@ -0,0 +1,74 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestSafeDecode(t *testing.T) {
+	lossy := mustEncode(t, gradientTestImage(24, 16), DefaultOptions())
+	lossless := mustEncode(t, iconTestImage(24, 16), &EncoderOptions{Lossless: true})
+	for _, data := range [][]byte{lossy, lossless} {
+		want, err := DecodeBytes(data)
+		if err != nil {
+			t.Fatalf("DecodeBytes: %v", err)
+		}
+		got, err := SafeDecode(data)
+		if err != nil {
+			t.Fatalf("SafeDecode: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Error("SafeDecode and DecodeBytes disagree")
+		}
+	}
+
+	// vp8lHeader returns a VP8L payload header for a w x h image.
+	vp8lHeader := func(w, h int) []byte {
+		bits := uint32(w-1) | uint32(h-1)<<14
+		return binary.LittleEndian.AppendUint32([]byte{0x2f}, bits)
+	}
+	lyingChunk := append([]byte("VP8L"), binary.LittleEndian.AppendUint32(nil, 1000)...)
+	lyingChunk = append(lyingChunk, vp8lHeader(8, 8)...)
+	bigPartition := append([]byte(nil), lossy...)
+	bigPartition[20] |= 0xe0 // first partition length
+	bigPartition[22] = 0xff
+
+	inputs := map[string][]byte{
+		"empty":               nil,
+		"vp8l_lying_size":     riffFile(lyingChunk),
+		"vp8l_huge_truncated": riffFile(riffChunk("VP8L", vp8lHeader(16383, 16383))),
+		"vp8l_garbage":        riffFile(riffChunk("VP8L", append(vp8lHeader(8, 8), bytes.Repeat([]byte{0xff}, 64)...))),
+		"vp8l_zero_bits":      riffFile(riffChunk("VP8L", append(vp8lHeader(64, 64), make([]byte, 32)...))),
+		"vp8_partition":       bigPartition,
+		"alph_garbage": riffFile(riffChunk("VP8X", vp8xPayload(0x10, 24, 16)),
+			riffChunk("ALPH", append([]byte{1}, bytes.Repeat([]byte{0xa5}, 40)...)), lossy[12:]),
+	}
+	// Truncated files whose RIFF and chunk sizes are patched to match.
+	for n := 20; n < len(lossless); n += 7 {
+		truncated := append([]byte(nil), lossless[:n]...)
+		binary.LittleEndian.PutUint32(truncated[4:], uint32(n-8))
+		binary.LittleEndian.PutUint32(truncated[16:], uint32(n-20))
+		inputs[fmt.Sprintf("vp8l_truncated_%d", n)] = truncated
+	}
+	for name, data := range inputs {
+		t.Run(name, func(t *testing.T) {
+			if _, err := SafeDecode(data); err == nil {
+				t.Error("SafeDecode accepted malformed data")
+			}
+		})
+	}
+
+	img, err := recoverDecode(func() (image.Image, error) {
+		var s []color.Color
+		return nil, s[3].(error)
+	})
+	if img != nil || !errors.Is(err, ErrCorrupt) {
+		t.Errorf("recoverDecode of a panic = %v, %v; want ErrCorrupt", img, err)
+	}
+}
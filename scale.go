@@ -0,0 +1,95 @@
+package webp
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/deepteams/webp/internal/dsp"
+)
+
+// fitSize returns the size of a w x h image scaled down, preserving its
+// aspect ratio, to fit within box. A box dimension <= 0 leaves that axis
+// unconstrained, and an image that already fits keeps its size.
+func fitSize(w, h int, box image.Point) (int, int) {
+	// The scale factor is num/den, at most 1.
+	num, den := 1, 1
+	if box.X > 0 && box.X < w {
+		num, den = box.X, w
+	}
+	if box.Y > 0 && box.Y*den < h*num {
+		num, den = box.Y, h
+	}
+	if num == den {
+		return w, h
+	}
+	// Rounding to nearest cannot overflow the box: the constrained axis
+	// comes out exact.
+	return max(1, (w*num+den/2)/den), max(1, (h*num+den/2)/den)
+}
+
+// fitWithin returns img scaled down to fit within box, as described by
+// DecodeOptions.FitWithin, or img itself if it already fits.
+func fitWithin(img image.Image, box image.Point) image.Image {
+	b := img.Bounds()
+	w, h := fitSize(b.Dx(), b.Dy(), box)
+	if w == b.Dx() && h == b.Dy() {
+		return img
+	}
+	var src *image.NRGBA
+	switch t := img.(type) {
+	case *image.NRGBA:
+		src = t
+	case *image.YCbCr:
+		src = ycbcrToNRGBA(t)
+	default:
+		src = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+	}
+	return scaleNRGBA(src, w, h)
+}
+
+// scaleNRGBA resamples src to w x h pixels with the libwebp box-filter
+// rescaler, one rescaler per channel. Color is averaged premultiplied by
+// alpha, so that the color of transparent pixels does not bleed into their
+// neighbours.
+func scaleNRGBA(src *image.NRGBA, w, h int) *image.NRGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	opaque := src.Opaque()
+	var rs [4]dsp.Rescaler
+	for c := range rs {
+		dsp.RescalerInit(&rs[c], sw, sh, w, h)
+	}
+	in := make([]byte, 4*sw)
+	plane := make([]byte, sw)
+	out := make([]byte, w)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	y := 0
+	for sy := 0; sy < sh; sy++ {
+		copy(in, src.Pix[src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+sy):])
+		if !opaque {
+			dsp.ApplyAlphaMultiply(in, false, sw, 1, len(in), false)
+		}
+		for c := range rs {
+			for x := range plane {
+				plane[x] = in[4*x+c]
+			}
+			dsp.RescalerImportRow(&rs[c], plane)
+		}
+		// The rescalers advance in step: all have a row ready or none.
+		for y < h && dsp.RescalerHasDstRow(&rs[0]) {
+			row := dst.Pix[y*dst.Stride:][:4*w]
+			for c := range rs {
+				dsp.RescalerExportRow(&rs[c], out)
+				for x, v := range out {
+					row[4*x+c] = v
+				}
+			}
+			y++
+		}
+	}
+	if !opaque {
+		dsp.ApplyAlphaMultiply(dst.Pix, false, w, h, dst.Stride, true)
+	}
+	return dst
+}
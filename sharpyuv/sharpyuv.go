@@ -18,6 +18,7 @@ type Options struct {
 	Matrix       *ConversionMatrix
 	TransferType TransferFunc
 	SharpEnabled bool // When false, use standard (averaging) downsampling
+	Iterations   int  // Maximum refinement passes; <= 0 uses the libwebp default (4)
 }
 
 // DefaultOptions returns default options using the WebP matrix and sRGB transfer.
@@ -60,7 +61,11 @@ func Convert(rgb []byte, width, height, rgbStride int, yuv *image.YCbCr, opts *O
 	if !opts.SharpEnabled {
 		return convertStandard(rgb, width, height, rgbStride, yuv, opts.Matrix)
 	}
-	return convertSharp(rgb, width, height, rgbStride, yuv, opts.Matrix, opts.TransferType)
+	iterations := opts.Iterations
+	if iterations <= 0 {
+		iterations = numIterations
+	}
+	return convertSharp(rgb, width, height, rgbStride, yuv, opts.Matrix, opts.TransferType, iterations)
 }
 
 // --- Standard (simple averaging) conversion ---
@@ -167,7 +172,7 @@ func clipBitDepth(y, bitDepth int) uint16 {
 	return uint16(y)
 }
 
-func convertSharp(rgb []byte, width, height, rgbStride int, yuv *image.YCbCr, matrix *ConversionMatrix, tf TransferFunc) error {
+func convertSharp(rgb []byte, width, height, rgbStride int, yuv *image.YCbCr, matrix *ConversionMatrix, tf TransferFunc, iterations int) error {
 	initGammaTables()
 
 	w := (width + 1) & ^1  // round up to even
@@ -221,7 +226,7 @@ func convertSharp(rgb []byte, width, height, rgbStride int, yuv *image.YCbCr, ma
 	diffYThreshold := uint64(3 * w * h)
 	prevDiffYSum := ^uint64(0)
 
-	for iter := 0; iter < numIterations; iter++ {
+	for iter := 0; iter < iterations; iter++ {
 		var diffYSum uint64
 
 		for j := 0; j < h; j += 2 {
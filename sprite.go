@@ -0,0 +1,90 @@
+package webp
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"slices"
+)
+
+// PackSprites lays out imgs on one transparent canvas, encodes it to w with
+// opts, and returns where each image was placed, keyed like imgs.
+//
+// Images are packed in rows ("shelves"), tallest first, on a canvas about
+// as wide as it is tall; ties are broken by width and then by key, so the
+// same input always gives the same atlas. Sprites touch each other: lossy
+// compression blurs across their edges, so opts.Lossless is usually wanted.
+// If opts is nil, DefaultOptions() is used.
+func PackSprites(w io.Writer, imgs map[string]image.Image, opts *EncoderOptions) (map[string]image.Rectangle, error) {
+	if w == nil {
+		return nil, errors.New("webp: nil writer")
+	}
+	if len(imgs) == 0 {
+		return nil, errors.New("webp: no sprites")
+	}
+	keys := make([]string, 0, len(imgs))
+	area, maxWidth := 0, 0
+	for k, img := range imgs {
+		if img == nil {
+			return nil, fmt.Errorf("webp: sprite %q: nil image", k)
+		}
+		b := img.Bounds()
+		if b.Empty() {
+			return nil, fmt.Errorf("webp: sprite %q: empty image", k)
+		}
+		keys = append(keys, k)
+		area += b.Dx() * b.Dy()
+		maxWidth = max(maxWidth, b.Dx())
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		ra, rb := imgs[a].Bounds(), imgs[b].Bounds()
+		if c := cmp.Compare(rb.Dy(), ra.Dy()); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(rb.Dx(), ra.Dx()); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+
+	rects := packShelves(keys, imgs, max(maxWidth, int(math.Ceil(math.Sqrt(float64(area))))))
+	var size image.Rectangle
+	for _, r := range rects {
+		size = size.Union(r)
+	}
+	if size.Dx() > MaxDimension || size.Dy() > MaxDimension {
+		return nil, fmt.Errorf("webp: sprite sheet %dx%d exceeds maximum %d", size.Dx(), size.Dy(), MaxDimension)
+	}
+
+	canvas := image.NewNRGBA(size)
+	for k, r := range rects {
+		img := imgs[k]
+		draw.Draw(canvas, r, img, img.Bounds().Min, draw.Src)
+	}
+	if err := Encode(w, canvas, opts); err != nil {
+		return nil, err
+	}
+	return rects, nil
+}
+
+// packShelves places the images of keys, in order, left to right in rows no
+// wider than width (or as wide as a single wider image), starting a new row
+// below the tallest image of the previous one.
+func packShelves(keys []string, imgs map[string]image.Image, width int) map[string]image.Rectangle {
+	rects := make(map[string]image.Rectangle, len(keys))
+	x, y, rowHeight := 0, 0, 0
+	for _, k := range keys {
+		b := imgs[k].Bounds()
+		if x > 0 && x+b.Dx() > width {
+			x, y, rowHeight = 0, y+rowHeight, 0
+		}
+		rects[k] = image.Rect(x, y, x+b.Dx(), y+b.Dy())
+		x += b.Dx()
+		rowHeight = max(rowHeight, b.Dy())
+	}
+	return rects
+}
@@ -0,0 +1,75 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPackSprites(t *testing.T) {
+	imgs := map[string]image.Image{
+		"wide":   solidImage(40, 8, color.NRGBA{R: 255, A: 255}),
+		"tall":   solidImage(8, 30, color.NRGBA{G: 255, A: 255}),
+		"square": solidImage(16, 16, color.NRGBA{B: 255, A: 255}),
+		"tiny":   solidImage(3, 3, color.NRGBA{R: 255, G: 255, A: 128}),
+		"offset": gradientTestImage(40, 40).SubImage(image.Rect(10, 5, 30, 17)),
+	}
+	opts := &EncoderOptions{Lossless: true, Quality: 75, Method: 4}
+	var buf bytes.Buffer
+	rects, err := PackSprites(&buf, imgs, opts)
+	if err != nil {
+		t.Fatalf("PackSprites: %v", err)
+	}
+	got, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	atlas := got.(*image.NRGBA)
+
+	if len(rects) != len(imgs) {
+		t.Fatalf("got %d rects, want %d", len(rects), len(imgs))
+	}
+	for k, r := range rects {
+		src := imgs[k]
+		if r.Size() != src.Bounds().Size() {
+			t.Errorf("%s: rect %v, want size %v", k, r, src.Bounds().Size())
+		}
+		if !r.In(atlas.Bounds()) {
+			t.Errorf("%s: rect %v outside the %v atlas", k, r, atlas.Bounds())
+		}
+		for k2, r2 := range rects {
+			if k < k2 && r.Overlaps(r2) {
+				t.Errorf("%s %v overlaps %s %v", k, r, k2, r2)
+			}
+		}
+		sb := src.Bounds()
+		for y := 0; y < r.Dy(); y++ {
+			for x := 0; x < r.Dx(); x++ {
+				want := color.NRGBAModel.Convert(src.At(sb.Min.X+x, sb.Min.Y+y))
+				if c := atlas.NRGBAAt(r.Min.X+x, r.Min.Y+y); c != want {
+					t.Fatalf("%s: pixel (%d, %d) = %v, want %v", k, x, y, c, want)
+				}
+			}
+		}
+	}
+
+	// The layout does not depend on map iteration order.
+	var again bytes.Buffer
+	if _, err := PackSprites(&again, imgs, opts); err != nil {
+		t.Fatalf("PackSprites: %v", err)
+	}
+	if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		t.Error("PackSprites output is not deterministic")
+	}
+
+	for name, bad := range map[string]map[string]image.Image{
+		"empty":     {},
+		"nil_image": {"a": nil},
+		"too_wide":  {"a": image.NewNRGBA(image.Rect(0, 0, MaxDimension+1, 1))},
+	} {
+		if _, err := PackSprites(&bytes.Buffer{}, bad, opts); err == nil {
+			t.Errorf("%s: PackSprites accepted invalid input", name)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/deepteams/webp/internal/container"
+)
+
+// MetadataFlags selects metadata chunks, for [StripMetadata].
+type MetadataFlags uint8
+
+const (
+	MetadataICC  MetadataFlags = 1 << iota // ICC color profile (ICCP chunk)
+	MetadataEXIF                           // EXIF metadata (EXIF chunk)
+	MetadataXMP                            // XMP metadata (XMP chunk)
+
+	MetadataNone MetadataFlags = 0
+	MetadataAll                = MetadataICC | MetadataEXIF | MetadataXMP
+)
+
+// VP8X feature flags of the metadata chunks.
+const (
+	vp8xFlagXMP  = 1 << 2
+	vp8xFlagEXIF = 1 << 3
+	vp8xFlagICC  = 1 << 5
+)
+
+// StripMetadata copies the WebP file in r to w without the metadata chunks
+// that keep does not select; for example MetadataICC removes EXIF and XMP,
+// which can carry a location or the camera owner's identity, but keeps the
+// color profile. The VP8X flags of the removed chunks are cleared, and a
+// still image left with nothing that needs the extended format (alpha
+// carried in an ALPH chunk, metadata or unknown chunks) is rewritten in the
+// simple format. Image data is copied as is, never re-encoded.
+func StripMetadata(w io.Writer, r io.Reader, keep MetadataFlags) error {
+	if w == nil {
+		return errors.New("webp: nil writer")
+	}
+	if r == nil {
+		return errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return fmt.Errorf("webp: reading data: %w", err)
+	}
+	if _, err := container.NewParser(data); err != nil {
+		return fmt.Errorf("webp: parsing container: %w", err)
+	}
+	hdr, _, err := container.ParseRIFFHeader(data)
+	if err != nil {
+		return err
+	}
+	end := min(len(data), container.ChunkHeaderSize+int(hdr.FileSize))
+
+	drop := map[uint32]bool{
+		container.FourCCICCP: keep&MetadataICC == 0,
+		container.FourCCEXIF: keep&MetadataEXIF == 0,
+		container.FourCCXMP:  keep&MetadataXMP == 0,
+	}
+	var (
+		chunks    [][]byte // kept chunks, with header and padding
+		vp8x      []byte   // copy of the VP8X chunk, nil in the simple format
+		bitstream []byte   // the VP8/VP8L chunk of a still image
+		extended  bool     // a kept chunk needs the VP8X format
+	)
+	for off := container.RIFFHeaderSize; off+container.ChunkHeaderSize <= end; {
+		fourcc, size, err := container.ReadChunkHeader(data[off:])
+		if err != nil {
+			return err
+		}
+		n := min(end-off, container.ChunkHeaderSize+int(container.PaddedSize(size)))
+		chunk := data[off : off+n]
+		off += n
+		switch {
+		case drop[fourcc]:
+			continue
+		case fourcc == container.FourCCVP8X:
+			vp8x = append([]byte(nil), chunk...)
+			chunk = vp8x
+		case fourcc == container.FourCCVP8 || fourcc == container.FourCCVP8L:
+			if bitstream != nil {
+				extended = true // not a single still image
+			}
+			bitstream = chunk
+		default:
+			extended = true
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if vp8x != nil {
+		payload := vp8x[container.ChunkHeaderSize:]
+		for fourcc, flag := range map[uint32]byte{
+			container.FourCCICCP: vp8xFlagICC,
+			container.FourCCEXIF: vp8xFlagEXIF,
+			container.FourCCXMP:  vp8xFlagXMP,
+		} {
+			if drop[fourcc] {
+				payload[0] &^= flag
+			}
+		}
+		if !extended && bitstream != nil {
+			// Only VP8X and the bitstream are left: write a simple file.
+			chunks = [][]byte{bitstream}
+		}
+	}
+
+	size := 4
+	for _, c := range chunks {
+		size += len(c)
+	}
+	out := make([]byte, 0, container.ChunkHeaderSize+size)
+	out = binary.LittleEndian.AppendUint32(out, container.FourCCRIFF)
+	out = binary.LittleEndian.AppendUint32(out, uint32(size))
+	out = binary.LittleEndian.AppendUint32(out, container.FourCCWEBP)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	_, err = w.Write(out)
+	return err
+}
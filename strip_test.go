@@ -0,0 +1,160 @@
+package webp
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deepteams/webp/animation"
+)
+
+func chunkTags(t *testing.T, data []byte) string {
+	t.Helper()
+	chunks, err := Chunks(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Chunks: %v", err)
+	}
+	var tags []string
+	for _, c := range chunks {
+		tags = append(tags, c.FourCC)
+	}
+	return fmt.Sprint(tags)
+}
+
+func TestStripMetadata(t *testing.T) {
+	translucent := gradientTestImage(32, 24)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = uint8(128 + i%100)
+	}
+	withMeta := func(lossless bool) *EncoderOptions {
+		opts := DefaultOptions()
+		opts.Lossless = lossless
+		opts.ICC = []byte("icc profile")
+		opts.EXIF = []byte("exif with GPS")
+		opts.XMP = []byte("<xmp/>")
+		return opts
+	}
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		opts     *EncoderOptions
+		keep     MetadataFlags
+		wantTags string
+		wantMeta Metadata
+	}{
+		{
+			name: "keep_icc", img: gradientTestImage(32, 24), opts: withMeta(false), keep: MetadataICC,
+			wantTags: "[VP8X ICCP VP8 ]",
+			wantMeta: Metadata{ICC: []byte("icc profile"), HasICC: true},
+		},
+		{
+			name: "keep_all", img: gradientTestImage(32, 24), opts: withMeta(false), keep: MetadataAll,
+			wantTags: "[VP8X ICCP VP8  EXIF XMP ]",
+			wantMeta: Metadata{
+				ICC: []byte("icc profile"), EXIF: []byte("exif with GPS"), XMP: []byte("<xmp/>"),
+				HasICC: true, HasEXIF: true, HasXMP: true,
+			},
+		},
+		{
+			name: "lossy_to_simple", img: gradientTestImage(32, 24), opts: withMeta(false), keep: MetadataNone,
+			wantTags: "[VP8 ]",
+		},
+		{
+			name: "lossless_alpha_to_simple", img: translucent, opts: withMeta(true), keep: MetadataNone,
+			wantTags: "[VP8L]",
+		},
+		{
+			name: "lossy_alpha_stays_extended", img: translucent, opts: withMeta(false), keep: MetadataNone,
+			wantTags: "[VP8X ALPH VP8 ]",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var src bytes.Buffer
+			if err := Encode(&src, tc.img, tc.opts); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			var out bytes.Buffer
+			if err := StripMetadata(&out, bytes.NewReader(src.Bytes()), tc.keep); err != nil {
+				t.Fatalf("StripMetadata: %v", err)
+			}
+
+			if got := chunkTags(t, out.Bytes()); got != tc.wantTags {
+				t.Errorf("chunks = %s, want %s", got, tc.wantTags)
+			}
+			m, err := ReadMetadata(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("ReadMetadata: %v", err)
+			}
+			if !reflect.DeepEqual(*m, tc.wantMeta) {
+				t.Errorf("metadata = %+v, want %+v", *m, tc.wantMeta)
+			}
+			feat, err := GetFeatures(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("GetFeatures: %v", err)
+			}
+			if feat.Format == "extended" {
+				// The VP8X flags describe what is left.
+				flags := out.Bytes()[20]
+				want := byte(0)
+				if tc.wantMeta.HasICC {
+					want |= vp8xFlagICC
+				}
+				if tc.wantMeta.HasEXIF {
+					want |= vp8xFlagEXIF
+				}
+				if tc.wantMeta.HasXMP {
+					want |= vp8xFlagXMP
+				}
+				if got := flags &^ (1 << 4); got != want {
+					t.Errorf("VP8X flags = %#x, want %#x", got, want)
+				}
+			}
+
+			want, err := Decode(bytes.NewReader(src.Bytes()))
+			if err != nil {
+				t.Fatalf("Decode original: %v", err)
+			}
+			got, err := Decode(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("Decode stripped: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Error("stripped image decodes differently")
+			}
+		})
+	}
+
+	t.Run("animation", func(t *testing.T) {
+		var src bytes.Buffer
+		enc := animation.NewEncoder(&src, 16, 16, nil)
+		for i := 0; i < 2; i++ {
+			img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+			img.SetNRGBA(i, i, color.NRGBA{R: 255, A: 255})
+			if err := enc.AddFrame(img, 50*time.Millisecond); err != nil {
+				t.Fatalf("AddFrame: %v", err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		var out bytes.Buffer
+		if err := StripMetadata(&out, bytes.NewReader(src.Bytes()), MetadataNone); err != nil {
+			t.Fatalf("StripMetadata: %v", err)
+		}
+		if !bytes.Equal(out.Bytes(), src.Bytes()) {
+			t.Error("animation without metadata changed")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if err := StripMetadata(&bytes.Buffer{}, bytes.NewReader([]byte("not a webp")), MetadataNone); err == nil {
+			t.Error("StripMetadata accepted invalid data")
+		}
+	})
+}
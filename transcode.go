@@ -0,0 +1,139 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/deepteams/webp/internal/container"
+	"github.com/deepteams/webp/internal/lossy"
+)
+
+// ErrUnsupportedTranscode is returned by [Requantize] for inputs that are
+// not a still lossy (VP8) image, such as lossless or animated files.
+var ErrUnsupportedTranscode = errors.New("webp: transcoding requires a still lossy image")
+
+// ErrNotLossless is returned by [OptimizeLossless] for inputs that are not
+// a still lossless (VP8L) image, such as lossy or animated files.
+var ErrNotLossless = errors.New("webp: optimizing requires a still lossless image")
+
+// Requantize re-encodes a still lossy WebP read from r at newQuality (0-100)
+// and writes the result to w. The VP8 bitstream is decoded to its Y'CbCr
+// planes, which are fed straight back to the encoder, so the lossy
+// RGB->YUV round trip of decoding to RGB and calling [Encode] is avoided.
+// The alpha (ALPH) chunk and the ICC, EXIF and XMP metadata are copied
+// unchanged.
+//
+// Lossless and animated inputs return [ErrUnsupportedTranscode].
+func Requantize(w io.Writer, r io.Reader, newQuality float32) error {
+	if w == nil {
+		return errors.New("webp: nil writer")
+	}
+	if r == nil {
+		return errors.New("webp: nil reader")
+	}
+	if newQuality < 0 || newQuality > 100 || math.IsNaN(float64(newQuality)) {
+		return fmt.Errorf("webp: invalid Quality %.2f (must be 0-100, finite)", newQuality)
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return fmt.Errorf("webp: reading data: %w", err)
+	}
+
+	p, err := container.NewParser(data)
+	if err != nil {
+		return fmt.Errorf("webp: parsing container: %w", err)
+	}
+	frames := p.Frames()
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+	if p.Features().HasAnim || len(frames) > 1 || frames[0].IsLossless {
+		return ErrUnsupportedTranscode
+	}
+	frame := frames[0]
+
+	dec, width, height, yPlane, yStride, uPlane, vPlane, uvStride, err := lossy.DecodeFrame(frame.Payload)
+	if err != nil {
+		return fmt.Errorf("webp: lossy decode: %w", err)
+	}
+	yuv := buildYCbCr(width, height, yPlane, yStride, uPlane, vPlane, uvStride)
+	lossy.ReleaseDecoder(dec)
+	if yuv == nil {
+		return fmt.Errorf("webp: image too large (%dx%d)", width, height)
+	}
+
+	opts := DefaultOptions()
+	opts.Quality = newQuality
+	m := metadataFromParser(p)
+	opts.ICC, opts.EXIF, opts.XMP = m.ICC, m.EXIF, m.XMP
+
+	cfg, err := lossyConfig(opts, width, height)
+	if err != nil {
+		return err
+	}
+	enc := lossy.NewEncoderFromYUV(yuv, width, height, cfg)
+	defer lossy.ReleaseEncoder(enc)
+
+	bs, err := enc.EncodeFrame()
+	if err != nil {
+		return fmt.Errorf("webp: lossy encode: %w", err)
+	}
+	return writeRIFF(w, container.FourCCVP8, bs, frame.AlphaData, width, height, opts)
+}
+
+// OptimizeLossless re-compresses a still lossless WebP read from r at the
+// maximum lossless effort (LosslessEffort 9) and writes the result to w if
+// it is smaller than the input; otherwise the input is copied to w
+// unchanged. The pixels, including the color of fully transparent pixels,
+// are preserved exactly, and the ICC, EXIF and XMP metadata are copied.
+//
+// Lossy and animated inputs return [ErrNotLossless].
+func OptimizeLossless(w io.Writer, r io.Reader) error {
+	if w == nil {
+		return errors.New("webp: nil writer")
+	}
+	if r == nil {
+		return errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return fmt.Errorf("webp: reading data: %w", err)
+	}
+
+	p, err := container.NewParser(data)
+	if err != nil {
+		return fmt.Errorf("webp: parsing container: %w", err)
+	}
+	frames := p.Frames()
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+	if p.Features().HasAnim || len(frames) > 1 || !frames[0].IsLossless {
+		return ErrNotLossless
+	}
+	img, err := decodeLossless(frames[0].Payload, nil)
+	if err != nil {
+		return err
+	}
+
+	opts := DefaultOptions()
+	opts.Lossless = true
+	opts.LosslessEffort = 9
+	opts.Exact = true
+	m := metadataFromParser(p)
+	opts.ICC, opts.EXIF, opts.XMP = m.ICC, m.EXIF, m.XMP
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, opts); err != nil {
+		return err
+	}
+	out := data
+	if buf.Len() < len(data) {
+		out = buf.Bytes()
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,176 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/deepteams/webp/animation"
+)
+
+func TestRequantize(t *testing.T) {
+	const W, H = 96, 64
+	var src bytes.Buffer
+	if err := Encode(&src, richTestImage(W, H), &EncoderOptions{Quality: 95, Method: 4}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Requantize(&out, bytes.NewReader(src.Bytes()), 30); err != nil {
+		t.Fatalf("Requantize: %v", err)
+	}
+	if out.Len() >= src.Len() {
+		t.Errorf("requantized size = %d, want < original %d", out.Len(), src.Len())
+	}
+
+	img, err := Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode requantized: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != W || b.Dy() != H {
+		t.Errorf("decoded size = %dx%d, want %dx%d", b.Dx(), b.Dy(), W, H)
+	}
+}
+
+func TestRequantize_KeepsAlphaAndMetadata(t *testing.T) {
+	const W, H = 32, 32
+	img := gradientTestImage(W, H)
+	for x := 0; x < W; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{R: 10, G: 20, B: 30, A: 40})
+	}
+	exif := []byte("Exif\x00\x00test")
+	var src bytes.Buffer
+	if err := Encode(&src, img, &EncoderOptions{Quality: 90, Method: 4, EXIF: exif}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Requantize(&out, bytes.NewReader(src.Bytes()), 50); err != nil {
+		t.Fatalf("Requantize: %v", err)
+	}
+	feat, err := GetFeatures(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("GetFeatures: %v", err)
+	}
+	if !feat.HasAlpha {
+		t.Error("requantized image lost its alpha channel")
+	}
+	m, err := ReadMetadata(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if !bytes.Equal(m.EXIF, exif) {
+		t.Errorf("EXIF = %q, want %q", m.EXIF, exif)
+	}
+}
+
+func TestRequantize_Unsupported(t *testing.T) {
+	var ll bytes.Buffer
+	if err := Encode(&ll, solidImage(8, 8, color.NRGBA{R: 200, A: 255}), &EncoderOptions{Lossless: true}); err != nil {
+		t.Fatalf("Encode lossless: %v", err)
+	}
+
+	var anim bytes.Buffer
+	enc := animation.NewEncoder(&anim, 8, 8, nil)
+	for i := 0; i < 2; i++ {
+		frame := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+		frame.SetNRGBA(i, i, color.NRGBA{G: 255, A: 255})
+		if err := enc.AddFrame(frame, 100*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	for name, data := range map[string][]byte{"lossless": ll.Bytes(), "animated": anim.Bytes()} {
+		err := Requantize(io.Discard, bytes.NewReader(data), 50)
+		if !errors.Is(err, ErrUnsupportedTranscode) {
+			t.Errorf("%s: err = %v, want ErrUnsupportedTranscode", name, err)
+		}
+	}
+
+	if err := Requantize(io.Discard, bytes.NewReader(ll.Bytes()), 101); err == nil {
+		t.Error("quality 101: expected error")
+	}
+}
+
+func TestOptimizeLossless(t *testing.T) {
+	const W, H = 96, 64
+	img := richTestImage(W, H)
+	// Fully transparent pixels whose color must survive.
+	for x := 0; x < W; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{R: uint8(x), G: 7, B: 99, A: 0})
+	}
+	xmp := []byte("<x:xmpmeta/>")
+	var src bytes.Buffer
+	if err := Encode(&src, img, &EncoderOptions{Lossless: true, LosslessEffort: 0, Exact: true, XMP: xmp}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := OptimizeLossless(&out, bytes.NewReader(src.Bytes())); err != nil {
+		t.Fatalf("OptimizeLossless: %v", err)
+	}
+	if out.Len() >= src.Len() {
+		t.Errorf("optimized size = %d, want < original %d", out.Len(), src.Len())
+	}
+	dec, err := Decode(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode optimized: %v", err)
+	}
+	if got := dec.(*image.NRGBA); !bytes.Equal(got.Pix, img.Pix) {
+		t.Error("optimized image pixels differ from the source")
+	}
+	m, err := ReadMetadata(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if !bytes.Equal(m.XMP, xmp) {
+		t.Errorf("XMP = %q, want %q", m.XMP, xmp)
+	}
+
+	// An already optimized file is copied through unchanged.
+	var again bytes.Buffer
+	if err := OptimizeLossless(&again, bytes.NewReader(out.Bytes())); err != nil {
+		t.Fatalf("OptimizeLossless (second pass): %v", err)
+	}
+	if !bytes.Equal(again.Bytes(), out.Bytes()) {
+		t.Errorf("second pass changed the file (%d -> %d bytes)", out.Len(), again.Len())
+	}
+}
+
+func TestOptimizeLossless_NotLossless(t *testing.T) {
+	var lossy bytes.Buffer
+	if err := Encode(&lossy, solidImage(8, 8, color.NRGBA{R: 200, A: 255}), nil); err != nil {
+		t.Fatalf("Encode lossy: %v", err)
+	}
+
+	var anim bytes.Buffer
+	enc := animation.NewEncoder(&anim, 8, 8, &animation.EncodeOptions{Lossless: true})
+	for i := 0; i < 2; i++ {
+		frame := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+		frame.SetNRGBA(i, i, color.NRGBA{G: 255, A: 255})
+		if err := enc.AddFrame(frame, 100*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	for name, data := range map[string][]byte{"lossy": lossy.Bytes(), "animated": anim.Bytes()} {
+		var out bytes.Buffer
+		err := OptimizeLossless(&out, bytes.NewReader(data))
+		if !errors.Is(err, ErrNotLossless) {
+			t.Errorf("%s: err = %v, want ErrNotLossless", name, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("%s: wrote %d bytes on error", name, out.Len())
+		}
+	}
+}
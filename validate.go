@@ -0,0 +1,388 @@
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/deepteams/webp/internal/container"
+)
+
+// ErrInvalidStructure is wrapped by the errors [Validate] returns for a
+// WebP file whose chunks are inconsistent with each other.
+var ErrInvalidStructure = errors.New("webp: invalid file structure")
+
+// Validate checks the structure of the WebP file in r without decoding it:
+// every chunk must fit in the file and be where the format allows it, the
+// VP8X flags must match the chunks present, every VP8 and VP8L bitstream
+// must start with a valid frame header, and every ANMF frame must hold a
+// bitstream of its own size that fits in the canvas. The entropy-coded
+// data is not read, so a file that passes can still fail to decode.
+//
+// Validate returns nil for a well-formed file. Otherwise it returns an
+// error describing the first problem found, which wraps ErrInvalidFormat if
+// r does not hold a WebP file, ErrUnexpectedEOF if the file is cut short,
+// ErrChecksumMismatch if a CKSM chunk (see EncoderOptions.EmbedChecksum)
+// does not match the image data, and ErrInvalidStructure for anything else.
+func Validate(r io.Reader) error {
+	if r == nil {
+		return errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return fmt.Errorf("webp: reading data: %w", err)
+	}
+	return validate(data)
+}
+
+// checkEncoded checks a file the encoder produced for a width x height
+// image, for EncoderOptions.Strict: it must pass validate, its padding
+// bytes must be zero, and its canvas must have the image's size.
+func checkEncoded(data []byte, width, height int) error {
+	if err := validate(data); err != nil {
+		return err
+	}
+	// validate accepted the RIFF header and chunk layout.
+	chunks, _ := validateChunks(data, container.RIFFHeaderSize, nil)
+	var w, h int
+	for _, c := range chunks {
+		if n := len(c.payload); n&1 == 1 && data[c.offset+container.ChunkHeaderSize+n] != 0 {
+			return invalidStructure("%v has a nonzero padding byte", c)
+		}
+		switch c.fourcc {
+		case container.FourCCVP8X:
+			w, h = le24(c.payload[4:])+1, le24(c.payload[7:])+1
+		case container.FourCCVP8, container.FourCCVP8L:
+			if w == 0 {
+				w, h, _ = validateBitstream(c)
+			}
+		}
+	}
+	if w != width || h != height {
+		return invalidStructure("canvas is %dx%d, want %dx%d", w, h, width, height)
+	}
+	return nil
+}
+
+// validChunk is a chunk found by validate.
+type validChunk struct {
+	fourcc  uint32
+	offset  int // of the chunk header in the file
+	payload []byte
+}
+
+func (c validChunk) String() string {
+	return fmt.Sprintf("%s chunk at offset %d", container.FourCCString(c.fourcc), c.offset)
+}
+
+func invalidStructure(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidStructure, fmt.Sprintf(format, args...))
+}
+
+func validate(data []byte) error {
+	hdr, _, err := container.ParseRIFFHeader(data)
+	if err != nil {
+		return err
+	}
+	end := container.ChunkHeaderSize + int(hdr.FileSize)
+	if end > len(data) {
+		return fmt.Errorf("%w: RIFF size %d exceeds the %d bytes of data", ErrUnexpectedEOF, hdr.FileSize, len(data)-container.ChunkHeaderSize)
+	}
+	if end < len(data) {
+		return invalidStructure("%d bytes of trailing data after the RIFF chunk", len(data)-end)
+	}
+
+	chunks, err := validateChunks(data[:end], container.RIFFHeaderSize, func(c validChunk) error {
+		return fmt.Errorf("%w: %v overruns the RIFF chunk", ErrUnexpectedEOF, c)
+	})
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return invalidStructure("no chunks")
+	}
+
+	first := chunks[0]
+	switch first.fourcc {
+	case container.FourCCVP8, container.FourCCVP8L:
+		if len(chunks) > 1 {
+			return invalidStructure("%v follows the image of a simple format file", chunks[1])
+		}
+		_, _, err := validateBitstream(first)
+		return err
+	case container.FourCCVP8X:
+		if err := validateExtended(chunks); err != nil {
+			return err
+		}
+		return validateChecksum(data[:end], chunks)
+	default:
+		return invalidStructure("first chunk is %v, want VP8, VP8L or VP8X", first)
+	}
+}
+
+// validateChunks splits buf[off:] into chunks. overrun returns the error
+// for a chunk that does not fit in buf.
+func validateChunks(buf []byte, off int, overrun func(validChunk) error) ([]validChunk, error) {
+	var chunks []validChunk
+	for off < len(buf) {
+		if len(buf)-off < container.ChunkHeaderSize {
+			return nil, fmt.Errorf("%w: %d bytes at offset %d are too short for a chunk header", ErrUnexpectedEOF, len(buf)-off, off)
+		}
+		fourcc := binary.LittleEndian.Uint32(buf[off:])
+		size := binary.LittleEndian.Uint32(buf[off+4:])
+		c := validChunk{fourcc: fourcc, offset: off}
+		start := off + container.ChunkHeaderSize
+		if uint64(size)+uint64(size&1) > uint64(len(buf)-start) {
+			return nil, overrun(c)
+		}
+		c.payload = buf[start : start+int(size)]
+		chunks = append(chunks, c)
+		off = start + int(container.PaddedSize(size))
+	}
+	return chunks, nil
+}
+
+// validateBitstream checks the frame header of a VP8 or VP8L chunk and
+// returns the image size it declares.
+func validateBitstream(c validChunk) (width, height int, err error) {
+	if c.fourcc == container.FourCCVP8 {
+		width, height, err = container.ParseVP8Header(c.payload)
+	} else {
+		width, height, _, err = container.ParseVP8LHeader(c.payload)
+	}
+	if err != nil {
+		return 0, 0, invalidStructure("%v: %v", c, err)
+	}
+	return width, height, nil
+}
+
+// validateAlpha checks the header of an ALPH chunk for an image of the
+// given size.
+func validateAlpha(c validChunk, width, height int) error {
+	if len(c.payload) < container.AlphaHeaderLen {
+		return invalidStructure("%v is empty", c)
+	}
+	h := c.payload[0]
+	method, preprocessing, reserved := h&0x03, h>>4&0x03, h>>6
+	switch {
+	case method > container.AlphaLosslessCompression:
+		return invalidStructure("%v: unknown compression method %d", c, method)
+	case preprocessing > container.AlphaPreprocessedLevels:
+		return invalidStructure("%v: unknown preprocessing %d", c, preprocessing)
+	case reserved != 0:
+		return invalidStructure("%v: reserved header bits set", c)
+	case method == container.AlphaNoCompression && len(c.payload)-container.AlphaHeaderLen < width*height:
+		return invalidStructure("%v holds %d alpha values for a %dx%d image", c, len(c.payload)-container.AlphaHeaderLen, width, height)
+	}
+	return nil
+}
+
+// validateImage checks the image chunks of a still image or of an ANMF
+// frame: an optional ALPH chunk, then a VP8 chunk, or a VP8L chunk alone.
+// Unknown chunks may follow. It returns the image size.
+func validateImage(chunks []validChunk, where string) (width, height int, err error) {
+	var alph *validChunk
+	for i := range chunks {
+		c := chunks[i]
+		switch c.fourcc {
+		case container.FourCCALPH:
+			if alph != nil {
+				return 0, 0, invalidStructure("%s: second ALPH chunk, %v", where, c)
+			}
+			alph = &chunks[i]
+			continue
+		case container.FourCCVP8, container.FourCCVP8L:
+		default:
+			return 0, 0, invalidStructure("%s: %v before the image", where, c)
+		}
+
+		if width, height, err = validateBitstream(c); err != nil {
+			return 0, 0, err
+		}
+		if alph != nil {
+			if c.fourcc == container.FourCCVP8L {
+				return 0, 0, invalidStructure("%s: ALPH chunk with a VP8L image", where)
+			}
+			if err := validateAlpha(*alph, width, height); err != nil {
+				return 0, 0, err
+			}
+		}
+		for _, u := range chunks[i+1:] {
+			switch u.fourcc {
+			case container.FourCCALPH, container.FourCCVP8, container.FourCCVP8L:
+				return 0, 0, invalidStructure("%s: %v after the image", where, u)
+			}
+		}
+		return width, height, nil
+	}
+	return 0, 0, invalidStructure("%s: no VP8 or VP8L chunk", where)
+}
+
+// validateExtended checks a file in the VP8X format; chunks[0] is VP8X.
+func validateExtended(chunks []validChunk) error {
+	vp8x := chunks[0]
+	if len(vp8x.payload) != container.VP8XChunkSize {
+		return invalidStructure("%v has %d bytes, want %d", vp8x, len(vp8x.payload), container.VP8XChunkSize)
+	}
+	flags := binary.LittleEndian.Uint32(vp8x.payload)
+	if flags&^container.AllValidFlags != 0 {
+		return invalidStructure("VP8X reserved flags %#x are set", flags&^container.AllValidFlags)
+	}
+	canvasW := le24(vp8x.payload[4:]) + 1
+	canvasH := le24(vp8x.payload[7:]) + 1
+	if uint64(canvasW)*uint64(canvasH) >= 1<<32 {
+		return invalidStructure("VP8X canvas %dx%d is too large", canvasW, canvasH)
+	}
+
+	var (
+		seen   = map[uint32]validChunk{container.FourCCVP8X: vp8x}
+		images []validChunk // ALPH, VP8, VP8L and what follows them
+		frames []validChunk // ANMF
+	)
+	for _, c := range chunks[1:] {
+		switch c.fourcc {
+		case container.FourCCVP8X, container.FourCCICCP, container.FourCCANIM, container.FourCCEXIF, container.FourCCXMP:
+			if prev, ok := seen[c.fourcc]; ok {
+				return invalidStructure("%v repeats the %v", c, prev)
+			}
+			seen[c.fourcc] = c
+		}
+		switch c.fourcc {
+		case container.FourCCICCP:
+			if _, ok := seen[container.FourCCANIM]; ok || len(images) > 0 || len(frames) > 0 {
+				return invalidStructure("%v follows the image data", c)
+			}
+		case container.FourCCANIM:
+			if len(images) > 0 || len(frames) > 0 {
+				return invalidStructure("%v follows the image data", c)
+			}
+			if len(c.payload) != container.ANIMChunkSize {
+				return invalidStructure("%v has %d bytes, want %d", c, len(c.payload), container.ANIMChunkSize)
+			}
+		case container.FourCCANMF:
+			if _, ok := seen[container.FourCCANIM]; !ok {
+				return invalidStructure("%v comes before any ANIM chunk", c)
+			}
+			frames = append(frames, c)
+		case container.FourCCALPH, container.FourCCVP8, container.FourCCVP8L:
+			images = append(images, c)
+		default:
+			if len(images) > 0 {
+				images = append(images, c)
+			}
+		}
+	}
+
+	for _, m := range []struct {
+		flag   uint32
+		fourcc uint32
+		name   string
+	}{
+		{container.ICCPFlag, container.FourCCICCP, "an ICC profile"},
+		{container.EXIFFlag, container.FourCCEXIF, "EXIF metadata"},
+		{container.XMPFlag, container.FourCCXMP, "XMP metadata"},
+	} {
+		_, present := seen[m.fourcc]
+		if declared := flags&m.flag != 0; declared != present {
+			if declared {
+				return invalidStructure("VP8X flags declare %s but there is no %s chunk", m.name, container.FourCCString(m.fourcc))
+			}
+			return invalidStructure("%v is not declared in the VP8X flags", seen[m.fourcc])
+		}
+	}
+
+	anim, hasANIM := seen[container.FourCCANIM]
+	if flags&container.AnimationFlag == 0 {
+		switch {
+		case hasANIM:
+			return invalidStructure("%v in a file without the VP8X animation flag", anim)
+		case len(frames) > 0:
+			return invalidStructure("%v in a file without the VP8X animation flag", frames[0])
+		}
+		width, height, err := validateImage(images, "still image")
+		if err != nil {
+			return err
+		}
+		if width != canvasW || height != canvasH {
+			return invalidStructure("image is %dx%d but the VP8X canvas is %dx%d", width, height, canvasW, canvasH)
+		}
+		if images[0].fourcc == container.FourCCALPH && flags&container.AlphaFlag == 0 {
+			return invalidStructure("%v is not declared in the VP8X flags", images[0])
+		}
+		return nil
+	}
+
+	switch {
+	case !hasANIM:
+		return invalidStructure("VP8X flags declare an animation but there is no ANIM chunk")
+	case len(frames) == 0:
+		return invalidStructure("VP8X flags declare an animation but there is no ANMF chunk")
+	case len(images) > 0:
+		return invalidStructure("%v outside of an ANMF frame", images[0])
+	}
+	for i, f := range frames {
+		if err := validateFrame(f, i, canvasW, canvasH); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateChecksum checks the CKSM chunk of the extended format file data,
+// if it has one, against the image data.
+func validateChecksum(data []byte, chunks []validChunk) error {
+	for _, c := range chunks {
+		if c.fourcc != fourCCCKSM {
+			continue
+		}
+		// validateExtended accepted the layout the parser needs.
+		p, err := container.NewParser(data)
+		if err != nil {
+			return invalidStructure("%v", err)
+		}
+		return verifyChecksum(p)
+	}
+	return nil
+}
+
+// validateFrame checks the i'th ANMF chunk of an animation.
+func validateFrame(f validChunk, i, canvasW, canvasH int) error {
+	where := fmt.Sprintf("frame %d (%v)", i, f)
+	if len(f.payload) < container.ANMFChunkSize {
+		return invalidStructure("%s: %d bytes, want at least %d", where, len(f.payload), container.ANMFChunkSize)
+	}
+	p := f.payload
+	x, y := 2*le24(p[0:]), 2*le24(p[3:])
+	w, h := le24(p[6:])+1, le24(p[9:])+1
+	if p[15]>>2 != 0 {
+		return invalidStructure("%s: reserved flag bits set", where)
+	}
+	if x+w > canvasW || y+h > canvasH {
+		return invalidStructure("%s: %dx%d at (%d,%d) does not fit in the %dx%d canvas", where, w, h, x, y, canvasW, canvasH)
+	}
+
+	sub, err := validateChunks(f.payload, container.ANMFChunkSize, func(c validChunk) error {
+		return invalidStructure("%s: %s chunk overruns the frame", where, container.FourCCString(c.fourcc))
+	})
+	if err != nil {
+		return err
+	}
+	// Report sub-chunk offsets within the file.
+	for j := range sub {
+		sub[j].offset += f.offset + container.ChunkHeaderSize
+	}
+	width, height, err := validateImage(sub, where)
+	if err != nil {
+		return err
+	}
+	if width != w || height != h {
+		return invalidStructure("%s: image is %dx%d but the frame is %dx%d", where, width, height, w, h)
+	}
+	return nil
+}
+
+// le24 reads a 24-bit little-endian integer.
+func le24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
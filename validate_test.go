@@ -0,0 +1,238 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepteams/webp/animation"
+)
+
+// riffChunk returns a chunk with its header and padding.
+func riffChunk(tag string, payload []byte) []byte {
+	b := append([]byte(tag), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	b = append(b, payload...)
+	if len(payload)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// riffFile returns a WebP file holding chunks.
+func riffFile(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
+
+// vp8xPayload returns a VP8X payload for a w x h canvas.
+func vp8xPayload(flags byte, w, h int) []byte {
+	b := []byte{flags, 0, 0, 0}
+	b = append(b, byte(w-1), byte((w-1)>>8), byte((w-1)>>16))
+	return append(b, byte(h-1), byte((h-1)>>8), byte((h-1)>>16))
+}
+
+// anmfPayload returns an ANMF payload for a w x h frame at (x, y) holding
+// the chunks of frame.
+func anmfPayload(x, y, w, h int, frame ...[]byte) []byte {
+	var b []byte
+	for _, v := range []int{x / 2, y / 2, w - 1, h - 1} {
+		b = append(b, byte(v), byte(v>>8), byte(v>>16))
+	}
+	b = append(b, 100, 0, 0, 0) // duration, flags
+	for _, c := range frame {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func TestValidate(t *testing.T) {
+	// The VP8 and VP8L chunks of simple format 8x8 images.
+	lossy := mustEncode(t, solidImage(8, 8, color.NRGBA{R: 200, A: 255}), &EncoderOptions{Quality: 75})
+	lossless := mustEncode(t, solidImage(8, 8, color.NRGBA{G: 200, A: 255}), &EncoderOptions{Lossless: true})
+	vp8, vp8l := lossy[12:], lossless[12:]
+	alph := riffChunk("ALPH", append([]byte{0}, bytes.Repeat([]byte{128}, 64)...))
+	anim := riffChunk("ANIM", make([]byte, 6))
+
+	translucent := gradientTestImage(32, 24)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = uint8(i)
+	}
+	metaOpts := DefaultOptions()
+	metaOpts.ICC = []byte("icc")
+	metaOpts.EXIF = []byte("exif")
+	metaOpts.XMP = []byte("<xmp/>")
+
+	var animated bytes.Buffer
+	enc := animation.NewEncoder(&animated, 16, 16, nil)
+	for i := 0; i < 3; i++ {
+		img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+		img.SetNRGBA(i, i, color.NRGBA{R: 255, A: 255})
+		if err := enc.AddFrame(img, 50*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	for name, data := range map[string][]byte{
+		"lossy":          lossy,
+		"lossless":       lossless,
+		"lossy_alpha":    mustEncode(t, translucent, metaOpts),
+		"animation":      animated.Bytes(),
+		"raw_alpha":      riffFile(riffChunk("VP8X", vp8xPayload(0x10, 8, 8)), alph, vp8),
+		"unknown_chunks": riffFile(riffChunk("VP8X", vp8xPayload(0, 8, 8)), riffChunk("ABCD", []byte{1}), vp8, riffChunk("WXYZ", nil)),
+		"anmf_offsets": riffFile(riffChunk("VP8X", vp8xPayload(0x02, 16, 16)), anim,
+			riffChunk("ANMF", anmfPayload(0, 0, 8, 8, vp8)),
+			riffChunk("ANMF", anmfPayload(8, 8, 8, 8, alph, vp8)),
+			riffChunk("ANMF", anmfPayload(4, 2, 8, 8, vp8l))),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := Validate(bytes.NewReader(data)); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+		})
+	}
+
+	badVP8 := append([]byte(nil), vp8...)
+	badVP8[8+3] = 0x9c // signature
+	badVP8L := append([]byte(nil), vp8l...)
+	badVP8L[8] = 0x2e
+	tests := []struct {
+		name string
+		data []byte
+		want error
+		msg  string
+	}{
+		{"not_webp", []byte("this is not a WebP file"), ErrInvalidFormat, "RIFF"},
+		{"truncated", lossy[:len(lossy)-5], ErrUnexpectedEOF, "RIFF size"},
+		{"chunk_overrun", riffFile(vp8[:len(vp8)-4]), ErrUnexpectedEOF, "VP8  chunk at offset 12"},
+		{"trailing_data", append(append([]byte(nil), lossy...), 0, 0), ErrInvalidStructure, "trailing"},
+		{"bad_vp8_signature", riffFile(badVP8), ErrInvalidStructure, "VP8 signature"},
+		{"bad_vp8l_signature", riffFile(badVP8L), ErrInvalidStructure, "VP8L signature"},
+		{"simple_extra_chunk", riffFile(vp8, riffChunk("EXIF", []byte("x"))), ErrInvalidStructure, "simple format"},
+		{"bad_first_chunk", riffFile(riffChunk("ICCP", nil), vp8), ErrInvalidStructure, "first chunk"},
+		{"vp8x_size", riffFile(riffChunk("VP8X", make([]byte, 9)), vp8), ErrInvalidStructure, "want 10"},
+		{"reserved_flags", riffFile(riffChunk("VP8X", vp8xPayload(0x01, 8, 8)), vp8), ErrInvalidStructure, "reserved"},
+		{"icc_flag_no_chunk", riffFile(riffChunk("VP8X", vp8xPayload(0x20, 8, 8)), vp8), ErrInvalidStructure, "no ICCP chunk"},
+		{"exif_chunk_no_flag", riffFile(riffChunk("VP8X", vp8xPayload(0, 8, 8)), vp8, riffChunk("EXIF", []byte("x"))), ErrInvalidStructure, fmt.Sprintf("EXIF chunk at offset %d", 30+len(vp8))},
+		{"duplicate_xmp", riffFile(riffChunk("VP8X", vp8xPayload(0x04, 8, 8)), vp8, riffChunk("XMP ", nil), riffChunk("XMP ", nil)), ErrInvalidStructure, "repeats"},
+		{"iccp_after_image", riffFile(riffChunk("VP8X", vp8xPayload(0x20, 8, 8)), vp8, riffChunk("ICCP", nil)), ErrInvalidStructure, "follows the image"},
+		{"canvas_mismatch", riffFile(riffChunk("VP8X", vp8xPayload(0, 9, 8)), vp8), ErrInvalidStructure, "canvas is 9x8"},
+		{"no_image", riffFile(riffChunk("VP8X", vp8xPayload(0, 8, 8))), ErrInvalidStructure, "no VP8 or VP8L"},
+		{"alph_with_vp8l", riffFile(riffChunk("VP8X", vp8xPayload(0x10, 8, 8)), alph, vp8l), ErrInvalidStructure, "ALPH chunk with a VP8L"},
+		{"alph_no_flag", riffFile(riffChunk("VP8X", vp8xPayload(0, 8, 8)), alph, vp8), ErrInvalidStructure, "not declared"},
+		{"alph_short", riffFile(riffChunk("VP8X", vp8xPayload(0x10, 8, 8)), riffChunk("ALPH", make([]byte, 10)), vp8), ErrInvalidStructure, "9 alpha values"},
+		{"alph_method", riffFile(riffChunk("VP8X", vp8xPayload(0x10, 8, 8)), riffChunk("ALPH", []byte{3, 0}), vp8), ErrInvalidStructure, "compression method 3"},
+		{"anim_flag_no_anim", riffFile(riffChunk("VP8X", vp8xPayload(0x02, 8, 8)), riffChunk("ANMF", anmfPayload(0, 0, 8, 8, vp8))), ErrInvalidStructure, "ANMF chunk at offset 30 comes before"},
+		{"anim_no_frames", riffFile(riffChunk("VP8X", vp8xPayload(0x02, 8, 8)), anim), ErrInvalidStructure, "no ANMF chunk"},
+		{"anim_no_flag", riffFile(riffChunk("VP8X", vp8xPayload(0, 8, 8)), anim, riffChunk("ANMF", anmfPayload(0, 0, 8, 8, vp8))), ErrInvalidStructure, "animation flag"},
+		{"anim_with_still_image", riffFile(riffChunk("VP8X", vp8xPayload(0x02, 8, 8)), anim, riffChunk("ANMF", anmfPayload(0, 0, 8, 8, vp8)), vp8), ErrInvalidStructure, "outside of an ANMF"},
+		{"frame_outside_canvas", riffFile(riffChunk("VP8X", vp8xPayload(0x02, 8, 8)), anim, riffChunk("ANMF", anmfPayload(2, 0, 8, 8, vp8))), ErrInvalidStructure, "frame 0"},
+		{"frame_size_mismatch", riffFile(riffChunk("VP8X", vp8xPayload(0x02, 16, 16)), anim, riffChunk("ANMF", anmfPayload(0, 0, 8, 8, vp8)), riffChunk("ANMF", anmfPayload(0, 0, 10, 8, vp8))), ErrInvalidStructure, "frame 1"},
+		{"frame_subchunk_overrun", riffFile(riffChunk("VP8X", vp8xPayload(0x02, 8, 8)), anim, riffChunk("ANMF", anmfPayload(0, 0, 8, 8, vp8[:len(vp8)-2]))), ErrInvalidStructure, "overruns the frame"},
+		{"frame_bad_signature", riffFile(riffChunk("VP8X", vp8xPayload(0x02, 8, 8)), anim, riffChunk("ANMF", anmfPayload(0, 0, 8, 8, badVP8))), ErrInvalidStructure, "VP8  chunk at offset 68"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(bytes.NewReader(tc.data))
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("Validate = %v, want %v", err, tc.want)
+			}
+			if !strings.Contains(err.Error(), tc.msg) {
+				t.Errorf("Validate = %q, want it to mention %q", err, tc.msg)
+			}
+		})
+	}
+}
+
+func TestEncode_Strict(t *testing.T) {
+	translucent := gradientTestImage(37, 21)
+	translucent.Pix[3] = 0
+	gray := image.NewGray(image.Rect(0, 0, 9, 7))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 3)
+	}
+	paletted := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.Black, color.White})
+	paletted.Pix[5] = 1
+
+	withMeta := DefaultOptions()
+	withMeta.EXIF = []byte("odd") // padded
+	withMeta.ICC = buildICC(srgbTags(iccSRGBCurve()))
+	encodes := map[string]func(io.Writer, *EncoderOptions) error{
+		"lossy":       func(w io.Writer, o *EncoderOptions) error { return Encode(w, gradientTestImage(37, 21), o) },
+		"lossy_alpha": func(w io.Writer, o *EncoderOptions) error { return Encode(w, translucent, o) },
+		"lossless": func(w io.Writer, o *EncoderOptions) error {
+			o.Lossless = true
+			return Encode(w, translucent, o)
+		},
+		"paletted":   func(w io.Writer, o *EncoderOptions) error { return EncodePaletted(w, paletted, o) },
+		"gray":       func(w io.Writer, o *EncoderOptions) error { return EncodeGray(w, gray, o) },
+		"alpha_only": func(w io.Writer, o *EncoderOptions) error { o.AlphaOnly = true; return EncodeGray(w, gray, o) },
+	}
+	for name, encode := range encodes {
+		t.Run(name, func(t *testing.T) {
+			for _, base := range []*EncoderOptions{DefaultOptions(), withMeta} {
+				var plain, strict bytes.Buffer
+				o := *base
+				if err := encode(&plain, &o); err != nil {
+					t.Fatalf("encode: %v", err)
+				}
+				o = *base
+				o.Strict = true
+				if err := encode(&strict, &o); err != nil {
+					t.Fatalf("Strict encode: %v", err)
+				}
+				if !bytes.Equal(strict.Bytes(), plain.Bytes()) {
+					t.Error("Strict changed the output")
+				}
+			}
+		})
+	}
+
+	// A broken encoder, simulated by a hook, is caught and writes nothing.
+	breaks := map[string]func(data []byte) []byte{
+		"alpha_flag": func(data []byte) []byte {
+			data[20] &^= 0x10 // VP8X flags
+			return data
+		},
+		"canvas": func(data []byte) []byte {
+			data[24]++ // VP8X canvas width - 1
+			return data
+		},
+		"padding": func(data []byte) []byte {
+			i := bytes.Index(data, []byte("EXIF"))
+			data[i+8+3] = 0xff
+			return data
+		},
+		"trailing": func(data []byte) []byte {
+			return append(data, 0, 0)
+		},
+	}
+	for name, hook := range breaks {
+		t.Run("broken_"+name, func(t *testing.T) {
+			strictEncodeHook = hook
+			defer func() { strictEncodeHook = nil }()
+			o := *withMeta
+			o.Strict = true
+			var buf bytes.Buffer
+			err := Encode(&buf, translucent, &o)
+			if !errors.Is(err, ErrInvalidStructure) {
+				t.Errorf("Encode = %v, want ErrInvalidStructure", err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("wrote %d bytes of a file that failed the check", buf.Len())
+			}
+		})
+	}
+}
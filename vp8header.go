@@ -0,0 +1,104 @@
+package webp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/deepteams/webp/internal/container"
+	"github.com/deepteams/webp/internal/lossy"
+)
+
+// VP8HeaderInfo holds the frame header fields of a lossy (VP8) bitstream,
+// as returned by [VP8Header]. The numbering follows RFC 6386.
+type VP8HeaderInfo struct {
+	KeyFrame      bool // always true in a valid WebP file
+	Profile       int  // version number, 0-3
+	PartitionSize int  // size of the first (mode) partition in bytes
+
+	Width, Height  int
+	XScale, YScale int // upscaling the decoder should apply, 0-3; unused by WebP
+
+	ColorSpace   int // 0 = YUV, 1 = reserved
+	ClampingType int // 0 = pixel values must be clamped, 1 = no clamping needed
+
+	Segmentation     bool // segment-based quantizer and filter adjustments
+	UpdateSegmentMap bool // the macroblocks carry a segment map
+
+	// Quantizer index and filter level of each segment, when Segmentation
+	// is set: absolute values if SegmentAbsolute, otherwise deltas added to
+	// the frame's.
+	SegmentAbsolute     bool
+	SegmentQuantizers   [4]int
+	SegmentFilterLevels [4]int
+
+	SimpleFilter    bool // simple loop filter instead of the normal one
+	FilterLevel     int  // 0-63, 0 disables the loop filter
+	FilterSharpness int  // 0-7
+
+	NumPartitions int // DCT token partitions: 1, 2, 4 or 8
+
+	// Macroblocks using 16x16 and 4x4 luma prediction.
+	I16Macroblocks, I4Macroblocks int
+}
+
+// VP8Header reads a WebP file from r and returns the frame header of its
+// lossy bitstream, or of the first frame of an animation. Only the headers
+// and the macroblock modes of the first partition are parsed: no
+// coefficient is decoded. It returns an error wrapping
+// [ErrUnsupported] if the image is lossless (VP8L).
+func VP8Header(r io.Reader) (*VP8HeaderInfo, error) {
+	if r == nil {
+		return nil, errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("webp: reading data: %w", err)
+	}
+	p, err := container.NewParser(data)
+	if err != nil {
+		return nil, fmt.Errorf("webp: parsing container: %w", err)
+	}
+	frames := p.Frames()
+	if len(frames) == 0 {
+		return nil, ErrNoFrames
+	}
+	if frames[0].IsLossless {
+		return nil, fmt.Errorf("%w: lossless image has no VP8 header", ErrUnsupported)
+	}
+	hdr, err := lossy.ParseHeader(frames[0].Payload)
+	if err != nil {
+		return nil, fmt.Errorf("webp: lossy header: %w", err)
+	}
+	i16, i4, err := lossy.CountIntraModes(frames[0].Payload)
+	if err != nil {
+		return nil, fmt.Errorf("webp: lossy modes: %w", err)
+	}
+	info := &VP8HeaderInfo{
+		KeyFrame:         hdr.Frame.KeyFrame,
+		Profile:          int(hdr.Frame.Profile),
+		PartitionSize:    int(hdr.Frame.PartitionLength),
+		Width:            hdr.Picture.Width,
+		Height:           hdr.Picture.Height,
+		XScale:           int(hdr.Picture.XScale),
+		YScale:           int(hdr.Picture.YScale),
+		ColorSpace:       int(hdr.Picture.Colorspace),
+		ClampingType:     int(hdr.Picture.ClampType),
+		Segmentation:     hdr.Segment.UseSegment,
+		UpdateSegmentMap: hdr.Segment.UpdateMap,
+		SimpleFilter:     hdr.Filter.Simple,
+		FilterLevel:      hdr.Filter.Level,
+		FilterSharpness:  hdr.Filter.Sharpness,
+		NumPartitions:    hdr.NumPartitions,
+		I16Macroblocks:   i16,
+		I4Macroblocks:    i4,
+	}
+	if hdr.Segment.UseSegment {
+		info.SegmentAbsolute = hdr.Segment.AbsoluteDelta
+		for s := range info.SegmentQuantizers {
+			info.SegmentQuantizers[s] = int(hdr.Segment.Quantizer[s])
+			info.SegmentFilterLevels[s] = int(hdr.Segment.FilterStrength[s])
+		}
+	}
+	return info, nil
+}
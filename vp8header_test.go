@@ -0,0 +1,74 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/deepteams/webp/animation"
+)
+
+func TestVP8Header(t *testing.T) {
+	img := gradientTestImage(37, 21)
+
+	opts := DefaultOptions()
+	opts.FilterType = 0
+	opts.FilterSharpness = 5
+	opts.Partitions = 2
+	opts.Segments = 4
+	hdr, err := VP8Header(bytes.NewReader(mustEncode(t, img, opts)))
+	if err != nil {
+		t.Fatalf("VP8Header: %v", err)
+	}
+	if !hdr.KeyFrame || hdr.Width != 37 || hdr.Height != 21 || hdr.XScale != 0 || hdr.YScale != 0 || hdr.ColorSpace != 0 {
+		t.Errorf("VP8Header = %+v, want a 37x21 keyframe", *hdr)
+	}
+	if !hdr.SimpleFilter || hdr.FilterLevel == 0 || hdr.FilterSharpness != 5 || hdr.NumPartitions != 4 {
+		t.Errorf("VP8Header = %+v, want a simple filter with sharpness 5 and 4 partitions", *hdr)
+	}
+	if !hdr.Segmentation || hdr.PartitionSize <= 0 {
+		t.Errorf("VP8Header = %+v, want segmentation and a mode partition", *hdr)
+	}
+
+	// Filter off, one partition; the same header is reached through VP8X.
+	opts = DefaultOptions()
+	opts.FilterStrength = 0
+	opts.EXIF = []byte("exif")
+	hdr, err = VP8Header(bytes.NewReader(mustEncode(t, img, opts)))
+	if err != nil {
+		t.Fatalf("VP8Header: %v", err)
+	}
+	if hdr.FilterLevel != 0 || hdr.SimpleFilter || hdr.NumPartitions != 1 {
+		t.Errorf("VP8Header = %+v, want no filter and 1 partition", *hdr)
+	}
+
+	// The first frame of an animation.
+	var anim bytes.Buffer
+	enc := animation.NewEncoder(&anim, 16, 16, &animation.EncodeOptions{Quality: 75})
+	for i := 0; i < 2; i++ {
+		frame := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+		frame.SetNRGBA(i, i, color.NRGBA{R: 255, A: 255})
+		if err := enc.AddFrame(frame, 50*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if hdr, err = VP8Header(bytes.NewReader(anim.Bytes())); err != nil {
+		t.Fatalf("VP8Header(animation): %v", err)
+	}
+	if hdr.Width != 16 || hdr.Height != 16 {
+		t.Errorf("VP8Header(animation) = %dx%d, want 16x16", hdr.Width, hdr.Height)
+	}
+
+	if _, err := VP8Header(bytes.NewReader(mustEncode(t, img, &EncoderOptions{Lossless: true}))); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("VP8Header(lossless) = %v, want ErrUnsupported", err)
+	}
+	if _, err := VP8Header(bytes.NewReader([]byte("not a webp"))); err == nil {
+		t.Error("VP8Header accepted invalid data")
+	}
+}
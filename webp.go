@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"io"
 
 	"github.com/deepteams/webp/animation"
@@ -28,6 +29,7 @@ func init() {
 
 	// Wire the animation package's frame encoder to our VP8/VP8L encoders.
 	animation.FrameEncoderFunc = encodeFrameForAnimation
+	animation.FrameEncoderMethodFunc = encodeFrameForAnimationMethod
 
 	// Wire the animation package's simple encoder for single-frame optimization.
 	animation.SimpleEncodeFunc = simpleEncodeForAnimation
@@ -37,6 +39,32 @@ func init() {
 var (
 	ErrUnsupported = errors.New("webp: unsupported format")
 	ErrNoFrames    = errors.New("webp: no image frames found")
+
+	// ErrUnexpectedEOF is returned when the data is the start of a WebP
+	// file but ends early: the RIFF header itself is cut short, or a chunk
+	// claims more bytes than remain. It wraps io.ErrUnexpectedEOF.
+	ErrUnexpectedEOF = container.ErrTruncated
+
+	// ErrInvalidFormat is returned when the data is not a WebP file at all.
+	ErrInvalidFormat = container.ErrNotWebP
+
+	// ErrTooManyFrames is returned when an animation has more frames than
+	// DecodeOptions.MaxFrames allows.
+	ErrTooManyFrames = animation.ErrTooManyFrames
+
+	// ErrAnimatedNotSupported is returned for an animated file when
+	// DecodeOptions.RejectAnimated is set.
+	ErrAnimatedNotSupported = errors.New("webp: animated image not supported")
+
+	// ErrUnsupportedVP8LVersion is returned when a lossless (VP8L)
+	// bitstream declares a version other than 0, the only one defined:
+	// the reserved version bits suggest a file from a later revision of
+	// the format rather than a damaged one.
+	ErrUnsupportedVP8LVersion = container.ErrUnsupportedVP8LVersion
+
+	// ErrRepeatedTransform is returned when a lossless bitstream lists a
+	// transform type twice, which the format forbids: the file is corrupt.
+	ErrRepeatedTransform = lossless.ErrRepeatedTransform
 )
 
 // Features describes a WebP file's properties, as returned by [GetFeatures].
@@ -48,6 +76,25 @@ type Features struct {
 	Format       string // Container format: "lossy" (VP8), "lossless" (VP8L), or "extended" (VP8X).
 	LoopCount    int    // Animation loop count (0 = infinite). Only meaningful when HasAnimation is true.
 	FrameCount   int    // Number of frames (1 for still images).
+
+	// BitsPerPixel is the color depth stored by a lossless (VP8L) image:
+	// the palette index width (1, 2, 4 or 8) for palette images, otherwise
+	// 32 or 24 depending on the alpha bit of the VP8L header. It is 0 for
+	// lossy images. For extended files it describes the first frame.
+	BitsPerPixel int
+	// IsPalette reports whether a lossless image uses the color indexing
+	// (palette) transform.
+	IsPalette bool
+	// Lossless reports whether the image data is a VP8L bitstream, which
+	// tells lossy and lossless extended files apart. For extended files it
+	// describes the first frame.
+	Lossless bool
+	// ColorSpaceHint classifies the color space declared by the ICC
+	// profile, from its header and colorants only (it is not a color
+	// management check), so that color-critical applications can decide
+	// whether to apply the profile (DecodeOptions.ApplyICC) or reject the
+	// image. Images without a profile are sRGB.
+	ColorSpaceHint ColorSpaceHint
 }
 
 // MaxInputSize is the maximum allowed input size for WebP decoding (256 MB).
@@ -85,6 +132,13 @@ func readAll(r io.Reader) ([]byte, error) {
 // Decode reads a WebP image from r and returns it as an image.Image.
 // For lossless images the returned type is *image.NRGBA.
 // For lossy images the returned type is *image.YCbCr (when available) or *image.NRGBA.
+//
+// For an animation, Decode returns the first frame composited onto the
+// canvas, which is how players show it before the animation starts: a
+// canvas-sized image, transparent black outside the frame (an
+// *image.NRGBA if the frame does not cover the whole canvas). Use
+// [animation.Decode] for the other frames, or
+// DecodeOptions.RejectAnimated to refuse animations.
 func Decode(r io.Reader) (image.Image, error) {
 	if r == nil {
 		return nil, errors.New("webp: nil reader")
@@ -93,7 +147,104 @@ func Decode(r io.Reader) (image.Image, error) {
 	if err != nil {
 		return nil, fmt.Errorf("webp: reading data: %w", err)
 	}
-	return decodeBytes(data)
+	return decodeBytes(data, nil)
+}
+
+// DecodeBytes decodes a WebP image held in memory. It is like [Decode] but
+// parses data in place: the bitstream is decoded straight from the slice,
+// without the copy that reading from an io.Reader needs, which matters when
+// decoding many small images. data is only read, and the returned image
+// does not reference it.
+func DecodeBytes(data []byte) (image.Image, error) {
+	return decodeBytes(data, nil)
+}
+
+// DecodeOptions controls optional decoder behavior. A nil *DecodeOptions and
+// the zero value are both equivalent to [DefaultDecodeOptions], which
+// matches [Decode].
+type DecodeOptions struct {
+	// LowMemory lowers peak memory use when decoding lossless (VP8L)
+	// images by applying the inverse transforms a few rows at a time
+	// instead of through a full-size intermediate buffer. The decoded
+	// image is identical; decoding is slightly slower.
+	LowMemory bool
+
+	// ApplyICC converts the pixels to sRGB when the file carries an ICC
+	// profile (ICCP chunk), so that colors display correctly without a
+	// color-managed viewer. Matrix/TRC RGB profiles, such as typical
+	// display profiles, are supported; the image is then returned as an
+	// *image.NRGBA. Pixels are returned unchanged if there is no profile
+	// or it cannot be interpreted (for example a lookup-table-only or
+	// CMYK profile); use [ReadMetadata] to get the profile in that case.
+	ApplyICC bool
+
+	// NoFilter skips the in-loop deblocking filter when decoding lossy
+	// (VP8) images, returning the raw reconstruction as libwebp does with
+	// bypass_filtering. The output is not a conformant decode: block edges
+	// are left unsmoothed, which can help when analyzing pixel art or the
+	// encoder itself, and decoding is faster. Ignored for lossless images.
+	NoFilter bool
+
+	// DitheringStrength adds random noise to the chroma of lossy (VP8)
+	// images to reduce banding, as libwebp's dithering_strength does. It
+	// ranges from 0 (off) to 100 (full); larger values are treated as 100.
+	// The amplitude follows the quantizer of each segment, and only smooth
+	// macroblocks coded with a fine chroma quantizer are dithered.
+	// Ignored for lossless images.
+	DitheringStrength int
+
+	// PremultipliedRGBA returns an *image.RGBA, whose color channels are
+	// premultiplied by alpha, instead of an *image.NRGBA or *image.YCbCr.
+	// This saves a conversion when the pixels go to an API that expects
+	// premultiplied data, such as a GPU texture upload. Opaque images are
+	// only reinterpreted; for the others the premultiplication is done in
+	// place, rounding as libwebp does for its premultiplied output modes.
+	PremultipliedRGBA bool
+
+	// MaxFrames, if positive, rejects animations with more frames with an
+	// error wrapping ErrTooManyFrames, although only the first frame is
+	// decoded, to turn away animation bombs among untrusted uploads early:
+	// the container parse stops at the first frame past the limit.
+	// animation.DecodeConfig.MaxFrames is the same limit for
+	// animation.DecodeWithConfig.
+	MaxFrames int
+
+	// RejectAnimated returns ErrAnimatedNotSupported for animated files
+	// instead of their first frame, for callers that only handle stills.
+	RejectAnimated bool
+
+	// FitWithin, if either dimension is positive, scales the image down,
+	// preserving its aspect ratio, to the largest size that fits within
+	// FitWithin.X x FitWithin.Y pixels: the usual thumbnail. A dimension
+	// <= 0 leaves that axis unconstrained, and images that already fit are
+	// not enlarged. The image is decoded at full size, then resampled with
+	// a box filter (libwebp's rescaler) and returned as an *image.NRGBA.
+	// DecodeConfig still reports the full size.
+	FitWithin image.Point
+
+	// Lenient accepts files with container errors that browsers display
+	// anyway, which [Decode] rejects: a RIFF size field smaller than the
+	// data is ignored, unknown chunks are skipped wherever they appear, the
+	// padding byte of the last chunk may be missing, and damaged chunks or
+	// garbage after the image data are ignored.
+	Lenient bool
+}
+
+// DefaultDecodeOptions returns the options used by [Decode].
+func DefaultDecodeOptions() *DecodeOptions {
+	return &DecodeOptions{}
+}
+
+// DecodeWithOptions is like [Decode] but applies opts.
+func DecodeWithOptions(r io.Reader, opts *DecodeOptions) (image.Image, error) {
+	if r == nil {
+		return nil, errors.New("webp: nil reader")
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("webp: reading data: %w", err)
+	}
+	return decodeBytes(data, opts)
 }
 
 // DecodeConfig returns the color model and dimensions of a WebP image
@@ -118,9 +269,13 @@ func DecodeConfig(r io.Reader) (image.Config, error) {
 	//   - VP8L (lossless) always decodes to *image.NRGBA
 	//   - VP8 (lossy) without alpha decodes to *image.YCbCr
 	//   - VP8 (lossy) with alpha decodes to *image.NRGBA
+	//   - an animation frame not covering the canvas is composited into an
+	//     *image.NRGBA
 	cm := color.NRGBAModel
 	if frames := p.Frames(); len(frames) > 0 {
-		if !frames[0].IsLossless && frames[0].AlphaData == nil {
+		f := frames[0]
+		covers := !feat.HasAnim || f.XOffset == 0 && f.YOffset == 0 && f.Width == feat.CanvasWidth && f.Height == feat.CanvasHeight
+		if !f.IsLossless && f.AlphaData == nil && covers {
 			cm = color.YCbCrModel
 		}
 	} else if !feat.HasAlpha {
@@ -150,7 +305,11 @@ func GetFeatures(r io.Reader) (*Features, error) {
 	if err != nil {
 		return nil, fmt.Errorf("webp: parsing container: %w", err)
 	}
+	return featuresFromParser(p), nil
+}
 
+// featuresFromParser returns the Features of the file parsed by p.
+func featuresFromParser(p *container.Parser) *Features {
 	feat := p.Features()
 	f := &Features{
 		Width:      feat.Width,
@@ -160,6 +319,29 @@ func GetFeatures(r io.Reader) (*Features, error) {
 		FrameCount: len(p.Frames()),
 		LoopCount:  feat.LoopCount,
 	}
+	f.ColorSpaceHint = iccColorSpaceHint(metadataFromParser(p).ICC)
+
+	if frames := p.Frames(); len(frames) > 0 && frames[0].IsLossless {
+		f.Lossless = true
+		// Best effort: leave the fields unset if the transforms are damaged.
+		if info, err := lossless.ReadStreamInfo(frames[0].Payload); err == nil {
+			f.IsPalette = info.PaletteSize > 0
+			switch {
+			case info.PaletteSize > 16:
+				f.BitsPerPixel = 8
+			case info.PaletteSize > 4:
+				f.BitsPerPixel = 4
+			case info.PaletteSize > 2:
+				f.BitsPerPixel = 2
+			case info.PaletteSize > 0:
+				f.BitsPerPixel = 1
+			case info.HasAlpha:
+				f.BitsPerPixel = 32
+			default:
+				f.BitsPerPixel = 24
+			}
+		}
+	}
 
 	switch feat.Format {
 	case container.FormatVP8:
@@ -172,37 +354,146 @@ func GetFeatures(r io.Reader) (*Features, error) {
 		f.Format = "unknown"
 	}
 
-	return f, nil
+	return f
 }
 
-// decodeBytes decodes a complete WebP file from a byte slice.
-func decodeBytes(data []byte) (image.Image, error) {
-	p, err := container.NewParser(data)
+// decodeBytes decodes a complete WebP file from a byte slice. opts may be
+// nil.
+func decodeBytes(data []byte, opts *DecodeOptions) (image.Image, error) {
+	var p *container.Parser
+	var err error
+	if opts == nil {
+		p, err = container.NewParser(data)
+	} else {
+		p, err = container.NewParserMaxFrames(data, opts.MaxFrames, opts.Lenient)
+	}
+	if errors.Is(err, container.ErrTooManyFrames) {
+		return nil, fmt.Errorf("%w: more than %d frames", ErrTooManyFrames, opts.MaxFrames)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("webp: parsing container: %w", err)
 	}
+	return decodeFirstFrame(p, opts)
+}
 
+// decodeFirstFrame decodes the first image frame found by p.
+func decodeFirstFrame(p *container.Parser, opts *DecodeOptions) (image.Image, error) {
 	frames := p.Frames()
 	if len(frames) == 0 {
 		return nil, ErrNoFrames
 	}
+	if opts != nil && opts.RejectAnimated && p.Features().HasAnim {
+		return nil, ErrAnimatedNotSupported
+	}
+	if err := verifyChecksum(p); err != nil {
+		return nil, err
+	}
 
 	// Decode the first frame only; use animation.Decode() for multi-frame.
 	frame := frames[0]
-	return decodeFrame(frame)
+	img, err := decodeFrame(frame, opts)
+	if err != nil {
+		return nil, err
+	}
+	img = firstFrameCanvas(p, frame, img)
+	if opts == nil {
+		return img, nil
+	}
+	if opts.ApplyICC {
+		if m := metadataFromParser(p); m.HasICC {
+			if t, err := parseICC(m.ICC); err == nil {
+				img = t.apply(img)
+			}
+		}
+	}
+	if opts.FitWithin.X > 0 || opts.FitWithin.Y > 0 {
+		img = fitWithin(img, opts.FitWithin)
+	}
+	if opts.PremultipliedRGBA {
+		img = premultipliedRGBA(img)
+	}
+	return img, nil
+}
+
+// firstFrameCanvas returns img, the decoded first frame of the file parsed
+// by p, composited onto the canvas as players show it before an animation
+// starts: img itself for a still image or a frame covering the canvas,
+// otherwise a transparent *image.NRGBA of the canvas size with the frame
+// drawn at its offset.
+func firstFrameCanvas(p *container.Parser, frame container.FrameInfo, img image.Image) image.Image {
+	if coversCanvas(p, frame) {
+		return img
+	}
+	feat := p.Features()
+	b := img.Bounds()
+	m := image.NewNRGBA(image.Rect(0, 0, feat.CanvasWidth, feat.CanvasHeight))
+	draw.Draw(m, frameRect(frame), img, b.Min, draw.Src)
+	return m
+}
+
+// grayFirstFrameCanvas is firstFrameCanvas for a plane of the first frame,
+// such as its alpha: outside the frame the canvas is 0, transparent.
+func grayFirstFrameCanvas(p *container.Parser, frame container.FrameInfo, g *image.Gray) *image.Gray {
+	if coversCanvas(p, frame) {
+		return g
+	}
+	feat := p.Features()
+	m := image.NewGray(image.Rect(0, 0, feat.CanvasWidth, feat.CanvasHeight))
+	draw.Draw(m, frameRect(frame), g, g.Rect.Min, draw.Src)
+	return m
+}
+
+// coversCanvas reports whether frame, the first frame of the file parsed
+// by p, fills the canvas on its own: always for a still image.
+func coversCanvas(p *container.Parser, frame container.FrameInfo) bool {
+	feat := p.Features()
+	return !feat.HasAnim || frameRect(frame) == image.Rect(0, 0, feat.CanvasWidth, feat.CanvasHeight)
+}
+
+// frameRect returns the rectangle frame covers on the canvas.
+func frameRect(frame container.FrameInfo) image.Rectangle {
+	return image.Rect(0, 0, frame.Width, frame.Height).Add(image.Pt(frame.XOffset, frame.YOffset))
+}
+
+// premultipliedRGBA returns img as an *image.RGBA. An *image.NRGBA is
+// premultiplied in place and shares its pixels with the result; an opaque
+// image only needs its pixels reinterpreted.
+func premultipliedRGBA(img image.Image) *image.RGBA {
+	var m *image.NRGBA
+	switch t := img.(type) {
+	case *image.RGBA:
+		return t
+	case *image.NRGBA:
+		m = t
+		if !m.Opaque() {
+			dsp.ApplyAlphaMultiply(m.Pix, false, m.Rect.Dx(), m.Rect.Dy(), m.Stride, false)
+		}
+	case *image.YCbCr:
+		m = ycbcrToNRGBA(t)
+	default:
+		b := img.Bounds()
+		rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+		return rgba
+	}
+	return &image.RGBA{Pix: m.Pix, Stride: m.Stride, Rect: m.Rect}
 }
 
 // decodeFrame decodes a single image frame.
-func decodeFrame(frame container.FrameInfo) (image.Image, error) {
+func decodeFrame(frame container.FrameInfo, opts *DecodeOptions) (image.Image, error) {
 	if frame.IsLossless {
-		return decodeLossless(frame.Payload)
+		return decodeLossless(frame.Payload, opts)
 	}
-	return decodeLossy(frame.Payload, frame.AlphaData)
+	return decodeLossy(frame.Payload, frame.AlphaData, opts)
 }
 
 // decodeLossless decodes a VP8L lossless bitstream.
-func decodeLossless(data []byte) (image.Image, error) {
-	img, err := lossless.DecodeVP8L(data)
+func decodeLossless(data []byte, opts *DecodeOptions) (image.Image, error) {
+	decodeVP8L := lossless.DecodeVP8L
+	if opts != nil && opts.LowMemory {
+		decodeVP8L = lossless.DecodeVP8LLowMemory
+	}
+	img, err := decodeVP8L(data)
 	if err != nil {
 		return nil, fmt.Errorf("webp: lossless decode: %w", err)
 	}
@@ -212,10 +503,16 @@ func decodeLossless(data []byte) (image.Image, error) {
 // encodeFrameForAnimation encodes an image to a raw VP8/VP8L bitstream
 // for use by the animation package's FrameEncoderFunc.
 func encodeFrameForAnimation(img image.Image, isLossless bool, quality int) ([]byte, error) {
+	return encodeFrameForAnimationMethod(img, isLossless, quality, 4)
+}
+
+// encodeFrameForAnimationMethod is encodeFrameForAnimation with an explicit
+// compression method, used for per-frame animation encoder overrides.
+func encodeFrameForAnimationMethod(img image.Image, isLossless bool, quality, method int) ([]byte, error) {
 	opts := &EncoderOptions{
 		Lossless: isLossless,
 		Quality:  float32(quality),
-		Method:   4,
+		Method:   method,
 	}
 	if isLossless {
 		bs, _, err := encodeLossless(img, opts)
@@ -248,9 +545,9 @@ func decodeFrameForAnimation(bitstreamData, alphaData []byte) (*image.NRGBA, err
 	var img image.Image
 	var err error
 	if isLossless {
-		img, err = decodeLossless(bitstreamData)
+		img, err = decodeLossless(bitstreamData, nil)
 	} else {
-		img, err = decodeLossy(bitstreamData, alphaData)
+		img, err = decodeLossy(bitstreamData, alphaData, nil)
 	}
 	if err != nil {
 		return nil, err
@@ -320,8 +617,12 @@ func ycbcrToNRGBA(ycbcr *image.YCbCr) *image.NRGBA {
 // Without alpha data it returns *image.YCbCr (4:2:0) — no colour-space
 // conversion needed, just a plane copy.  With alpha it falls back to
 // *image.NRGBA using fancy chroma upsampling.
-func decodeLossy(data []byte, alphaData []byte) (image.Image, error) {
-	dec, width, height, yPlane, yStride, uPlane, vPlane, uvStride, err := lossy.DecodeFrame(data)
+func decodeLossy(data []byte, alphaData []byte, opts *DecodeOptions) (image.Image, error) {
+	var lopts *lossy.DecodeOptions
+	if opts != nil && (opts.NoFilter || opts.DitheringStrength > 0) {
+		lopts = &lossy.DecodeOptions{NoFilter: opts.NoFilter, DitheringStrength: opts.DitheringStrength}
+	}
+	dec, width, height, yPlane, yStride, uPlane, vPlane, uvStride, err := lossy.DecodeFrameWithOptions(data, lopts)
 	if err != nil {
 		return nil, fmt.Errorf("webp: lossy decode: %w", err)
 	}
@@ -377,7 +678,19 @@ func buildYCbCr(width, height int, yPlane []byte, yStride int, uPlane, vPlane []
 // buildNRGBA constructs an *image.NRGBA from raw YUV planes + alpha using
 // the diamond-shaped 4-tap fancy upsampler (FANCY_UPSAMPLING from libwebp).
 func buildNRGBA(width, height int, yPlane []byte, yStride int, uPlane, vPlane []byte, uvStride int, alphaPlane []byte) *image.NRGBA {
-	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	img := &image.NRGBA{}
+	buildNRGBAInto(img, width, height, yPlane, yStride, uPlane, vPlane, uvStride, alphaPlane)
+	return img
+}
+
+// buildNRGBAInto is buildNRGBA writing into img, whose Pix is reused if it
+// is large enough. Every pixel is written, alpha included.
+func buildNRGBAInto(img *image.NRGBA, width, height int, yPlane []byte, yStride int, uPlane, vPlane []byte, uvStride int, alphaPlane []byte) {
+	n := 4 * width * height
+	if cap(img.Pix) < n {
+		img.Pix = make([]uint8, n)
+	}
+	img.Pix, img.Stride, img.Rect = img.Pix[:n], 4*width, image.Rect(0, 0, width, height)
 
 	yRow := func(row int) []byte {
 		off := row * yStride
@@ -408,7 +721,7 @@ func buildNRGBA(width, height int, yPlane []byte, yStride int, uPlane, vPlane []
 			yRow(0), nil, uRow(0), vRow(0), uRow(0), vRow(0),
 			dstRow(0), nil, aRow(0), nil, width,
 		)
-		return img
+		return
 	}
 
 	// Row 0: mirror chroma.
@@ -445,6 +758,4 @@ func buildNRGBA(width, height int, yPlane []byte, yStride int, uPlane, vPlane []
 			width,
 		)
 	}
-
-	return img
 }
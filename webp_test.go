@@ -2,14 +2,20 @@ package webp
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"image"
 	"image/color"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/deepteams/webp/animation"
+	"github.com/deepteams/webp/internal/container"
 	"github.com/deepteams/webp/mux"
 )
 
@@ -79,6 +85,72 @@ func TestGetFeatures_LosslessGradient(t *testing.T) {
 	}
 }
 
+func TestGetFeatures_BitsPerPixel(t *testing.T) {
+	// nColors distinct opaque colors in a 32x32 image.
+	colors := func(n int) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+		for i := 0; i < 32*32; i++ {
+			c := i % n
+			img.Pix[4*i], img.Pix[4*i+1], img.Pix[4*i+2], img.Pix[4*i+3] = uint8(c), uint8(c>>8), 7, 255
+		}
+		return img
+	}
+	tests := []struct {
+		colors      int
+		wantBits    int
+		wantPalette bool
+	}{
+		{2, 1, true},
+		{3, 2, true},
+		{12, 4, true},
+		{200, 8, true},
+		{1024, 32, false}, // the encoder always sets the VP8L alpha bit
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.Lossless = true
+		var buf bytes.Buffer
+		if err := Encode(&buf, colors(tt.colors), opts); err != nil {
+			t.Fatalf("%d colors: Encode: %v", tt.colors, err)
+		}
+		feat, err := GetFeatures(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%d colors: GetFeatures: %v", tt.colors, err)
+		}
+		if feat.BitsPerPixel != tt.wantBits || feat.IsPalette != tt.wantPalette {
+			t.Errorf("%d colors: BitsPerPixel=%d IsPalette=%v, want %d %v",
+				tt.colors, feat.BitsPerPixel, feat.IsPalette, tt.wantBits, tt.wantPalette)
+		}
+	}
+
+	feat, err := GetFeatures(bytes.NewReader(readTestFile(t, "red_4x4_lossy.webp")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feat.BitsPerPixel != 0 || feat.IsPalette {
+		t.Errorf("lossy: BitsPerPixel=%d IsPalette=%v, want 0 false", feat.BitsPerPixel, feat.IsPalette)
+	}
+}
+
+func TestGetFeatures_Lossless_Extended(t *testing.T) {
+	for _, lossless := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.Lossless = lossless
+		opts.ICC = []byte("icc") // forces the extended format
+		var buf bytes.Buffer
+		if err := Encode(&buf, gradientTestImage(16, 16), opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		feat, err := GetFeatures(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("GetFeatures: %v", err)
+		}
+		if feat.Format != "extended" || feat.Lossless != lossless {
+			t.Errorf("Format=%q Lossless=%v, want extended %v", feat.Format, feat.Lossless, lossless)
+		}
+	}
+}
+
 // --- DecodeConfig tests ---
 
 func TestDecodeConfig_Lossless(t *testing.T) {
@@ -319,6 +391,52 @@ func TestDecodeConfig_ColorModel_LossyWithAlpha(t *testing.T) {
 
 // --- Error cases ---
 
+func TestDecodeWithOptions_LowMemory(t *testing.T) {
+	// Sizes that are not multiples of the 16-row transform batch.
+	const W, H = 45, 37
+	paletted := image.NewNRGBA(image.Rect(0, 0, W, H))
+	for y := 0; y < H; y++ {
+		for x := 0; x < W; x++ {
+			paletted.SetNRGBA(x, y, color.NRGBA{R: uint8(80 * ((x + y) % 3)), G: 40, A: 255})
+		}
+	}
+	bugFile, err := os.ReadFile(filepath.Join("testdata", "lossless", "bug-decode", "input-vp8l.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(img image.Image, transforms LosslessTransform) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, &EncoderOptions{Lossless: true, Quality: 75, Method: 4, LosslessTransforms: transforms}); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.Bytes()
+	}
+	cases := map[string][]byte{
+		"all":            encode(richTestImage(W, H), TransformAll),
+		"predictor":      encode(richTestImage(W, H), TransformPredictor),
+		"cross color":    encode(richTestImage(W, H), TransformCrossColor|TransformSubtractGreen),
+		"palette packed": encode(paletted, TransformColorIndexing),
+		"libwebp file":   bugFile,
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			want, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			got, err := DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{LowMemory: true})
+			if err != nil {
+				t.Fatalf("DecodeWithOptions: %v", err)
+			}
+			if !bytes.Equal(got.(*image.NRGBA).Pix, want.(*image.NRGBA).Pix) {
+				t.Error("low-memory decode differs from the default decode")
+			}
+		})
+	}
+}
+
 func TestDecode_InvalidData(t *testing.T) {
 	_, err := Decode(bytes.NewReader([]byte("not a webp file")))
 	if err == nil {
@@ -459,6 +577,233 @@ func TestDecodeAnimatedReturnsFirstFrame(t *testing.T) {
 	if bounds.Dx() != W || bounds.Dy() != H {
 		t.Errorf("decoded size = %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), W, H)
 	}
+	// Frame 0 has no red; the later frames do.
+	if r, _, _, _ := decoded.At(8, 8).RGBA(); r>>8 > 20 {
+		t.Errorf("decoded red = %d, want frame 0's 0", r>>8)
+	}
+}
+
+func TestDecodeAnimatedSubFrameCanvas(t *testing.T) {
+	// An animation whose first frame covers only part of the canvas.
+	const W, H = 20, 16
+	still := mustEncode(t, gradientTestImage(8, 6), &EncoderOptions{Quality: 90})
+	bitstream := still[20 : 20+binary.LittleEndian.Uint32(still[16:20])]
+	m := mux.NewMuxer()
+	m.SetCanvasSize(W, H)
+	if err := m.AddFrame(bitstream, &mux.FrameOptions{Duration: 100, OffsetX: 4, OffsetY: 6}); err != nil {
+		t.Fatalf("AddFrame: %v", err)
+	}
+	if err := m.AddFrame(bitstream, &mux.FrameOptions{Duration: 100}); err != nil {
+		t.Fatalf("AddFrame: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := m.Assemble(&buf); err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	data := buf.Bytes()
+
+	decoded, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got := decoded.Bounds(); got != image.Rect(0, 0, W, H) {
+		t.Fatalf("Decode bounds = %v, want the %dx%d canvas", got, W, H)
+	}
+	frame, err := Decode(bytes.NewReader(still))
+	if err != nil {
+		t.Fatalf("Decode still: %v", err)
+	}
+	for y := 0; y < H; y++ {
+		for x := 0; x < W; x++ {
+			want := color.NRGBA{}
+			if p := image.Pt(x-4, y-6); p.In(frame.Bounds()) {
+				want = color.NRGBAModel.Convert(frame.At(p.X, p.Y)).(color.NRGBA)
+			}
+			if got := color.NRGBAModel.Convert(decoded.At(x, y)); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	cfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeConfig: %v", err)
+	}
+	if cfg.Width != W || cfg.Height != H || cfg.ColorModel != decoded.ColorModel() {
+		t.Errorf("DecodeConfig = %dx%d %T, Decode returned %v %T", cfg.Width, cfg.Height, cfg.ColorModel, decoded.Bounds(), decoded)
+	}
+}
+
+func TestDecodeAnimatedSubFrameCanvasAllDecoders(t *testing.T) {
+	// The other first-frame decoders composite an offset first frame onto
+	// the canvas as Decode does.
+	const W, H = 20, 16
+	img := gradientTestImage(8, 6)
+	for i := 3; i < len(img.Pix); i += 8 {
+		img.Pix[i] = 0x80
+	}
+	for _, tc := range []struct {
+		name string
+		opts *EncoderOptions
+	}{
+		{"lossy_alpha", &EncoderOptions{Quality: 90}},
+		{"lossless", &EncoderOptions{Lossless: true, Quality: 75}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := container.NewParser(mustEncode(t, img, tc.opts))
+			if err != nil {
+				t.Fatalf("NewParser: %v", err)
+			}
+			f := p.Frames()[0]
+			var frame []byte
+			if len(f.AlphaData) > 0 {
+				frame = binary.LittleEndian.AppendUint32([]byte("ALPH"), uint32(len(f.AlphaData)))
+				frame = append(frame, f.AlphaData...)
+				if len(f.AlphaData)%2 != 0 {
+					frame = append(frame, 0)
+				}
+			}
+			frame = append(frame, f.Payload...)
+			m := mux.NewMuxer()
+			m.SetCanvasSize(W, H)
+			if err := m.AddFrame(frame, &mux.FrameOptions{Duration: 100, OffsetX: 4, OffsetY: 6}); err != nil {
+				t.Fatalf("AddFrame: %v", err)
+			}
+			if err := m.AddFrame(frame, &mux.FrameOptions{Duration: 100}); err != nil {
+				t.Fatalf("AddFrame: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := m.Assemble(&buf); err != nil {
+				t.Fatalf("Assemble: %v", err)
+			}
+			data := buf.Bytes()
+
+			decoded, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			want, ok := decoded.(*image.NRGBA)
+			if !ok || want.Rect != image.Rect(0, 0, W, H) {
+				t.Fatalf("Decode = %T %v, want an NRGBA %dx%d canvas", decoded, decoded.Bounds(), W, H)
+			}
+			wantAlpha := image.NewGray(want.Rect)
+			for i := range wantAlpha.Pix {
+				wantAlpha.Pix[i] = want.Pix[4*i+3]
+			}
+
+			pix, w, h, _, err := DecodeRaw(bytes.NewReader(data), PixelFormatRGBA)
+			if err != nil {
+				t.Fatalf("DecodeRaw: %v", err)
+			}
+			if w != W || h != H || !bytes.Equal(pix, want.Pix) {
+				t.Errorf("DecodeRaw: %dx%d, pixels equal %v; want Decode's %dx%d canvas", w, h, bytes.Equal(pix, want.Pix), W, H)
+			}
+
+			// A dst left with other pixels is cleared outside the frame.
+			dst := image.NewNRGBA(image.Rect(0, 0, 2*W, H))
+			for i := range dst.Pix {
+				dst.Pix[i] = 0xff
+			}
+			if err := NewDecoder().Decode(dst, bytes.NewReader(data)); err != nil {
+				t.Fatalf("Decoder.Decode: %v", err)
+			}
+			if dst.Rect != want.Rect || !bytes.Equal(dst.Pix, want.Pix) {
+				t.Errorf("Decoder.Decode: %v, pixels equal %v; want Decode's canvas", dst.Rect, bytes.Equal(dst.Pix, want.Pix))
+			}
+
+			alpha, err := DecodeAlpha(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("DecodeAlpha: %v", err)
+			}
+			if alpha.Rect != want.Rect || !bytes.Equal(alpha.Pix, wantAlpha.Pix) {
+				t.Errorf("DecodeAlpha: %v, want the alpha of Decode's canvas", alpha.Rect)
+			}
+
+			// DecodeGray reads the alpha plane of a lossy image with alpha
+			// (the EncodeGray AlphaOnly form), and the gray of others.
+			wantGray := wantAlpha
+			if tc.opts.Lossless {
+				wantGray = toGray(want)
+			}
+			gray, err := DecodeGray(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("DecodeGray: %v", err)
+			}
+			if gray.Rect != want.Rect || !bytes.Equal(gray.Pix, wantGray.Pix) {
+				t.Errorf("DecodeGray: %v, want the %dx%d canvas", gray.Rect, W, H)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectAnimated(t *testing.T) {
+	var buf bytes.Buffer
+	enc := animation.NewEncoder(&buf, 16, 16, nil)
+	for i := 0; i < 2; i++ {
+		frame := gradientTestImage(16, 16)
+		frame.Pix[0] = uint8(i * 100)
+		if err := enc.AddFrame(frame, 100*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	opts := DefaultDecodeOptions()
+	opts.RejectAnimated = true
+	if _, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), opts); !errors.Is(err, ErrAnimatedNotSupported) {
+		t.Errorf("animation: err = %v, want ErrAnimatedNotSupported", err)
+	}
+	// The default decodes the first frame.
+	if _, err := Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Errorf("animation without RejectAnimated: %v", err)
+	}
+	// Stills, extended ones included, are accepted.
+	for _, o := range []*EncoderOptions{{Quality: 75}, {Quality: 75, EXIF: []byte("exif")}} {
+		data := mustEncode(t, gradientTestImage(16, 16), o)
+		if _, err := DecodeWithOptions(bytes.NewReader(data), opts); err != nil {
+			t.Errorf("still %s: %v", chunkTags(t, data), err)
+		}
+	}
+}
+
+func TestAnimationParallelMatchesSerial(t *testing.T) {
+	const W, H = 48, 32
+	encode := func(opts *animation.EncodeOptions) []byte {
+		var buf bytes.Buffer
+		enc := animation.NewEncoder(&buf, W, H, opts)
+		for i := 0; i < 8; i++ {
+			img := image.NewNRGBA(image.Rect(0, 0, W, H))
+			for y := 0; y < H; y++ {
+				for x := 0; x < W; x++ {
+					c := color.NRGBA{R: uint8(x * 5), G: uint8(y * 7), B: 90, A: 255}
+					if x >= 3*i && x < 3*i+12 && y >= 8 && y < 20 {
+						c = color.NRGBA{R: 250, G: 40, B: uint8(20 * i), A: 255}
+					}
+					img.SetNRGBA(x, y, c)
+				}
+			}
+			if err := enc.AddFrame(img, 80*time.Millisecond); err != nil {
+				t.Fatalf("AddFrame %d: %v", i, err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, opts := range []animation.EncodeOptions{
+		{Quality: 75, Kmax: 4},
+		{Quality: 75, AllowMixed: true},
+		{Quality: 75, Lossless: true},
+	} {
+		want := encode(&opts)
+		opts.Parallel = true
+		if got := encode(&opts); !bytes.Equal(got, want) {
+			t.Errorf("%+v: Parallel output (%d bytes) differs from serial (%d bytes)", opts, len(got), len(want))
+		}
+	}
 }
 
 func TestDecodeConfigEdgeCases(t *testing.T) {
@@ -679,6 +1024,133 @@ func TestEncodeWithMetadata_Lossy(t *testing.T) {
 	}
 }
 
+// TestReadMetadata verifies that ReadMetadata returns the chunks written by
+// Encode for both lossy and lossless files, and nothing for simple files.
+func TestReadMetadata(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 90
+	}
+
+	for _, lossless := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.Lossless = lossless
+		opts.ICC = []byte("icc")
+		opts.EXIF = []byte("exif payload")
+		opts.XMP = []byte("<x:xmpmeta/>")
+
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("lossless=%v: Encode: %v", lossless, err)
+		}
+		md, err := ReadMetadata(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("lossless=%v: ReadMetadata: %v", lossless, err)
+		}
+		if !bytes.Equal(md.ICC, opts.ICC) || !bytes.Equal(md.EXIF, opts.EXIF) || !bytes.Equal(md.XMP, opts.XMP) {
+			t.Errorf("lossless=%v: metadata = {%q, %q, %q}, want {%q, %q, %q}",
+				lossless, md.ICC, md.EXIF, md.XMP, opts.ICC, opts.EXIF, opts.XMP)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, DefaultOptions()); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	md, err := ReadMetadata(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadMetadata (simple): %v", err)
+	}
+	if md.ICC != nil || md.EXIF != nil || md.XMP != nil {
+		t.Errorf("simple file: metadata = %+v, want empty", md)
+	}
+}
+
+// TestDecodeWithMetadata verifies that a still VP8X image keeps its ICC
+// profile through a decode/re-encode cycle.
+func TestDecodeWithMetadata(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 160
+	}
+	opts := DefaultOptions()
+	opts.ICC = []byte("display profile")
+	opts.XMP = []byte("<x:xmpmeta/>")
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, md, err := DecodeWithMetadata(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("DecodeWithMetadata: %v", err)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Errorf("bounds = %v, want %v", decoded.Bounds(), img.Bounds())
+	}
+	if !md.HasICC || !md.HasXMP || md.HasEXIF {
+		t.Errorf("presence = {ICC %v, EXIF %v, XMP %v}, want {true, false, true}", md.HasICC, md.HasEXIF, md.HasXMP)
+	}
+	if !bytes.Equal(md.ICC, opts.ICC) || !bytes.Equal(md.XMP, opts.XMP) {
+		t.Errorf("metadata = {%q, %q}, want {%q, %q}", md.ICC, md.XMP, opts.ICC, opts.XMP)
+	}
+
+	// Re-encode with the recovered profile.
+	opts2 := DefaultOptions()
+	opts2.ICC = md.ICC
+	buf.Reset()
+	if err := Encode(&buf, decoded, opts2); err != nil {
+		t.Fatalf("re-Encode: %v", err)
+	}
+	md2, err := ReadMetadata(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if !bytes.Equal(md2.ICC, opts.ICC) {
+		t.Errorf("ICC after re-encode = %q, want %q", md2.ICC, opts.ICC)
+	}
+}
+
+// TestEncodeWithMetadata_ChunkOrder verifies the canonical chunk order and
+// that the metadata flags coexist with the alpha flag in VP8X.
+func TestEncodeWithMetadata_ChunkOrder(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+3] = 200, uint8(i)
+	}
+	opts := DefaultOptions()
+	opts.ICC = []byte("icc profile")
+	opts.EXIF = []byte("exif")
+	opts.XMP = []byte("xmp data")
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	data := buf.Bytes()
+
+	var order []string
+	for off := 12; off+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[off+4:]))
+		order = append(order, string(data[off:off+4]))
+		off += 8 + size + size&1
+	}
+	if got, want := strings.Join(order, ","), "VP8X,ICCP,ALPH,VP8 ,EXIF,XMP "; got != want {
+		t.Errorf("chunk order = %s, want %s", got, want)
+	}
+	if flags := data[20]; flags != 0x10|0x20|0x08|0x04 {
+		t.Errorf("VP8X flags = %#x, want %#x", flags, 0x10|0x20|0x08|0x04)
+	}
+
+	md, err := ReadMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadMetadata: %v", err)
+	}
+	if !bytes.Equal(md.ICC, opts.ICC) {
+		t.Errorf("ICC = %q, want %q", md.ICC, opts.ICC)
+	}
+}
+
 // TestEncodeWithMetadata_Lossless verifies VP8L + metadata round-trip.
 func TestEncodeWithMetadata_Lossless(t *testing.T) {
 	const W, H = 8, 8
@@ -1019,3 +1491,1055 @@ func TestEncodeWithMetadata_AllLargeBlobs(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeBytes(t *testing.T) {
+	img := gradientTestImage(40, 24)
+	for _, lossless := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, &EncoderOptions{Lossless: lossless, Quality: 75}); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		data := buf.Bytes()
+		want, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		got, err := DecodeBytes(data)
+		if err != nil {
+			t.Fatalf("DecodeBytes: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("lossless=%v: DecodeBytes differs from Decode", lossless)
+		}
+
+		// The image does not alias the input.
+		clear(data)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("lossless=%v: image changed with the input slice", lossless)
+		}
+	}
+
+	if _, err := DecodeBytes(nil); !errors.Is(err, ErrUnexpectedEOF) {
+		t.Errorf("DecodeBytes(nil) error = %v, want ErrUnexpectedEOF", err)
+	}
+	if _, err := DecodeBytes([]byte("GIF89a")); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("DecodeBytes(GIF) error = %v, want ErrInvalidFormat", err)
+	}
+}
+
+func TestDecodeOptions_PremultipliedRGBA(t *testing.T) {
+	translucent := gradientTestImage(33, 21)
+	for i := 3; i < len(translucent.Pix); i += 4 {
+		translucent.Pix[i] = uint8(i * 7)
+	}
+	opaque := gradientTestImage(33, 21)
+	premul := &DecodeOptions{PremultipliedRGBA: true}
+
+	for _, tc := range []struct {
+		name string
+		img  *image.NRGBA
+		opts *EncoderOptions
+	}{
+		{"lossless_alpha", translucent, &EncoderOptions{Lossless: true, Quality: 75}},
+		{"lossless_opaque", opaque, &EncoderOptions{Lossless: true, Quality: 75}},
+		{"lossy_alpha", translucent, DefaultOptions()},
+		{"lossy_opaque", opaque, DefaultOptions()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, tc.img, tc.opts); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			plain, err := Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			out, err := DecodeWithOptions(bytes.NewReader(buf.Bytes()), premul)
+			if err != nil {
+				t.Fatalf("DecodeWithOptions: %v", err)
+			}
+			got, ok := out.(*image.RGBA)
+			if !ok {
+				t.Fatalf("decoded %T, want *image.RGBA", out)
+			}
+			if got.Bounds() != plain.Bounds() {
+				t.Fatalf("bounds %v, want %v", got.Bounds(), plain.Bounds())
+			}
+			for y := 0; y < 21; y++ {
+				for x := 0; x < 33; x++ {
+					want := color.RGBAModel.Convert(plain.At(x, y)).(color.RGBA)
+					c := got.RGBAAt(x, y)
+					if c.A != want.A || absDiff(c.R, want.R) > 1 || absDiff(c.G, want.G) > 1 || absDiff(c.B, want.B) > 1 {
+						t.Fatalf("(%d,%d) = %v, want %v", x, y, c, want)
+					}
+				}
+			}
+			if n, ok := plain.(*image.NRGBA); ok && n.Opaque() && !bytes.Equal(got.Pix, n.Pix) {
+				t.Error("opaque image is not byte-identical to the NRGBA decode")
+			}
+		})
+	}
+}
+
+func TestDecode_Truncated(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Lossless = true
+	opts.ICC = []byte("icc profile")
+	var buf bytes.Buffer
+	if err := Encode(&buf, gradientTestImage(32, 32), opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	data := buf.Bytes()
+	if string(data[12:16]) != "VP8X" {
+		t.Fatalf("first chunk = %q, want VP8X", data[12:16])
+	}
+
+	for _, tc := range []struct {
+		name string
+		n    int
+	}{
+		{"riff_header", 8},
+		{"vp8x", 12 + 8 + 5},
+		{"bitstream", len(data) - 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cut := data[:tc.n]
+			_, err := Decode(bytes.NewReader(cut))
+			if !errors.Is(err, ErrUnexpectedEOF) || !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("Decode error = %v, want ErrUnexpectedEOF", err)
+			}
+			_, err = GetFeatures(bytes.NewReader(cut))
+			if !errors.Is(err, ErrUnexpectedEOF) || errors.Is(err, ErrInvalidFormat) {
+				t.Errorf("GetFeatures error = %v, want ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
+
+func TestDecode_InvalidFormat(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("GIF8"),
+		[]byte("not a webp file at all"),
+		[]byte("RIFF\x10\x00\x00\x00WAVEfmt "),
+	} {
+		_, err := Decode(bytes.NewReader(data))
+		if !errors.Is(err, ErrInvalidFormat) || errors.Is(err, ErrUnexpectedEOF) {
+			t.Errorf("Decode(%q) error = %v, want ErrInvalidFormat", data, err)
+		}
+		_, err = GetFeatures(bytes.NewReader(data))
+		if !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("GetFeatures(%q) error = %v, want ErrInvalidFormat", data, err)
+		}
+	}
+}
+
+func TestDecodeOptions_Lenient(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, gradientTestImage(24, 24), &EncoderOptions{Lossless: true, Quality: 75}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	// A RIFF size that stops short of the image data, and trailing bytes.
+	data := append(bytes.Clone(buf.Bytes()), "trailing"...)
+	binary.LittleEndian.PutUint32(data[4:8], 16)
+
+	if _, err := Decode(bytes.NewReader(data)); err == nil {
+		t.Error("Decode accepted a file with a wrong RIFF size")
+	}
+	if _, err := DecodeWithOptions(bytes.NewReader(data), DefaultDecodeOptions()); err == nil {
+		t.Error("strict DecodeWithOptions accepted a file with a wrong RIFF size")
+	}
+	// Options that leave Lenient unset, as written before it existed, stay
+	// strict.
+	if _, err := DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{LowMemory: true}); err == nil {
+		t.Error("DecodeWithOptions with LowMemory accepted a file with a wrong RIFF size")
+	}
+	img, err := DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("lenient DecodeWithOptions: %v", err)
+	}
+	want, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !bytes.Equal(img.(*image.NRGBA).Pix, want.(*image.NRGBA).Pix) {
+		t.Error("lenient decode differs from the decode of the valid file")
+	}
+}
+
+func TestDecodeOptions_NoFilter(t *testing.T) {
+	// A smooth gradient quantized coarsely turns blocky; the deblocking
+	// filter smooths the block edges.
+	img := gradientTestImage(256, 256)
+	encode := func(filter int) []byte {
+		t.Helper()
+		opts := DefaultOptions()
+		opts.Quality = 20
+		opts.Segments = 1 // a single frame-wide filter level
+		opts.SegmentFilterStrengths = [4]int{filter}
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, opts); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return buf.Bytes()
+	}
+	// The filter level only goes in the frame header, so the unfiltered
+	// encode carries the same coefficients and is a reference for what
+	// skipping the filter must produce.
+	data, ref := encode(40), encode(-1)
+
+	noFilter := DefaultDecodeOptions()
+	noFilter.NoFilter = true
+	filtered, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	raw, err := DecodeWithOptions(bytes.NewReader(data), noFilter)
+	if err != nil {
+		t.Fatalf("DecodeWithOptions: %v", err)
+	}
+	want, err := Decode(bytes.NewReader(ref))
+	if err != nil {
+		t.Fatalf("Decode reference: %v", err)
+	}
+	if bytes.Equal(filtered.(*image.YCbCr).Y, raw.(*image.YCbCr).Y) {
+		t.Error("NoFilter decode is identical to the filtered decode")
+	}
+	got, w := raw.(*image.YCbCr), want.(*image.YCbCr)
+	if !bytes.Equal(got.Y, w.Y) || !bytes.Equal(got.Cb, w.Cb) || !bytes.Equal(got.Cr, w.Cr) {
+		t.Error("NoFilter decode differs from the decode of an unfiltered encode")
+	}
+
+	// Lossless images are unaffected.
+	var ll bytes.Buffer
+	if err := Encode(&ll, img, &EncoderOptions{Lossless: true}); err != nil {
+		t.Fatalf("Encode lossless: %v", err)
+	}
+	ld, err := DecodeWithOptions(bytes.NewReader(ll.Bytes()), noFilter)
+	if err != nil {
+		t.Fatalf("DecodeWithOptions lossless: %v", err)
+	}
+	if !bytes.Equal(ld.(*image.NRGBA).Pix, img.Pix) {
+		t.Error("NoFilter changed a lossless decode")
+	}
+}
+
+func TestDecodeOptions_FitWithin(t *testing.T) {
+	box := image.Pt(64, 64)
+	for _, tc := range []struct {
+		name         string
+		w, h         int
+		box          image.Point
+		wantW, wantH int
+	}{
+		{"landscape", 200, 100, box, 64, 32},
+		{"portrait", 90, 300, box, 19, 64},
+		{"square", 100, 100, box, 64, 64},
+		{"height_only", 200, 100, image.Pt(0, 50), 100, 50},
+		{"width_only", 90, 300, image.Pt(30, -1), 30, 100},
+		{"sliver", 1000, 3, box, 64, 1},
+		{"fits", 30, 20, box, 30, 20},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := mustEncode(t, gradientTestImage(tc.w, tc.h), DefaultOptions())
+			img, err := DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{FitWithin: tc.box})
+			if err != nil {
+				t.Fatalf("DecodeWithOptions: %v", err)
+			}
+			if b := img.Bounds(); b != image.Rect(0, 0, tc.wantW, tc.wantH) {
+				t.Errorf("bounds = %v, want %dx%d", b, tc.wantW, tc.wantH)
+			}
+			if _, ok := img.(*image.YCbCr); ok != (tc.w == tc.wantW) {
+				t.Errorf("decoded a %T", img)
+			}
+		})
+	}
+
+	// 2x2 blocks of one color scale down by 2 to exactly those colors.
+	blocks := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			blocks.SetNRGBA(x, y, color.NRGBA{uint8(x / 2 * 8), uint8(y / 2 * 16), uint8(x/2 + y/2), 255})
+		}
+	}
+	data := mustEncode(t, blocks, &EncoderOptions{Lossless: true})
+	img, err := DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{FitWithin: image.Pt(32, 0)})
+	if err != nil {
+		t.Fatalf("DecodeWithOptions: %v", err)
+	}
+	got := img.(*image.NRGBA)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			if c, want := got.NRGBAAt(x, y), blocks.NRGBAAt(2*x, 2*y); c != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, c, want)
+			}
+		}
+	}
+
+	// Transparent pixels do not tint their neighbours: columns alternate
+	// between transparent red and opaque blue.
+	stripes := image.NewNRGBA(image.Rect(0, 0, 32, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 32; x++ {
+			c := color.NRGBA{R: 255}
+			if x%2 == 1 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			stripes.SetNRGBA(x, y, c)
+		}
+	}
+	data = mustEncode(t, stripes, &EncoderOptions{Lossless: true, Exact: true})
+	if img, err = DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{FitWithin: image.Pt(16, 16)}); err != nil {
+		t.Fatalf("DecodeWithOptions: %v", err)
+	}
+	got = img.(*image.NRGBA)
+	for i := 0; i < len(got.Pix); i += 4 {
+		if p := got.Pix[i : i+4]; p[0] > 2 || p[2] < 253 || p[3] < 126 || p[3] > 129 {
+			t.Fatalf("pixel %d = %v, want half-transparent blue", i/4, p)
+		}
+	}
+}
+
+func TestDecodeOptions_DitheringStrength(t *testing.T) {
+	// Dithering only applies to smooth macroblocks coded with a fine
+	// chroma quantizer, as in libwebp.
+	img := richTestImage(128, 128)
+	opts := DefaultOptions()
+	opts.QuantIndex = 2
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	data := buf.Bytes()
+
+	decode := func(strength int) *image.YCbCr {
+		t.Helper()
+		out, err := DecodeWithOptions(bytes.NewReader(data), &DecodeOptions{DitheringStrength: strength})
+		if err != nil {
+			t.Fatalf("DecodeWithOptions: %v", err)
+		}
+		return out.(*image.YCbCr)
+	}
+	mse := func(m *image.YCbCr) float64 {
+		var sum float64
+		for y := 0; y < 128; y++ {
+			for x := 0; x < 128; x++ {
+				r, g, b, _ := m.At(x, y).RGBA()
+				want := img.NRGBAAt(x, y)
+				for _, d := range [3]float64{float64(r>>8) - float64(want.R), float64(g>>8) - float64(want.G), float64(b>>8) - float64(want.B)} {
+					sum += d * d
+				}
+			}
+		}
+		return sum / (128 * 128 * 3)
+	}
+
+	plain, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	ref := plain.(*image.YCbCr)
+	off := decode(0)
+	if !bytes.Equal(off.Y, ref.Y) || !bytes.Equal(off.Cb, ref.Cb) || !bytes.Equal(off.Cr, ref.Cr) {
+		t.Error("DitheringStrength 0 changed the decode")
+	}
+
+	dithered := decode(100)
+	if !bytes.Equal(dithered.Y, ref.Y) {
+		t.Error("dithering changed the luma plane")
+	}
+	if bytes.Equal(dithered.Cb, ref.Cb) || bytes.Equal(dithered.Cr, ref.Cr) {
+		t.Fatal("DitheringStrength 100 left the chroma planes unchanged")
+	}
+	if again := decode(100); !bytes.Equal(again.Cb, dithered.Cb) {
+		t.Error("dithering is not deterministic")
+	}
+	// The noise costs a little fidelity, never much.
+	m0, m1 := mse(ref), mse(dithered)
+	if m1 <= m0 || m1 > m0*1.1 {
+		t.Errorf("MSE %.3f dithered vs %.3f plain, want slightly higher", m1, m0)
+	}
+
+	// A weaker strength stays closer to the plain decode.
+	if m := mse(decode(30)); m < m0 || m > m1 {
+		t.Errorf("MSE at strength 30 = %.3f, want between %.3f and %.3f", m, m0, m1)
+	}
+}
+
+func TestAnimation_LosslessOddOffsetSubFrame(t *testing.T) {
+	// ANMF stores frame offsets halved, so even a lossless sub-frame for a
+	// single changed pixel at odd coordinates must grow to start at an even
+	// offset; 2x2 is the smallest frame that covers it.
+	red := color.NRGBA{R: 255, A: 255}
+	frame := func(changed bool) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+3] = red.R, red.A
+		}
+		if changed {
+			img.SetNRGBA(3, 5, color.NRGBA{B: 255, A: 255})
+		}
+		return img
+	}
+
+	var buf bytes.Buffer
+	enc := animation.NewEncoder(&buf, 8, 8, &animation.EncodeOptions{Lossless: true})
+	for _, img := range []*image.NRGBA{frame(false), frame(true)} {
+		if err := enc.AddFrame(img, 50*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame: %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	anim, err := animation.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(anim.Frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(anim.Frames))
+	}
+	f := anim.Frames[1]
+	if f.Codec != animation.CodecLossless {
+		t.Errorf("frame codec = %v, want lossless", f.Codec)
+	}
+	// VP8L header: signature byte, then 14-bit width-1 and height-1.
+	bits := binary.LittleEndian.Uint32(f.BitstreamData[1:])
+	got := image.Rect(f.OffsetX, f.OffsetY, f.OffsetX+int(bits&0x3fff)+1, f.OffsetY+int(bits>>14&0x3fff)+1)
+	if want := image.Rect(2, 4, 4, 6); got != want {
+		t.Errorf("sub-frame = %v, want %v", got, want)
+	}
+}
+
+func TestAnimation_MergeThreshold(t *testing.T) {
+	const threshold = 8
+	frame := func(delta uint8) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 100, 120, 140, 255
+		}
+		img.Pix[4*(5*16+7)+1] += delta
+		return img
+	}
+	encode := func(opts *animation.EncodeOptions, frames ...*image.NRGBA) *animation.Animation {
+		t.Helper()
+		var buf bytes.Buffer
+		enc := animation.NewEncoder(&buf, 16, 16, opts)
+		for _, img := range frames {
+			if err := enc.AddFrame(img, 100*time.Millisecond); err != nil {
+				t.Fatalf("AddFrame: %v", err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		anim, err := animation.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		return anim
+	}
+	durations := func(anim *animation.Animation) []time.Duration {
+		var d []time.Duration
+		for _, f := range anim.Frames {
+			d = append(d, f.Duration)
+		}
+		return d
+	}
+
+	opts := &animation.EncodeOptions{Quality: 75, MergeThreshold: threshold}
+	// A frame differing by exactly the threshold is merged; one differing by
+	// more (from the canvas that is still shown) is not.
+	anim := encode(opts, frame(0), frame(threshold), frame(threshold+1))
+	if got, want := durations(anim), []time.Duration{200 * time.Millisecond, 100 * time.Millisecond}; !reflect.DeepEqual(got, want) {
+		t.Errorf("durations = %v, want %v", got, want)
+	}
+
+	// The default only merges identical frames.
+	anim = encode(&animation.EncodeOptions{Quality: 75}, frame(0), frame(1))
+	if len(anim.Frames) != 2 {
+		t.Errorf("default: got %d frames, want 2", len(anim.Frames))
+	}
+	// Lossless frames are never merged when they differ.
+	anim = encode(&animation.EncodeOptions{Lossless: true, MergeThreshold: threshold}, frame(0), frame(1))
+	if len(anim.Frames) != 2 {
+		t.Errorf("lossless: got %d frames, want 2", len(anim.Frames))
+	}
+	// -1 derives the threshold from the quality: 31 at quality 0.
+	anim = encode(&animation.EncodeOptions{Quality: 0, MergeThreshold: -1}, frame(0), frame(31))
+	if len(anim.Frames) != 1 {
+		t.Errorf("MergeThreshold -1 at quality 0: got %d frames, want 1", len(anim.Frames))
+	}
+
+	for _, bad := range []int{-2, 32} {
+		enc := animation.NewEncoder(io.Discard, 16, 16, &animation.EncodeOptions{MergeThreshold: bad})
+		if err := enc.AddFrame(frame(0), 100*time.Millisecond); err == nil {
+			t.Errorf("MergeThreshold %d accepted", bad)
+		}
+	}
+}
+
+func TestAnimation_ForceVP8XFlags(t *testing.T) {
+	encode := func(opts *animation.EncodeOptions, n int) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		enc := animation.NewEncoder(&buf, 32, 24, opts)
+		for i := 0; i < n; i++ {
+			frame := gradientTestImage(32, 24)
+			frame.Pix[4*i] ^= 0xff
+			if err := enc.AddFrame(frame, 100*time.Millisecond); err != nil {
+				t.Fatalf("AddFrame: %v", err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	// Opaque frames: no alpha flag unless forced. One frame would be written
+	// as a simple WebP without the option.
+	for _, n := range []int{1, 3} {
+		feat, err := GetFeatures(bytes.NewReader(encode(&animation.EncodeOptions{Quality: 75}, n)))
+		if err != nil {
+			t.Fatalf("%d frames: GetFeatures: %v", n, err)
+		}
+		if feat.HasAlpha {
+			t.Errorf("%d opaque frames: HasAlpha = true", n)
+		}
+
+		data := encode(&animation.EncodeOptions{Quality: 75, ForceAlphaFlag: true}, n)
+		feat, err = GetFeatures(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%d frames, ForceAlphaFlag: GetFeatures: %v", n, err)
+		}
+		if !feat.HasAlpha {
+			t.Errorf("%d frames, ForceAlphaFlag: HasAlpha = false", n)
+		}
+		if flags := data[20]; flags&0x10 == 0 || flags&0x20 != 0 {
+			t.Errorf("%d frames, ForceAlphaFlag: VP8X flags = %#x", n, flags)
+		}
+		if err := Validate(bytes.NewReader(data)); err != nil {
+			t.Errorf("%d frames, ForceAlphaFlag: Validate: %v", n, err)
+		}
+		if _, err := animation.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("%d frames, ForceAlphaFlag: Decode: %v", n, err)
+		}
+	}
+
+	// ForceICCFlag needs a profile; without one Close must fail rather
+	// than write a flag with no ICCP chunk behind it.
+	for _, icc := range [][]byte{nil, []byte("icc")} {
+		var buf bytes.Buffer
+		enc := animation.NewEncoder(&buf, 32, 24, &animation.EncodeOptions{Quality: 75, ForceICCFlag: true})
+		if icc != nil {
+			enc.SetICCProfile(icc)
+		}
+		if err := enc.AddFrame(gradientTestImage(32, 24), 100*time.Millisecond); err != nil {
+			t.Fatalf("AddFrame: %v", err)
+		}
+		err := enc.Close()
+		if icc == nil {
+			if !errors.Is(err, mux.ErrMuxValidation) {
+				t.Errorf("ForceICCFlag without profile: Close = %v, want ErrMuxValidation", err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("ForceICCFlag without profile: wrote %d bytes", buf.Len())
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ForceICCFlag: Close: %v", err)
+		}
+		data := buf.Bytes()
+		if flags := data[20]; flags&0x20 == 0 || flags&0x10 != 0 {
+			t.Errorf("ForceICCFlag: VP8X flags = %#x", flags)
+		}
+		if err := Validate(bytes.NewReader(data)); err != nil {
+			t.Errorf("ForceICCFlag: Validate: %v", err)
+		}
+		if _, err := animation.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("ForceICCFlag: Decode: %v", err)
+		}
+	}
+}
+
+func TestAnimation_DecodeRawFrame(t *testing.T) {
+	const W, H = 32, 24
+	first := gradientTestImage(W, H)
+	second := gradientTestImage(W, H)
+	for y := 6; y < 10; y++ {
+		for x := 4; x < 12; x++ {
+			second.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := animation.EncodeAll(&buf, []image.Image{first, second},
+		[]time.Duration{100 * time.Millisecond, 100 * time.Millisecond},
+		&animation.EncodeOptions{Lossless: true}); err != nil {
+		t.Fatalf("EncodeAll: %v", err)
+	}
+	anim, err := animation.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if len(anim.Frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(anim.Frames))
+	}
+
+	// The first frame covers the canvas: raw and composited agree.
+	raw, err := anim.DecodeRawFrame(0)
+	if err != nil {
+		t.Fatalf("DecodeRawFrame(0): %v", err)
+	}
+	rawFrames, err := animation.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if err := rawFrames.DecodeFrames(); err != nil {
+		t.Fatalf("DecodeFrames: %v", err)
+	}
+	dec, err := animation.NewAnimDecoder(rawFrames)
+	if err != nil {
+		t.Fatalf("NewAnimDecoder: %v", err)
+	}
+	canvas, _, err := dec.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if raw.Bounds() != canvas.Bounds() || !bytes.Equal(raw.Pix, canvas.Pix) {
+		t.Error("raw full-canvas frame differs from the composited canvas")
+	}
+
+	// The second frame is a sub-frame, decoded at its own size.
+	f := anim.Frames[1]
+	raw, err = anim.DecodeRawFrame(1)
+	if err != nil {
+		t.Fatalf("DecodeRawFrame(1): %v", err)
+	}
+	if raw.Bounds() != image.Rect(0, 0, 8, 4) || f.OffsetX != 4 || f.OffsetY != 6 {
+		t.Errorf("raw frame 1 is %v at (%d,%d), want 8x4 at (4,6)", raw.Bounds(), f.OffsetX, f.OffsetY)
+	} else if !bytes.Equal(raw.Pix, solidImage(8, 4, color.NRGBA{R: 255, A: 255}).Pix) {
+		t.Error("raw frame 1 pixels differ from the changed region")
+	}
+	if f.Image != nil {
+		t.Error("DecodeRawFrame stored the decoded image in the Animation")
+	}
+
+	for _, i := range []int{-1, 2} {
+		if _, err := anim.DecodeRawFrame(i); err == nil {
+			t.Errorf("DecodeRawFrame(%d) succeeded", i)
+		}
+	}
+}
+
+func TestAnimation_FixedFPS(t *testing.T) {
+	encode := func(opts *animation.EncodeOptions, frames []image.Image, delays []time.Duration) *animation.Animation {
+		t.Helper()
+		var buf bytes.Buffer
+		enc := animation.NewEncoder(&buf, 16, 16, opts)
+		for i, img := range frames {
+			if err := enc.AddFrame(img, delays[i]); err != nil {
+				t.Fatalf("AddFrame: %v", err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		anim, err := animation.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		return anim
+	}
+	durations := func(anim *animation.Animation) []time.Duration {
+		var d []time.Duration
+		for _, f := range anim.Frames {
+			d = append(d, f.Duration)
+		}
+		return d
+	}
+	red := solidImage(16, 16, color.NRGBA{R: 255, A: 255})
+	green := solidImage(16, 16, color.NRGBA{G: 255, A: 255})
+	blue := solidImage(16, 16, color.NRGBA{B: 255, A: 255})
+
+	opts := &animation.EncodeOptions{Lossless: true, FixedFPS: 10}
+	anim := encode(opts, []image.Image{red, green, blue},
+		[]time.Duration{30 * time.Millisecond, 250 * time.Millisecond, 0})
+	if got, want := durations(anim), []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond}; !reflect.DeepEqual(got, want) {
+		t.Errorf("durations = %v, want %v", got, want)
+	}
+
+	// A merged identical frame adds the fixed duration to the previous one.
+	anim = encode(opts, []image.Image{red, red, blue},
+		[]time.Duration{30 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond})
+	if got, want := durations(anim), []time.Duration{200 * time.Millisecond, 100 * time.Millisecond}; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged durations = %v, want %v", got, want)
+	}
+
+	for _, bad := range []int{-1, 1001} {
+		enc := animation.NewEncoder(io.Discard, 16, 16, &animation.EncodeOptions{FixedFPS: bad})
+		if err := enc.AddFrame(red, 100*time.Millisecond); err == nil {
+			t.Errorf("FixedFPS %d accepted", bad)
+		}
+	}
+}
+
+func TestAnimation_MaxBytes(t *testing.T) {
+	const W, H = 64, 48
+	frame := func(shift int) *image.NRGBA {
+		img := gradientTestImage(W, H)
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i] += uint8(shift)
+		}
+		return img
+	}
+	encode := func(opts *animation.EncodeOptions, frames []*image.NRGBA, delays []time.Duration) ([]byte, error) {
+		t.Helper()
+		var buf bytes.Buffer
+		enc := animation.NewEncoder(&buf, W, H, opts)
+		for i, img := range frames {
+			if err := enc.AddFrame(img, delays[i]); err != nil {
+				t.Fatalf("AddFrame: %v", err)
+			}
+		}
+		err := enc.Close()
+		return buf.Bytes(), err
+	}
+	mustEncode := func(opts *animation.EncodeOptions, frames []*image.NRGBA, delays []time.Duration) []byte {
+		t.Helper()
+		data, err := encode(opts, frames, delays)
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		return data
+	}
+	frames := []*image.NRGBA{frame(0), frame(40), frame(80)}
+	delays := []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond}
+
+	// A budget between the quality 90 and quality 80 sizes lowers the
+	// quality by one step.
+	q90 := mustEncode(&animation.EncodeOptions{Quality: 90}, frames, delays)
+	q80 := mustEncode(&animation.EncodeOptions{Quality: 80}, frames, delays)
+	if len(q80) >= len(q90) {
+		t.Fatalf("quality 80 size %d not below quality 90 size %d", len(q80), len(q90))
+	}
+	got := mustEncode(&animation.EncodeOptions{Quality: 90, MaxBytes: len(q80)}, frames, delays)
+	if !bytes.Equal(got, q80) {
+		t.Errorf("MaxBytes %d: got %d bytes, want the quality 80 encoding", len(q80), len(got))
+	}
+	// A file that fits is written unchanged.
+	got = mustEncode(&animation.EncodeOptions{Quality: 90, MaxBytes: len(q90)}, frames, delays)
+	if !bytes.Equal(got, q90) {
+		t.Error("MaxBytes: a file within budget changed")
+	}
+
+	// At the lowest quality, the least-changed frame is merged into the
+	// previous one.
+	nearly := frame(0)
+	nearly.Pix[4*(10*W+10)] ^= 0xff
+	merged := mustEncode(&animation.EncodeOptions{Quality: 10},
+		[]*image.NRGBA{frames[0], frames[2]}, []time.Duration{200 * time.Millisecond, 100 * time.Millisecond})
+	got = mustEncode(&animation.EncodeOptions{Quality: 20, MaxBytes: len(merged)},
+		[]*image.NRGBA{frames[0], nearly, frames[2]}, delays)
+	if !bytes.Equal(got, merged) {
+		t.Errorf("MaxBytes %d: got %d bytes, want the merged encoding", len(merged), len(got))
+	}
+
+	// With FixedFPS, a merged frame keeps the time of both frames.
+	got = mustEncode(&animation.EncodeOptions{Quality: 20, MaxBytes: len(merged), FixedFPS: 10},
+		[]*image.NRGBA{frames[0], nearly, frames[2]}, delays)
+	anim, err := animation.Decode(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	var total time.Duration
+	for _, f := range anim.Frames {
+		total += f.Duration
+	}
+	if len(anim.Frames) != 2 || total != 300*time.Millisecond {
+		t.Errorf("MaxBytes with FixedFPS: %d frames lasting %v, want 2 lasting 300ms", len(anim.Frames), total)
+	}
+
+	// An impossible budget fails without writing anything.
+	if data, err := encode(&animation.EncodeOptions{Quality: 90, MaxBytes: 50}, frames, delays); !errors.Is(err, animation.ErrCannotMeetBudget) || len(data) != 0 {
+		t.Errorf("MaxBytes 50: %d bytes, err = %v, want ErrCannotMeetBudget", len(data), err)
+	}
+}
+
+func TestAnimation_FrameInfoFunc(t *testing.T) {
+	const W, H = 48, 32
+	frame := func(i int) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, W, H))
+		for y := 0; y < H; y++ {
+			for x := 0; x < W; x++ {
+				c := color.NRGBA{R: uint8(x * 5), G: uint8(y * 7), B: 90, A: 255}
+				if x >= 3*i && x < 3*i+12 && y >= 8 && y < 20 {
+					c = color.NRGBA{R: 250, G: 40, B: uint8(20 * i), A: 255}
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+		return img
+	}
+	encode := func(opts *animation.EncodeOptions) []byte {
+		var buf bytes.Buffer
+		enc := animation.NewEncoder(&buf, W, H, opts)
+		// Frame 3 repeats frame 2 and is merged into it.
+		for _, i := range []int{0, 1, 2, 2, 3, 4, 5} {
+			if err := enc.AddFrame(frame(i), 80*time.Millisecond); err != nil {
+				t.Fatalf("AddFrame: %v", err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, opts := range []animation.EncodeOptions{
+		{Quality: 75, Kmax: 4},
+		{Quality: 75, AllowMixed: true, Parallel: true},
+		{Lossless: true},
+	} {
+		want := encode(&opts)
+		var infos []animation.FrameEncodeInfo
+		opts.FrameInfoFunc = func(info animation.FrameEncodeInfo) { infos = append(infos, info) }
+		if got := encode(&opts); !bytes.Equal(got, want) {
+			t.Errorf("%+v: FrameInfoFunc changed the output", opts)
+		}
+
+		anim, err := animation.Decode(bytes.NewReader(want))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if len(infos) != len(anim.Frames) {
+			t.Fatalf("%+v: got %d infos for %d frames", opts, len(infos), len(anim.Frames))
+		}
+		for i, info := range infos {
+			f := anim.Frames[i]
+			size := len(f.BitstreamData)
+			if len(f.AlphaData) > 0 {
+				size += 8 + len(f.AlphaData) + len(f.AlphaData)&1
+			}
+			if (i == 0 && !info.IsKeyframe) || (info.IsKeyframe && info.Rect != image.Rect(0, 0, W, H)) {
+				t.Errorf("%+v: frame %d: IsKeyframe = %v for %v", opts, i, info.IsKeyframe, info.Rect)
+			}
+			if info.Index != i || info.Rect.Min != image.Pt(f.OffsetX, f.OffsetY) ||
+				info.Dispose != f.Dispose || info.Blend != f.Blend || info.Codec != f.Codec ||
+				info.Size != size || info.Duration != f.Duration {
+				t.Errorf("%+v: frame %d: info = %+v, file has %+v", opts, i, info, f)
+			}
+		}
+		if infos[2].Duration != 160*time.Millisecond {
+			t.Errorf("%+v: merged frame duration = %v, want 160ms", opts, infos[2].Duration)
+		}
+	}
+}
+
+func TestAnimation_ContactSheet(t *testing.T) {
+	const W, H = 8, 6
+	colors := []color.NRGBA{
+		{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}, {R: 255, G: 255, A: 255},
+	}
+	var frames []image.Image
+	var durations []time.Duration
+	for _, c := range colors {
+		frames = append(frames, solidImage(W, H, c))
+		durations = append(durations, 100*time.Millisecond)
+	}
+	var buf bytes.Buffer
+	if err := animation.EncodeAll(&buf, frames, durations, &animation.EncodeOptions{Lossless: true}); err != nil {
+		t.Fatalf("EncodeAll: %v", err)
+	}
+
+	for _, tc := range []struct {
+		cols, wantCols, wantRows int
+	}{
+		{2, 2, 2},
+		{0, 2, 2},
+		{3, 3, 2},
+		{10, 4, 1},
+	} {
+		sheet, err := animation.ContactSheet(bytes.NewReader(buf.Bytes()), tc.cols)
+		if err != nil {
+			t.Fatalf("ContactSheet(%d): %v", tc.cols, err)
+		}
+		if got, want := sheet.Bounds(), image.Rect(0, 0, tc.wantCols*W, tc.wantRows*H); got != want {
+			t.Fatalf("ContactSheet(%d) bounds = %v, want %v", tc.cols, got, want)
+		}
+		for i := 0; i < tc.wantCols*tc.wantRows; i++ {
+			want := color.NRGBA{}
+			if i < len(colors) {
+				want = colors[i]
+			}
+			x0, y0 := i%tc.wantCols*W, i/tc.wantCols*H
+			for _, p := range []image.Point{{x0, y0}, {x0 + W - 1, y0 + H - 1}} {
+				if got := sheet.NRGBAAt(p.X, p.Y); got != want {
+					t.Errorf("ContactSheet(%d): cell %d pixel %v = %v, want %v", tc.cols, i, p, got, want)
+				}
+			}
+		}
+	}
+
+	if _, err := animation.ContactSheet(bytes.NewReader([]byte("not a webp")), 2); err == nil {
+		t.Error("ContactSheet accepted invalid data")
+	}
+}
+
+func TestAnimation_IsSeamlessLoop(t *testing.T) {
+	const W, H = 8, 6
+	red, blue := color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}
+	decode := func(colors ...color.NRGBA) *animation.Animation {
+		t.Helper()
+		var frames []image.Image
+		var durations []time.Duration
+		for _, c := range colors {
+			frames = append(frames, solidImage(W, H, c))
+			durations = append(durations, 100*time.Millisecond)
+		}
+		var buf bytes.Buffer
+		if err := animation.EncodeAll(&buf, frames, durations, &animation.EncodeOptions{Lossless: true}); err != nil {
+			t.Fatalf("EncodeAll: %v", err)
+		}
+		anim, err := animation.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		return anim
+	}
+
+	// nearRed differs from red by 3 levels in R and 2 in G; offRed by 1 in
+	// B; fadedRed by 3 in A.
+	nearRed := color.NRGBA{R: 252, G: 2, A: 255}
+	offRed := color.NRGBA{R: 255, B: 1, A: 255}
+	fadedRed := color.NRGBA{R: 255, A: 252}
+	for _, tc := range []struct {
+		name      string
+		colors    []color.NRGBA
+		tolerance int
+		want      bool
+		loopCount int
+	}{
+		{"back_to_start", []color.NRGBA{red, blue, red}, 0, true, 0},
+		{"jump", []color.NRGBA{red, blue}, 0, false, 1},
+		{"off_by_one_exact", []color.NRGBA{red, blue, offRed}, 0, false, 0},
+		{"off_by_one_at_tolerance", []color.NRGBA{red, blue, offRed}, 1, true, 0},
+		{"at_tolerance", []color.NRGBA{red, blue, nearRed}, 3, true, 0},
+		{"one_above_tolerance", []color.NRGBA{red, blue, nearRed}, 2, false, 0},
+		{"alpha_at_tolerance", []color.NRGBA{red, blue, fadedRed}, 3, true, 0},
+		{"alpha_one_above_tolerance", []color.NRGBA{red, blue, fadedRed}, 2, false, 0},
+		{"max_tolerance", []color.NRGBA{red, blue}, 255, true, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			anim := decode(tc.colors...)
+			got, err := anim.IsSeamlessLoop(tc.tolerance)
+			if err != nil {
+				t.Fatalf("IsSeamlessLoop: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("IsSeamlessLoop(%d) = %v, want %v", tc.tolerance, got, tc.want)
+			}
+			if got := anim.SuggestLoopCount(); got != tc.loopCount {
+				t.Errorf("SuggestLoopCount = %d, want %d", got, tc.loopCount)
+			}
+		})
+	}
+
+	if _, err := decode(red, blue, red).IsSeamlessLoop(-1); err == nil {
+		t.Error("IsSeamlessLoop(-1): no error")
+	}
+}
+
+func TestDecode_MaxFrames(t *testing.T) {
+	// A synthetic 1000-frame animation of 1x1 lossless frames.
+	vp8l := mustEncode(t, solidImage(1, 1, color.NRGBA{R: 255, A: 255}), &EncoderOptions{Lossless: true})[12:]
+	chunks := [][]byte{riffChunk("VP8X", vp8xPayload(0x02, 1, 1)), riffChunk("ANIM", make([]byte, 6))}
+	for i := 0; i < 1000; i++ {
+		chunks = append(chunks, riffChunk("ANMF", anmfPayload(0, 0, 1, 1, vp8l)))
+	}
+	data := riffFile(chunks...)
+
+	_, err := animation.DecodeWithConfig(bytes.NewReader(data), &animation.DecodeConfig{MaxFrames: 10})
+	if !errors.Is(err, animation.ErrTooManyFrames) {
+		t.Errorf("DecodeWithConfig(MaxFrames: 10) = %v, want ErrTooManyFrames", err)
+	}
+	anim, err := animation.DecodeWithConfig(bytes.NewReader(data), &animation.DecodeConfig{MaxFrames: 1000, DecodePixels: true})
+	if err != nil {
+		t.Fatalf("DecodeWithConfig(MaxFrames: 1000): %v", err)
+	}
+	if len(anim.Frames) != 1000 || anim.Frames[999].Image == nil {
+		t.Errorf("DecodeWithConfig(MaxFrames: 1000) = %d frames, want 1000 decoded", len(anim.Frames))
+	}
+	if anim, err := animation.Decode(bytes.NewReader(data)); err != nil || len(anim.Frames) != 1000 {
+		t.Errorf("Decode without a limit: %v", err)
+	}
+
+	opts := DefaultDecodeOptions()
+	opts.MaxFrames = 10
+	if _, err := DecodeWithOptions(bytes.NewReader(data), opts); !errors.Is(err, ErrTooManyFrames) {
+		t.Errorf("DecodeWithOptions(MaxFrames: 10) = %v, want ErrTooManyFrames", err)
+	}
+	opts.MaxFrames = 1000
+	if _, err := DecodeWithOptions(bytes.NewReader(data), opts); err != nil {
+		t.Errorf("DecodeWithOptions(MaxFrames: 1000): %v", err)
+	}
+
+	// The limit is enforced while parsing: a damaged 11th ANMF chunk is
+	// never reached with MaxFrames 10.
+	damaged := riffFile(append(chunks[:12:12], riffChunk("ANMF", make([]byte, 4)))...)
+	opts.MaxFrames = 0
+	if _, err := DecodeWithOptions(bytes.NewReader(damaged), opts); err == nil || errors.Is(err, ErrTooManyFrames) {
+		t.Fatalf("damaged ANMF without a limit = %v, want a parse error", err)
+	}
+	for _, lenient := range []bool{false, true} {
+		opts.MaxFrames = 10
+		opts.Lenient = lenient
+		if _, err := DecodeWithOptions(bytes.NewReader(damaged), opts); !errors.Is(err, ErrTooManyFrames) {
+			t.Errorf("damaged ANMF, Lenient %v, MaxFrames 10 = %v, want ErrTooManyFrames", lenient, err)
+		}
+	}
+}
+
+func TestDecode_VP8LFutureFormat(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Lossless = true
+	valid := mustEncode(t, iconTestImage(16, 16), opts)
+	if string(valid[12:16]) != "VP8L" {
+		t.Fatalf("chunks %q, want a simple VP8L file", valid[12:16])
+	}
+
+	// The version is in the top 3 bits of the fifth bitstream byte.
+	for version := byte(1); version < 8; version++ {
+		data := bytes.Clone(valid)
+		data[20+4] |= version << 5
+		_, err := Decode(bytes.NewReader(data))
+		if !errors.Is(err, ErrUnsupportedVP8LVersion) {
+			t.Errorf("version %d: Decode err = %v, want ErrUnsupportedVP8LVersion", version, err)
+		}
+		if _, err := GetFeatures(bytes.NewReader(data)); !errors.Is(err, ErrUnsupportedVP8LVersion) {
+			t.Errorf("version %d: GetFeatures err = %v, want ErrUnsupportedVP8LVersion", version, err)
+		}
+		// Animation frames reach the VP8L decoder without the container check.
+		if _, err := decodeFrameForAnimation(data[20:], nil); !errors.Is(err, ErrUnsupportedVP8LVersion) {
+			t.Errorf("version %d: frame decode err = %v, want ErrUnsupportedVP8LVersion", version, err)
+		}
+	}
+
+	// A 1x1 version 0 bitstream whose transform list starts with
+	// subtract green (bits 1, 0 1), then has a second transform.
+	vp8l := func(second byte) []byte {
+		return riffFile(riffChunk("VP8L", []byte{0x2f, 0, 0, 0, 0, 0x05 | second<<3, 0, 0, 0, 0, 0, 0}))
+	}
+	for typ := byte(0); typ < 4; typ++ {
+		_, err := Decode(bytes.NewReader(vp8l(1 | typ<<1)))
+		if repeated := typ == 2; errors.Is(err, ErrRepeatedTransform) != repeated {
+			t.Errorf("subtract green then transform %d: err = %v, ErrRepeatedTransform expected %v", typ, err, repeated)
+		}
+		if errors.Is(err, ErrUnsupportedVP8LVersion) {
+			t.Errorf("subtract green then transform %d: err = %v, want no version error", typ, err)
+		}
+	}
+	if _, err := Decode(bytes.NewReader(vp8l(0))); errors.Is(err, ErrRepeatedTransform) {
+		t.Errorf("one transform: err = %v, want no transform error", err)
+	}
+}